@@ -0,0 +1,58 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/francisco-serrano/gokit-auth/service"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+// ErrInternal is returned by RecoveringMiddleware in place of a panic. It
+// says nothing about what went wrong, which is only logged.
+var ErrInternal = errors.New("internal error")
+
+// RecoveringMiddleware turns a panic in the wrapped endpoint, or anywhere
+// below it in the service and its middlewares, into ErrInternal, which the
+// transports answer with a 500 or codes.Internal. The panic is logged with
+// the request ID and a stack trace and counted in panics. Wrap it around
+// every endpoint with Endpoints.Wrap so it sits outside the logging and
+// instrumenting middlewares too.
+func RecoveringMiddleware(logger log.Logger, panics metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					panics.Add(1)
+					_ = level.Error(logger).Log(
+						"msg", "panic while serving request",
+						"request_id", service.RequestIDFromContext(ctx),
+						"panic", fmt.Sprint(r),
+						"stack", string(debug.Stack()),
+					)
+
+					response, err = nil, ErrInternal
+				}
+			}()
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// Wrap returns e with every endpoint wrapped in mw.
+func (e Endpoints) Wrap(mw endpoint.Middleware) Endpoints {
+	fields := reflect.ValueOf(&e).Elem()
+	for i := range fields.NumField() {
+		field := fields.Field(i)
+		field.Set(reflect.ValueOf(mw(field.Interface().(endpoint.Endpoint))))
+	}
+
+	return e
+}
@@ -0,0 +1,78 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/francisco-serrano/gokit-auth/service"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+func TestRecoveringMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	panics := generic.NewCounter("panics")
+
+	panicking := func(context.Context, interface{}) (interface{}, error) {
+		panic("secret internal detail")
+	}
+
+	ctx := service.ContextWithRequestID(context.Background(), "req-1")
+
+	resp, err := RecoveringMiddleware(log.NewLogfmtLogger(&buf), panics)(panicking)(ctx, nil)
+	if resp != nil || !errors.Is(err, ErrInternal) || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("endpoint = %v, %v, want ErrInternal alone", resp, err)
+	}
+
+	for _, want := range []string{"request_id=req-1", "secret internal detail", "recovery_test.go"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q lacks %q", buf.String(), want)
+		}
+	}
+
+	if n := panics.Value(); n != 1 {
+		t.Fatalf("%v panics counted, want 1", n)
+	}
+}
+
+// panickingService panics in HealthCheck, below every endpoint middleware.
+type panickingService struct {
+	service.UserService
+}
+
+func (panickingService) HealthCheck(context.Context) service.HealthStatus {
+	panic("health check exploded")
+}
+
+// TestRecoveringMiddlewareWrapsTimeouts checks a panic in the service is
+// recovered by RecoveringMiddleware wrapped outside the timeout middleware,
+// even though the endpoint ran in another goroutine.
+func TestRecoveringMiddlewareWrapsTimeouts(t *testing.T) {
+	var buf bytes.Buffer
+	panics := generic.NewCounter("panics")
+
+	endpoints, err := MakeServerEndpoints(panickingService{}).WithTimeouts(Timeouts{Default: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints = endpoints.Wrap(RecoveringMiddleware(log.NewLogfmtLogger(&buf), panics))
+
+	if _, err := endpoints.HealthEndpoint(context.Background(), HealthRequest{}); !errors.Is(err, ErrInternal) {
+		t.Fatalf("HealthEndpoint() error = %v, want ErrInternal", err)
+	}
+
+	// The stack logged is the one of the service, not of the goroutine the
+	// panic was raised again in.
+	if !strings.Contains(buf.String(), "health check exploded") || !strings.Contains(buf.String(), "panickingService") {
+		t.Fatalf("log %q lacks the panic and the stack it came from", buf.String())
+	}
+
+	if n := panics.Value(); n != 1 {
+		t.Fatalf("%v panics counted, want 1", n)
+	}
+}
@@ -0,0 +1,138 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// ErrTimeout is returned by TimeoutMiddleware when the wrapped endpoint does
+// not answer in time. It wraps context.DeadlineExceeded, so the transports
+// answer it with a 504 or codes.DeadlineExceeded.
+var ErrTimeout = fmt.Errorf("request timed out: %w", context.DeadlineExceeded)
+
+// DefaultTimeout bounds the endpoints DefaultTimeouts does not name.
+const DefaultTimeout = 10 * time.Second
+
+// Timeouts configures Endpoints.WithTimeouts. PerEndpoint is keyed by the
+// name of the Endpoints field, such as "LoginEndpoint"; the endpoints it does
+// not name get Default. A zero duration leaves an endpoint without timeout.
+type Timeouts struct {
+	Default     time.Duration
+	PerEndpoint map[string]time.Duration
+}
+
+// DefaultTimeouts gives the endpoints hashing passwords more time than the
+// rest, and HealthCheck, which only pings the stores, less.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Default: DefaultTimeout,
+		PerEndpoint: map[string]time.Duration{
+			"RegisterEndpoint":       30 * time.Second,
+			"LoginEndpoint":          30 * time.Second,
+			"CreateUserEndpoint":     30 * time.Second,
+			"ChangePasswordEndpoint": 30 * time.Second,
+			"ResetPasswordEndpoint":  30 * time.Second,
+			"HealthEndpoint":         5 * time.Second,
+		},
+	}
+}
+
+// WithTimeouts returns e with every endpoint wrapped in a TimeoutMiddleware
+// configured by timeouts. It fails on a PerEndpoint name that is not a field
+// of Endpoints, so a typo does not go unnoticed.
+func (e Endpoints) WithTimeouts(timeouts Timeouts) (Endpoints, error) {
+	fields := reflect.ValueOf(&e).Elem()
+	for name := range timeouts.PerEndpoint {
+		if !fields.FieldByName(name).IsValid() {
+			return Endpoints{}, fmt.Errorf("unknown endpoint %q", name)
+		}
+	}
+
+	for i := range fields.NumField() {
+		timeout, ok := timeouts.PerEndpoint[fields.Type().Field(i).Name]
+		if !ok {
+			timeout = timeouts.Default
+		}
+
+		if timeout <= 0 {
+			continue
+		}
+
+		field := fields.Field(i)
+		field.Set(reflect.ValueOf(TimeoutMiddleware(timeout)(field.Interface().(endpoint.Endpoint))))
+	}
+
+	return e, nil
+}
+
+// TimeoutMiddleware gives the wrapped endpoint timeout to answer. It runs in
+// its own goroutine with a context cancelled once the timeout expires or the
+// request returns, so a store ignoring its context cannot hold the request
+// past the timeout, while one honouring it stops working on it. A timeout
+// returns ErrTimeout and a cancelled request the context's error.
+//
+// A panic in the wrapped endpoint is raised again in the caller's goroutine,
+// where RecoveringMiddleware can recover it, with the original stack in its
+// message.
+func TimeoutMiddleware(timeout time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			// Buffered so an abandoned call does not leak its goroutine.
+			done := make(chan timeoutResult, 1)
+
+			go func() {
+				var res timeoutResult
+				defer func() {
+					if r := recover(); r != nil {
+						res = timeoutResult{panic: &endpointPanic{value: r, stack: debug.Stack()}}
+					}
+
+					done <- res
+				}()
+
+				res.response, res.err = next(ctx, request)
+			}()
+
+			select {
+			case res := <-done:
+				if res.panic != nil {
+					panic(res.panic)
+				}
+
+				return res.response, res.err
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return nil, ErrTimeout
+				}
+
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+type timeoutResult struct {
+	response interface{}
+	err      error
+	panic    *endpointPanic
+}
+
+// endpointPanic carries a panic recovered by TimeoutMiddleware to the
+// caller's goroutine.
+type endpointPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *endpointPanic) String() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
@@ -0,0 +1,152 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/francisco-serrano/gokit-auth/service"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	// hung ignores its context, like a store stuck on a dead connection.
+	hung := make(chan struct{})
+	defer close(hung)
+
+	for _, tt := range []struct {
+		name string
+		next func(context.Context, interface{}) (interface{}, error)
+		want error
+	}{
+		{
+			name: "fast",
+			next: func(context.Context, interface{}) (interface{}, error) { return "ok", nil },
+		},
+		{
+			name: "ignores its context",
+			next: func(context.Context, interface{}) (interface{}, error) {
+				<-hung
+
+				return "late", nil
+			},
+			want: ErrTimeout,
+		},
+		{
+			name: "honours its context",
+			next: func(ctx context.Context, _ interface{}) (interface{}, error) {
+				<-ctx.Done()
+
+				return nil, ctx.Err()
+			},
+			want: ErrTimeout,
+		},
+	} {
+		start := time.Now()
+
+		resp, err := TimeoutMiddleware(20*time.Millisecond)(tt.next)(context.Background(), nil)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+
+		if tt.want == nil && resp != "ok" {
+			t.Errorf("%s: response = %v, want ok", tt.name, resp)
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: took %s, want the timeout to fire", tt.name, elapsed)
+		}
+	}
+
+	if !errors.Is(ErrTimeout, context.DeadlineExceeded) {
+		t.Fatal("ErrTimeout does not wrap context.DeadlineExceeded")
+	}
+}
+
+func TestTimeoutMiddlewareCancelsEndpoint(t *testing.T) {
+	cancelled := make(chan error, 1)
+	next := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+
+		return nil, ctx.Err()
+	}
+
+	// The caller gives up first.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := TimeoutMiddleware(time.Hour)(next)(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("endpoint context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the endpoint's context was never cancelled")
+	}
+}
+
+// slowService takes delay to answer HealthCheck and Login, or until its
+// context is done.
+type slowService struct {
+	service.UserService
+	delay time.Duration
+}
+
+func (s slowService) wait(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s slowService) HealthCheck(ctx context.Context) service.HealthStatus {
+	if err := s.wait(ctx); err != nil {
+		return service.HealthStatus{Status: err.Error()}
+	}
+
+	return service.HealthStatus{Status: service.HealthStatusOK}
+}
+
+func (s slowService) LoginWithOptions(ctx context.Context, _, _ string, _ service.LoginOptions) (service.LoginResult, error) {
+	if err := s.wait(ctx); err != nil {
+		return service.LoginResult{}, err
+	}
+
+	return service.LoginResult{AccessToken: "token"}, nil
+}
+
+func TestWithTimeouts(t *testing.T) {
+	endpoints, err := MakeServerEndpoints(slowService{delay: 100 * time.Millisecond}).WithTimeouts(Timeouts{
+		Default:     time.Second,
+		PerEndpoint: map[string]time.Duration{"HealthEndpoint": 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := endpoints.HealthEndpoint(context.Background(), HealthRequest{}); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("HealthEndpoint() error = %v, want ErrTimeout", err)
+	}
+
+	resp, err := endpoints.LoginEndpoint(context.Background(), LoginRequest{User: "alice", Pass: testPassword})
+	if err != nil || resp.(LoginResponse).AccessToken != "token" {
+		t.Fatalf("LoginEndpoint() under the default timeout = %+v, %v, want a token", resp, err)
+	}
+
+	if _, err := MakeServerEndpoints(slowService{}).WithTimeouts(Timeouts{PerEndpoint: map[string]time.Duration{"LogonEndpoint": time.Second}}); err == nil {
+		t.Fatal("WithTimeouts() accepted an unknown endpoint name")
+	}
+}
+
+func TestDefaultTimeoutsNameEndpoints(t *testing.T) {
+	if _, err := MakeServerEndpoints(slowService{}).WithTimeouts(DefaultTimeouts()); err != nil {
+		t.Fatalf("WithTimeouts(DefaultTimeouts()) error = %v", err)
+	}
+}
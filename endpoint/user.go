@@ -0,0 +1,975 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/francisco-serrano/gokit-auth/service"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// Failer is implemented by every response so transports can tell a business
+// failure, carried inside the response, from a transport-level error.
+type Failer interface {
+	Failed() error
+}
+
+// Endpoints collects every UserService endpoint.
+type Endpoints struct {
+	HealthEndpoint                    endpoint.Endpoint
+	LivenessEndpoint                  endpoint.Endpoint
+	ReadinessEndpoint                 endpoint.Endpoint
+	MainEndpoint                      endpoint.Endpoint
+	HomeStateEndpoint                 endpoint.Endpoint
+	RegisterEndpoint                  endpoint.Endpoint
+	UsernameAvailableEndpoint         endpoint.Endpoint
+	LoginEndpoint                     endpoint.Endpoint
+	EnableTOTPEndpoint                endpoint.Endpoint
+	BeginPasskeyRegistrationEndpoint  endpoint.Endpoint
+	FinishPasskeyRegistrationEndpoint endpoint.Endpoint
+	BeginPasskeyLoginEndpoint         endpoint.Endpoint
+	FinishPasskeyLoginEndpoint        endpoint.Endpoint
+	OAuthLoginEndpoint                endpoint.Endpoint
+	ConfirmTOTPEndpoint               endpoint.Endpoint
+	GenerateRecoveryCodesEndpoint     endpoint.Endpoint
+	RefreshEndpoint                   endpoint.Endpoint
+	LogoutEndpoint                    endpoint.Endpoint
+	GetProfileEndpoint                endpoint.Endpoint
+	IntrospectTokenEndpoint           endpoint.Endpoint
+	PublicJWKSEndpoint                endpoint.Endpoint
+	ListSessionsEndpoint              endpoint.Endpoint
+	RevokeAllSessionsEndpoint         endpoint.Endpoint
+	RevokeSessionEndpoint             endpoint.Endpoint
+	ListUsersEndpoint                 endpoint.Endpoint
+	SessionStatsEndpoint              endpoint.Endpoint
+	CreateUserEndpoint                endpoint.Endpoint
+	SetUserActiveEndpoint             endpoint.Endpoint
+	CreateInviteEndpoint              endpoint.Endpoint
+	ChangePasswordEndpoint            endpoint.Endpoint
+	DeleteAccountEndpoint             endpoint.Endpoint
+	RenameUsernameEndpoint            endpoint.Endpoint
+	GenerateVerificationTokenEndpoint endpoint.Endpoint
+	VerifyEmailEndpoint               endpoint.Endpoint
+	ResendVerificationEndpoint        endpoint.Endpoint
+	RequestPasswordResetEndpoint      endpoint.Endpoint
+	ResetPasswordEndpoint             endpoint.Endpoint
+}
+
+func MakeServerEndpoints(svc service.UserService) Endpoints {
+	return Endpoints{
+		HealthEndpoint:                    MakeHealthEndpoint(svc),
+		LivenessEndpoint:                  MakeLivenessEndpoint(svc),
+		ReadinessEndpoint:                 MakeReadinessEndpoint(svc),
+		MainEndpoint:                      MakeMainEndpoint(svc),
+		HomeStateEndpoint:                 MakeHomeStateEndpoint(svc),
+		RegisterEndpoint:                  ValidatingMiddleware()(MakeRegisterEndpoint(svc)),
+		UsernameAvailableEndpoint:         MakeUsernameAvailableEndpoint(svc),
+		LoginEndpoint:                     ValidatingMiddleware()(MakeLoginEndpoint(svc)),
+		EnableTOTPEndpoint:                MakeEnableTOTPEndpoint(svc),
+		BeginPasskeyRegistrationEndpoint:  MakeBeginPasskeyRegistrationEndpoint(svc),
+		FinishPasskeyRegistrationEndpoint: MakeFinishPasskeyRegistrationEndpoint(svc),
+		BeginPasskeyLoginEndpoint:         MakeBeginPasskeyLoginEndpoint(svc),
+		FinishPasskeyLoginEndpoint:        MakeFinishPasskeyLoginEndpoint(svc),
+		OAuthLoginEndpoint:                MakeOAuthLoginEndpoint(svc),
+		ConfirmTOTPEndpoint:               MakeConfirmTOTPEndpoint(svc),
+		GenerateRecoveryCodesEndpoint:     MakeGenerateRecoveryCodesEndpoint(svc),
+		RefreshEndpoint:                   MakeRefreshEndpoint(svc),
+		LogoutEndpoint:                    MakeLogoutEndpoint(svc),
+		GetProfileEndpoint:                MakeGetProfileEndpoint(svc),
+		IntrospectTokenEndpoint:           MakeIntrospectTokenEndpoint(svc),
+		PublicJWKSEndpoint:                MakePublicJWKSEndpoint(svc),
+		ListSessionsEndpoint:              MakeListSessionsEndpoint(svc),
+		RevokeAllSessionsEndpoint:         MakeRevokeAllSessionsEndpoint(svc),
+		RevokeSessionEndpoint:             MakeRevokeSessionEndpoint(svc),
+		ListUsersEndpoint:                 MakeListUsersEndpoint(svc),
+		SessionStatsEndpoint:              MakeSessionStatsEndpoint(svc),
+		CreateUserEndpoint:                MakeCreateUserEndpoint(svc),
+		SetUserActiveEndpoint:             MakeSetUserActiveEndpoint(svc),
+		CreateInviteEndpoint:              MakeCreateInviteEndpoint(svc),
+		ChangePasswordEndpoint:            MakeChangePasswordEndpoint(svc),
+		DeleteAccountEndpoint:             MakeDeleteAccountEndpoint(svc),
+		RenameUsernameEndpoint:            MakeRenameUsernameEndpoint(svc),
+		GenerateVerificationTokenEndpoint: MakeGenerateVerificationTokenEndpoint(svc),
+		VerifyEmailEndpoint:               MakeVerifyEmailEndpoint(svc),
+		ResendVerificationEndpoint:        ValidatingMiddleware()(MakeResendVerificationEndpoint(svc)),
+		RequestPasswordResetEndpoint:      MakeRequestPasswordResetEndpoint(svc),
+		ResetPasswordEndpoint:             MakeResetPasswordEndpoint(svc),
+	}
+}
+
+type HealthRequest struct{}
+
+type HealthResponse struct {
+	Message      string                `json:"message"`
+	Dependencies map[string]string     `json:"dependencies,omitempty"`
+	Integrations service.ServiceHealth `json:"integrations,omitempty"`
+}
+
+func (r HealthResponse) Failed() error { return nil }
+
+type ProbeRequest struct{}
+
+type ProbeResponse struct {
+	Err error `json:"-"`
+}
+
+func (r ProbeResponse) Failed() error { return r.Err }
+
+type MainRequest struct {
+	Token string
+}
+
+type MainResponse struct {
+	Render service.TemplateRender `json:"-"`
+	Err    error                  `json:"-"`
+}
+
+func (r MainResponse) Failed() error { return r.Err }
+
+type HomeStateRequest struct {
+	Token string
+}
+
+type HomeStateResponse struct {
+	State service.HomeState
+	Err   error `json:"-"`
+}
+
+func (r HomeStateResponse) Failed() error { return r.Err }
+
+type RegisterRequest struct {
+	User       string `json:"user"`
+	Pass       string `json:"pass"`
+	Email      string `json:"email"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+type RegisterResponse struct {
+	Message string `json:"message,omitempty"`
+	Err     error  `json:"-"`
+}
+
+func (r RegisterResponse) Failed() error { return r.Err }
+
+type UsernameAvailableRequest struct {
+	User string
+}
+
+type UsernameAvailableResponse struct {
+	Available bool  `json:"available"`
+	Err       error `json:"-"`
+}
+
+func (r UsernameAvailableResponse) Failed() error { return r.Err }
+
+type LoginRequest struct {
+	User       string `json:"user"`
+	Pass       string `json:"pass"`
+	RememberMe bool   `json:"remember_me"`
+	TOTPCode   string `json:"totp_code"`
+	// RecoveryCode stands in for TOTPCode when the authenticator is lost.
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	// TTLSeconds asks for a session of that length instead of the default.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+	// UserAgent and IP are filled in by the transport from the connection,
+	// never from the request body.
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
+}
+
+type LoginResponse struct {
+	AccessToken        string    `json:"access_token,omitempty"`
+	RefreshToken       string    `json:"refresh_token,omitempty"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	MustChangePassword bool      `json:"must_change_password,omitempty"`
+	RememberMe         bool      `json:"-"`
+	Err                error     `json:"-"`
+}
+
+func (r LoginResponse) Failed() error { return r.Err }
+
+type BeginPasskeyRegistrationRequest struct {
+	Token string `json:"-"`
+}
+
+type BeginPasskeyRegistrationResponse struct {
+	Options *protocol.CredentialCreation `json:"options,omitempty"`
+	Err     error                        `json:"-"`
+}
+
+func (r BeginPasskeyRegistrationResponse) Failed() error { return r.Err }
+
+// FinishPasskeyRegistrationRequest carries the credential exactly as
+// navigator.credentials.create produced it.
+type FinishPasskeyRegistrationRequest struct {
+	Token      string          `json:"-"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type FinishPasskeyRegistrationResponse struct {
+	Err error `json:"-"`
+}
+
+func (r FinishPasskeyRegistrationResponse) Failed() error { return r.Err }
+
+type BeginPasskeyLoginRequest struct {
+	User string `json:"user"`
+}
+
+type BeginPasskeyLoginResponse struct {
+	Options *protocol.CredentialAssertion `json:"options,omitempty"`
+	Err     error                         `json:"-"`
+}
+
+func (r BeginPasskeyLoginResponse) Failed() error { return r.Err }
+
+// FinishPasskeyLoginRequest carries the assertion exactly as
+// navigator.credentials.get produced it.
+type FinishPasskeyLoginRequest struct {
+	User       string          `json:"user"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type OAuthLoginRequest struct {
+	Provider string `json:"-"`
+	Code     string `json:"code"`
+}
+
+type EnableTOTPRequest struct {
+	Token string
+}
+
+type EnableTOTPResponse struct {
+	Secret     string `json:"secret,omitempty"`
+	OTPAuthURL string `json:"otpauth_url,omitempty"`
+	Err        error  `json:"-"`
+}
+
+func (r EnableTOTPResponse) Failed() error { return r.Err }
+
+type ConfirmTOTPRequest struct {
+	Token string `json:"-"`
+	Code  string `json:"code"`
+}
+
+type ConfirmTOTPResponse struct {
+	Err error `json:"-"`
+}
+
+func (r ConfirmTOTPResponse) Failed() error { return r.Err }
+
+type GenerateRecoveryCodesRequest struct {
+	Token string
+}
+
+type GenerateRecoveryCodesResponse struct {
+	Codes []string `json:"codes,omitempty"`
+	Err   error    `json:"-"`
+}
+
+func (r GenerateRecoveryCodesResponse) Failed() error { return r.Err }
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	Err         error  `json:"-"`
+}
+
+func (r RefreshResponse) Failed() error { return r.Err }
+
+type LogoutRequest struct {
+	Token string
+}
+
+type LogoutResponse struct {
+	Err error `json:"-"`
+}
+
+func (r LogoutResponse) Failed() error { return r.Err }
+
+type GetProfileRequest struct {
+	Token string
+}
+
+type GetProfileResponse struct {
+	Profile service.Profile
+	Err     error `json:"-"`
+}
+
+func (r GetProfileResponse) Failed() error { return r.Err }
+
+type IntrospectTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type IntrospectTokenResponse struct {
+	Introspection service.Introspection
+	Err           error `json:"-"`
+}
+
+func (r IntrospectTokenResponse) Failed() error { return r.Err }
+
+type PublicJWKSRequest struct{}
+
+type PublicJWKSResponse struct {
+	Set service.JSONWebKeySet
+	Err error `json:"-"`
+}
+
+func (r PublicJWKSResponse) Failed() error { return r.Err }
+
+type ListSessionsRequest struct {
+	Token  string
+	Cursor string
+	Limit  int
+}
+
+type ListSessionsResponse struct {
+	Sessions   []service.SessionInfo `json:"sessions"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	Err        error                 `json:"-"`
+}
+
+func (r ListSessionsResponse) Failed() error { return r.Err }
+
+type RevokeAllSessionsRequest struct {
+	Token string
+}
+
+type RevokeAllSessionsResponse struct {
+	Err error `json:"-"`
+}
+
+func (r RevokeAllSessionsResponse) Failed() error { return r.Err }
+
+type RevokeSessionRequest struct {
+	Token     string
+	SessionID string
+}
+
+type RevokeSessionResponse struct {
+	Err error `json:"-"`
+}
+
+func (r RevokeSessionResponse) Failed() error { return r.Err }
+
+type ListUsersRequest struct {
+	Token  string
+	Offset int
+	Limit  int
+}
+
+type ListUsersResponse struct {
+	Page service.UserPage
+	Err  error `json:"-"`
+}
+
+func (r ListUsersResponse) Failed() error { return r.Err }
+
+type SessionStatsRequest struct {
+	Token string
+}
+
+type SessionStatsResponse struct {
+	Stats service.SessionStats
+	Err   error `json:"-"`
+}
+
+func (r SessionStatsResponse) Failed() error { return r.Err }
+
+// CreateUserRequest carries the temporary password an admin picked for a new
+// account.
+type CreateUserRequest struct {
+	Token string   `json:"-"`
+	User  string   `json:"user"`
+	Pass  string   `json:"pass"`
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+type CreateUserResponse struct {
+	Err error `json:"-"`
+}
+
+func (r CreateUserResponse) Failed() error { return r.Err }
+
+type SetUserActiveRequest struct {
+	Token    string `json:"-"`
+	Username string `json:"-"`
+	Active   bool   `json:"active"`
+}
+
+type SetUserActiveResponse struct {
+	Err error `json:"-"`
+}
+
+func (r SetUserActiveResponse) Failed() error { return r.Err }
+
+type CreateInviteRequest struct {
+	Token string `json:"-"`
+}
+
+type CreateInviteResponse struct {
+	Code string `json:"code,omitempty"`
+	Err  error  `json:"-"`
+}
+
+func (r CreateInviteResponse) Failed() error { return r.Err }
+
+type ChangePasswordRequest struct {
+	Token   string `json:"-"`
+	OldPass string `json:"old_pass"`
+	NewPass string `json:"new_pass"`
+}
+
+type ChangePasswordResponse struct {
+	Err error `json:"-"`
+}
+
+func (r ChangePasswordResponse) Failed() error { return r.Err }
+
+type RenameUsernameRequest struct {
+	Token       string `json:"-"`
+	NewUsername string `json:"username"`
+}
+
+type RenameUsernameResponse struct {
+	Err error `json:"-"`
+}
+
+func (r RenameUsernameResponse) Failed() error { return r.Err }
+
+type DeleteAccountRequest struct {
+	Token    string `json:"-"`
+	Password string `json:"pass"`
+}
+
+type DeleteAccountResponse struct {
+	Err error `json:"-"`
+}
+
+func (r DeleteAccountResponse) Failed() error { return r.Err }
+
+type GenerateVerificationTokenRequest struct {
+	User string `json:"user"`
+}
+
+type GenerateVerificationTokenResponse struct {
+	Token string `json:"-"`
+	Err   error  `json:"-"`
+}
+
+func (r GenerateVerificationTokenResponse) Failed() error { return r.Err }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type VerifyEmailResponse struct {
+	Err error `json:"-"`
+}
+
+func (r VerifyEmailResponse) Failed() error { return r.Err }
+
+type ResendVerificationRequest struct {
+	UsernameOrEmail string `json:"user"`
+}
+
+type ResendVerificationResponse struct {
+	Err error `json:"-"`
+}
+
+func (r ResendVerificationResponse) Failed() error { return r.Err }
+
+type RequestPasswordResetRequest struct {
+	UsernameOrEmail string `json:"user"`
+}
+
+type RequestPasswordResetResponse struct {
+	Token string `json:"-"`
+	Err   error  `json:"-"`
+}
+
+func (r RequestPasswordResetResponse) Failed() error { return r.Err }
+
+type ResetPasswordRequest struct {
+	ResetToken string `json:"token"`
+	NewPass    string `json:"new_pass"`
+}
+
+type ResetPasswordResponse struct {
+	Err error `json:"-"`
+}
+
+func (r ResetPasswordResponse) Failed() error { return r.Err }
+
+func MakeHealthEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		health := svc.HealthCheck(ctx)
+
+		return HealthResponse{
+			Message:      health.String(),
+			Dependencies: health.Dependencies,
+			Integrations: health.Integrations,
+		}, nil
+	}
+}
+
+func MakeLivenessEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(_ context.Context, _ interface{}) (interface{}, error) {
+		return ProbeResponse{Err: svc.Liveness()}, nil
+	}
+}
+
+func MakeReadinessEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ProbeResponse{Err: svc.Readiness(ctx)}, nil
+	}
+}
+
+func MakeMainEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(MainRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to main request: %T", request)
+		}
+
+		render, err := svc.SendMainTemplateData(ctx, req.Token)
+
+		return MainResponse{Render: render, Err: err}, nil
+	}
+}
+
+func MakeHomeStateEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(HomeStateRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to home state request: %T", request)
+		}
+
+		state, err := svc.GetHomeState(ctx, req.Token)
+
+		return HomeStateResponse{State: state, Err: err}, nil
+	}
+}
+
+func MakeRegisterEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RegisterRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to register request: %T", request)
+		}
+
+		if req.InviteCode != "" {
+			message, err := svc.RegisterWithInvite(ctx, req.User, req.Pass, req.Email, req.InviteCode)
+
+			return RegisterResponse{Message: message, Err: err}, nil
+		}
+
+		message, err := svc.Register(ctx, req.User, req.Pass, req.Email)
+
+		return RegisterResponse{Message: message, Err: err}, nil
+	}
+}
+
+func MakeUsernameAvailableEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(UsernameAvailableRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to username available request: %T", request)
+		}
+
+		available, err := svc.IsUsernameAvailable(ctx, req.User)
+
+		return UsernameAvailableResponse{Available: available, Err: err}, nil
+	}
+}
+
+func MakeLoginEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(LoginRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to login request: %T", request)
+		}
+
+		result, err := svc.LoginWithOptions(ctx, req.User, req.Pass, service.LoginOptions{
+			RememberMe:   req.RememberMe,
+			TOTPCode:     req.TOTPCode,
+			RecoveryCode: req.RecoveryCode,
+			UserAgent:    req.UserAgent,
+			IP:           req.IP,
+			TTL:          time.Duration(req.TTLSeconds) * time.Second,
+		})
+
+		return LoginResponse{
+			AccessToken:        result.AccessToken,
+			RefreshToken:       result.RefreshToken,
+			ExpiresAt:          result.ExpiresAt,
+			MustChangePassword: result.MustChangePassword,
+			RememberMe:         req.RememberMe,
+			Err:                err,
+		}, nil
+	}
+}
+
+func MakeBeginPasskeyRegistrationEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(BeginPasskeyRegistrationRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to begin passkey registration request: %T", request)
+		}
+
+		options, err := svc.BeginPasskeyRegistration(ctx, req.Token)
+
+		return BeginPasskeyRegistrationResponse{Options: options, Err: err}, nil
+	}
+}
+
+func MakeFinishPasskeyRegistrationEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(FinishPasskeyRegistrationRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to finish passkey registration request: %T", request)
+		}
+
+		return FinishPasskeyRegistrationResponse{Err: svc.FinishPasskeyRegistration(ctx, req.Token, req.Credential)}, nil
+	}
+}
+
+func MakeBeginPasskeyLoginEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(BeginPasskeyLoginRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to begin passkey login request: %T", request)
+		}
+
+		options, err := svc.BeginPasskeyLogin(ctx, req.User)
+
+		return BeginPasskeyLoginResponse{Options: options, Err: err}, nil
+	}
+}
+
+func MakeFinishPasskeyLoginEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(FinishPasskeyLoginRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to finish passkey login request: %T", request)
+		}
+
+		result, err := svc.FinishPasskeyLogin(ctx, req.User, req.Credential)
+
+		return LoginResponse{
+			AccessToken:  result.AccessToken,
+			RefreshToken: result.RefreshToken,
+			ExpiresAt:    result.ExpiresAt,
+			Err:          err,
+		}, nil
+	}
+}
+
+func MakeOAuthLoginEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(OAuthLoginRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to oauth login request: %T", request)
+		}
+
+		result, err := svc.OAuthLogin(ctx, req.Provider, req.Code)
+
+		return LoginResponse{
+			AccessToken:  result.AccessToken,
+			RefreshToken: result.RefreshToken,
+			ExpiresAt:    result.ExpiresAt,
+			Err:          err,
+		}, nil
+	}
+}
+
+func MakeEnableTOTPEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(EnableTOTPRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to enable totp request: %T", request)
+		}
+
+		secret, otpauthURL, err := svc.EnableTOTP(ctx, req.Token)
+
+		return EnableTOTPResponse{Secret: secret, OTPAuthURL: otpauthURL, Err: err}, nil
+	}
+}
+
+func MakeConfirmTOTPEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ConfirmTOTPRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to confirm totp request: %T", request)
+		}
+
+		return ConfirmTOTPResponse{Err: svc.ConfirmTOTP(ctx, req.Token, req.Code)}, nil
+	}
+}
+
+func MakeGenerateRecoveryCodesEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GenerateRecoveryCodesRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to generate recovery codes request: %T", request)
+		}
+
+		codes, err := svc.GenerateRecoveryCodes(ctx, req.Token)
+
+		return GenerateRecoveryCodesResponse{Codes: codes, Err: err}, nil
+	}
+}
+
+func MakeRefreshEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RefreshRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to refresh request: %T", request)
+		}
+
+		token, err := svc.Refresh(ctx, req.RefreshToken)
+
+		return RefreshResponse{AccessToken: token, Err: err}, nil
+	}
+}
+
+func MakeLogoutEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(LogoutRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to logout request: %T", request)
+		}
+
+		return LogoutResponse{Err: svc.Logout(ctx, req.Token)}, nil
+	}
+}
+
+func MakeGetProfileEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GetProfileRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to get profile request: %T", request)
+		}
+
+		profile, err := svc.GetProfile(ctx, req.Token)
+
+		return GetProfileResponse{Profile: profile, Err: err}, nil
+	}
+}
+
+func MakePublicJWKSEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		set, err := svc.PublicJWKS(ctx)
+
+		return PublicJWKSResponse{Set: set, Err: err}, nil
+	}
+}
+
+func MakeIntrospectTokenEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(IntrospectTokenRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to introspect token request: %T", request)
+		}
+
+		introspection, err := svc.IntrospectToken(ctx, req.Token)
+
+		return IntrospectTokenResponse{Introspection: introspection, Err: err}, nil
+	}
+}
+
+func MakeListSessionsEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ListSessionsRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to list sessions request: %T", request)
+		}
+
+		page, err := svc.ListSessions(ctx, req.Token, req.Cursor, req.Limit)
+
+		return ListSessionsResponse{Sessions: page.Sessions, NextCursor: page.NextCursor, Err: err}, nil
+	}
+}
+
+func MakeRevokeAllSessionsEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RevokeAllSessionsRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to revoke all sessions request: %T", request)
+		}
+
+		return RevokeAllSessionsResponse{Err: svc.RevokeAllSessions(ctx, req.Token)}, nil
+	}
+}
+
+func MakeRevokeSessionEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RevokeSessionRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to revoke session request: %T", request)
+		}
+
+		return RevokeSessionResponse{Err: svc.RevokeSession(ctx, req.Token, req.SessionID)}, nil
+	}
+}
+
+func MakeListUsersEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ListUsersRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to list users request: %T", request)
+		}
+
+		page, err := svc.ListUsers(ctx, req.Token, req.Offset, req.Limit)
+
+		return ListUsersResponse{Page: page, Err: err}, nil
+	}
+}
+
+func MakeSessionStatsEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(SessionStatsRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to session stats request: %T", request)
+		}
+
+		stats, err := svc.SessionStats(ctx, req.Token)
+
+		return SessionStatsResponse{Stats: stats, Err: err}, nil
+	}
+}
+
+func MakeCreateUserEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateUserRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to create user request: %T", request)
+		}
+
+		return CreateUserResponse{Err: svc.CreateUser(ctx, req.Token, req.User, req.Pass, req.Email, req.Roles...)}, nil
+	}
+}
+
+func MakeSetUserActiveEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(SetUserActiveRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to set user active request: %T", request)
+		}
+
+		return SetUserActiveResponse{Err: svc.SetUserActive(ctx, req.Token, req.Username, req.Active)}, nil
+	}
+}
+
+func MakeCreateInviteEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateInviteRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to create invite request: %T", request)
+		}
+
+		code, err := svc.CreateInvite(ctx, req.Token)
+
+		return CreateInviteResponse{Code: code, Err: err}, nil
+	}
+}
+
+func MakeChangePasswordEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ChangePasswordRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to change password request: %T", request)
+		}
+
+		return ChangePasswordResponse{Err: svc.ChangePassword(ctx, req.Token, req.OldPass, req.NewPass)}, nil
+	}
+}
+
+func MakeRenameUsernameEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RenameUsernameRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to rename username request: %T", request)
+		}
+
+		return RenameUsernameResponse{Err: svc.RenameUsername(ctx, req.Token, req.NewUsername)}, nil
+	}
+}
+
+func MakeDeleteAccountEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(DeleteAccountRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to delete account request: %T", request)
+		}
+
+		return DeleteAccountResponse{Err: svc.DeleteAccount(ctx, req.Token, req.Password)}, nil
+	}
+}
+
+func MakeGenerateVerificationTokenEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GenerateVerificationTokenRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to generate verification token request: %T", request)
+		}
+
+		token, err := svc.GenerateVerificationToken(ctx, req.User)
+
+		return GenerateVerificationTokenResponse{Token: token, Err: err}, nil
+	}
+}
+
+func MakeVerifyEmailEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(VerifyEmailRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to verify email request: %T", request)
+		}
+
+		return VerifyEmailResponse{Err: svc.VerifyEmail(ctx, req.Token)}, nil
+	}
+}
+
+func MakeResendVerificationEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ResendVerificationRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to resend verification request: %T", request)
+		}
+
+		return ResendVerificationResponse{Err: svc.ResendVerification(ctx, req.UsernameOrEmail)}, nil
+	}
+}
+
+func MakeRequestPasswordResetEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RequestPasswordResetRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to request password reset request: %T", request)
+		}
+
+		token, err := svc.RequestPasswordReset(ctx, req.UsernameOrEmail)
+
+		return RequestPasswordResetResponse{Token: token, Err: err}, nil
+	}
+}
+
+func MakeResetPasswordEndpoint(svc service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ResetPasswordRequest)
+		if !ok {
+			return nil, fmt.Errorf("error while casting to reset password request: %T", request)
+		}
+
+		return ResetPasswordResponse{Err: svc.ResetPassword(ctx, req.ResetToken, req.NewPass)}, nil
+	}
+}
@@ -0,0 +1,93 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/francisco-serrano/gokit-auth/service"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "passw0rd-1"
+
+// newTestEndpoints returns the endpoints of a service backed by the
+// in-memory stores.
+func newTestEndpoints(t *testing.T, opts ...service.Option) Endpoints {
+	t.Helper()
+
+	opts = append([]service.Option{service.WithBcryptCost(bcrypt.MinCost)}, opts...)
+
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { svc.Close(context.Background()) })
+
+	return MakeServerEndpoints(svc)
+}
+
+func TestEndpoints(t *testing.T) {
+	endpoints := newTestEndpoints(t)
+	ctx := context.Background()
+
+	resp, err := endpoints.RegisterEndpoint(ctx, RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"})
+	if err != nil || resp.(RegisterResponse).Failed() != nil {
+		t.Fatalf("RegisterEndpoint() = %+v, %v", resp, err)
+	}
+
+	resp, err = endpoints.RegisterEndpoint(ctx, RegisterRequest{User: "alice", Pass: testPassword, Email: "other@example.com"})
+	if err != nil || !errors.Is(resp.(RegisterResponse).Failed(), service.ErrUserAlreadyExists) {
+		t.Fatalf("RegisterEndpoint() of a taken username = %+v, %v, want ErrUserAlreadyExists in the response", resp, err)
+	}
+
+	resp, err = endpoints.LoginEndpoint(ctx, LoginRequest{User: "alice", Pass: "wrong-passw0rd"})
+	if err != nil || !errors.Is(resp.(LoginResponse).Failed(), service.ErrInvalidCredentials) {
+		t.Fatalf("LoginEndpoint() with a wrong password = %+v, %v, want ErrInvalidCredentials in the response", resp, err)
+	}
+
+	resp, err = endpoints.LoginEndpoint(ctx, LoginRequest{User: "alice", Pass: testPassword, RememberMe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := resp.(LoginResponse)
+	if login.Failed() != nil || login.AccessToken == "" || login.RefreshToken == "" || !login.RememberMe {
+		t.Fatalf("LoginEndpoint() = %+v, want tokens", login)
+	}
+
+	resp, err = endpoints.HomeStateEndpoint(ctx, HomeStateRequest{Token: login.AccessToken})
+	if err != nil || resp.(HomeStateResponse).Failed() != nil {
+		t.Fatalf("HomeStateEndpoint() = %+v, %v", resp, err)
+	}
+
+	resp, err = endpoints.LogoutEndpoint(ctx, LogoutRequest{Token: login.AccessToken})
+	if err != nil || resp.(LogoutResponse).Failed() != nil {
+		t.Fatalf("LogoutEndpoint() = %+v, %v", resp, err)
+	}
+
+	resp, err = endpoints.LogoutEndpoint(ctx, LogoutRequest{Token: login.AccessToken})
+	if err != nil || resp.(LogoutResponse).Failed() == nil {
+		t.Fatalf("second LogoutEndpoint() = %+v, %v, want a failed response", resp, err)
+	}
+}
+
+func TestEndpointsRejectWrongRequestType(t *testing.T) {
+	endpoints := newTestEndpoints(t)
+
+	if _, err := endpoints.LogoutEndpoint(context.Background(), LoginRequest{}); err == nil {
+		t.Fatal("LogoutEndpoint() accepted a LoginRequest")
+	}
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	resp, err := newTestEndpoints(t).HealthEndpoint(context.Background(), HealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if health := resp.(HealthResponse); health.Message != service.HealthStatusOK {
+		t.Fatalf("HealthEndpoint() = %+v, want %s", health, service.HealthStatusOK)
+	}
+}
@@ -0,0 +1,125 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+const (
+	// maxIdentifierLength bounds usernames and emails in bytes. It is well
+	// above what the service accepts and only keeps oversized input from
+	// reaching it.
+	maxIdentifierLength = 254
+	// maxSecretLength bounds passwords and codes in bytes, sparing the
+	// service from hashing megabytes sent as a password.
+	maxSecretLength = 1024
+)
+
+// Validator is implemented by requests ValidatingMiddleware checks. Validate
+// returns a ValidationError, or nil for a well-formed request.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes why one request field was rejected. Field is the
+// field's JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every offending field of a request rejected by
+// ValidatingMiddleware.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		msgs = append(msgs, field.Field+" "+field.Message)
+	}
+
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// ValidatingMiddleware rejects requests implementing Validator that fail
+// validation before the wrapped endpoint, and so the service, sees them. The
+// ValidationError is returned as the endpoint's error rather than inside a
+// response, like a request that failed to decode. It only checks the shape
+// of the input; business rules such as the password policy stay with the
+// service.
+func ValidatingMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if v, ok := request.(Validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, err
+				}
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// fieldChecks collects the FieldErrors of one request.
+type fieldChecks struct {
+	fields []FieldError
+}
+
+// required checks that value is not blank and at most maxLength bytes.
+func (c *fieldChecks) required(field, value string, maxLength int) {
+	if strings.TrimSpace(value) == "" {
+		c.fields = append(c.fields, FieldError{Field: field, Message: "is required"})
+
+		return
+	}
+
+	c.optional(field, value, maxLength)
+}
+
+// optional checks that value is at most maxLength bytes.
+func (c *fieldChecks) optional(field, value string, maxLength int) {
+	if len(value) > maxLength {
+		c.fields = append(c.fields, FieldError{Field: field, Message: fmt.Sprintf("must be at most %d bytes", maxLength)})
+	}
+}
+
+func (c *fieldChecks) err() error {
+	if len(c.fields) == 0 {
+		return nil
+	}
+
+	return ValidationError{Fields: c.fields}
+}
+
+func (r RegisterRequest) Validate() error {
+	var c fieldChecks
+	c.required("user", r.User, maxIdentifierLength)
+	c.required("pass", r.Pass, maxSecretLength)
+	c.required("email", r.Email, maxIdentifierLength)
+	c.optional("invite_code", r.InviteCode, maxSecretLength)
+
+	return c.err()
+}
+
+func (r LoginRequest) Validate() error {
+	var c fieldChecks
+	c.required("user", r.User, maxIdentifierLength)
+	c.required("pass", r.Pass, maxSecretLength)
+	c.optional("totp_code", r.TOTPCode, maxSecretLength)
+	c.optional("recovery_code", r.RecoveryCode, maxSecretLength)
+
+	return c.err()
+}
+
+func (r ResendVerificationRequest) Validate() error {
+	var c fieldChecks
+	c.required("user", r.UsernameOrEmail, maxIdentifierLength)
+
+	return c.err()
+}
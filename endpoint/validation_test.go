@@ -0,0 +1,77 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidatingMiddleware(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		request interface{}
+		fields  []string
+	}{
+		{
+			name:    "missing username",
+			request: LoginRequest{User: " ", Pass: testPassword},
+			fields:  []string{"user"},
+		},
+		{
+			name:    "oversized password",
+			request: RegisterRequest{User: "alice", Pass: strings.Repeat("x", maxSecretLength+1), Email: "alice@example.com"},
+			fields:  []string{"pass"},
+		},
+		{
+			name:    "every offending field",
+			request: RegisterRequest{Email: strings.Repeat("x", maxIdentifierLength+1)},
+			fields:  []string{"user", "pass", "email"},
+		},
+		{
+			name:    "valid",
+			request: LoginRequest{User: "alice", Pass: testPassword},
+		},
+		{
+			name:    "not a Validator",
+			request: LogoutRequest{},
+		},
+	} {
+		var called bool
+		next := func(context.Context, interface{}) (interface{}, error) {
+			called = true
+
+			return nil, nil
+		}
+
+		_, err := ValidatingMiddleware()(next)(context.Background(), tt.request)
+
+		if tt.fields == nil {
+			if err != nil || !called {
+				t.Errorf("%s: error = %v, called = %t, want the request passed through", tt.name, err, called)
+			}
+
+			continue
+		}
+
+		var validationErr ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: error = %v, want a ValidationError", tt.name, err)
+
+			continue
+		}
+
+		if called {
+			t.Errorf("%s: the invalid request reached the endpoint", tt.name)
+		}
+
+		var fields []string
+		for _, field := range validationErr.Fields {
+			fields = append(fields, field.Field)
+		}
+
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: offending fields = %v, want %v", tt.name, fields, tt.fields)
+		}
+	}
+}
@@ -1,55 +1,398 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/pb"
 	"github.com/francisco-serrano/gokit-auth/service"
 	"github.com/francisco-serrano/gokit-auth/transport"
+	grpctransport "github.com/francisco-serrano/gokit-auth/transport/grpc"
+	apihttp "github.com/francisco-serrano/gokit-auth/transport/http"
+	kitlog "github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/go-kit/kit/transport/http"
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
 	"log"
+	"net"
+	nethttp "net/http"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// shutdownDrainDelay is how long readiness reports failure before the servers
+// are stopped on SIGINT/SIGTERM.
+const shutdownDrainDelay = 5 * time.Second
+
+// shutdownTimeout bounds how long the user service may take to close once
+// the servers have stopped.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	svc := service.NewUserService()
+	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+	logger = kitlog.With(logger, "ts", kitlog.DefaultTimestampUTC)
+
+	users := service.NewMemoryUserRepository()
+
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		users, err = service.NewPostgresUserRepository(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		users, err = service.NewRetryUserRepository(users, service.DefaultRetryPolicy())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sessions := service.NewMemorySessionStore()
+
+	opts := []service.Option{service.WithLogger(logger)}
+
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+
+		breakerSessions, err := service.NewCircuitBreakerSessionStore(service.NewRedisSessionStore(client), service.DefaultBreakerSettings())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sessions = breakerSessions
+		opts = append(opts,
+			service.WithRefreshTokenStore(service.NewRedisRefreshTokenStore(client)),
+			service.WithOneTimeTokenStore(service.NewRedisOneTimeTokenStore(client)),
+			service.WithDenylist(service.NewRedisDenylist(client)),
+		)
+	}
+
+	if alg := os.Getenv("JWT_ALGORITHM"); alg != "" {
+		keys, err := newKeyManager(alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts = append(opts, service.WithKeyManager(keys))
+	}
+
+	// JWT_ENCRYPTION_KEY is a base64 encoded 32 byte key.
+	if encoded := os.Getenv("JWT_ENCRYPTION_KEY"); encoded != "" {
+		encryptionKey, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Fatal(fmt.Errorf("error while decoding JWT_ENCRYPTION_KEY: %w", err))
+		}
+
+		opts = append(opts, service.WithEncryptedTokens(encryptionKey))
+	}
+
+	if threshold := os.Getenv("BREACH_THRESHOLD"); threshold != "" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts = append(opts, service.WithBreachChecker(service.NewHIBPBreachChecker(nil), n))
+	}
+
+	if path := os.Getenv("EMAIL_DENYLIST_FILE"); path != "" {
+		denied, err := service.LoadEmailDomainList(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts = append(opts, service.WithEmailDomainPolicy(service.NewEmailDomainPolicy(denied, nil)))
+	}
+
+	// SMTP_ADDR is host:port. SMTP_USERNAME and SMTP_PASSWORD are optional.
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		var auth smtp.Auth
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+
+		opts = append(opts, service.WithMailer(service.NewSMTPMailer(addr, os.Getenv("SMTP_FROM"), auth)))
+	}
+
+	if pepper := os.Getenv("PASSWORD_PEPPER"); pepper != "" {
+		opts = append(opts, service.WithPepper([]byte(pepper)))
+	}
+
+	if os.Getenv("NEW_LOGIN_ALERTS") == "true" {
+		opts = append(opts, service.WithNewLoginAlerts())
+	}
+
+	if os.Getenv("INVITE_ONLY") == "true" {
+		opts = append(opts, service.WithInviteOnly())
+	}
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		google := service.NewGoogleOAuthProvider(clientID, os.Getenv("GOOGLE_CLIENT_SECRET"), os.Getenv("GOOGLE_REDIRECT_URL"), nil)
+		opts = append(opts, service.WithOAuthProvider("google", google))
+	}
+
+	// WEBAUTHN_ORIGINS is a comma separated list such as
+	// "https://auth.example.com".
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		origins := strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",")
+		opts = append(opts, service.WithWebAuthn(rpID, "gokit-auth", origins...))
+	}
+
+	opts = append(opts, service.WithBusinessMetrics(
+		kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "user_service",
+			Name:      "active_users",
+			Help:      "Number of distinct users holding at least one session.",
+		}, []string{}),
+		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "user_service",
+			Name:      "registrations_total",
+			Help:      "Number of accounts created.",
+		}, []string{}),
+	))
+
+	svc, err := service.NewUserService(users, sessions, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	svc = service.NewRateLimitMiddleware(service.DefaultRateLimits(), nil)(svc)
+
+	if raw := os.Getenv("AUTH_CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		svc = service.NewCachingMiddleware(ttl, nil)(svc)
+	}
+
+	fieldKeys := []string{"method", "success"}
+
+	svc = service.NewInstrumentingMiddleware(
+		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "user_service",
+			Name:      "request_count",
+			Help:      "Number of requests received.",
+		}, fieldKeys),
+		kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "user_service",
+			Name:      "request_latency_seconds",
+			Help:      "Total duration of requests in seconds.",
+		}, fieldKeys),
+		kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "user_service",
+			Name:      "active_sessions",
+			Help:      "Number of sessions opened through Login and not yet logged out.",
+		}, []string{}),
+	)(svc)
+	svc = service.NewTracingMiddleware(otel.Tracer("github.com/francisco-serrano/gokit-auth"))(svc)
+	if os.Getenv("LOG_FORMAT") == "json" {
+		svc = service.NewJSONLoggingMiddleware(os.Stderr)(svc)
+	} else {
+		svc = service.NewLoggingMiddleware(logger)(svc)
+	}
+
+	endpoints, err := endpoint.MakeServerEndpoints(svc).WithTimeouts(endpoint.DefaultTimeouts())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpoints = endpoints.Wrap(endpoint.RecoveringMiddleware(logger,
+		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "gokit_auth",
+			Subsystem: "endpoint",
+			Name:      "panics_total",
+			Help:      "Number of panics recovered while serving requests.",
+		}, []string{}),
+	))
 
 	userHandler := http.NewServer(
-		transport.MakeHealthEndpoint(svc),
-		transport.DecodeRequest,
+		endpoints.HealthEndpoint,
+		transport.DecodeHealthRequest,
 		transport.EncodeResponseJSON,
+		withRequestID()...,
 	)
 
+	tokenCookies := transport.DefaultTokenCookieConfig()
+	if os.Getenv("INSECURE_COOKIES") == "true" {
+		tokenCookies.Secure = false
+	}
+
+	templates, err := transport.NewTemplateManager("templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mainHandler := http.NewServer(
-		transport.MakeMainEndpoint(svc),
-		transport.DecodeRequest,
-		transport.SetMainResponse,
+		endpoints.MainEndpoint,
+		transport.DecodeMainRequest,
+		templates.SetMainResponse,
+		withRequestID(http.ServerBefore(transport.PopulateLanguage, transport.PopulateCSRFToken, transport.PopulateFlash))...,
 	)
 
 	registerHandler := http.NewServer(
-		transport.MakeRegisterEndpoint(svc),
-		transport.DecodeLoginRegisterRequest,
+		endpoints.RegisterEndpoint,
+		transport.DecodeRegisterRequest,
 		transport.EncodeResponseString,
+		withRequestID(http.ServerBefore(transport.PopulateClientIP))...,
 	)
 
 	loginHandler := http.NewServer(
-		transport.MakeLoginEndpoint(svc),
-		transport.DecodeLoginRegisterRequest,
-		transport.SetLoginResponse,
+		endpoints.LoginEndpoint,
+		transport.DecodeLoginRequest,
+		tokenCookies.SetLoginResponse,
+		withRequestID(http.ServerBefore(transport.PopulateClientIP))...,
+	)
+
+	refreshHandler := http.NewServer(
+		endpoints.RefreshEndpoint,
+		transport.DecodeRefreshRequest,
+		tokenCookies.SetRefreshResponse,
+		withRequestID()...,
 	)
 
 	logoutHandler := http.NewServer(
-		transport.MakeLogoutEndpoint(svc),
-		transport.DecodeRequest,
-		transport.SetLogoutResponse,
+		endpoints.LogoutEndpoint,
+		transport.DecodeLogoutRequest,
+		tokenCookies.SetLogoutResponse,
+		withRequestID()...,
+	)
+
+	jwksHandler := http.NewServer(
+		endpoints.PublicJWKSEndpoint,
+		apihttp.DecodePublicJWKSRequest,
+		apihttp.EncodePublicJWKSResponse,
+		withRequestID()...,
 	)
 
 	app := fiber.New()
+	probeHandler := adaptor.HTTPHandler(apihttp.NewProbeHandler(endpoints))
+
 	app.Get("/health", adaptor.HTTPHandler(userHandler))
+	app.Get("/healthz", probeHandler)
+	app.Get("/readyz", probeHandler)
+	app.Get("/.well-known/jwks.json", adaptor.HTTPHandler(jwksHandler))
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 	app.Get("/", adaptor.HTTPHandler(mainHandler))
-	app.Post("/register", adaptor.HTTPHandler(registerHandler))
-	app.Post("/login", adaptor.HTTPHandler(loginHandler))
-	app.Post("/logout", adaptor.HTTPHandler(logoutHandler))
+	app.Post("/register", adaptor.HTTPHandler(transport.CSRFProtect(registerHandler)))
+	app.Post("/login", adaptor.HTTPHandler(transport.CSRFProtect(loginHandler)))
+	app.Post("/refresh", adaptor.HTTPHandler(refreshHandler))
+	app.Post("/logout", adaptor.HTTPHandler(transport.CSRFProtect(logoutHandler)))
+	app.All("/api/*", adaptor.HTTPHandler(nethttp.StripPrefix("/api", apihttp.NewHTTPHandler(endpoints, apihttp.WithLogger(logger)))))
+
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":8081"
+	}
+
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterUserServiceServer(grpcServer, grpctransport.NewGRPCServer(endpoints))
+
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		// Fail readiness first and give load balancers time to notice before
+		// the servers stop accepting connections.
+		svc.BeginShutdown()
+		time.Sleep(shutdownDrainDelay)
+
+		grpcServer.GracefulStop()
+
+		if err := app.Shutdown(); err != nil {
+			log.Print(fmt.Errorf("error while shutting down http server: %w", err))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := svc.Close(ctx); err != nil {
+			log.Print(fmt.Errorf("error while closing user service: %w", err))
+		}
+	}()
 
 	if err := app.Listen(":8080"); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// newKeyManager builds the token KeyManager for alg: HS256 reads its secret
+// from JWT_SECRET, RS256 and ES256 read a PEM private key from the file named
+// by JWT_PRIVATE_KEY_FILE. JWT_KEY_ID names the key and defaults to "v1".
+func newKeyManager(alg string) (*service.KeyManager, error) {
+	kid := os.Getenv("JWT_KEY_ID")
+	if kid == "" {
+		kid = "v1"
+	}
+
+	if alg == service.AlgorithmHS256 {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_SECRET is required for %s", alg)
+		}
+
+		return service.NewKeyManager(alg, kid, []byte(secret))
+	}
+
+	pemBytes, err := os.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+
+	key, err := service.ParsePrivateKeyPEM(alg, pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing signing key: %w", err)
+	}
+
+	return service.NewKeyManager(alg, kid, key)
+}
+
+// withRequestID prepends the hooks correlating a request's log lines to opts,
+// along with the one scoping it to the caller's tenant.
+func withRequestID(opts ...http.ServerOption) []http.ServerOption {
+	return append([]http.ServerOption{
+		http.ServerBefore(transport.PopulateRequestID, transport.PopulateTenant),
+		http.ServerAfter(transport.SetRequestIDHeader),
+	}, opts...)
+}
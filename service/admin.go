@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultUserPageLimit is used by ListUsers when limit is zero.
+	DefaultUserPageLimit = 50
+	// MaxUserPageLimit is the largest page ListUsers will return.
+	MaxUserPageLimit = 500
+	// SessionStatsWindow is how far back SessionStats counts new sessions.
+	SessionStatsWindow = time.Hour
+)
+
+var (
+	// ErrForbidden is returned when an authenticated caller lacks the role an
+	// operation requires.
+	ErrForbidden = errors.New("forbidden")
+	// ErrInvalidPage is returned by ListUsers and ListSessions for a negative
+	// offset, a limit out of bounds or a malformed cursor.
+	ErrInvalidPage = errors.New("invalid page")
+	// ErrAccountSuspended is returned by Login for accounts suspended through
+	// SetUserActive.
+	ErrAccountSuspended = errors.New("account suspended")
+)
+
+// UserPage is one page of usernames, sorted ascending. Total counts every
+// user, not only the ones in the page.
+type UserPage struct {
+	Usernames []string `json:"usernames"`
+	Offset    int      `json:"offset"`
+	Limit     int      `json:"limit"`
+	Total     int      `json:"total"`
+	HasMore   bool     `json:"has_more"`
+}
+
+// ListUsers pages through every registered username. The caller must hold
+// RoleAdmin. A limit of zero selects DefaultUserPageLimit.
+func (u *userService) ListUsers(ctx context.Context, token string, offset, limit int) (UserPage, error) {
+	if offset < 0 {
+		return UserPage{}, fmt.Errorf("%w: offset must not be negative, got %d", ErrInvalidPage, offset)
+	}
+
+	if limit == 0 {
+		limit = DefaultUserPageLimit
+	}
+
+	if limit < 0 || limit > MaxUserPageLimit {
+		return UserPage{}, fmt.Errorf("%w: limit must be between 1 and %d, got %d", ErrInvalidPage, MaxUserPageLimit, limit)
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	if _, err := u.requireRole(ctx, token, RoleAdmin); err != nil {
+		return UserPage{}, err
+	}
+
+	usernames, total, err := u.users.ListUsernames(ctx, offset, limit)
+	if err != nil {
+		return UserPage{}, fmt.Errorf("error while listing users: %w", err)
+	}
+
+	return UserPage{
+		Usernames: usernames,
+		Offset:    offset,
+		Limit:     limit,
+		Total:     total,
+		HasMore:   offset+len(usernames) < total,
+	}, nil
+}
+
+// SessionStats counts the live sessions of the caller's tenant, the users
+// holding them and the sessions created within SessionStatsWindow. The
+// caller must hold RoleAdmin. The SessionStore has to look at every session
+// of the tenant, so this is meant for dashboards rather than hot paths.
+func (u *userService) SessionStats(ctx context.Context, token string) (SessionStats, error) {
+	if u.statelessSessions {
+		return SessionStats{}, ErrStatelessSessions
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	if _, err := u.requireRole(ctx, token, RoleAdmin); err != nil {
+		return SessionStats{}, err
+	}
+
+	stats, err := u.sessions.SessionStats(ctx, u.clock.Now().UTC().Add(-SessionStatsWindow))
+	if err != nil {
+		return SessionStats{}, fmt.Errorf("error while counting sessions: %w", err)
+	}
+
+	return stats, nil
+}
+
+// CreateUser registers an account on behalf of its owner with the temporary
+// password pass, which they have to change on their first login, see
+// UserFields.MustChangePassword. The caller must hold RoleAdmin. It goes
+// through the checks of Register but works while registration is closed or
+// invite-only.
+func (u *userService) CreateUser(ctx context.Context, token, user, pass, email string, roles ...string) error {
+	// The role is checked before newUser too, sparing callers without it the
+	// password hash, and again under the lock in case it was revoked since.
+	u.mu.RLock()
+	_, err := u.requireRole(ctx, token, RoleAdmin)
+	u.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	userFields, err := u.newUser(ctx, user, pass, email, roles)
+	if err != nil {
+		return err
+	}
+
+	userFields.MustChangePassword = true
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, err := u.requireRole(ctx, token, RoleAdmin); err != nil {
+		return err
+	}
+
+	if err := u.checkUsernameFree(ctx, userFields.Username); err != nil {
+		return err
+	}
+
+	if err := u.checkEmailFree(ctx, userFields.Email); err != nil {
+		return err
+	}
+
+	return u.saveNewUser(ctx, userFields)
+}
+
+// SetUserActive suspends or reactivates username. The caller must hold
+// RoleAdmin and cannot suspend themselves. Suspending an account revokes
+// every session it has, so its tokens stop working at once rather than when
+// they expire; the account and its data are kept until reactivated.
+func (u *userService) SetUserActive(ctx context.Context, token, username string, active bool) error {
+	username = normalizeUsername(username)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	admin, err := u.requireRole(ctx, token, RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if !active && username == admin {
+		return fmt.Errorf("%w: cannot suspend your own account", ErrForbidden)
+	}
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if err != nil {
+		return fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if userFields.Suspended == !active {
+		return nil
+	}
+
+	userFields.Suspended = !active
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	if active {
+		u.audit(ctx, AuditReactivate, username)
+
+		return nil
+	}
+
+	u.audit(ctx, AuditSuspend, username)
+
+	if _, err := u.revokeUserSessions(ctx, username); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// requireRole authenticates token against its session and checks that it
+// was issued with role. It returns the username the token belongs to.
+func (u *userService) requireRole(ctx context.Context, token, role string) (string, error) {
+	username, err := u.authenticate(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := u.keys.hasRole(token, TenantFromContext(ctx), role)
+	if err != nil {
+		return "", fmt.Errorf("error while checking role: %w", err)
+	}
+
+	if !ok {
+		return "", fmt.Errorf("%w: %s role required", ErrForbidden, role)
+	}
+
+	return username, nil
+}
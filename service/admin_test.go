@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRequireRole(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+	mustRegister(t, svc, "alice")
+
+	admin := mustLogin(t, svc, "admin")
+	if _, err := svc.ListUsers(context.Background(), admin.AccessToken, 0, 0); err != nil {
+		t.Fatalf("ListUsers() as admin error = %v", err)
+	}
+
+	alice := mustLogin(t, svc, "alice")
+	if _, err := svc.ListUsers(context.Background(), alice.AccessToken, 0, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("ListUsers() as a user error = %v, want ErrForbidden", err)
+	}
+
+	other := ContextWithTenant(context.Background(), "other")
+	if _, err := svc.ListUsers(other, admin.AccessToken, 0, 0); err == nil {
+		t.Fatal("ListUsers() with an admin token of another tenant succeeded")
+	}
+
+	if err := svc.Logout(context.Background(), admin.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.ListUsers(context.Background(), admin.AccessToken, 0, 0); err == nil {
+		t.Fatal("ListUsers() with a logged out admin token succeeded")
+	}
+}
+
+func TestHasRoleChecksTenant(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+	admin := mustLogin(t, svc, "admin")
+
+	if ok, err := svc.keys.hasRole(admin.AccessToken, "", RoleAdmin); err != nil || !ok {
+		t.Fatalf("hasRole() = %t, %v, want true", ok, err)
+	}
+
+	if ok, err := svc.keys.hasRole(admin.AccessToken, "other", RoleAdmin); !errors.Is(err, ErrTokenInvalid) || ok {
+		t.Fatalf("hasRole() in another tenant = %t, %v, want ErrTokenInvalid", ok, err)
+	}
+}
+
+func TestListUsersPages(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+
+	for i := range 119 {
+		user := fmt.Sprintf("user%03d", i)
+		if err := svc.users.CreateUser(context.Background(), UserFields{Username: user, HashedPassword: "x", Email: user + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := mustLogin(t, svc, "admin")
+
+	var listed []string
+	for offset := 0; ; {
+		page, err := svc.ListUsers(context.Background(), admin.AccessToken, offset, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != 120 || page.Limit != DefaultUserPageLimit || len(page.Usernames) > DefaultUserPageLimit {
+			t.Fatalf("ListUsers(%d) = %+v, want 120 users in pages of %d", offset, page, DefaultUserPageLimit)
+		}
+
+		listed = append(listed, page.Usernames...)
+		offset += len(page.Usernames)
+
+		if !page.HasMore {
+			break
+		}
+	}
+
+	if len(listed) != 120 || !sort.StringsAreSorted(listed) {
+		t.Fatalf("paging listed %d users, sorted %t; want all 120 in order", len(listed), sort.StringsAreSorted(listed))
+	}
+
+	for _, bounds := range [][2]int{{-1, 10}, {0, -1}, {0, MaxUserPageLimit + 1}} {
+		if _, err := svc.ListUsers(context.Background(), admin.AccessToken, bounds[0], bounds[1]); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("ListUsers(%d, %d) error = %v, want ErrInvalidPage", bounds[0], bounds[1], err)
+		}
+	}
+}
+
+func TestSetUserActive(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+	mustRegister(t, svc, "alice")
+
+	admin := mustLogin(t, svc, "admin")
+	session := mustLogin(t, svc, "alice")
+
+	if err := svc.SetUserActive(context.Background(), session.AccessToken, "admin", false); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("SetUserActive() by a plain user error = %v, want ErrForbidden", err)
+	}
+
+	if err := svc.SetUserActive(context.Background(), admin.AccessToken, "admin", false); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("SetUserActive() of the caller's own account error = %v, want ErrForbidden", err)
+	}
+
+	if err := svc.SetUserActive(context.Background(), admin.AccessToken, "nobody", false); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("SetUserActive() of an unknown user error = %v, want ErrUserNotFound", err)
+	}
+
+	if err := svc.SetUserActive(context.Background(), admin.AccessToken, "Alice", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrAccountSuspended) {
+		t.Fatalf("Login() while suspended error = %v, want ErrAccountSuspended", err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token issued before the suspension succeeded")
+	}
+
+	if err := svc.SetUserActive(context.Background(), admin.AccessToken, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), mustLogin(t, svc, "alice").AccessToken); err != nil {
+		t.Fatalf("GetHomeState() after reactivation error = %v", err)
+	}
+}
+
+func TestCreateUserForcesPasswordChange(t *testing.T) {
+	svc := newTestService(t, WithRegistrationEnabled(false))
+	mustRegister(t, newTestServiceWithRepository(t, svc.users), "admin", RoleUser, RoleAdmin)
+	admin := mustLogin(t, svc, "admin")
+
+	if err := svc.CreateUser(context.Background(), "not-a-token", "alice", testPassword, "alice@example.com"); err == nil {
+		t.Fatal("CreateUser() without a valid token succeeded")
+	}
+
+	if err := svc.CreateUser(context.Background(), admin.AccessToken, "alice", testPassword, "alice@example.com"); err != nil {
+		t.Fatalf("CreateUser() while registration is closed error = %v", err)
+	}
+
+	if err := svc.CreateUser(context.Background(), mustLogin(t, svc, "admin").AccessToken, "bob", testPassword, "bob@example.com", RoleUser, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	restricted := mustLogin(t, svc, "alice")
+	if !restricted.MustChangePassword || restricted.RefreshToken != "" {
+		t.Fatalf("Login() with a temporary password = %+v, want MustChangePassword and no refresh token", restricted)
+	}
+
+	// The restricted token is good for ChangePassword only.
+	if _, err := svc.GetHomeState(context.Background(), restricted.AccessToken); !errors.Is(err, ErrPasswordChangeRequired) {
+		t.Fatalf("GetHomeState() with a restricted token error = %v, want ErrPasswordChangeRequired", err)
+	}
+
+	if _, err := svc.GetProfile(context.Background(), restricted.AccessToken); !errors.Is(err, ErrPasswordChangeRequired) {
+		t.Fatalf("GetProfile() with a restricted token error = %v, want ErrPasswordChangeRequired", err)
+	}
+
+	if err := svc.CreateUser(context.Background(), mustLogin(t, svc, "bob").AccessToken, "carol", testPassword, "carol@example.com"); err == nil {
+		t.Fatal("CreateUser() with the restricted token of an admin succeeded")
+	}
+
+	if err := svc.ChangePassword(context.Background(), restricted.AccessToken, testPassword, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if mustGetUser(t, svc, "alice").MustChangePassword {
+		t.Fatal("MustChangePassword still set after ChangePassword")
+	}
+
+	session, err := svc.Login(context.Background(), "alice", "n3w-password")
+	if err != nil || session.MustChangePassword || session.RefreshToken == "" {
+		t.Fatalf("Login() after the change = %+v, %v, want a full session", session, err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() after the change error = %v", err)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(24*time.Hour))
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		mustRegister(t, svc, user)
+	}
+
+	alice := []LoginResult{mustLogin(t, svc, "alice"), mustLogin(t, svc, "alice")}
+	mustLogin(t, svc, "bob")
+
+	clock.Advance(SessionStatsWindow + time.Minute)
+
+	mustLogin(t, svc, "carol")
+	admin := mustLogin(t, svc, "admin")
+
+	if _, err := svc.SessionStats(context.Background(), alice[0].AccessToken); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("SessionStats() as a plain user error = %v, want ErrForbidden", err)
+	}
+
+	want := SessionStats{Sessions: 5, Users: 4, Since: clock.Now().UTC().Add(-SessionStatsWindow), CreatedSince: 2}
+	if stats, err := svc.SessionStats(context.Background(), admin.AccessToken); err != nil || stats != want {
+		t.Fatalf("SessionStats() = %+v, %v, want %+v", stats, err, want)
+	}
+
+	// alice still holds a session after the first logout.
+	for i, users := range []int{4, 3} {
+		if err := svc.Logout(context.Background(), alice[i].AccessToken); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := svc.SessionStats(context.Background(), admin.AccessToken)
+		if err != nil || stats.Sessions != 4-i || stats.Users != users {
+			t.Fatalf("SessionStats() after %d logouts = %+v, %v, want %d sessions of %d users", i+1, stats, err, 4-i, users)
+		}
+	}
+
+	// Other tenants are counted apart.
+	other := ContextWithTenant(context.Background(), "other")
+	if _, err := svc.Register(other, "dave", testPassword, "dave@example.com", RoleUser, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	dave, err := svc.Login(other, "dave", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, err := svc.SessionStats(other, dave.AccessToken); err != nil || stats.Sessions != 1 || stats.Users != 1 {
+		t.Fatalf("SessionStats() in another tenant = %+v, %v, want only dave's session", stats, err)
+	}
+}
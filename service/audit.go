@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AuditAction names what an AuditEvent records.
+type AuditAction string
+
+const (
+	AuditRegister       AuditAction = "register"
+	AuditLogin          AuditAction = "login"
+	AuditLogout         AuditAction = "logout"
+	AuditFailedLogin    AuditAction = "failed-login"
+	AuditLockout        AuditAction = "lockout"
+	AuditPasswordChange AuditAction = "password-change"
+	AuditRename         AuditAction = "rename"
+	AuditSuspend        AuditAction = "suspend"
+	AuditReactivate     AuditAction = "reactivate"
+)
+
+// AuditEvent is one entry of the audit trail. IP is the client address
+// recorded by ContextWithClientIP, empty when the transport did not set one.
+// Failed logins carry the username as typed, which need not exist.
+type AuditEvent struct {
+	Action   AuditAction `json:"action"`
+	Username string      `json:"username"`
+	Tenant   string      `json:"tenant,omitempty"`
+	Time     time.Time   `json:"time"`
+	IP       string      `json:"ip,omitempty"`
+}
+
+// AuditSink receives the audit trail. Record is called synchronously while
+// the service holds its lock, so implementations must be quick and must not
+// call back into the service; ship events elsewhere from a goroutine.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// AuditFlusher is implemented by AuditSinks that buffer events. Close calls
+// Flush so buffered events are not lost on shutdown.
+type AuditFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+type nopAuditSink struct{}
+
+func (nopAuditSink) Record(AuditEvent) {}
+
+// MemoryAuditSink keeps every event in memory, in order. It is meant for
+// tests and local debugging.
+type MemoryAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (m *MemoryAuditSink) Record(event AuditEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, event)
+}
+
+// Events returns a copy of the events recorded so far.
+func (m *MemoryAuditSink) Events() []AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]AuditEvent(nil), m.events...)
+}
+
+func (u *userService) audit(ctx context.Context, action AuditAction, username string) {
+	u.auditSink.Record(AuditEvent{
+		Action:   action,
+		Username: username,
+		Tenant:   TenantFromContext(ctx),
+		Time:     u.clock.Now().UTC(),
+		IP:       clientIPFromContext(ctx),
+	})
+}
+
+// loginFailed counts a failed login against username and audits it, along
+// with the lockout when this failure is the one that locks the account.
+func (u *userService) loginFailed(ctx context.Context, username string) {
+	u.audit(ctx, AuditFailedLogin, username)
+
+	if u.loginAttempts.fail(tenantKey(TenantFromContext(ctx), username), u.clock.Now()) {
+		u.audit(ctx, AuditLockout, username)
+	}
+}
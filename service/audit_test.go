@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func auditActions(sink *MemoryAuditSink) []AuditAction {
+	var actions []AuditAction
+	for _, event := range sink.Events() {
+		actions = append(actions, event.Action)
+	}
+
+	return actions
+}
+
+func TestAuditLoginFailuresAndLockout(t *testing.T) {
+	sink := &MemoryAuditSink{}
+	clock := newFakeClock()
+	svc := newTestService(t, WithAuditSink(sink), WithClock(clock), WithLockoutThreshold(2))
+	mustRegister(t, svc, "alice")
+
+	ctx := ContextWithClientIP(context.Background(), "192.0.2.1")
+
+	if _, err := svc.Login(ctx, "alice", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := svc.Login(ctx, "alice", "wrong-passw0rd"); err == nil {
+			t.Fatal("Login() with a wrong password succeeded")
+		}
+	}
+
+	if _, err := svc.Login(ctx, "alice", testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Login() after the lockout error = %v, want ErrAccountLocked", err)
+	}
+
+	// The attempt against the locked account is a failed login too.
+	want := []AuditAction{AuditRegister, AuditLogin, AuditFailedLogin, AuditFailedLogin, AuditLockout, AuditFailedLogin}
+	if got := auditActions(sink); !reflect.DeepEqual(got, want) {
+		t.Fatalf("audited %v, want %v", got, want)
+	}
+
+	for _, event := range sink.Events()[1:] {
+		if event.Username != "alice" || event.IP != "192.0.2.1" || !event.Time.Equal(clock.Now().UTC()) {
+			t.Errorf("event = %+v, want alice from 192.0.2.1 at %s", event, clock.Now().UTC())
+		}
+	}
+}
+
+func TestAuditSessionAndPasswordActions(t *testing.T) {
+	sink := &MemoryAuditSink{}
+	svc := newTestService(t, WithAuditSink(sink))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := svc.Login(context.Background(), "alice", "n3w-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Logout(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []AuditAction{AuditRegister, AuditLogin, AuditPasswordChange, AuditLogin, AuditLogout}
+	if got := auditActions(sink); !reflect.DeepEqual(got, want) {
+		t.Fatalf("audited %v, want %v", got, want)
+	}
+}
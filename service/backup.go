@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// exportPageSize is how many usernames ExportUsers lists per repository call.
+const exportPageSize = 500
+
+// userExport is the document ExportUsers writes and ImportUsers reads.
+type userExport struct {
+	Users []exportedUser `json:"users"`
+}
+
+type exportedUser struct {
+	Username       string    `json:"username"`
+	Tenant         string    `json:"tenant,omitempty"`
+	HashedPassword string    `json:"hashed_password"`
+	Email          string    `json:"email,omitempty"`
+	EmailVerified  bool      `json:"email_verified"`
+	Roles          []string  `json:"roles"`
+	TOTPSecret     string    `json:"totp_secret,omitempty"`
+	TOTPEnabled    bool      `json:"totp_enabled"`
+	CreatedAt      time.Time `json:"created_at,omitzero"`
+	LastLoginAt    time.Time `json:"last_login_at,omitzero"`
+	Suspended      bool      `json:"suspended"`
+	// Passkeys hold public keys only, nothing that lets anyone log in.
+	PasskeyUserID []byte                `json:"passkey_user_id,omitempty"`
+	Passkeys      []webauthn.Credential `json:"passkeys,omitempty"`
+	OAuthProvider string                `json:"oauth_provider,omitempty"`
+	OAuthSubject  string                `json:"oauth_subject,omitempty"`
+	// RecoveryCodeHashes are SHA-256 hashes of random codes, useless
+	// without the codes themselves.
+	RecoveryCodeHashes []string  `json:"recovery_code_hashes,omitempty"`
+	PasswordChangedAt  time.Time `json:"password_changed_at,omitzero"`
+	PasswordHistory    []string  `json:"password_history,omitempty"`
+	MustChangePassword bool      `json:"must_change_password,omitempty"`
+}
+
+// ExportUsers writes every user of the tenant of ctx in users to w as JSON,
+// for backups and for moving accounts between repositories.
+//
+// The export holds password hashes and TOTP secrets in the clear: anyone
+// reading it can attack the hashes offline and generate second factors, so
+// it must be stored and moved as carefully as the database itself. Hashes
+// made with WithPepper only verify again under the same pepper.
+func ExportUsers(ctx context.Context, users UserRepository, w io.Writer) error {
+	export := userExport{Users: []exportedUser{}}
+
+	for offset := 0; ; offset += exportPageSize {
+		usernames, total, err := users.ListUsernames(ctx, offset, exportPageSize)
+		if err != nil {
+			return fmt.Errorf("error while listing users: %w", err)
+		}
+
+		for _, username := range usernames {
+			user, err := users.GetUser(ctx, username)
+			if errors.Is(err, ErrUserNotFound) {
+				// Deleted since it was listed.
+				continue
+			}
+
+			if err != nil {
+				return fmt.Errorf("error while reading user %q: %w", username, err)
+			}
+
+			export.Users = append(export.Users, exportedUser(user))
+		}
+
+		if len(usernames) == 0 || offset+len(usernames) >= total {
+			break
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		return fmt.Errorf("error while encoding users: %w", err)
+	}
+
+	return nil
+}
+
+// ImportUsers saves the users of an ExportUsers document read from r into
+// users and reports how many were written. Usernames that already exist are
+// left untouched when skipExisting is set; otherwise the first one aborts
+// the import with ErrUserAlreadyExists, leaving the users before it saved.
+// Every entry is validated before anything is written. Users are imported
+// into the tenant recorded in the document, whatever the tenant of ctx.
+func ImportUsers(ctx context.Context, users UserRepository, r io.Reader, skipExisting bool) (int, error) {
+	var export userExport
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&export); err != nil {
+		return 0, fmt.Errorf("error while decoding users: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(export.Users))
+
+	for i, entry := range export.Users {
+		username := normalizeUsername(entry.Username)
+		if username == "" {
+			return 0, fmt.Errorf("%w: user %d has no username", ErrInvalidUsername, i)
+		}
+
+		tenant := normalizeTenant(entry.Tenant)
+		if err := validateTenant(tenant); err != nil {
+			return 0, fmt.Errorf("user %q: %w", username, err)
+		}
+
+		key := tenantKey(tenant, username)
+		if _, ok := seen[key]; ok {
+			return 0, fmt.Errorf("%w: %q appears twice in the import", ErrUserAlreadyExists, key)
+		}
+
+		seen[key] = struct{}{}
+
+		if entry.HashedPassword == "" {
+			return 0, fmt.Errorf("user %q has no password hash", username)
+		}
+
+		roles, err := normalizeRoles(entry.Roles)
+		if err != nil {
+			return 0, fmt.Errorf("user %q: %w", username, err)
+		}
+
+		export.Users[i].Username = username
+		export.Users[i].Tenant = tenant
+		export.Users[i].Roles = roles
+	}
+
+	imported := 0
+
+	for _, entry := range export.Users {
+		err := users.CreateUser(ctx, UserFields(entry))
+		if errors.Is(err, ErrUserAlreadyExists) {
+			if skipExisting {
+				continue
+			}
+
+			return imported, fmt.Errorf("%w: %q", ErrUserAlreadyExists, entry.Username)
+		}
+
+		if err != nil {
+			return imported, fmt.Errorf("error while saving user %q: %w", entry.Username, err)
+		}
+
+		imported++
+	}
+
+	return imported, nil
+}
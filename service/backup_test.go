@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice", RoleUser, RoleAdmin)
+	mustRegister(t, svc, "bob")
+
+	var backup bytes.Buffer
+	if err := ExportUsers(context.Background(), svc.users, &backup); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMemoryUserRepository()
+
+	imported, err := ImportUsers(context.Background(), restored, bytes.NewReader(backup.Bytes()), false)
+	if err != nil || imported != 2 {
+		t.Fatalf("ImportUsers() = %d, %v, want 2", imported, err)
+	}
+
+	fresh := newTestServiceWithRepository(t, restored)
+	for _, user := range []string{"alice", "bob"} {
+		mustLogin(t, fresh, user)
+	}
+
+	alice := mustGetUser(t, fresh, "alice")
+	if alice.Email != "alice@example.com" || len(alice.Roles) != 2 {
+		t.Fatalf("restored alice = %+v", alice)
+	}
+
+	// Importing again fails on the first duplicate unless told to skip.
+	if _, err := ImportUsers(context.Background(), restored, bytes.NewReader(backup.Bytes()), false); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("second ImportUsers() error = %v, want ErrUserAlreadyExists", err)
+	}
+
+	if imported, err := ImportUsers(context.Background(), restored, bytes.NewReader(backup.Bytes()), true); err != nil || imported != 0 {
+		t.Fatalf("ImportUsers() skipping existing users = %d, %v, want 0", imported, err)
+	}
+}
+
+func TestImportUsersValidatesBeforeWriting(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no username":   `{"users": [{"username": "alice", "hashed_password": "x"}, {"username": "", "hashed_password": "x"}]}`,
+		"no hash":       `{"users": [{"username": "alice", "hashed_password": "x"}, {"username": "bob"}]}`,
+		"duplicate":     `{"users": [{"username": "alice", "hashed_password": "x"}, {"username": "ALICE", "hashed_password": "x"}]}`,
+		"unknown field": `{"users": [{"username": "alice", "hashed_password": "x", "password": "plain"}]}`,
+		"malformed":     `{"users": [`,
+	} {
+		users := NewMemoryUserRepository()
+
+		if _, err := ImportUsers(context.Background(), users, strings.NewReader(doc), false); err == nil {
+			t.Errorf("%s: ImportUsers() succeeded", name)
+		}
+
+		if _, err := users.GetUser(context.Background(), "alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("%s: ImportUsers() wrote alice before failing", name)
+		}
+	}
+}
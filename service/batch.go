@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// NewUser is one account to create through RegisterBatch.
+type NewUser struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles,omitempty"`
+	// MustChangePassword marks Password as temporary, see
+	// UserFields.MustChangePassword.
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+}
+
+// BatchEntryResult reports what happened to the NewUser at the same index.
+// Username is normalized once the entry got that far, and Err is nil when
+// the account was created.
+type BatchEntryResult struct {
+	Username string
+	Err      error
+}
+
+// BatchResult is the outcome of RegisterBatch, one entry per NewUser in the
+// order they were given.
+type BatchResult struct {
+	Entries []BatchEntryResult
+	Created int
+}
+
+// RegisterBatch creates many accounts at once, typically to seed a
+// development or test environment. Every entry goes through the same checks
+// as Register, and a failing entry, a duplicate username included, is
+// reported in its BatchEntryResult without stopping the others. Passwords
+// are hashed concurrently by a pool bounded by GOMAXPROCS before the lock is
+// taken. The returned error is reserved for failures of the whole batch:
+// registration being disabled or invite-only, or ctx ending.
+func (u *userService) RegisterBatch(ctx context.Context, users []NewUser) (BatchResult, error) {
+	if u.inviteOnly {
+		return BatchResult{}, ErrInviteRequired
+	}
+
+	prepared := make([]UserFields, len(users))
+	result := BatchResult{Entries: make([]BatchEntryResult, len(users))}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(users)); w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				prepared[i], result.Entries[i] = u.prepareBatchEntry(ctx, users[i])
+			}
+		}()
+	}
+
+	for i := range users {
+		if ctx.Err() != nil {
+			break
+		}
+
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return BatchResult{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.registrationDisabled {
+		return BatchResult{}, ErrRegistrationDisabled
+	}
+
+	seen := make(map[string]struct{}, len(users))
+
+	for i, userFields := range prepared {
+		entry := &result.Entries[i]
+		if entry.Err != nil {
+			continue
+		}
+
+		if _, ok := seen[userFields.Username]; ok {
+			entry.Err = fmt.Errorf("%w: %q appears twice in the batch", ErrUserAlreadyExists, userFields.Username)
+
+			continue
+		}
+
+		seen[userFields.Username] = struct{}{}
+
+		if err := u.checkUsernameFree(ctx, userFields.Username); err != nil {
+			entry.Err = err
+
+			continue
+		}
+
+		// Emails repeated within the batch are caught here too, since the
+		// entries before were already saved.
+		if err := u.checkEmailFree(ctx, userFields.Email); err != nil {
+			entry.Err = err
+
+			continue
+		}
+
+		userFields.CreatedAt = u.clock.Now().UTC()
+		u.setPassword(&userFields, userFields.HashedPassword, userFields.CreatedAt)
+
+		if err := u.users.CreateUser(ctx, userFields); err != nil {
+			entry.Err = fmt.Errorf("error while saving user: %w", err)
+
+			continue
+		}
+
+		u.audit(ctx, AuditRegister, userFields.Username)
+		u.registrations.Add(1)
+		result.Created++
+	}
+
+	return result, nil
+}
+
+// prepareBatchEntry validates and hashes one entry of RegisterBatch.
+func (u *userService) prepareBatchEntry(ctx context.Context, user NewUser) (UserFields, BatchEntryResult) {
+	userFields, err := u.newUser(ctx, user.Username, user.Password, user.Email, user.Roles)
+	if err != nil {
+		return UserFields{}, BatchEntryResult{Username: user.Username, Err: err}
+	}
+
+	userFields.MustChangePassword = user.MustChangePassword
+
+	return userFields, BatchEntryResult{Username: userFields.Username}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegisterBatch(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "taken")
+
+	result, err := svc.RegisterBatch(context.Background(), []NewUser{
+		{Username: "Alice", Password: testPassword, Email: "alice@example.com", Roles: []string{RoleUser, RoleAdmin}},
+		{Username: "taken", Password: testPassword, Email: "taken2@example.com"},
+		{Username: "bob", Password: "short", Email: "bob@example.com"},
+		{Username: "carol", Password: testPassword, Email: "carol@example.com"},
+		{Username: "alice", Password: testPassword, Email: "alice2@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created != 2 || len(result.Entries) != 5 {
+		t.Fatalf("RegisterBatch() = %+v, want 2 of 5 created", result)
+	}
+
+	for i, want := range []error{nil, ErrUserAlreadyExists, ErrWeakPassword, nil, ErrUserAlreadyExists} {
+		entry := result.Entries[i]
+		if !errors.Is(entry.Err, want) {
+			t.Errorf("entry %d (%s) error = %v, want %v", i, entry.Username, entry.Err, want)
+		}
+	}
+
+	if result.Entries[0].Username != "alice" {
+		t.Errorf("entry 0 username = %q, want it normalized to alice", result.Entries[0].Username)
+	}
+
+	for _, user := range []string{"alice", "carol"} {
+		mustLogin(t, svc, user)
+	}
+
+	if _, err := svc.Login(context.Background(), "bob", "short"); err == nil {
+		t.Fatal("Login() as the rejected bob succeeded")
+	}
+
+	if roles := mustGetUser(t, svc, "alice").Roles; len(roles) != 2 {
+		t.Fatalf("alice's roles = %v, want user and admin", roles)
+	}
+}
+
+func TestRegisterBatchWholeBatchFailures(t *testing.T) {
+	batch := []NewUser{{Username: "alice", Password: testPassword, Email: "alice@example.com"}}
+
+	if _, err := newTestService(t, WithRegistrationEnabled(false)).RegisterBatch(context.Background(), batch); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Errorf("RegisterBatch() while disabled error = %v, want ErrRegistrationDisabled", err)
+	}
+
+	if _, err := newTestService(t, WithInviteOnly()).RegisterBatch(context.Background(), batch); !errors.Is(err, ErrInviteRequired) {
+		t.Errorf("RegisterBatch() while invite-only error = %v, want ErrInviteRequired", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := newTestService(t).RegisterBatch(ctx, batch); !errors.Is(err, context.Canceled) {
+		t.Errorf("RegisterBatch() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
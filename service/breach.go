@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// DefaultHIBPRangeURL is the Have I Been Pwned range API the checker returned
+// by NewHIBPBreachChecker queries.
+const DefaultHIBPRangeURL = "https://api.pwnedpasswords.com/range/"
+
+// ErrBreachedPassword is returned by Register, ChangePassword and
+// ResetPassword when the new password shows up in known data breaches more
+// often than the configured threshold allows.
+var ErrBreachedPassword = errors.New("password appears in a known data breach")
+
+// BreachChecker reports how many times a password was seen in data breaches.
+type BreachChecker interface {
+	PwnedCount(ctx context.Context, password string) (int, error)
+}
+
+type hibpBreachChecker struct {
+	client   *http.Client
+	rangeURL string
+}
+
+// NewHIBPBreachChecker returns a BreachChecker backed by the Have I Been
+// Pwned range API. Only the first 5 hex characters of the password's SHA-1
+// ever leave the process; the match against the returned suffixes happens
+// locally. A nil client uses one with a 5 second timeout.
+func NewHIBPBreachChecker(client *http.Client) BreachChecker {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &hibpBreachChecker{client: client, rangeURL: DefaultHIBPRangeURL}
+}
+
+func (h *hibpBreachChecker) PwnedCount(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.rangeURL+prefix, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error while building breach check request: %w", err)
+	}
+
+	// Padding hides the real number of suffixes sharing the prefix from
+	// anyone watching response sizes.
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error while querying breach API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected breach API status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		hashSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(hashSuffix, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("error while parsing breach API count: %w", err)
+		}
+
+		return n, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error while reading breach API response: %w", err)
+	}
+
+	return 0, nil
+}
+
+// checkBreached rejects pass when the BreachChecker has seen it more than
+// breachThreshold times. A checker error is logged and then handled under
+// the IntegrationBreachCheck FailurePolicy set by WithFailurePolicy: FailOpen,
+// the default, allows the password, while FailClosed fails the request with
+// ErrIntegrationUnavailable. Callers run it before taking u.mu so a slow
+// breach API never stalls other requests.
+func (u *userService) checkBreached(ctx context.Context, pass string) error {
+	if u.breachChecker == nil {
+		return nil
+	}
+
+	count, err := u.breachChecker.PwnedCount(ctx, pass)
+	if err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while checking password against breaches", "err", err)
+
+		return u.integrationFailed(IntegrationBreachCheck, err)
+	}
+
+	u.integrations.record(IntegrationBreachCheck, nil)
+
+	if count > u.breachThreshold {
+		return ErrBreachedPassword
+	}
+
+	return nil
+}
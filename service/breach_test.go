@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// breachCheckerFunc adapts a function to BreachChecker.
+type breachCheckerFunc func(ctx context.Context, password string) (int, error)
+
+func (f breachCheckerFunc) PwnedCount(ctx context.Context, password string) (int, error) {
+	return f(ctx, password)
+}
+
+func TestCheckBreachedFailurePolicy(t *testing.T) {
+	unreachable := breachCheckerFunc(func(context.Context, string) (int, error) {
+		return 0, errors.New("breach API unreachable")
+	})
+
+	for _, tc := range []struct {
+		policy FailurePolicy
+		want   error
+	}{
+		{FailOpen, nil},
+		{FailClosed, ErrIntegrationUnavailable},
+	} {
+		svc := newTestService(t, WithBreachChecker(unreachable, 0), WithFailurePolicy(IntegrationBreachCheck, tc.policy))
+
+		if err := svc.checkBreached(context.Background(), testPassword); !errors.Is(err, tc.want) {
+			t.Errorf("%s: checkBreached() error = %v, want %v", tc.policy, err, tc.want)
+		}
+	}
+}
+
+func TestCheckBreachedThreshold(t *testing.T) {
+	seen := breachCheckerFunc(func(context.Context, string) (int, error) {
+		return 3, nil
+	})
+
+	if err := newTestService(t, WithBreachChecker(seen, 2)).checkBreached(context.Background(), testPassword); !errors.Is(err, ErrBreachedPassword) {
+		t.Fatalf("checkBreached() above the threshold error = %v, want ErrBreachedPassword", err)
+	}
+
+	if err := newTestService(t, WithBreachChecker(seen, 3)).checkBreached(context.Background(), testPassword); err != nil {
+		t.Fatalf("checkBreached() at the threshold error = %v", err)
+	}
+}
+
+// TestChangePasswordChecksBreachAfterAuth checks ChangePassword does not send
+// the new password to the breach API for a caller it has not authenticated.
+func TestChangePasswordChecksBreachAfterAuth(t *testing.T) {
+	var checks atomic.Int64
+
+	counting := breachCheckerFunc(func(context.Context, string) (int, error) {
+		checks.Add(1)
+
+		return 0, nil
+	})
+
+	svc := newTestService(t, WithBreachChecker(counting, 0))
+	mustRegister(t, svc, "alice")
+	login := mustLogin(t, svc, "alice")
+	checks.Store(0)
+
+	if err := svc.ChangePassword(context.Background(), "not-a-token", testPassword, "new-passw0rd"); err == nil {
+		t.Fatal("ChangePassword() with an invalid token succeeded")
+	}
+
+	if err := svc.ChangePassword(context.Background(), login.AccessToken, "wrong-passw0rd", "new-passw0rd"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatalf("ChangePassword() with a wrong password error = %v, want ErrIncorrectPassword", err)
+	}
+
+	if n := checks.Load(); n != 0 {
+		t.Fatalf("%d breach checks before authenticating, want 0", n)
+	}
+
+	if err := svc.ChangePassword(context.Background(), login.AccessToken, testPassword, "new-passw0rd"); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+
+	if n := checks.Load(); n != 1 {
+		t.Fatalf("%d breach checks after changing the password, want 1", n)
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper, standing in for
+// the breach API.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHIBPBreachChecker(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+	var requested []string
+
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+
+		if req.Header.Get("Add-Padding") != "true" {
+			t.Errorf("request without the Add-Padding header")
+		}
+
+		body := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:0\r\n"
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	checker := NewHIBPBreachChecker(client)
+
+	count, err := checker.PwnedCount(context.Background(), "password")
+	if err != nil || count != 3861493 {
+		t.Fatalf("PwnedCount(password) = %d, %v, want 3861493", count, err)
+	}
+
+	if len(requested) != 1 || requested[0] != DefaultHIBPRangeURL+"5BAA6" {
+		t.Fatalf("requested %v, want only the 5 character prefix", requested)
+	}
+
+	if count, err := checker.PwnedCount(context.Background(), "c0rrect-h0rse-battery"); err != nil || count != 0 {
+		t.Fatalf("PwnedCount() of an unseen password = %d, %v, want 0", count, err)
+	}
+}
+
+func TestHIBPBreachCheckerErrors(t *testing.T) {
+	for name, transport := range map[string]roundTripperFunc{
+		"unreachable": func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		},
+		"failing": func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+		},
+	} {
+		checker := NewHIBPBreachChecker(&http.Client{Transport: transport})
+
+		if _, err := checker.PwnedCount(context.Background(), "password"); err == nil {
+			t.Errorf("%s API: PwnedCount() succeeded", name)
+		}
+
+		// Registration fails open by default.
+		svc := newTestService(t, WithBreachChecker(checker, 0))
+		if _, err := svc.Register(context.Background(), "alice", testPassword, "alice@example.com"); err != nil {
+			t.Errorf("%s API: Register() error = %v, want the check to fail open", name, err)
+		}
+	}
+}
+
+func TestBreachedPasswordsRejected(t *testing.T) {
+	const breached = "breached-passw0rd"
+
+	checker := breachCheckerFunc(func(_ context.Context, password string) (int, error) {
+		if password == breached {
+			return 10, nil
+		}
+
+		return 0, nil
+	})
+
+	svc := newTestService(t, WithBreachChecker(checker, 0))
+
+	if _, err := svc.Register(context.Background(), "bob", breached, "bob@example.com"); !errors.Is(err, ErrBreachedPassword) {
+		t.Fatalf("Register() with a breached password error = %v, want ErrBreachedPassword", err)
+	}
+
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, breached); !errors.Is(err, ErrBreachedPassword) {
+		t.Fatalf("ChangePassword() to a breached password error = %v, want ErrBreachedPassword", err)
+	}
+
+	resetToken, err := svc.RequestPasswordReset(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), resetToken, breached); !errors.Is(err, ErrBreachedPassword) {
+		t.Fatalf("ResetPassword() to a breached password error = %v, want ErrBreachedPassword", err)
+	}
+}
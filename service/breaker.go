@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// ErrStoreUnavailable is returned by a circuit broken SessionStore while the
+// breaker is open, without calling the store at all.
+var ErrStoreUnavailable = errors.New("session store unavailable")
+
+// BreakerSettings configures NewCircuitBreakerSessionStore. The breaker opens
+// after FailureThreshold consecutive failures, fails fast for OpenTimeout,
+// then lets HalfOpenRequests calls through to probe the store: it closes
+// again once they all succeed and reopens on the first failure.
+type BreakerSettings struct {
+	FailureThreshold uint32
+	OpenTimeout      time.Duration
+	HalfOpenRequests uint32
+}
+
+// DefaultBreakerSettings opens after 5 consecutive failures and probes again
+// after 30 seconds with a single request.
+func DefaultBreakerSettings() BreakerSettings {
+	return BreakerSettings{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenRequests: 1,
+	}
+}
+
+type breakerSessionStore struct {
+	next    SessionStore
+	breaker *gobreaker.CircuitBreaker
+}
+
+// NewCircuitBreakerSessionStore guards next, typically the Redis store, so
+// that while it is down requests fail fast with ErrStoreUnavailable instead
+// of each waiting for its own timeout. ErrSessionNotFound and cancelled
+// contexts are normal outcomes and do not count as failures. Ping goes
+// through the breaker too, so readiness reports the outage right away.
+func NewCircuitBreakerSessionStore(next SessionStore, settings BreakerSettings) (SessionStore, error) {
+	if settings.FailureThreshold < 1 {
+		return nil, fmt.Errorf("breaker failure threshold must be at least 1, got %d", settings.FailureThreshold)
+	}
+
+	if settings.OpenTimeout <= 0 {
+		return nil, fmt.Errorf("breaker open timeout must be positive, got %s", settings.OpenTimeout)
+	}
+
+	if settings.HalfOpenRequests < 1 {
+		return nil, fmt.Errorf("breaker half-open requests must be at least 1, got %d", settings.HalfOpenRequests)
+	}
+
+	breaker := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "session-store",
+		MaxRequests: settings.HalfOpenRequests,
+		Timeout:     settings.OpenTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= settings.FailureThreshold
+		},
+		IsSuccessful: func(err error) bool {
+			return err == nil || errors.Is(err, ErrSessionNotFound) || errors.Is(err, context.Canceled)
+		},
+	})
+
+	return &breakerSessionStore{next: next, breaker: breaker}, nil
+}
+
+func (b *breakerSessionStore) call(fn func() error) error {
+	_, err := b.breaker.Execute(func() (interface{}, error) {
+		return nil, fn()
+	})
+
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return fmt.Errorf("%w: %v", ErrStoreUnavailable, err)
+	}
+
+	return err
+}
+
+func (b *breakerSessionStore) Get(ctx context.Context, sessionID string) (Session, error) {
+	var session Session
+
+	err := b.call(func() (err error) {
+		session, err = b.next.Get(ctx, sessionID)
+
+		return err
+	})
+
+	return session, err
+}
+
+func (b *breakerSessionStore) Set(ctx context.Context, session Session, ttl time.Duration) error {
+	return b.call(func() error {
+		return b.next.Set(ctx, session, ttl)
+	})
+}
+
+func (b *breakerSessionStore) Touch(ctx context.Context, session Session, ttl time.Duration) error {
+	return b.call(func() error {
+		return b.next.Touch(ctx, session, ttl)
+	})
+}
+
+func (b *breakerSessionStore) Delete(ctx context.Context, sessionID string) error {
+	return b.call(func() error {
+		return b.next.Delete(ctx, sessionID)
+	})
+}
+
+func (b *breakerSessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	var n int
+
+	err := b.call(func() (err error) {
+		n, err = b.next.DeleteUserSessions(ctx, username)
+
+		return err
+	})
+
+	return n, err
+}
+
+func (b *breakerSessionStore) ListUserSessions(ctx context.Context, username string) ([]Session, error) {
+	var sessions []Session
+
+	err := b.call(func() (err error) {
+		sessions, err = b.next.ListUserSessions(ctx, username)
+
+		return err
+	})
+
+	return sessions, err
+}
+
+func (b *breakerSessionStore) SessionStats(ctx context.Context, since time.Time) (SessionStats, error) {
+	var stats SessionStats
+
+	err := b.call(func() (err error) {
+		stats, err = b.next.SessionStats(ctx, since)
+
+		return err
+	})
+
+	return stats, err
+}
+
+func (b *breakerSessionStore) Ping(ctx context.Context) error {
+	return b.call(func() error {
+		return b.next.Ping(ctx)
+	})
+}
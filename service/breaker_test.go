@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flappySessionStore fails Ping while down is set and counts the calls that
+// reach it.
+type flappySessionStore struct {
+	SessionStore
+	down  atomic.Bool
+	calls atomic.Int64
+}
+
+func (s *flappySessionStore) Ping(ctx context.Context) error {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return syscall.ECONNREFUSED
+	}
+
+	return s.SessionStore.Ping(ctx)
+}
+
+func TestCircuitBreakerSessionStore(t *testing.T) {
+	flappy := &flappySessionStore{SessionStore: NewMemorySessionStore()}
+
+	store, err := NewCircuitBreakerSessionStore(flappy, BreakerSettings{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// Closed: failures reach the store until the threshold trips it.
+	flappy.down.Store(true)
+	for range 2 {
+		if err := store.Ping(ctx); !errors.Is(err, syscall.ECONNREFUSED) {
+			t.Fatalf("Ping() while closed error = %v, want ECONNREFUSED", err)
+		}
+	}
+
+	// Open: calls fail fast without reaching the store.
+	if err := store.Ping(ctx); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("Ping() while open error = %v, want ErrStoreUnavailable", err)
+	}
+
+	if n := flappy.calls.Load(); n != 2 {
+		t.Fatalf("%d calls reached the store, want the open breaker to stop the third", n)
+	}
+
+	// Half-open: a failed probe opens the breaker again.
+	time.Sleep(30 * time.Millisecond)
+
+	if err := store.Ping(ctx); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("half-open probe error = %v, want ECONNREFUSED", err)
+	}
+
+	if err := store.Ping(ctx); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("Ping() after a failed probe error = %v, want ErrStoreUnavailable", err)
+	}
+
+	// Half-open: a successful probe closes it.
+	flappy.down.Store(false)
+	time.Sleep(30 * time.Millisecond)
+
+	for range 3 {
+		if err := store.Ping(ctx); err != nil {
+			t.Fatalf("Ping() after recovery error = %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresMissingSessions(t *testing.T) {
+	store, err := NewCircuitBreakerSessionStore(NewMemorySessionStore(), BreakerSettings{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Hour,
+		HalfOpenRequests: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := store.Get(context.Background(), "missing"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() error = %v, want ErrSessionNotFound", err)
+		}
+	}
+}
+
+func TestCircuitBreakerSettingsValidated(t *testing.T) {
+	for _, settings := range []BreakerSettings{
+		{FailureThreshold: 0, OpenTimeout: time.Second, HalfOpenRequests: 1},
+		{FailureThreshold: 1, OpenTimeout: 0, HalfOpenRequests: 1},
+		{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 0},
+	} {
+		if _, err := NewCircuitBreakerSessionStore(NewMemorySessionStore(), settings); err == nil {
+			t.Errorf("NewCircuitBreakerSessionStore(%+v) succeeded", settings)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cachePruneInterval is how many inserts go by between sweeps of expired
+// cache entries.
+const cachePruneInterval = 1024
+
+type cacheEntry struct {
+	// user is the tenantKey of the token's user.
+	user    string
+	expires time.Time
+
+	introspection    Introspection
+	hasIntrospection bool
+	profile          Profile
+	hasProfile       bool
+}
+
+type cachingMiddleware struct {
+	UserService
+
+	ttl   time.Duration
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	inserts int
+	// generation counts the invalidations. Results fetched across one are
+	// not stored, as they may predate it.
+	generation uint64
+}
+
+// NewCachingMiddleware memoizes the results of IntrospectToken and
+// GetProfile per tenant and token for ttl, and never past the token's own
+// exp. Only successful results for active tokens are kept. A successful
+// Logout drops the entry of its token; a successful ChangePassword,
+// RevokeAllSessions, RevokeSession, DeleteAccount or RenameUsername drops
+// every entry of the token's user, and a successful ResetPassword, which does
+// not say whose password it reset, drops them all. Suspending a user through
+// SetUserActive drops the entries of that user. Results still being fetched
+// when entries are dropped are not cached, so a token logged out meanwhile
+// does not come back as active. Sessions ending any other way, such as
+// expiring or being evicted, are noticed once the entry expires, and cached
+// hits do not slide the session's idle timeout: keep ttl short. A nil clock
+// uses the wall clock.
+func NewCachingMiddleware(ttl time.Duration, clock Clock) Middleware {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return func(next UserService) UserService {
+		return &cachingMiddleware{
+			UserService: next,
+			ttl:         ttl,
+			clock:       clock,
+			entries:     make(map[string]*cacheEntry),
+		}
+	}
+}
+
+func (mw *cachingMiddleware) IntrospectToken(ctx context.Context, token string) (Introspection, error) {
+	if entry, ok := mw.get(ctx, token); ok && entry.hasIntrospection {
+		return entry.introspection, nil
+	}
+
+	generation := mw.currentGeneration()
+
+	introspection, err := mw.UserService.IntrospectToken(ctx, token)
+	if err != nil || !introspection.Active {
+		return introspection, err
+	}
+
+	mw.put(ctx, generation, token, introspection.Username, introspection.ExpiresAt, func(entry *cacheEntry) {
+		entry.introspection = introspection
+		entry.hasIntrospection = true
+	})
+
+	return introspection, nil
+}
+
+func (mw *cachingMiddleware) GetProfile(ctx context.Context, token string) (Profile, error) {
+	if entry, ok := mw.get(ctx, token); ok && entry.hasProfile {
+		return entry.profile, nil
+	}
+
+	generation := mw.currentGeneration()
+
+	profile, err := mw.UserService.GetProfile(ctx, token)
+	if err != nil {
+		return profile, err
+	}
+
+	// A Profile does not say when its token expires; the token's
+	// introspection, itself cached, does.
+	introspection, err := mw.IntrospectToken(ctx, token)
+	if err != nil || !introspection.Active {
+		return profile, nil
+	}
+
+	mw.put(ctx, generation, token, profile.Username, introspection.ExpiresAt, func(entry *cacheEntry) {
+		entry.profile = profile
+		entry.hasProfile = true
+	})
+
+	return profile, nil
+}
+
+func (mw *cachingMiddleware) Logout(ctx context.Context, token string) error {
+	if err := mw.UserService.Logout(ctx, token); err != nil {
+		return err
+	}
+
+	key := cacheKey(ctx, token)
+	mw.invalidate(func(k string, _ *cacheEntry) bool { return k == key })
+
+	return nil
+}
+
+func (mw *cachingMiddleware) ChangePassword(ctx context.Context, token, oldPass, newPass string) error {
+	user := mw.userOf(ctx, token)
+	if err := mw.UserService.ChangePassword(ctx, token, oldPass, newPass); err != nil {
+		return err
+	}
+
+	mw.invalidateUser(user)
+
+	return nil
+}
+
+func (mw *cachingMiddleware) RevokeAllSessions(ctx context.Context, token string) error {
+	user := mw.userOf(ctx, token)
+	if err := mw.UserService.RevokeAllSessions(ctx, token); err != nil {
+		return err
+	}
+
+	mw.invalidateUser(user)
+
+	return nil
+}
+
+func (mw *cachingMiddleware) RevokeSession(ctx context.Context, token, sessionID string) error {
+	user := mw.userOf(ctx, token)
+	if err := mw.UserService.RevokeSession(ctx, token, sessionID); err != nil {
+		return err
+	}
+
+	mw.invalidateUser(user)
+
+	return nil
+}
+
+func (mw *cachingMiddleware) DeleteAccount(ctx context.Context, token, password string) error {
+	user := mw.userOf(ctx, token)
+	if err := mw.UserService.DeleteAccount(ctx, token, password); err != nil {
+		return err
+	}
+
+	mw.invalidateUser(user)
+
+	return nil
+}
+
+func (mw *cachingMiddleware) RenameUsername(ctx context.Context, token, newUsername string) error {
+	user := mw.userOf(ctx, token)
+	if err := mw.UserService.RenameUsername(ctx, token, newUsername); err != nil {
+		return err
+	}
+
+	mw.invalidateUser(user)
+
+	return nil
+}
+
+func (mw *cachingMiddleware) SetUserActive(ctx context.Context, token, username string, active bool) error {
+	if err := mw.UserService.SetUserActive(ctx, token, username, active); err != nil || active {
+		return err
+	}
+
+	mw.invalidateUser(tenantKey(TenantFromContext(ctx), normalizeUsername(username)))
+
+	return nil
+}
+
+// ResetPassword drops every entry once a reset succeeded. That takes a
+// valid reset token, which only the account's owner is mailed.
+func (mw *cachingMiddleware) ResetPassword(ctx context.Context, resetToken, newPass string) error {
+	if err := mw.UserService.ResetPassword(ctx, resetToken, newPass); err != nil {
+		return err
+	}
+
+	mw.invalidate(func(string, *cacheEntry) bool { return true })
+
+	return nil
+}
+
+// cacheKey scopes token to the tenant of ctx, so a token is never answered
+// from the cache under a tenant it was not issued for.
+func cacheKey(ctx context.Context, token string) string {
+	return tenantKey(TenantFromContext(ctx), token)
+}
+
+func (mw *cachingMiddleware) get(ctx context.Context, token string) (cacheEntry, bool) {
+	key := cacheKey(ctx, token)
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	entry, ok := mw.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	if !mw.clock.Now().Before(entry.expires) {
+		delete(mw.entries, key)
+
+		return cacheEntry{}, false
+	}
+
+	return *entry, true
+}
+
+// currentGeneration returns the generation to pass to put for a result
+// about to be fetched.
+func (mw *cachingMiddleware) currentGeneration() uint64 {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	return mw.generation
+}
+
+// put records a result for token, which expires at tokenExpires, through
+// set, creating the entry if needed. Tokens without an expiry are not
+// cached, and neither are results fetched before generation ended.
+func (mw *cachingMiddleware) put(ctx context.Context, generation uint64, token, username string, tokenExpires time.Time, set func(*cacheEntry)) {
+	if tokenExpires.IsZero() {
+		return
+	}
+
+	now := mw.clock.Now()
+
+	expires := now.Add(mw.ttl)
+	if tokenExpires.Before(expires) {
+		expires = tokenExpires
+	}
+
+	if !now.Before(expires) {
+		return
+	}
+
+	key := cacheKey(ctx, token)
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if mw.generation != generation {
+		return
+	}
+
+	entry, ok := mw.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		entry = &cacheEntry{user: tenantKey(TenantFromContext(ctx), username), expires: expires}
+		mw.entries[key] = entry
+
+		mw.inserts++
+		if mw.inserts%cachePruneInterval == 0 {
+			mw.prune(now)
+		}
+	}
+
+	set(entry)
+}
+
+// userOf returns the tenantKey of the user token belongs to, from the cache
+// or else from the wrapped service, or "" when the token is not active.
+func (mw *cachingMiddleware) userOf(ctx context.Context, token string) string {
+	mw.mu.Lock()
+	entry, ok := mw.entries[cacheKey(ctx, token)]
+	mw.mu.Unlock()
+
+	if ok {
+		return entry.user
+	}
+
+	introspection, err := mw.UserService.IntrospectToken(ctx, token)
+	if err != nil || !introspection.Active {
+		return ""
+	}
+
+	return tenantKey(TenantFromContext(ctx), introspection.Username)
+}
+
+// invalidateUser drops the entries of user, a tenantKey.
+func (mw *cachingMiddleware) invalidateUser(user string) {
+	mw.invalidate(func(_ string, entry *cacheEntry) bool { return entry.user == user })
+}
+
+// invalidate drops the entries drop selects and ends the generation, so
+// that results fetched before do not make it into the cache afterwards.
+func (mw *cachingMiddleware) invalidate(drop func(key string, entry *cacheEntry) bool) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	mw.generation++
+
+	for key, entry := range mw.entries {
+		if drop(key, entry) {
+			delete(mw.entries, key)
+		}
+	}
+}
+
+func (mw *cachingMiddleware) prune(now time.Time) {
+	for key, entry := range mw.entries {
+		if !now.Before(entry.expires) {
+			delete(mw.entries, key)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingService counts the calls that reach the service behind a cache.
+type countingService struct {
+	UserService
+	introspections atomic.Int64
+	profiles       atomic.Int64
+}
+
+func (s *countingService) IntrospectToken(ctx context.Context, token string) (Introspection, error) {
+	s.introspections.Add(1)
+
+	return s.UserService.IntrospectToken(ctx, token)
+}
+
+func (s *countingService) GetProfile(ctx context.Context, token string) (Profile, error) {
+	s.profiles.Add(1)
+
+	return s.UserService.GetProfile(ctx, token)
+}
+
+// TestCachingMiddlewareCachesEncryptedTokens checks encrypted tokens, whose
+// claims cannot be read without the key, are cached like signed ones.
+func TestCachingMiddlewareCachesEncryptedTokens(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithEncryptedTokens(bytes.Repeat([]byte{1}, EncryptionKeySize))}} {
+		svc := newTestService(t, opts...)
+		mustRegister(t, svc, "alice")
+		session := mustLogin(t, svc, "alice")
+
+		counting := &countingService{UserService: svc}
+		cached := NewCachingMiddleware(time.Minute, nil)(counting)
+
+		for range 3 {
+			introspection, err := cached.IntrospectToken(context.Background(), session.AccessToken)
+			if err != nil || !introspection.Active {
+				t.Fatalf("IntrospectToken() = %+v, %v, want active", introspection, err)
+			}
+
+			if _, err := cached.GetProfile(context.Background(), session.AccessToken); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n := counting.introspections.Load(); n != 1 {
+			t.Errorf("encrypted %t: %d introspections reached the service, want 1", opts != nil, n)
+		}
+
+		if n := counting.profiles.Load(); n != 1 {
+			t.Errorf("encrypted %t: %d profile lookups reached the service, want 1", opts != nil, n)
+		}
+	}
+}
+
+func TestCachingMiddlewareExpiresWithToken(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	counting := &countingService{UserService: svc}
+	cached := NewCachingMiddleware(time.Hour, clock)(counting)
+
+	if _, err := cached.IntrospectToken(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(session.ExpiresAt.Sub(clock.Now()) + time.Second)
+
+	introspection, err := cached.IntrospectToken(context.Background(), session.AccessToken)
+	if err != nil || introspection.Active {
+		t.Fatalf("IntrospectToken() after the token expired = %+v, %v, want inactive", introspection, err)
+	}
+
+	if n := counting.introspections.Load(); n != 2 {
+		t.Fatalf("%d introspections reached the service, want the expired token to miss the cache", n)
+	}
+}
+
+func TestEncryptedTokensKeepCallerKeys(t *testing.T) {
+	signingKey := bytes.Repeat([]byte{2}, 32)
+
+	keys, err := NewKeyManager(AlgorithmHS256, defaultKeyID, signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callerKey := bytes.Repeat([]byte{3}, EncryptionKeySize)
+	if err := keys.AddEncryptionKey(defaultKeyID, callerKey); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestService(t, WithKeyManager(keys), WithEncryptedTokens(bytes.Repeat([]byte{1}, EncryptionKeySize)))
+
+	if !bytes.Equal(keys.encryptionKeys[defaultKeyID], callerKey) {
+		t.Fatal("WithEncryptedTokens replaced the caller's encryption key")
+	}
+
+	if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithKeyManager(keys), WithEncryptedTokens(bytes.Repeat([]byte{4}, EncryptionKeySize))); err == nil {
+		t.Fatal("NewUserService() replaced the encryption key of an earlier service")
+	}
+}
+
+func TestCachingMiddlewareInvalidates(t *testing.T) {
+	for name, end := range map[string]func(UserService, LoginResult) error{
+		"Logout": func(svc UserService, session LoginResult) error {
+			return svc.Logout(context.Background(), session.AccessToken)
+		},
+		"ChangePassword": func(svc UserService, session LoginResult) error {
+			return svc.ChangePassword(context.Background(), session.AccessToken, testPassword, "n3w-password")
+		},
+		"RevokeAllSessions": func(svc UserService, session LoginResult) error {
+			return svc.RevokeAllSessions(context.Background(), session.AccessToken)
+		},
+	} {
+		clock := newFakeClock()
+		svc := newTestService(t, WithClock(clock))
+		mustRegister(t, svc, "alice")
+
+		counting := &countingService{UserService: svc}
+		cached := NewCachingMiddleware(time.Minute, clock)(counting)
+
+		session, err := cached.Login(context.Background(), "alice", testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Within the TTL cached answers are reused.
+		for range 2 {
+			if introspection, err := cached.IntrospectToken(context.Background(), session.AccessToken); err != nil || !introspection.Active {
+				t.Fatalf("%s: IntrospectToken() = %+v, %v, want active", name, introspection, err)
+			}
+
+			if _, err := cached.GetProfile(context.Background(), session.AccessToken); err != nil {
+				t.Fatal(err)
+			}
+
+			clock.Advance(10 * time.Second)
+		}
+
+		if n := counting.introspections.Load() + counting.profiles.Load(); n != 2 {
+			t.Fatalf("%s: %d calls reached the service within the TTL, want 2", name, n)
+		}
+
+		if err := end(cached, session); err != nil {
+			t.Fatal(err)
+		}
+
+		introspection, err := cached.IntrospectToken(context.Background(), session.AccessToken)
+		if err != nil || introspection.Active {
+			t.Fatalf("%s: IntrospectToken() afterwards = %+v, %v, want inactive", name, introspection, err)
+		}
+
+		if _, err := cached.GetProfile(context.Background(), session.AccessToken); err == nil {
+			t.Fatalf("%s: GetProfile() afterwards succeeded", name)
+		}
+	}
+}
+
+// TestCachingMiddlewareKeepsEntriesOnFailure checks calls that fail, such
+// as ones made with a garbage token, cannot flush the cache.
+func TestCachingMiddlewareKeepsEntriesOnFailure(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	counting := &countingService{UserService: svc}
+	cached := NewCachingMiddleware(time.Minute, nil)(counting)
+
+	if _, err := cached.IntrospectToken(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, call := range map[string]func() error{
+		"Logout": func() error { return cached.Logout(context.Background(), "garbage") },
+		"ChangePassword": func() error {
+			return cached.ChangePassword(context.Background(), "garbage", testPassword, "n3w-password")
+		},
+		"RevokeAllSessions": func() error { return cached.RevokeAllSessions(context.Background(), "garbage") },
+		"RevokeSession":     func() error { return cached.RevokeSession(context.Background(), "garbage", "s1") },
+		"DeleteAccount":     func() error { return cached.DeleteAccount(context.Background(), "garbage", testPassword) },
+		"RenameUsername":    func() error { return cached.RenameUsername(context.Background(), "garbage", "mallory") },
+		"ResetPassword":     func() error { return cached.ResetPassword(context.Background(), "garbage", "n3w-password") },
+		"wrong password": func() error {
+			return cached.ChangePassword(context.Background(), session.AccessToken, "wrong-passw0rd", "n3w-password")
+		},
+	} {
+		if err := call(); err == nil {
+			t.Fatalf("%s succeeded", name)
+		}
+	}
+
+	before := counting.introspections.Load()
+
+	if _, err := cached.IntrospectToken(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := counting.introspections.Load(); n != before {
+		t.Fatal("failed calls dropped the cached entry")
+	}
+}
+
+// blockingIntrospection holds IntrospectToken calls until release is
+// closed, once the answer has been fetched.
+type blockingIntrospection struct {
+	UserService
+	fetched chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingIntrospection) IntrospectToken(ctx context.Context, token string) (Introspection, error) {
+	introspection, err := s.UserService.IntrospectToken(ctx, token)
+
+	select {
+	case s.fetched <- struct{}{}:
+		<-s.release
+	default:
+	}
+
+	return introspection, err
+}
+
+// TestCachingMiddlewareLogoutDuringIntrospection logs a token out while its
+// introspection is on its way back: the stale active answer must not be
+// cached.
+func TestCachingMiddlewareLogoutDuringIntrospection(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	blocking := &blockingIntrospection{UserService: svc, fetched: make(chan struct{}), release: make(chan struct{})}
+	cached := NewCachingMiddleware(time.Minute, nil)(blocking)
+
+	done := make(chan Introspection)
+	go func() {
+		introspection, _ := cached.IntrospectToken(context.Background(), session.AccessToken)
+		done <- introspection
+	}()
+
+	<-blocking.fetched
+
+	if err := cached.Logout(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	close(blocking.release)
+
+	if introspection := <-done; !introspection.Active {
+		t.Fatal("the introspection started before Logout answered inactive")
+	}
+
+	if introspection, err := cached.IntrospectToken(context.Background(), session.AccessToken); err != nil || introspection.Active {
+		t.Fatalf("IntrospectToken() after Logout = %+v, %v, want inactive", introspection, err)
+	}
+}
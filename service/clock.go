@@ -0,0 +1,33 @@
+package service
+
+import (
+	"time"
+)
+
+// Clock tells the service what time it is, so expiry logic can be driven by
+// a fake clock instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockSetter is implemented by the in-memory stores and the KeyManager.
+// NewUserService hands them its clock so a fake clock configured through
+// WithClock also drives token, session and one-time token expiry.
+type clockSetter interface {
+	setClock(clock Clock)
+}
+
+// shareClock passes clock to every dependency that keeps time of its own.
+func shareClock(clock Clock, deps ...interface{}) {
+	for _, dep := range deps {
+		if setter, ok := dep.(clockSetter); ok {
+			setter.setClock(clock)
+		}
+	}
+}
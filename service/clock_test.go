@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWithClockDrivesExpiry moves an injected clock past each kind of expiry
+// without sleeping: tokens, sessions and one-time tokens all follow it.
+func TestWithClockDrivesExpiry(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Hour), WithSessionTTL(2*time.Hour), WithPasswordResetTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+
+	session := mustLogin(t, svc, "alice")
+
+	resetToken, err := svc.RequestPasswordReset(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() just before expiry error = %v", err)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("GetHomeState() after the token expired error = %v, want ErrTokenExpired", err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), resetToken, "n3w-password"); err == nil {
+		t.Fatal("ResetPassword() with an expired reset token succeeded")
+	}
+
+	// The session outlives the token and is renewable until its own TTL.
+	if _, err := svc.Refresh(context.Background(), session.RefreshToken); err != nil {
+		t.Fatalf("Refresh() within the session TTL error = %v", err)
+	}
+
+	clock.Advance(time.Hour)
+
+	if _, err := svc.Refresh(context.Background(), session.RefreshToken); err == nil {
+		t.Fatal("Refresh() after the session expired succeeded")
+	}
+}
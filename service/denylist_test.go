@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDenylist runs the Denylist contract against denylist, which must start
+// empty. advance moves the denylist's clock forward.
+func testDenylist(t *testing.T, denylist Denylist, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	if denied, err := denylist.Contains(ctx, "unknown"); err != nil || denied {
+		t.Fatalf("Contains() of an unknown jti = %t, %v, want false", denied, err)
+	}
+
+	if err := denylist.Add(ctx, "jti", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if denied, err := denylist.Contains(ctx, "jti"); err != nil || !denied {
+		t.Fatalf("Contains() = %t, %v, want true", denied, err)
+	}
+
+	advance(1100 * time.Millisecond)
+
+	if denied, err := denylist.Contains(ctx, "jti"); err != nil || denied {
+		t.Fatalf("Contains() after the TTL = %t, %v, want false", denied, err)
+	}
+}
+
+func TestMemoryDenylist(t *testing.T) {
+	clock := newFakeClock()
+	denylist := NewMemoryDenylist()
+	denylist.(*memoryDenylist).setClock(clock)
+
+	testDenylist(t, denylist, clock.Advance)
+
+	// The next Add drops the expired entry, so the denylist stays bounded.
+	if err := denylist.Add(context.Background(), "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(denylist.(*memoryDenylist).entries); n != 1 {
+		t.Fatalf("%d entries kept, want the expired one dropped", n)
+	}
+}
+
+// TestLogoutDeniesTokenEverywhere logs a stateless token out on one of two
+// instances sharing a Denylist: the other rejects it straight away, and the
+// entry goes once the token has expired.
+func TestLogoutDeniesTokenEverywhere(t *testing.T) {
+	clock := newFakeClock()
+	users := NewMemoryUserRepository()
+	denylist := NewMemoryDenylist()
+
+	first := newTestServiceWithRepository(t, users, WithClock(clock), WithStatelessSessions(), WithDenylist(denylist))
+	second := newTestServiceWithRepository(t, users, WithClock(clock), WithStatelessSessions(), WithDenylist(denylist))
+
+	mustRegister(t, first, "alice")
+	session := mustLogin(t, first, "alice")
+
+	if _, err := second.GetHomeState(context.Background(), session.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() on the other instance error = %v", err)
+	}
+
+	if err := first.Logout(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := second.GetHomeState(context.Background(), session.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("GetHomeState() on the other instance after Logout error = %v, want ErrTokenRevoked", err)
+	}
+
+	if introspection, err := second.IntrospectToken(context.Background(), session.AccessToken); err != nil || introspection.Active {
+		t.Fatalf("IntrospectToken() after Logout = %+v, %v, want inactive", introspection, err)
+	}
+
+	claims, err := first.keys.parseToken(session.AccessToken, accessTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(session.ExpiresAt.Sub(clock.Now()))
+
+	if denied, err := denylist.Contains(context.Background(), claims.Id); err != nil || !denied {
+		t.Fatalf("Contains() while the token is still accepted = %t, %v, want true", denied, err)
+	}
+
+	clock.Advance(time.Second)
+
+	if denied, err := denylist.Contains(context.Background(), claims.Id); err != nil || denied {
+		t.Fatalf("Contains() after the token expired = %t, %v, want false", denied, err)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultVerificationTokenTTL       = 24 * time.Hour
+	DefaultVerificationResendInterval = time.Minute
+
+	verificationTokenPurpose = "email-verification"
+	// latestVerificationPurpose keys the record of the last verification
+	// token issued to each account.
+	latestVerificationPurpose = "email-verification-latest"
+)
+
+var (
+	// ErrInvalidEmail is returned when an email address cannot be parsed.
+	ErrInvalidEmail = errors.New("invalid email address")
+	// ErrEmailNotVerified is returned by Login when verified emails are
+	// required and the account has not confirmed its address yet.
+	ErrEmailNotVerified = errors.New("email not verified")
+	// ErrEmailAlreadyVerified is returned by GenerateVerificationToken when
+	// there is nothing left to verify.
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+)
+
+// validateEmail accepts a bare address only, rejecting display-name forms
+// such as "Alice <alice@example.com>".
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEmail, err)
+	}
+
+	if addr.Address != email {
+		return fmt.Errorf("%w: expected a bare address", ErrInvalidEmail)
+	}
+
+	return nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(email)
+}
+
+// GenerateVerificationToken mints a single-use token that confirms the email
+// of username when passed to VerifyEmail before it expires. It invalidates
+// the tokens issued to username before.
+func (u *userService) GenerateVerificationToken(ctx context.Context, username string) (string, error) {
+	username = normalizeUsername(username)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if err != nil {
+		return "", fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if userFields.EmailVerified {
+		return "", ErrEmailAlreadyVerified
+	}
+
+	return u.issueVerificationToken(ctx, username)
+}
+
+// ResendVerification mails a fresh verification token to the owner of
+// usernameOrEmail if the account exists and its email is unverified. To
+// avoid revealing which accounts exist it returns nil either way, and since
+// the mail is sent in the background the response time gives nothing away
+// either, unless WithFailurePolicy makes the mailer fail closed. An account
+// gets at most one mail per WithVerificationResendInterval however often it
+// is asked for.
+func (u *userService) ResendVerification(ctx context.Context, usernameOrEmail string) error {
+	u.mu.Lock()
+	userFields, token, err := u.resendVerificationToken(ctx, usernameOrEmail)
+	u.mu.Unlock()
+
+	if err != nil || token == "" {
+		return err
+	}
+
+	subject, body := verificationMail(userFields.Username, token, u.verificationTTL)
+
+	return u.sendMail(ctx, userFields.Email, subject, body)
+}
+
+// resendVerificationToken issues the token ResendVerification mails, or
+// returns an empty one when there is nothing to send.
+func (u *userService) resendVerificationToken(ctx context.Context, usernameOrEmail string) (UserFields, string, error) {
+	userFields, err := u.findUser(ctx, usernameOrEmail)
+	if errors.Is(err, ErrUserNotFound) {
+		return UserFields{}, "", nil
+	}
+
+	if err != nil {
+		return UserFields{}, "", fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if userFields.EmailVerified || userFields.Email == "" {
+		return UserFields{}, "", nil
+	}
+
+	latest, err := u.takeLatestVerification(ctx, userFields.Username)
+
+	switch {
+	case errors.Is(err, ErrOneTimeTokenNotFound):
+	case err != nil:
+		return UserFields{}, "", err
+	case u.clock.Now().Before(latest.issuedAt.Add(u.verificationResend)):
+		return UserFields{}, "", u.restoreLatestVerification(ctx, userFields.Username, latest)
+	}
+
+	token, err := u.issueVerificationToken(ctx, userFields.Username)
+	if err != nil {
+		return UserFields{}, "", err
+	}
+
+	return userFields, token, nil
+}
+
+// VerifyEmail redeems a token from GenerateVerificationToken and marks the
+// owner's email as verified.
+func (u *userService) VerifyEmail(ctx context.Context, token string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := oneTimeTokenKey(ctx, verificationTokenPurpose, token)
+
+	username, err := u.oneTimeTokens.Take(ctx, key)
+	if err != nil {
+		return fmt.Errorf("invalid verification token: %w", err)
+	}
+
+	latest, err := u.takeLatestVerification(ctx, username)
+
+	switch {
+	case errors.Is(err, ErrOneTimeTokenNotFound):
+		// Nothing newer was issued since the token.
+	case err != nil:
+		return err
+	case !SecureEqual(latest.key, key):
+		if err := u.restoreLatestVerification(ctx, username, latest); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("invalid verification token: %w", ErrOneTimeTokenNotFound)
+	}
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if err != nil {
+		return fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	userFields.EmailVerified = true
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return nil
+}
+
+// latestVerification records the last verification token issued to an
+// account. Only that token is accepted by VerifyEmail, so issuing a new one
+// invalidates the ones before it.
+type latestVerification struct {
+	issuedAt time.Time
+	// key is the token's OneTimeTokenStore key.
+	key string
+}
+
+// issueVerificationToken mints a verification token for username and
+// records it as the account's latest.
+func (u *userService) issueVerificationToken(ctx context.Context, username string) (string, error) {
+	token, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	key := oneTimeTokenKey(ctx, verificationTokenPurpose, token)
+
+	if err := u.oneTimeTokens.Put(ctx, key, username, u.verificationTTL); err != nil {
+		return "", fmt.Errorf("error while saving verification token: %w", err)
+	}
+
+	latest := latestVerification{issuedAt: u.clock.Now(), key: key}
+	if err := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, latestVerificationPurpose, username), latest.encode(), u.verificationTTL); err != nil {
+		return "", fmt.Errorf("error while saving verification token: %w", err)
+	}
+
+	return token, nil
+}
+
+// takeLatestVerification removes and returns the latest verification of
+// username, failing with ErrOneTimeTokenNotFound when it has none left.
+// OneTimeTokenStore can only be read by taking, so callers that merely look
+// give it back with restoreLatestVerification.
+func (u *userService) takeLatestVerification(ctx context.Context, username string) (latestVerification, error) {
+	value, err := u.oneTimeTokens.Take(ctx, oneTimeTokenKey(ctx, latestVerificationPurpose, username))
+	if err != nil {
+		return latestVerification{}, err
+	}
+
+	issuedAt, key, ok := strings.Cut(value, " ")
+	seconds, err := strconv.ParseInt(issuedAt, 10, 64)
+	if !ok || err != nil {
+		return latestVerification{}, fmt.Errorf("malformed latest verification of %q", username)
+	}
+
+	return latestVerification{issuedAt: time.Unix(seconds, 0), key: key}, nil
+}
+
+// restoreLatestVerification puts back latest for as long as its token has
+// left to live.
+func (u *userService) restoreLatestVerification(ctx context.Context, username string, latest latestVerification) error {
+	ttl := latest.issuedAt.Add(u.verificationTTL).Sub(u.clock.Now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, latestVerificationPurpose, username), latest.encode(), ttl); err != nil {
+		return fmt.Errorf("error while restoring latest verification: %w", err)
+	}
+
+	return nil
+}
+
+func (l latestVerification) encode() string {
+	return strconv.FormatInt(l.issuedAt.Unix(), 10) + " " + l.key
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithRequireVerifiedEmail(), WithVerificationTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Login() before verifying error = %v, want ErrEmailNotVerified", err)
+	}
+
+	stale, err := svc.GenerateVerificationToken(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := svc.GenerateVerificationToken(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.VerifyEmail(context.Background(), stale); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("VerifyEmail() with a superseded token error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+
+	if err := svc.VerifyEmail(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.VerifyEmail(context.Background(), token); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("VerifyEmail() twice error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+
+	if !mustGetUser(t, svc, "alice").EmailVerified {
+		t.Fatal("EmailVerified not set")
+	}
+
+	mustLogin(t, svc, "alice")
+}
+
+func TestVerificationTokenExpires(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithVerificationTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+
+	token, err := svc.GenerateVerificationToken(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Hour + time.Second)
+
+	if err := svc.VerifyEmail(context.Background(), token); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("VerifyEmail() with an expired token error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+}
+
+func TestLoginWithoutVerificationByDefault(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	mustLogin(t, svc, "alice")
+}
+
+func TestRegisterValidatesEmail(t *testing.T) {
+	svc := newTestService(t)
+
+	for _, email := range []string{"not-an-email", "Alice <alice@example.com>"} {
+		if _, err := svc.Register(context.Background(), "alice", testPassword, email); !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("Register() with email %q error = %v, want ErrInvalidEmail", email, err)
+		}
+	}
+}
+
+// sentMail is a message a capturingMailer was given.
+type sentMail struct {
+	to, subject, body string
+}
+
+// capturingMailer keeps the messages sent through it.
+type capturingMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func (m *capturingMailer) Send(_ context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, sentMail{to: to, subject: subject, body: body})
+
+	return nil
+}
+
+// take waits for the mails svc is still sending and returns the messages
+// sent since the last call.
+func (m *capturingMailer) take(svc *userService) []sentMail {
+	svc.mails.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := m.sent
+	m.sent = nil
+
+	return sent
+}
+
+// mailedToken returns the code a verification or reset mail carries on a
+// line of its own after the greeting and the instructions.
+func mailedToken(t *testing.T, mail sentMail) string {
+	t.Helper()
+
+	lines := strings.Split(mail.body, "\n")
+	if len(lines) < 5 || lines[4] == "" {
+		t.Fatalf("no token in mail %q", mail.body)
+	}
+
+	return lines[4]
+}
+
+func TestResendVerification(t *testing.T) {
+	clock := newFakeClock()
+	mailer := &capturingMailer{}
+	svc := newTestService(t, WithClock(clock), WithMailer(mailer), WithVerificationResendInterval(time.Minute))
+	mustRegister(t, svc, "alice")
+
+	stale, err := svc.GenerateVerificationToken(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token was issued just now, so nothing is sent yet.
+	if err := svc.ResendVerification(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := mailer.take(svc); len(sent) != 0 {
+		t.Fatalf("ResendVerification() within the interval sent %d mails, want none", len(sent))
+	}
+
+	clock.Advance(time.Minute)
+
+	if err := svc.ResendVerification(context.Background(), "Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mailer.take(svc)
+	if len(sent) != 1 || sent[0].to != "alice@example.com" {
+		t.Fatalf("ResendVerification() sent %+v, want one mail to alice@example.com", sent)
+	}
+
+	if err := svc.VerifyEmail(context.Background(), stale); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("VerifyEmail() with the token issued before the resend error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+
+	if err := svc.VerifyEmail(context.Background(), mailedToken(t, sent[0])); err != nil {
+		t.Fatalf("VerifyEmail() with the mailed token error = %v", err)
+	}
+
+	// Once verified there is nothing left to resend.
+	clock.Advance(time.Minute)
+
+	if err := svc.ResendVerification(context.Background(), "alice"); err != nil {
+		t.Fatalf("ResendVerification() of a verified account error = %v", err)
+	}
+
+	if sent := mailer.take(svc); len(sent) != 0 {
+		t.Fatalf("ResendVerification() of a verified account sent %d mails, want none", len(sent))
+	}
+}
+
+// TestResendVerificationUnknownAccount checks an unknown account gets the
+// same answer as a known one and no mail goes out.
+func TestResendVerificationUnknownAccount(t *testing.T) {
+	mailer := &capturingMailer{}
+	svc := newTestService(t, WithMailer(mailer))
+
+	for _, usernameOrEmail := range []string{"nobody", "nobody@example.com"} {
+		if err := svc.ResendVerification(context.Background(), usernameOrEmail); err != nil {
+			t.Fatalf("ResendVerification(%q) error = %v, want nil", usernameOrEmail, err)
+		}
+	}
+
+	if sent := mailer.take(svc); len(sent) != 0 {
+		t.Fatalf("ResendVerification() of unknown accounts sent %+v", sent)
+	}
+}
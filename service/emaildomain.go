@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrEmailDomainNotAllowed is returned by Register when the email's domain is
+// denied by, or missing from the allowlist of, the EmailDomainPolicy.
+var ErrEmailDomainNotAllowed = errors.New("email domain not allowed")
+
+// EmailDomainPolicy filters the email domains accepted on Register, e.g. to
+// keep out disposable mailbox providers. Domains are matched exactly and
+// case-insensitively; a denied domain does not cover its subdomains.
+type EmailDomainPolicy struct {
+	denied  map[string]struct{}
+	allowed map[string]struct{}
+}
+
+// NewEmailDomainPolicy returns a policy rejecting the domains in denied. When
+// allowed is not empty only its domains are accepted, and denied still takes
+// precedence over it.
+func NewEmailDomainPolicy(denied, allowed []string) *EmailDomainPolicy {
+	return &EmailDomainPolicy{
+		denied:  domainSet(denied),
+		allowed: domainSet(allowed),
+	}
+}
+
+// LoadEmailDomainList reads one domain per line from path, skipping blank
+// lines and lines starting with #, in the format disposable domain lists are
+// usually published in.
+func LoadEmailDomainList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening email domain list: %w", err)
+	}
+	defer f.Close()
+
+	var domains []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		domains = append(domains, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading email domain list: %w", err)
+	}
+
+	return domains, nil
+}
+
+// Validate checks the domain part of email, which must already have passed
+// validateEmail.
+func (p *EmailDomainPolicy) Validate(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return fmt.Errorf("%w: missing domain", ErrInvalidEmail)
+	}
+
+	domain := normalizeDomain(email[at+1:])
+
+	if _, ok := p.denied[domain]; ok {
+		return fmt.Errorf("%w: %s", ErrEmailDomainNotAllowed, domain)
+	}
+
+	if len(p.allowed) > 0 {
+		if _, ok := p.allowed[domain]; !ok {
+			return fmt.Errorf("%w: %s", ErrEmailDomainNotAllowed, domain)
+		}
+	}
+
+	return nil
+}
+
+func domainSet(domains []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		if domain = normalizeDomain(domain); domain != "" {
+			set[domain] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+// normalizeDomain lowercases domain and drops the trailing dot of a fully
+// qualified name, so "Example.COM." and "example.com" match.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
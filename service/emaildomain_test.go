@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmailDomainPolicyValidate(t *testing.T) {
+	denyOnly := NewEmailDomainPolicy([]string{"Mailinator.com", "trashmail.net."}, nil)
+	withAllowlist := NewEmailDomainPolicy([]string{"blocked.example.com"}, []string{"example.com", "blocked.example.com"})
+
+	tests := []struct {
+		policy *EmailDomainPolicy
+		email  string
+		want   error
+	}{
+		{denyOnly, "alice@example.com", nil},
+		{denyOnly, "alice@MAILINATOR.com", ErrEmailDomainNotAllowed},
+		{denyOnly, "alice@trashmail.net", ErrEmailDomainNotAllowed},
+		{denyOnly, "mailinator.com@example.com", nil},
+		{denyOnly, "alice@sub.mailinator.com", nil},
+		{denyOnly, "alice", ErrInvalidEmail},
+		{withAllowlist, "alice@Example.com", nil},
+		{withAllowlist, "alice@other.org", ErrEmailDomainNotAllowed},
+		{withAllowlist, "alice@blocked.example.com", ErrEmailDomainNotAllowed},
+	}
+
+	for _, tt := range tests {
+		if err := tt.policy.Validate(tt.email); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%q) error = %v, want %v", tt.email, err, tt.want)
+		}
+	}
+}
+
+func TestLoadEmailDomainList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disposable.txt")
+	if err := os.WriteFile(path, []byte("# disposable domains\nmailinator.com\n\n  trashmail.net  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	domains, err := LoadEmailDomainList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domains) != 2 || domains[0] != "mailinator.com" || domains[1] != "trashmail.net" {
+		t.Fatalf("LoadEmailDomainList() = %q", domains)
+	}
+
+	if _, err := LoadEmailDomainList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("LoadEmailDomainList() of a missing file succeeded")
+	}
+}
+
+func TestRegisterChecksEmailDomain(t *testing.T) {
+	svc := newTestService(t, WithEmailDomainPolicy(NewEmailDomainPolicy([]string{"mailinator.com"}, nil)))
+
+	if _, err := svc.Register(context.Background(), "alice", testPassword, "alice@mailinator.com"); !errors.Is(err, ErrEmailDomainNotAllowed) {
+		t.Fatalf("Register() with a denied domain error = %v, want ErrEmailDomainNotAllowed", err)
+	}
+
+	// Syntax is checked first.
+	if _, err := svc.Register(context.Background(), "alice", testPassword, "not-an-email"); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("Register() with a malformed email error = %v, want ErrInvalidEmail", err)
+	}
+
+	mustRegister(t, svc, "alice")
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestSentinelErrors checks each failure path wraps its sentinel so callers
+// can branch with errors.Is.
+func TestSentinelErrors(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	revoked := mustLogin(t, svc, "alice")
+	loggedOut := mustLogin(t, svc, "alice")
+
+	// The token Logout was called with is denylisted; the other session is
+	// simply gone.
+	if err := svc.Logout(context.Background(), loggedOut.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.RevokeAllSessions(context.Background(), mustLogin(t, svc, "alice").AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Register of a taken username", func() error { _, err := svc.Register(ctx, "alice", testPassword, "other@example.com"); return err }(), ErrUserAlreadyExists},
+		{"GetUser of an unknown user", func() error { _, err := svc.users.GetUser(ctx, "nobody"); return err }(), ErrUserNotFound},
+		{"Login with a wrong password", func() error { _, err := svc.Login(ctx, "alice", "wrong-passw0rd"); return err }(), ErrInvalidCredentials},
+		{"GetHomeState of a revoked session", func() error { _, err := svc.GetHomeState(ctx, revoked.AccessToken); return err }(), ErrSessionNotFound},
+		{"GetHomeState of a logged out token", func() error { _, err := svc.GetHomeState(ctx, loggedOut.AccessToken); return err }(), ErrTokenRevoked},
+		{"GetHomeState with a malformed token", func() error { _, err := svc.GetHomeState(ctx, "not-a-token"); return err }(), ErrTokenInvalid},
+		{"Logout with a malformed token", svc.Logout(ctx, "not-a-token"), ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// ErrPasswordMismatch is returned by Hasher.Compare when the plaintext does
+// not produce the encoded hash.
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Hasher turns passwords into self-describing encoded hashes and checks
+// plaintexts against them.
+type Hasher interface {
+	Hash(plaintext string) (string, error)
+	Compare(plaintext, encoded string) error
+}
+
+// RehashChecker is implemented by Hashers that can tell when a stored hash
+// is weaker than the ones they produce now, or was produced by another
+// Hasher altogether. Login uses it to upgrade hashes transparently.
+type RehashChecker interface {
+	NeedsRehash(encoded string) bool
+}
+
+// pepperPassword returns the hex encoded HMAC-SHA256 of pass keyed with
+// pepper, or pass unchanged when there is no pepper. The 64 character digest
+// stays under bcrypt's 72 byte limit however long the password is, and
+// being hex it never contains the NUL bytes some bcrypt implementations
+// stop at.
+func pepperPassword(pepper []byte, pass string) string {
+	if len(pepper) == 0 {
+		return pass
+	}
+
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(pass))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher returns a Hasher producing bcrypt hashes at cost.
+func NewBcryptHasher(cost int) (Hasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+	}
+
+	return bcryptHasher{cost: cost}, nil
+}
+
+func (h bcryptHasher) Hash(plaintext string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h bcryptHasher) Compare(plaintext, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plaintext))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+
+	return err
+}
+
+func (h bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+
+	return cost < h.cost
+}
+
+// Argon2Params tunes an argon2id Hasher. Memory is in KiB.
+type Argon2Params struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// DefaultArgon2Params follows the second recommended option of RFC 9106:
+// one pass over 64 MiB with four lanes.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Time:       1,
+		Memory:     64 * 1024,
+		Threads:    4,
+		KeyLength:  32,
+		SaltLength: 16,
+	}
+}
+
+type argon2Hasher struct {
+	params Argon2Params
+}
+
+// NewArgon2Hasher returns a Hasher producing argon2id hashes encoded as PHC
+// strings, e.g. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>, so every hash
+// carries the parameters it was made with.
+func NewArgon2Hasher(params Argon2Params) (Hasher, error) {
+	if params.Time < 1 || params.Memory < 8*uint32(params.Threads) || params.Threads < 1 {
+		return nil, fmt.Errorf("argon2 needs time >= 1, threads >= 1 and memory >= 8 KiB per thread, got %+v", params)
+	}
+
+	if params.KeyLength < 16 || params.SaltLength < 8 {
+		return nil, fmt.Errorf("argon2 needs a key of at least 16 bytes and a salt of at least 8, got %+v", params)
+	}
+
+	return argon2Hasher{params: params}, nil
+}
+
+func (h argon2Hasher) Hash(plaintext string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error while generating salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Compare reads the parameters from encoded, so hashes made with other
+// parameters than h's still verify.
+func (h argon2Hasher) Compare(plaintext, encoded string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(plaintext), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if !SecureEqual(string(candidate), string(key)) {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h argon2Hasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Time < h.params.Time ||
+		params.Memory < h.params.Memory ||
+		params.Threads < h.params.Threads ||
+		uint32(len(salt)) < h.params.SaltLength ||
+		uint32(len(key)) < h.params.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	if params.Time < 1 || params.Threads < 1 || params.Memory < 1 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+
+	if len(key) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id hash: empty key")
+	}
+
+	return params, salt, key, nil
+}
+
+// hasherFor picks the Hasher able to verify encoded, so hashes made before a
+// switch of the configured Hasher keep working.
+func hasherFor(encoded string) Hasher {
+	if strings.HasPrefix(encoded, argon2idPrefix) {
+		return argon2Hasher{}
+	}
+
+	return bcryptHasher{}
+}
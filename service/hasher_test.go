@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keeps argon2id cheap enough for tests.
+var testArgon2Params = Argon2Params{Time: 1, Memory: 64, Threads: 1, KeyLength: 16, SaltLength: 8}
+
+func mustArgon2Hasher(t *testing.T) Hasher {
+	t.Helper()
+
+	hasher, err := NewArgon2Hasher(testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hasher
+}
+
+func TestArgon2Hasher(t *testing.T) {
+	hasher := mustArgon2Hasher(t)
+
+	hash, err := hasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Hash() = %q, want a PHC string carrying the parameters", hash)
+	}
+
+	if err := hasher.Compare(testPassword, hash); err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	if err := hasher.Compare("wrong-passw0rd", hash); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("Compare() with a wrong password error = %v, want ErrPasswordMismatch", err)
+	}
+
+	// Hashes made with other parameters verify too.
+	other, err := NewArgon2Hasher(Argon2Params{Time: 2, Memory: 128, Threads: 2, KeyLength: 32, SaltLength: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Compare(testPassword, hash); err != nil {
+		t.Fatalf("Compare() with other parameters error = %v", err)
+	}
+
+	for _, malformed := range []string{"", "$argon2id$v=19$m=64,t=1,p=1$salt", "$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5", "$argon2id$v=19$m=0,t=0,p=0$c2FsdHNhbHQ$a2V5"} {
+		if err := hasher.Compare(testPassword, malformed); err == nil {
+			t.Errorf("Compare() against %q succeeded", malformed)
+		}
+	}
+
+	if _, err := NewArgon2Hasher(Argon2Params{}); err == nil {
+		t.Error("NewArgon2Hasher() with zero parameters succeeded")
+	}
+}
+
+// TestCrossHasherLogin registers users under one Hasher and logs them in
+// after switching to the other, as a deployment changing hasher would.
+func TestCrossHasherLogin(t *testing.T) {
+	bcryptHasher, err := NewBcryptHasher(bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashers := map[string]Hasher{"bcrypt": bcryptHasher, "argon2id": mustArgon2Hasher(t)}
+
+	for from, before := range hashers {
+		for to, after := range hashers {
+			users := NewMemoryUserRepository()
+			mustRegister(t, newTestServiceWithRepository(t, users, WithHasher(before)), "alice")
+
+			if _, err := newTestServiceWithRepository(t, users, WithHasher(after)).Login(context.Background(), "alice", testPassword); err != nil {
+				t.Errorf("Login() with a %s hash under %s error = %v", from, to, err)
+			}
+		}
+	}
+}
+
+func TestLoginRehashesWeakerHashes(t *testing.T) {
+	users := NewMemoryUserRepository()
+	mustRegister(t, newTestServiceWithRepository(t, users), "alice")
+
+	svc := newTestServiceWithRepository(t, users, WithBcryptCost(bcrypt.MinCost+1))
+	mustLogin(t, svc, "alice")
+
+	cost, err := bcrypt.Cost([]byte(mustGetUser(t, svc, "alice").HashedPassword))
+	if err != nil || cost != bcrypt.MinCost+1 {
+		t.Fatalf("stored hash cost = %d, %v, want %d", cost, err, bcrypt.MinCost+1)
+	}
+
+	// Switching hasher upgrades the hash to the new one.
+	svc = newTestServiceWithRepository(t, users, WithHasher(mustArgon2Hasher(t)))
+	mustLogin(t, svc, "alice")
+
+	if hash := mustGetUser(t, svc, "alice").HashedPassword; !strings.HasPrefix(hash, argon2idPrefix) {
+		t.Fatalf("stored hash = %q, want it rehashed with argon2id", hash)
+	}
+
+	mustLogin(t, svc, "alice")
+}
+
+// saveFailingRepository fails every SaveUser.
+type saveFailingRepository struct {
+	UserRepository
+}
+
+func (saveFailingRepository) SaveUser(_ context.Context, _ UserFields) error {
+	return errors.New("disk full")
+}
+
+func TestLoginSucceedsWhenRehashCannotBeSaved(t *testing.T) {
+	users := NewMemoryUserRepository()
+	mustRegister(t, newTestServiceWithRepository(t, users), "alice")
+
+	svc := newTestServiceWithRepository(t, saveFailingRepository{users}, WithBcryptCost(bcrypt.MinCost+1))
+	mustLogin(t, svc, "alice")
+
+	cost, err := bcrypt.Cost([]byte(mustGetUser(t, svc, "alice").HashedPassword))
+	if err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("stored hash cost = %d, %v, want the original %d", cost, err, bcrypt.MinCost)
+	}
+}
+
+func TestPepper(t *testing.T) {
+	pepper := []byte("application-wide secret")
+
+	users := NewMemoryUserRepository()
+	mustRegister(t, newTestServiceWithRepository(t, users, WithPepper(pepper)), "alice")
+
+	mustLogin(t, newTestServiceWithRepository(t, users, WithPepper(pepper)), "alice")
+
+	for name, svc := range map[string]*userService{
+		"without a pepper":     newTestServiceWithRepository(t, users),
+		"with a different one": newTestServiceWithRepository(t, users, WithPepper([]byte("another secret"))),
+	} {
+		if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login() %s error = %v, want ErrInvalidCredentials", name, err)
+		}
+	}
+
+	if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithPepper(nil)); err == nil {
+		t.Error("NewUserService() with an empty pepper succeeded")
+	}
+
+	// Whatever the password, bcrypt is fed a 64 byte hex digest, clear of its
+	// 72 byte limit and of NUL bytes.
+	if got := pepperPassword(pepper, strings.Repeat("b", 1000)); len(got) != 64 || strings.ContainsRune(got, 0) {
+		t.Errorf("pepperPassword() = %q, want a 64 byte hex digest", got)
+	}
+}
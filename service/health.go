@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+
+	// healthCheckTimeout bounds each dependency ping so a hung dependency
+	// cannot hang the health check with it.
+	healthCheckTimeout = 2 * time.Second
+)
+
+// ErrShuttingDown is returned by Liveness and Readiness once BeginShutdown
+// has been called.
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// HealthStatus is the result of HealthCheck. Status is HealthStatusOK only
+// when every dependency answered; Dependencies holds "ok" or the error
+// reported by each one. Integrations reports the external integrations in
+// use, which do not affect Status.
+type HealthStatus struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]string `json:"dependencies"`
+	Integrations ServiceHealth     `json:"integrations,omitempty"`
+}
+
+// String returns the overall status, which is what used to be the whole
+// health check response.
+func (h HealthStatus) String() string {
+	return h.Status
+}
+
+func (u *userService) HealthCheck(ctx context.Context) HealthStatus {
+	health := HealthStatus{
+		Status:       HealthStatusOK,
+		Dependencies: make(map[string]string),
+		Integrations: u.integrations.serviceHealth(u.configuredIntegrations()),
+	}
+
+	checks := map[string]func(context.Context) error{
+		"user_repository": u.users.Ping,
+		"session_store":   u.sessions.Ping,
+	}
+
+	for name, ping := range checks {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := ping(pingCtx)
+		cancel()
+
+		if err != nil {
+			health.Status = HealthStatusDegraded
+			health.Dependencies[name] = err.Error()
+
+			continue
+		}
+
+		health.Dependencies[name] = HealthStatusOK
+	}
+
+	return health
+}
+
+// Liveness is the cheap "is the process alive" probe. It never touches a
+// dependency and only fails once BeginShutdown has been called.
+func (u *userService) Liveness() error {
+	if u.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	return nil
+}
+
+// Readiness reports whether the service can take traffic: it fails while any
+// dependency is unreachable and from the moment BeginShutdown is called, so
+// load balancers stop routing before the server stops accepting connections.
+func (u *userService) Readiness(ctx context.Context) error {
+	if u.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	health := u.HealthCheck(ctx)
+	if health.Status == HealthStatusOK {
+		return nil
+	}
+
+	var failing []string
+	for name, status := range health.Dependencies {
+		if status != HealthStatusOK {
+			failing = append(failing, fmt.Sprintf("%s: %s", name, status))
+		}
+	}
+
+	sort.Strings(failing)
+
+	return fmt.Errorf("dependencies not ready: %s", strings.Join(failing, "; "))
+}
+
+// BeginShutdown flips Liveness and Readiness to failing. It is meant to be
+// called as soon as a termination signal arrives, ahead of stopping the
+// servers.
+func (u *userService) BeginShutdown() {
+	u.shuttingDown.Store(true)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// unreachableUserRepository is a repository whose database is down.
+type unreachableUserRepository struct {
+	UserRepository
+}
+
+func (unreachableUserRepository) Ping(_ context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthCheck(t *testing.T) {
+	health := newTestService(t).HealthCheck(context.Background())
+
+	if health.Status != HealthStatusOK || health.String() != HealthStatusOK {
+		t.Fatalf("HealthCheck() = %+v, want ok", health)
+	}
+
+	for _, name := range []string{"user_repository", "session_store"} {
+		if health.Dependencies[name] != HealthStatusOK {
+			t.Errorf("Dependencies[%q] = %q, want ok", name, health.Dependencies[name])
+		}
+	}
+}
+
+func TestHealthCheckDegraded(t *testing.T) {
+	svc := newTestServiceWithRepository(t, unreachableUserRepository{NewMemoryUserRepository()})
+
+	health := svc.HealthCheck(context.Background())
+	if health.Status != HealthStatusDegraded || health.String() != HealthStatusDegraded {
+		t.Fatalf("HealthCheck() = %+v, want degraded", health)
+	}
+
+	if got := health.Dependencies["user_repository"]; got != "connection refused" {
+		t.Errorf("Dependencies[user_repository] = %q, want the ping error", got)
+	}
+
+	if got := health.Dependencies["session_store"]; got != HealthStatusOK {
+		t.Errorf("Dependencies[session_store] = %q, want ok", got)
+	}
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	svc := newTestService(t)
+
+	if err := svc.Liveness(); err != nil {
+		t.Fatalf("Liveness() error = %v", err)
+	}
+
+	if err := svc.Readiness(context.Background()); err != nil {
+		t.Fatalf("Readiness() error = %v", err)
+	}
+
+	svc.BeginShutdown()
+
+	if err := svc.Liveness(); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Liveness() after BeginShutdown error = %v, want ErrShuttingDown", err)
+	}
+
+	if err := svc.Readiness(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Readiness() after BeginShutdown error = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestReadinessFailsWithUnreachableDependency(t *testing.T) {
+	svc := newTestServiceWithRepository(t, unreachableUserRepository{NewMemoryUserRepository()})
+
+	if err := svc.Liveness(); err != nil {
+		t.Fatalf("Liveness() error = %v, want nil: it must not touch dependencies", err)
+	}
+
+	if err := svc.Readiness(context.Background()); err == nil {
+		t.Fatal("Readiness() with an unreachable repository succeeded")
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+// Message keys looked up through a Localizer.
+const (
+	MessageSessionExpired = "session_expired"
+	MessageRegistered     = "registered"
+	MessageLoggedIn       = "logged_in"
+	MessageLoggedOut      = "logged_out"
+)
+
+// defaultCatalogs holds the built-in translations. English is the fallback.
+var defaultCatalogs = map[language.Tag]map[string]string{
+	language.English: {
+		MessageSessionExpired: "Your session has expired, please log in again",
+		MessageRegistered:     "Your account was created, you can log in now",
+		MessageLoggedIn:       "Welcome back",
+		MessageLoggedOut:      "You have been logged out",
+	},
+	language.Spanish: {
+		MessageSessionExpired: "Tu sesión ha expirado, por favor inicia sesión nuevamente",
+		MessageRegistered:     "Tu cuenta fue creada, ya puedes iniciar sesión",
+		MessageLoggedIn:       "Bienvenido de nuevo",
+		MessageLoggedOut:      "Has cerrado sesión",
+	},
+}
+
+type languageContextKey struct{}
+
+type flashContextKey struct{}
+
+// ContextWithFlash records the flash message the previous request left for
+// this one to show, as a message key. It is carried by a short-lived
+// cookie, see transport.PopulateFlash.
+func ContextWithFlash(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, flashContextKey{}, key)
+}
+
+// FlashFromContext returns the key recorded by ContextWithFlash, if any.
+func FlashFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(flashContextKey{}).(string)
+
+	return key
+}
+
+// ContextWithLanguages records the caller's preferred languages, most
+// preferred first, for the Localizer to pick from.
+func ContextWithLanguages(ctx context.Context, tags []language.Tag) context.Context {
+	return context.WithValue(ctx, languageContextKey{}, tags)
+}
+
+func languagesFromContext(ctx context.Context) []language.Tag {
+	tags, _ := ctx.Value(languageContextKey{}).([]language.Tag)
+
+	return tags
+}
+
+// Localizer resolves message keys to the catalog best matching the caller's
+// languages, falling back to the first catalog's language.
+type Localizer struct {
+	matcher  language.Matcher
+	tags     []language.Tag
+	catalogs map[language.Tag]map[string]string
+}
+
+// NewLocalizer builds a Localizer over catalogs. fallback must be one of
+// them; it answers when nothing else matches and for keys a catalog lacks.
+func NewLocalizer(fallback language.Tag, catalogs map[language.Tag]map[string]string) (*Localizer, error) {
+	if _, ok := catalogs[fallback]; !ok {
+		return nil, fmt.Errorf("fallback language %s has no catalog", fallback)
+	}
+
+	// The matcher returns the first tag when nothing matches.
+	tags := []language.Tag{fallback}
+	for tag := range catalogs {
+		if tag != fallback {
+			tags = append(tags, tag)
+		}
+	}
+
+	return &Localizer{
+		matcher:  language.NewMatcher(tags),
+		tags:     tags,
+		catalogs: catalogs,
+	}, nil
+}
+
+// DefaultLocalizer serves the built-in English and Spanish catalogs.
+func DefaultLocalizer() *Localizer {
+	localizer, err := NewLocalizer(language.English, defaultCatalogs)
+	if err != nil {
+		panic(err)
+	}
+
+	return localizer
+}
+
+// Localize returns the message for key in the language preferred by ctx,
+// or key itself when no catalog has it.
+func (l *Localizer) Localize(ctx context.Context, key string) string {
+	_, index, _ := l.matcher.Match(languagesFromContext(ctx)...)
+
+	if message, ok := l.catalogs[l.tags[index]][key]; ok {
+		return message
+	}
+
+	if message, ok := l.catalogs[l.tags[0]][key]; ok {
+		return message
+	}
+
+	return key
+}
+
+// has reports whether the fallback catalog, which every key should be in,
+// knows key.
+func (l *Localizer) has(key string) bool {
+	_, ok := l.catalogs[l.tags[0]][key]
+
+	return ok
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// TestSendMainTemplateDataLocalized renders the same expired session for
+// callers preferring different languages.
+func TestSendMainTemplateDataLocalized(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Minute))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	clock.Advance(2 * time.Minute)
+
+	tests := []struct {
+		languages []language.Tag
+		want      string
+	}{
+		{nil, "Your session has expired, please log in again"},
+		{[]language.Tag{language.English}, "Your session has expired, please log in again"},
+		{[]language.Tag{language.MustParse("es-AR")}, "Tu sesión ha expirado, por favor inicia sesión nuevamente"},
+		{[]language.Tag{language.French, language.Spanish}, "Tu sesión ha expirado, por favor inicia sesión nuevamente"},
+		{[]language.Tag{language.French}, "Your session has expired, please log in again"},
+	}
+
+	for _, tt := range tests {
+		ctx := ContextWithLanguages(context.Background(), tt.languages)
+
+		render, _ := svc.SendMainTemplateData(ctx, session.AccessToken)
+		if render.Variables.LoginMessage != tt.want {
+			t.Errorf("LoginMessage for %v = %q, want %q", tt.languages, render.Variables.LoginMessage, tt.want)
+		}
+	}
+}
+
+func TestWithLocalizer(t *testing.T) {
+	localizer, err := NewLocalizer(language.German, map[language.Tag]map[string]string{
+		language.German:  {MessageSessionExpired: "Sitzung abgelaufen", MessageLoggedIn: "Willkommen"},
+		language.Italian: {MessageLoggedIn: "Bentornato"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	italian := ContextWithLanguages(context.Background(), []language.Tag{language.Italian})
+
+	if got := localizer.Localize(italian, MessageLoggedIn); got != "Bentornato" {
+		t.Errorf("Localize(it, logged_in) = %q, want Bentornato", got)
+	}
+
+	// A key the Italian catalog lacks falls back to German, and one no
+	// catalog has to the key itself.
+	if got := localizer.Localize(italian, MessageSessionExpired); got != "Sitzung abgelaufen" {
+		t.Errorf("Localize(it, session_expired) = %q, want the German fallback", got)
+	}
+
+	if got := localizer.Localize(italian, "unknown"); got != "unknown" {
+		t.Errorf("Localize(it, unknown) = %q, want the key", got)
+	}
+
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Minute), WithLocalizer(localizer))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	clock.Advance(2 * time.Minute)
+
+	if render, _ := svc.SendMainTemplateData(context.Background(), session.AccessToken); render.Variables.LoginMessage != "Sitzung abgelaufen" {
+		t.Errorf("LoginMessage = %q, want the injected localizer's", render.Variables.LoginMessage)
+	}
+
+	if _, err := NewLocalizer(language.French, defaultCatalogs); err == nil {
+		t.Error("NewLocalizer() with a fallback lacking a catalog succeeded")
+	}
+}
+
+func TestSendMainTemplateDataFlash(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	loggedIn := ContextWithFlash(context.Background(), MessageLoggedIn)
+
+	render, err := svc.SendMainTemplateData(loggedIn, session.AccessToken)
+	if err != nil || render.Variables.LoginMessage != svc.localizer.Localize(loggedIn, MessageLoggedIn) {
+		t.Fatalf("SendMainTemplateData() with a flash = %+v, %v, want the logged in message", render.Variables, err)
+	}
+
+	if render, _ := svc.SendMainTemplateData(context.Background(), session.AccessToken); render.Variables.LoginMessage != "" {
+		t.Fatalf("SendMainTemplateData() without a flash shows %q", render.Variables.LoginMessage)
+	}
+
+	// Keys the localizer does not know are not echoed.
+	forged := ContextWithFlash(context.Background(), "<script>alert(1)</script>")
+	if render, _ := svc.SendMainTemplateData(forged, ""); render.Variables.LoginMessage != "" {
+		t.Fatalf("SendMainTemplateData() with an unknown flash shows %q", render.Variables.LoginMessage)
+	}
+
+	// An expired session takes precedence.
+	clock.Advance(time.Hour + time.Second)
+
+	if render, _ := svc.SendMainTemplateData(loggedIn, session.AccessToken); render.Variables.LoginMessage != svc.localizer.Localize(loggedIn, MessageSessionExpired) {
+		t.Fatalf("SendMainTemplateData() of an expired session with a flash shows %q", render.Variables.LoginMessage)
+	}
+}
@@ -0,0 +1,417 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+type instrumentingMiddleware struct {
+	requestCount   metrics.Counter
+	requestLatency metrics.Histogram
+	activeSessions metrics.Gauge
+	next           UserService
+}
+
+// NewInstrumentingMiddleware records a request counter and a latency
+// histogram, both labeled by "method" and "success", plus a gauge of active
+// sessions. The gauge only follows Login, Logout and RevokeSession, so
+// sessions that expire, are purged in bulk (RevokeAllSessions,
+// ChangePassword, DeleteAccount, ResetPassword) or are evicted by
+// WithEvictOldestSession are not subtracted. Callers create and register the
+// metrics themselves.
+func NewInstrumentingMiddleware(requestCount metrics.Counter, requestLatency metrics.Histogram, activeSessions metrics.Gauge) Middleware {
+	return func(next UserService) UserService {
+		return &instrumentingMiddleware{
+			requestCount:   requestCount,
+			requestLatency: requestLatency,
+			activeSessions: activeSessions,
+			next:           next,
+		}
+	}
+}
+
+func (mw *instrumentingMiddleware) observe(method string, begin time.Time, err error) {
+	lvs := []string{"method", method, "success", strconv.FormatBool(err == nil)}
+	mw.requestCount.With(lvs...).Add(1)
+	mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+}
+
+func (mw *instrumentingMiddleware) HealthCheck(ctx context.Context) HealthStatus {
+	defer func(begin time.Time) {
+		mw.observe("HealthCheck", begin, nil)
+	}(time.Now())
+
+	return mw.next.HealthCheck(ctx)
+}
+
+func (mw *instrumentingMiddleware) Liveness() (err error) {
+	defer func(begin time.Time) {
+		mw.observe("Liveness", begin, err)
+	}(time.Now())
+
+	return mw.next.Liveness()
+}
+
+func (mw *instrumentingMiddleware) Readiness(ctx context.Context) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("Readiness", begin, err)
+	}(time.Now())
+
+	return mw.next.Readiness(ctx)
+}
+
+func (mw *instrumentingMiddleware) BeginShutdown() {
+	mw.next.BeginShutdown()
+}
+
+func (mw *instrumentingMiddleware) SetRegistrationEnabled(enabled bool) {
+	mw.next.SetRegistrationEnabled(enabled)
+}
+
+func (mw *instrumentingMiddleware) Close(ctx context.Context) error {
+	return mw.next.Close(ctx)
+}
+
+func (mw *instrumentingMiddleware) GetHomeState(ctx context.Context, token string) (state HomeState, err error) {
+	defer func(begin time.Time) {
+		mw.observe("GetHomeState", begin, err)
+	}(time.Now())
+
+	return mw.next.GetHomeState(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) SendMainTemplateData(ctx context.Context, token string) (render TemplateRender, err error) {
+	defer func(begin time.Time) {
+		mw.observe("SendMainTemplateData", begin, err)
+	}(time.Now())
+
+	return mw.next.SendMainTemplateData(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) Register(ctx context.Context, user, pass, email string, roles ...string) (response string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("Register", begin, err)
+	}(time.Now())
+
+	return mw.next.Register(ctx, user, pass, email, roles...)
+}
+
+func (mw *instrumentingMiddleware) RegisterWithInvite(ctx context.Context, user, pass, email, code string) (response string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("RegisterWithInvite", begin, err)
+	}(time.Now())
+
+	return mw.next.RegisterWithInvite(ctx, user, pass, email, code)
+}
+
+func (mw *instrumentingMiddleware) RegisterBatch(ctx context.Context, users []NewUser) (result BatchResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("RegisterBatch", begin, err)
+	}(time.Now())
+
+	return mw.next.RegisterBatch(ctx, users)
+}
+
+func (mw *instrumentingMiddleware) IsUsernameAvailable(ctx context.Context, username string) (available bool, err error) {
+	defer func(begin time.Time) {
+		mw.observe("IsUsernameAvailable", begin, err)
+	}(time.Now())
+
+	return mw.next.IsUsernameAvailable(ctx, username)
+}
+
+func (mw *instrumentingMiddleware) CreateUser(ctx context.Context, token, user, pass, email string, roles ...string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("CreateUser", begin, err)
+	}(time.Now())
+
+	return mw.next.CreateUser(ctx, token, user, pass, email, roles...)
+}
+
+func (mw *instrumentingMiddleware) CreateInvite(ctx context.Context, token string) (code string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("CreateInvite", begin, err)
+	}(time.Now())
+
+	return mw.next.CreateInvite(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) Login(ctx context.Context, user, pass string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("Login", begin, err)
+
+		if err == nil {
+			mw.activeSessions.Add(1)
+		}
+	}(time.Now())
+
+	return mw.next.Login(ctx, user, pass)
+}
+
+func (mw *instrumentingMiddleware) LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("LoginWithOptions", begin, err)
+
+		if err == nil {
+			mw.activeSessions.Add(1)
+		}
+	}(time.Now())
+
+	return mw.next.LoginWithOptions(ctx, user, pass, opts)
+}
+
+func (mw *instrumentingMiddleware) LoginTOTP(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("LoginTOTP", begin, err)
+
+		if err == nil {
+			mw.activeSessions.Add(1)
+		}
+	}(time.Now())
+
+	return mw.next.LoginTOTP(ctx, user, pass, code)
+}
+
+func (mw *instrumentingMiddleware) LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("LoginWithTTL", begin, err)
+	}(time.Now())
+
+	return mw.next.LoginWithTTL(ctx, user, pass, ttl)
+}
+
+func (mw *instrumentingMiddleware) BeginPasskeyRegistration(ctx context.Context, token string) (creation *protocol.CredentialCreation, err error) {
+	defer func(begin time.Time) {
+		mw.observe("BeginPasskeyRegistration", begin, err)
+	}(time.Now())
+
+	return mw.next.BeginPasskeyRegistration(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) FinishPasskeyRegistration(ctx context.Context, token string, credential []byte) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("FinishPasskeyRegistration", begin, err)
+	}(time.Now())
+
+	return mw.next.FinishPasskeyRegistration(ctx, token, credential)
+}
+
+func (mw *instrumentingMiddleware) BeginPasskeyLogin(ctx context.Context, username string) (assertion *protocol.CredentialAssertion, err error) {
+	defer func(begin time.Time) {
+		mw.observe("BeginPasskeyLogin", begin, err)
+	}(time.Now())
+
+	return mw.next.BeginPasskeyLogin(ctx, username)
+}
+
+func (mw *instrumentingMiddleware) FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("FinishPasskeyLogin", begin, err)
+	}(time.Now())
+
+	return mw.next.FinishPasskeyLogin(ctx, username, credential)
+}
+
+func (mw *instrumentingMiddleware) OAuthLogin(ctx context.Context, provider, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("OAuthLogin", begin, err)
+	}(time.Now())
+
+	return mw.next.OAuthLogin(ctx, provider, code)
+}
+
+func (mw *instrumentingMiddleware) EnableTOTP(ctx context.Context, token string) (secret string, otpauthURL string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("EnableTOTP", begin, err)
+	}(time.Now())
+
+	return mw.next.EnableTOTP(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) ConfirmTOTP(ctx context.Context, token, code string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("ConfirmTOTP", begin, err)
+	}(time.Now())
+
+	return mw.next.ConfirmTOTP(ctx, token, code)
+}
+
+func (mw *instrumentingMiddleware) GenerateRecoveryCodes(ctx context.Context, token string) (codes []string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("GenerateRecoveryCodes", begin, err)
+	}(time.Now())
+
+	return mw.next.GenerateRecoveryCodes(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.observe("LoginWithRecoveryCode", begin, err)
+	}(time.Now())
+
+	return mw.next.LoginWithRecoveryCode(ctx, user, pass, code)
+}
+
+func (mw *instrumentingMiddleware) Refresh(ctx context.Context, refreshToken string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("Refresh", begin, err)
+	}(time.Now())
+
+	return mw.next.Refresh(ctx, refreshToken)
+}
+
+func (mw *instrumentingMiddleware) Logout(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("Logout", begin, err)
+
+		if err == nil {
+			mw.activeSessions.Add(-1)
+		}
+	}(time.Now())
+
+	return mw.next.Logout(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) GetProfile(ctx context.Context, token string) (profile Profile, err error) {
+	defer func(begin time.Time) {
+		mw.observe("GetProfile", begin, err)
+	}(time.Now())
+
+	return mw.next.GetProfile(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) IntrospectToken(ctx context.Context, token string) (introspection Introspection, err error) {
+	defer func(begin time.Time) {
+		mw.observe("IntrospectToken", begin, err)
+	}(time.Now())
+
+	return mw.next.IntrospectToken(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) PublicJWKS(ctx context.Context) (set JSONWebKeySet, err error) {
+	defer func(begin time.Time) {
+		mw.observe("PublicJWKS", begin, err)
+	}(time.Now())
+
+	return mw.next.PublicJWKS(ctx)
+}
+
+func (mw *instrumentingMiddleware) ListSessions(ctx context.Context, token, cursor string, limit int) (page SessionPage, err error) {
+	defer func(begin time.Time) {
+		mw.observe("ListSessions", begin, err)
+	}(time.Now())
+
+	return mw.next.ListSessions(ctx, token, cursor, limit)
+}
+
+func (mw *instrumentingMiddleware) RevokeAllSessions(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("RevokeAllSessions", begin, err)
+	}(time.Now())
+
+	return mw.next.RevokeAllSessions(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) RevokeSession(ctx context.Context, token, sessionID string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("RevokeSession", begin, err)
+
+		if err == nil {
+			mw.activeSessions.Add(-1)
+		}
+	}(time.Now())
+
+	return mw.next.RevokeSession(ctx, token, sessionID)
+}
+
+func (mw *instrumentingMiddleware) SetUserActive(ctx context.Context, token, username string, active bool) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("SetUserActive", begin, err)
+	}(time.Now())
+
+	return mw.next.SetUserActive(ctx, token, username, active)
+}
+
+func (mw *instrumentingMiddleware) ListUsers(ctx context.Context, token string, offset, limit int) (page UserPage, err error) {
+	defer func(begin time.Time) {
+		mw.observe("ListUsers", begin, err)
+	}(time.Now())
+
+	return mw.next.ListUsers(ctx, token, offset, limit)
+}
+
+func (mw *instrumentingMiddleware) SessionStats(ctx context.Context, token string) (stats SessionStats, err error) {
+	defer func(begin time.Time) {
+		mw.observe("SessionStats", begin, err)
+	}(time.Now())
+
+	return mw.next.SessionStats(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) ChangePassword(ctx context.Context, token, oldPass, newPass string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("ChangePassword", begin, err)
+	}(time.Now())
+
+	return mw.next.ChangePassword(ctx, token, oldPass, newPass)
+}
+
+func (mw *instrumentingMiddleware) RenameUsername(ctx context.Context, token, newUsername string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("RenameUsername", begin, err)
+	}(time.Now())
+
+	return mw.next.RenameUsername(ctx, token, newUsername)
+}
+
+func (mw *instrumentingMiddleware) DeleteAccount(ctx context.Context, token, password string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("DeleteAccount", begin, err)
+	}(time.Now())
+
+	return mw.next.DeleteAccount(ctx, token, password)
+}
+
+func (mw *instrumentingMiddleware) GenerateVerificationToken(ctx context.Context, username string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("GenerateVerificationToken", begin, err)
+	}(time.Now())
+
+	return mw.next.GenerateVerificationToken(ctx, username)
+}
+
+func (mw *instrumentingMiddleware) VerifyEmail(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("VerifyEmail", begin, err)
+	}(time.Now())
+
+	return mw.next.VerifyEmail(ctx, token)
+}
+
+func (mw *instrumentingMiddleware) ResendVerification(ctx context.Context, usernameOrEmail string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("ResendVerification", begin, err)
+	}(time.Now())
+
+	return mw.next.ResendVerification(ctx, usernameOrEmail)
+}
+
+func (mw *instrumentingMiddleware) RequestPasswordReset(ctx context.Context, usernameOrEmail string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.observe("RequestPasswordReset", begin, err)
+	}(time.Now())
+
+	return mw.next.RequestPasswordReset(ctx, usernameOrEmail)
+}
+
+func (mw *instrumentingMiddleware) ResetPassword(ctx context.Context, resetToken, newPass string) (err error) {
+	defer func(begin time.Time) {
+		mw.observe("ResetPassword", begin, err)
+	}(time.Now())
+
+	return mw.next.ResetPassword(ctx, resetToken, newPass)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// recordedMetric is a metrics.Counter, Gauge and Histogram keeping the sum
+// of what was recorded per label values, and the number of observations.
+type recordedMetric struct {
+	mu     *sync.Mutex
+	sums   map[string]float64
+	counts map[string]int
+	lvs    []string
+}
+
+func newRecordedMetric() *recordedMetric {
+	return &recordedMetric{mu: new(sync.Mutex), sums: make(map[string]float64), counts: make(map[string]int)}
+}
+
+func (m *recordedMetric) with(labelValues ...string) *recordedMetric {
+	with := *m
+	with.lvs = append(append([]string(nil), m.lvs...), labelValues...)
+
+	return &with
+}
+
+func (m *recordedMetric) record(value float64, add bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.Join(m.lvs, ",")
+	if add {
+		m.sums[key] += value
+	} else {
+		m.sums[key] = value
+	}
+
+	m.counts[key]++
+}
+
+// value returns the sum recorded under labelValues and how many times
+// something was.
+func (m *recordedMetric) value(labelValues ...string) (float64, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.Join(labelValues, ",")
+
+	return m.sums[key], m.counts[key]
+}
+
+type recordedCounter struct{ *recordedMetric }
+
+func (c recordedCounter) With(labelValues ...string) metrics.Counter {
+	return recordedCounter{c.with(labelValues...)}
+}
+
+func (c recordedCounter) Add(delta float64) { c.record(delta, true) }
+
+type recordedGauge struct{ *recordedMetric }
+
+func (g recordedGauge) With(labelValues ...string) metrics.Gauge {
+	return recordedGauge{g.with(labelValues...)}
+}
+
+func (g recordedGauge) Set(value float64) { g.record(value, false) }
+
+func (g recordedGauge) Add(delta float64) { g.record(delta, true) }
+
+type recordedHistogram struct{ *recordedMetric }
+
+func (h recordedHistogram) With(labelValues ...string) metrics.Histogram {
+	return recordedHistogram{h.with(labelValues...)}
+}
+
+func (h recordedHistogram) Observe(value float64) { h.record(value, true) }
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	requests, latency, sessions := newRecordedMetric(), newRecordedMetric(), newRecordedMetric()
+
+	svc := NewInstrumentingMiddleware(recordedCounter{requests}, recordedHistogram{latency}, recordedGauge{sessions})(newTestService(t))
+	mustRegister(t, svc, "alice")
+
+	first := mustLogin(t, svc, "alice")
+	mustLogin(t, svc, "alice")
+
+	if _, err := svc.Login(context.Background(), "alice", "wrong-passw0rd"); err == nil {
+		t.Fatal("Login() with a wrong password succeeded")
+	}
+
+	if err := svc.Logout(context.Background(), first.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		lvs  []string
+		want float64
+	}{
+		{[]string{"method", "Register", "success", "true"}, 1},
+		{[]string{"method", "Login", "success", "true"}, 2},
+		{[]string{"method", "Login", "success", "false"}, 1},
+		{[]string{"method", "Logout", "success", "true"}, 1},
+	} {
+		if got, _ := requests.value(tc.lvs...); got != tc.want {
+			t.Errorf("request count %v = %v, want %v", tc.lvs, got, tc.want)
+		}
+
+		if _, observed := latency.value(tc.lvs...); float64(observed) != tc.want {
+			t.Errorf("latency observations %v = %d, want %v", tc.lvs, observed, tc.want)
+		}
+	}
+
+	if got, _ := sessions.value(); got != 1 {
+		t.Errorf("active sessions = %v, want 1", got)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Integration names an external service the user service calls out to.
+type Integration string
+
+const (
+	IntegrationBreachCheck Integration = "breach_check"
+	IntegrationMailer      Integration = "mailer"
+	IntegrationOAuth       Integration = "oauth"
+)
+
+// FailurePolicy decides what happens to a request when an Integration it
+// needs fails.
+type FailurePolicy string
+
+const (
+	// FailOpen carries on without the integration: a password goes
+	// unchecked, a mail is dropped. The failure is only logged.
+	FailOpen FailurePolicy = "fail-open"
+	// FailClosed fails the request with ErrIntegrationUnavailable.
+	FailClosed FailurePolicy = "fail-closed"
+)
+
+// ErrIntegrationUnavailable is returned when an Integration configured to
+// fail closed cannot be reached.
+var ErrIntegrationUnavailable = errors.New("integration unavailable")
+
+// defaultFailurePolicies keeps registrations and password resets working
+// through outages of the breach API and the mail server. OAuth always fails
+// closed, as without the provider there is no identity to log in with.
+func defaultFailurePolicies() map[Integration]FailurePolicy {
+	return map[Integration]FailurePolicy{
+		IntegrationBreachCheck: FailOpen,
+		IntegrationMailer:      FailOpen,
+		IntegrationOAuth:       FailClosed,
+	}
+}
+
+func validateFailurePolicy(integration Integration, policy FailurePolicy) error {
+	switch integration {
+	case IntegrationBreachCheck, IntegrationMailer:
+	case IntegrationOAuth:
+		if policy != FailClosed {
+			return fmt.Errorf("%s can only fail closed", integration)
+		}
+	default:
+		return fmt.Errorf("unknown integration %q", integration)
+	}
+
+	if policy != FailOpen && policy != FailClosed {
+		return fmt.Errorf("unknown failure policy %q", policy)
+	}
+
+	return nil
+}
+
+// IntegrationHealth is how one Integration has been doing. It is degraded
+// from the call that failed, at Since, until one succeeds again; Error is
+// the latest failure.
+type IntegrationHealth struct {
+	Policy   FailurePolicy `json:"policy"`
+	Degraded bool          `json:"degraded"`
+	Since    time.Time     `json:"since,omitzero"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ServiceHealth reports the configured Integrations by name. Unlike the
+// dependencies of HealthStatus a degraded integration never fails
+// Readiness: the service keeps taking traffic, failing open or closed as
+// configured.
+type ServiceHealth map[Integration]IntegrationHealth
+
+// integrationMonitor tracks which Integrations are degraded, based on the
+// outcome of the latest call to each.
+type integrationMonitor struct {
+	mu     sync.Mutex
+	clock  Clock
+	health map[Integration]IntegrationHealth
+}
+
+func newIntegrationMonitor() *integrationMonitor {
+	return &integrationMonitor{
+		clock:  realClock{},
+		health: make(map[Integration]IntegrationHealth),
+	}
+}
+
+func (m *integrationMonitor) setClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = clock
+}
+
+// record notes the outcome of a call to integration.
+func (m *integrationMonitor) record(integration Integration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.health, integration)
+
+		return
+	}
+
+	health := m.health[integration]
+	if !health.Degraded {
+		health = IntegrationHealth{Degraded: true, Since: m.clock.Now().UTC()}
+	}
+
+	health.Error = err.Error()
+	m.health[integration] = health
+}
+
+// serviceHealth reports every integration in policies.
+func (m *integrationMonitor) serviceHealth(policies map[Integration]FailurePolicy) ServiceHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	health := make(ServiceHealth, len(policies))
+	for integration, policy := range policies {
+		h := m.health[integration]
+		h.Policy = policy
+		health[integration] = h
+	}
+
+	return health
+}
+
+// integrationFailed records err as a failure of integration and decides
+// the request's fate under its FailurePolicy: nil to fail open, or an
+// ErrIntegrationUnavailable to fail closed.
+func (u *userService) integrationFailed(integration Integration, err error) error {
+	u.integrations.record(integration, err)
+
+	if u.failurePolicies[integration] == FailOpen {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s: %v", ErrIntegrationUnavailable, integration, err)
+}
+
+// configuredIntegrations returns the policies of the Integrations in use.
+func (u *userService) configuredIntegrations() map[Integration]FailurePolicy {
+	policies := make(map[Integration]FailurePolicy)
+
+	if u.breachChecker != nil {
+		policies[IntegrationBreachCheck] = u.failurePolicies[IntegrationBreachCheck]
+	}
+
+	if _, ok := u.mailer.(nopMailer); !ok {
+		policies[IntegrationMailer] = u.failurePolicies[IntegrationMailer]
+	}
+
+	if len(u.oauthProviders) > 0 {
+		policies[IntegrationOAuth] = u.failurePolicies[IntegrationOAuth]
+	}
+
+	return policies
+}
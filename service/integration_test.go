@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFailurePolicies fails each integration under each policy it allows.
+func TestFailurePolicies(t *testing.T) {
+	unreachable := breachCheckerFunc(func(context.Context, string) (int, error) {
+		return 0, errors.New("breach API timeout")
+	})
+
+	for _, tt := range []struct {
+		integration Integration
+		policy      FailurePolicy
+		opts        []Option
+		call        func(*userService) error
+		want        error
+	}{
+		{
+			integration: IntegrationBreachCheck,
+			policy:      FailOpen,
+			opts:        []Option{WithBreachChecker(unreachable, 0)},
+			call:        registerAlice,
+		},
+		{
+			integration: IntegrationBreachCheck,
+			policy:      FailClosed,
+			opts:        []Option{WithBreachChecker(unreachable, 0)},
+			call:        registerAlice,
+			want:        ErrIntegrationUnavailable,
+		},
+		{
+			integration: IntegrationMailer,
+			policy:      FailOpen,
+			opts:        []Option{WithMailer(failingMailer{})},
+			call:        requestAliceReset,
+		},
+		{
+			integration: IntegrationMailer,
+			policy:      FailClosed,
+			opts:        []Option{WithMailer(failingMailer{})},
+			call:        requestAliceReset,
+			want:        ErrIntegrationUnavailable,
+		},
+		{
+			integration: IntegrationOAuth,
+			policy:      FailClosed,
+			opts:        []Option{WithOAuthProvider("mock", mockOAuthProvider(nil))},
+			call: func(svc *userService) error {
+				_, err := svc.OAuthLogin(context.Background(), "mock", "code")
+
+				return err
+			},
+			want: ErrOAuthFailed,
+		},
+	} {
+		svc := newTestService(t, append(tt.opts, WithFailurePolicy(tt.integration, tt.policy))...)
+
+		if err := tt.call(svc); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s %s: error = %v, want %v", tt.integration, tt.policy, err, tt.want)
+		}
+
+		svc.mails.Wait()
+
+		if health := svc.HealthCheck(context.Background()).Integrations[tt.integration]; !health.Degraded || health.Policy != tt.policy {
+			t.Errorf("%s %s: health = %+v, want degraded under its policy", tt.integration, tt.policy, health)
+		}
+	}
+}
+
+func registerAlice(svc *userService) error {
+	_, err := svc.Register(context.Background(), "alice", testPassword, "alice@example.com")
+
+	return err
+}
+
+func requestAliceReset(svc *userService) error {
+	if err := registerAlice(svc); err != nil {
+		return err
+	}
+
+	_, err := svc.RequestPasswordReset(context.Background(), "alice")
+
+	return err
+}
+
+func TestWithFailurePolicyValidates(t *testing.T) {
+	for _, tt := range []struct {
+		integration Integration
+		policy      FailurePolicy
+	}{
+		{IntegrationOAuth, FailOpen},
+		{"sms", FailOpen},
+		{IntegrationMailer, "fail-sometimes"},
+	} {
+		if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithFailurePolicy(tt.integration, tt.policy)); err == nil {
+			t.Errorf("WithFailurePolicy(%q, %q) accepted", tt.integration, tt.policy)
+		}
+	}
+}
+
+// TestServiceHealthRecovers checks an integration is reported degraded from
+// its first failure until a call succeeds again, without failing the
+// health check as a whole.
+func TestServiceHealthRecovers(t *testing.T) {
+	var down atomic.Bool
+	checker := breachCheckerFunc(func(context.Context, string) (int, error) {
+		if down.Load() {
+			return 0, errors.New("breach API timeout")
+		}
+
+		return 0, nil
+	})
+
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithBreachChecker(checker, 0), WithMailer(&capturingMailer{}))
+
+	health := svc.HealthCheck(context.Background())
+	if len(health.Integrations) != 2 || health.Integrations[IntegrationBreachCheck].Degraded || health.Integrations[IntegrationMailer].Degraded {
+		t.Fatalf("Integrations = %+v, want the breach check and mailer, neither degraded", health.Integrations)
+	}
+
+	down.Store(true)
+	failedAt := clock.Now()
+
+	for range 2 {
+		if err := svc.checkBreached(context.Background(), testPassword); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(time.Second)
+	}
+
+	health = svc.HealthCheck(context.Background())
+	want := IntegrationHealth{Policy: FailOpen, Degraded: true, Since: failedAt, Error: "breach API timeout"}
+
+	if got := health.Integrations[IntegrationBreachCheck]; got != want {
+		t.Fatalf("Integrations[breach_check] = %+v, want %+v", got, want)
+	}
+
+	if health.Status != HealthStatusOK {
+		t.Fatalf("Status = %q, want a degraded integration not to degrade the service", health.Status)
+	}
+
+	down.Store(false)
+
+	if err := svc.checkBreached(context.Background(), testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := svc.HealthCheck(context.Background()).Integrations[IntegrationBreachCheck]; got.Degraded {
+		t.Fatalf("Integrations[breach_check] after a success = %+v, want recovered", got)
+	}
+}
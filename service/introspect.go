@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Introspection describes an access token the way OAuth2 token introspection
+// (RFC 7662) does: inactive tokens carry no other information.
+type Introspection struct {
+	Active    bool      `json:"active"`
+	Username  string    `json:"username,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// IntrospectToken lets other services validate an access token without
+// parsing it themselves. The token is active only if it verifies, has not
+// expired, was issued for the tenant of ctx, is not on the Denylist and its
+// session still exists. An inactive token is not an error; errors are
+// reserved for failing to reach the Denylist or the session store.
+func (u *userService) IntrospectToken(ctx context.Context, token string) (Introspection, error) {
+	claims, err := u.keys.parseTenantToken(token, TenantFromContext(ctx), accessTokenType)
+	if err != nil {
+		return Introspection{}, nil
+	}
+
+	err = u.checkDenylist(ctx, claims)
+	if errors.Is(err, ErrTokenRevoked) {
+		return Introspection{}, nil
+	}
+
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	session, err := u.claimsSession(ctx, claims)
+	if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrTokenInvalid) || errors.Is(err, ErrAccountSuspended) {
+		return Introspection{}, nil
+	}
+
+	if err != nil {
+		return Introspection{}, fmt.Errorf("error while looking up session: %w", err)
+	}
+
+	return Introspection{
+		Active:    true,
+		Username:  session.Username,
+		Tenant:    claims.Tenant,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Minute))
+	mustRegister(t, svc, "alice")
+
+	active := mustLogin(t, svc, "alice")
+	loggedOut := mustLogin(t, svc, "alice")
+
+	if err := svc.Logout(context.Background(), loggedOut.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	introspection, err := svc.IntrospectToken(context.Background(), active.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Introspection{Active: true, Username: "alice", ExpiresAt: clock.Now().Add(time.Minute)}); introspection != want {
+		t.Fatalf("IntrospectToken() = %+v, want %+v", introspection, want)
+	}
+
+	inactive := map[string]string{
+		"logged out":                loggedOut.AccessToken,
+		"malformed":                 "not-a-token",
+		"refresh token":             active.RefreshToken,
+		"issued for another tenant": active.AccessToken,
+	}
+
+	for name, token := range inactive {
+		ctx := context.Background()
+		if name == "issued for another tenant" {
+			ctx = ContextWithTenant(ctx, "other")
+		}
+
+		if introspection, err := svc.IntrospectToken(ctx, token); err != nil || introspection != (Introspection{}) {
+			t.Errorf("IntrospectToken() of a %s token = %+v, %v, want inactive", name, introspection, err)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	if introspection, err := svc.IntrospectToken(context.Background(), active.AccessToken); err != nil || introspection != (Introspection{}) {
+		t.Fatalf("IntrospectToken() of an expired token = %+v, %v, want inactive", introspection, err)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultInviteTTL = 7 * 24 * time.Hour
+
+	invitePurpose = "invite"
+)
+
+var (
+	// ErrInviteRequired is returned by Register when WithInviteOnly is set
+	// and no invite code was given.
+	ErrInviteRequired = errors.New("registration requires an invite code")
+	// ErrInvalidInvite is returned by RegisterWithInvite for codes that are
+	// unknown, already used or expired.
+	ErrInvalidInvite = errors.New("invalid invite code")
+)
+
+// CreateInvite mints a single-use invite code for RegisterWithInvite. Only
+// admins can create invites. Codes live in the one-time token store next to
+// verification and reset tokens, scoped to their own purpose, and expire
+// after the WithInviteTTL duration.
+func (u *userService) CreateInvite(ctx context.Context, token string) (string, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	admin, err := u.requireRole(ctx, token, RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, invitePurpose, code), admin, u.inviteTTL); err != nil {
+		return "", fmt.Errorf("error while saving invite: %w", err)
+	}
+
+	return code, nil
+}
+
+// RegisterWithInvite registers like Register, redeeming code from
+// CreateInvite. The code is only used up once the username is known to be
+// free, so a taken username or a weak password leaves it valid for another
+// try.
+func (u *userService) RegisterWithInvite(ctx context.Context, user, pass, email, code string) (string, error) {
+	if code == "" {
+		return "", ErrInviteRequired
+	}
+
+	return u.register(ctx, user, pass, email, code, nil)
+}
+
+// redeemInvite consumes code. It must be called with u.mu held.
+func (u *userService) redeemInvite(ctx context.Context, code string) error {
+	if _, err := u.oneTimeTokens.Take(ctx, oneTimeTokenKey(ctx, invitePurpose, code)); err != nil {
+		if errors.Is(err, ErrOneTimeTokenNotFound) {
+			return ErrInvalidInvite
+		}
+
+		return fmt.Errorf("error while redeeming invite: %w", err)
+	}
+
+	return nil
+}
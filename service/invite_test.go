@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newInviteOnlyService returns an invite-only service with an admin and a
+// plain user, registered before registration was closed, and the admin's
+// access token.
+func newInviteOnlyService(t *testing.T, opts ...Option) (*userService, string) {
+	users := NewMemoryUserRepository()
+
+	open := newTestServiceWithRepository(t, users)
+	mustRegister(t, open, "admin", RoleUser, RoleAdmin)
+	mustRegister(t, open, "alice")
+
+	svc := newTestServiceWithRepository(t, users, append(opts, WithInviteOnly())...)
+
+	return svc, mustLogin(t, svc, "admin").AccessToken
+}
+
+func TestRegisterWithInvite(t *testing.T) {
+	svc, admin := newInviteOnlyService(t)
+
+	if _, err := svc.Register(context.Background(), "bob", testPassword, "bob@example.com"); !errors.Is(err, ErrInviteRequired) {
+		t.Fatalf("Register() error = %v, want ErrInviteRequired", err)
+	}
+
+	if _, err := svc.CreateInvite(context.Background(), mustLogin(t, svc, "alice").AccessToken); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("CreateInvite() as a plain user error = %v, want ErrForbidden", err)
+	}
+
+	code, err := svc.CreateInvite(context.Background(), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A taken username leaves the code valid for another try.
+	if _, err := svc.RegisterWithInvite(context.Background(), "alice", testPassword, "other@example.com", code); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("RegisterWithInvite() of a taken username error = %v, want ErrUserAlreadyExists", err)
+	}
+
+	if _, err := svc.RegisterWithInvite(context.Background(), "bob", testPassword, "bob@example.com", code); err != nil {
+		t.Fatal(err)
+	}
+
+	mustLogin(t, svc, "bob")
+
+	if _, err := svc.RegisterWithInvite(context.Background(), "carol", testPassword, "carol@example.com", code); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("RegisterWithInvite() with a used code error = %v, want ErrInvalidInvite", err)
+	}
+
+	for _, code := range []string{"", "not-a-code"} {
+		if _, err := svc.RegisterWithInvite(context.Background(), "carol", testPassword, "carol@example.com", code); err == nil {
+			t.Fatalf("RegisterWithInvite() with code %q succeeded", code)
+		}
+	}
+}
+
+func TestInviteExpires(t *testing.T) {
+	clock := newFakeClock()
+	svc, admin := newInviteOnlyService(t, WithClock(clock), WithInviteTTL(time.Hour))
+
+	code, err := svc.CreateInvite(context.Background(), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Hour + time.Second)
+
+	if _, err := svc.RegisterWithInvite(context.Background(), "bob", testPassword, "bob@example.com", code); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("RegisterWithInvite() with an expired code error = %v, want ErrInvalidInvite", err)
+	}
+}
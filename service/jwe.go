@@ -0,0 +1,266 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EncryptionKeySize is the size in bytes of the AES-256 keys tokens are
+// encrypted with.
+const EncryptionKeySize = 32
+
+// Header values of the tokens a KeyManager encrypts: a JWE using the key
+// directly as an A256GCM content key and carrying a signed JWT.
+const (
+	jweAlgorithm   = "dir"
+	jweEncryption  = "A256GCM"
+	jweContentType = "JWT"
+)
+
+// encryptionKeyID names the key WithEncryptedTokens adds to the KeyManager.
+// It is kept apart from the signing key IDs so it never replaces a key the
+// caller registered.
+const encryptionKeyID = "encrypted-tokens"
+
+// jweHeader is the protected header of an encrypted token.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Kid string `json:"kid,omitempty"`
+	Cty string `json:"cty"`
+}
+
+// AddEncryptionKey registers key under kid, replacing any key already
+// there. key must be EncryptionKeySize bytes long. Like a signing key it
+// only decrypts tokens until SetActiveEncryptionKey makes it the key new
+// tokens are encrypted with.
+func (k *KeyManager) AddEncryptionKey(kid string, key []byte) error {
+	if kid == "" {
+		return fmt.Errorf("encryption key id cannot be empty")
+	}
+
+	if len(key) != EncryptionKeySize {
+		return fmt.Errorf("encryption key %q must be %d bytes, got %d", kid, EncryptionKeySize, len(key))
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.encryptionKeys == nil {
+		k.encryptionKeys = make(map[string][]byte)
+	}
+
+	k.encryptionKeys[kid] = append([]byte(nil), key...)
+
+	return nil
+}
+
+// hasEncryptionKey reports whether a key is registered under kid.
+func (k *KeyManager) hasEncryptionKey(kid string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	_, ok := k.encryptionKeys[kid]
+
+	return ok
+}
+
+// SetActiveEncryptionKey makes kid the key new tokens are encrypted with.
+// From then on every token is signed and then encrypted, and tokens that are
+// only signed are rejected.
+func (k *KeyManager) SetActiveEncryptionKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.encryptionKeys[kid]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+	}
+
+	k.activeEncryption = kid
+
+	return nil
+}
+
+// RemoveEncryptionKey retires kid; tokens encrypted with it stop parsing.
+func (k *KeyManager) RemoveEncryptionKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == k.activeEncryption {
+		return ErrActiveKeyRemoval
+	}
+
+	delete(k.encryptionKeys, kid)
+
+	return nil
+}
+
+// activeEncryptionKey returns the key new tokens are encrypted with, or a
+// nil key when tokens are only signed.
+func (k *KeyManager) activeEncryptionKey() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeEncryption, k.encryptionKeys[k.activeEncryption]
+}
+
+// decryptionKey looks up kid, defaulting to the active key like
+// verificationKey.
+func (k *KeyManager) decryptionKey(kid string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" {
+		kid = k.activeEncryption
+	}
+
+	key, ok := k.encryptionKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+	}
+
+	return key, nil
+}
+
+// encryptToken wraps a signed token in a JWE in compact serialization, with
+// the protected header as additional authenticated data. A token is returned
+// as is while no encryption key is active.
+func (k *KeyManager) encryptToken(signedToken string) (string, error) {
+	kid, key := k.activeEncryptionKey()
+	if key == nil {
+		return signedToken, nil
+	}
+
+	header, err := json.Marshal(jweHeader{Alg: jweAlgorithm, Enc: jweEncryption, Kid: kid, Cty: jweContentType})
+	if err != nil {
+		return "", fmt.Errorf("error while encoding JWE header: %w", err)
+	}
+
+	aead, err := newTokenAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", fmt.Errorf("error while generating JWE IV: %w", err)
+	}
+
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	sealed := aead.Seal(nil, iv, []byte(signedToken), []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	return strings.Join([]string{
+		encodedHeader,
+		"",
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// decryptToken returns the signed token inside a token from encryptToken.
+// The token is returned as is while no encryption key is active; otherwise
+// anything but a JWE with the expected header fails.
+func (k *KeyManager) decryptToken(token string) (string, error) {
+	if _, key := k.activeEncryptionKey(); key == nil {
+		return token, nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("token is not encrypted")
+	}
+
+	if parts[1] != "" {
+		return "", fmt.Errorf("unexpected JWE encrypted key")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed JWE header: %w", err)
+	}
+
+	kid, err := checkJWEHeader(rawHeader)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := k.decryptionKey(kid)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newTokenAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	var decoded [3][]byte
+	for i, part := range parts[2:] {
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return "", fmt.Errorf("malformed JWE: %w", err)
+		}
+	}
+
+	iv, ciphertext, tag := decoded[0], decoded[1], decoded[2]
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", fmt.Errorf("malformed JWE: bad IV or tag length")
+	}
+
+	signedToken, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("error while decrypting token: %w", err)
+	}
+
+	return string(signedToken), nil
+}
+
+// checkJWEHeader vets the protected header of an encrypted token like
+// checkHeader does for signed ones and returns its kid. Only the header
+// encryptToken writes is accepted, which among others rules out compression
+// and keys brought along by the token.
+func checkJWEHeader(rawHeader []byte) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(rawHeader, &fields); err != nil {
+		return "", fmt.Errorf("malformed JWE header: %w", err)
+	}
+
+	for name, value := range fields {
+		switch name {
+		case "alg", "enc", "kid", "cty":
+			if s, ok := value.(string); !ok || s == "" {
+				return "", fmt.Errorf("malformed %q JWE header %v", name, value)
+			}
+		default:
+			return "", fmt.Errorf("unexpected %q JWE header", name)
+		}
+	}
+
+	if fields["alg"] != jweAlgorithm || fields["enc"] != jweEncryption || fields["cty"] != jweContentType {
+		return "", fmt.Errorf("unexpected JWE algorithm %v, encryption %v or content type %v", fields["alg"], fields["enc"], fields["cty"])
+	}
+
+	kid, _ := fields["kid"].(string)
+
+	return kid, nil
+}
+
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating token cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating token cipher: %w", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEncryptingKeyManager returns an HS256 KeyManager encrypting tokens with
+// encryptionKey under kid.
+func newEncryptingKeyManager(t *testing.T, kid string, encryptionKey []byte) *KeyManager {
+	t.Helper()
+
+	keys, err := NewKeyManager(AlgorithmHS256, defaultKeyID, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.AddEncryptionKey(kid, encryptionKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.SetActiveEncryptionKey(kid); err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestEncryptedTokenRoundTrip(t *testing.T) {
+	keys := newEncryptingKeyManager(t, "enc-1", bytes.Repeat([]byte{2}, EncryptionKeySize))
+
+	token, err := keys.CreateToken("secret-session-id", "", []string{RoleAdmin}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		t.Fatalf("token has %d parts, want a compact JWE", len(parts))
+	}
+
+	// Neither the claims nor the signed JWT carrying them show through.
+	for _, part := range parts {
+		raw, _ := base64.RawURLEncoding.DecodeString(part)
+		if bytes.Contains(raw, []byte("secret-session-id")) || bytes.Contains(raw, []byte(RoleAdmin)) || bytes.Contains(raw, []byte("HS256")) {
+			t.Fatalf("token part %q gives the claims away", raw)
+		}
+	}
+
+	claims, err := keys.parseToken(token, accessTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.SessionID != "secret-session-id" || len(claims.Roles) != 1 || claims.Roles[0] != RoleAdmin {
+		t.Fatalf("claims = %+v, want the ones the token was created with", claims)
+	}
+}
+
+func TestEncryptedTokenUnreadableWithoutKey(t *testing.T) {
+	keys := newEncryptingKeyManager(t, "enc-1", bytes.Repeat([]byte{2}, EncryptionKeySize))
+
+	token, err := keys.CreateToken("s1", "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same signing key and key ID, another encryption key.
+	other := newEncryptingKeyManager(t, "enc-1", bytes.Repeat([]byte{3}, EncryptionKeySize))
+
+	signedOnly, err := NewKeyManager(AlgorithmHS256, defaultKeyID, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, keys := range map[string]*KeyManager{"wrong key": other, "no key": signedOnly} {
+		if _, err := keys.parseToken(token, accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: parseToken() error = %v, want ErrTokenInvalid", name, err)
+		}
+	}
+
+	parts := strings.Split(token, ".")
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext[0] ^= 1
+	parts[3] = base64.RawURLEncoding.EncodeToString(ciphertext)
+
+	if _, err := keys.parseToken(strings.Join(parts, "."), accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("parseToken() of a tampered ciphertext error = %v, want ErrTokenInvalid", err)
+	}
+
+	// Once tokens are encrypted, merely signed ones are refused.
+	plain, err := signedOnly.CreateToken("s1", "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.parseToken(plain, accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("parseToken() of a signed-only token error = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	keys := newEncryptingKeyManager(t, "enc-1", bytes.Repeat([]byte{2}, EncryptionKeySize))
+
+	before, err := keys.CreateToken("s1", "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.AddEncryptionKey("enc-2", bytes.Repeat([]byte{3}, EncryptionKeySize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.SetActiveEncryptionKey("enc-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := keys.CreateToken("s2", "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{before, after} {
+		if _, err := keys.parseToken(token, accessTokenType); err != nil {
+			t.Fatalf("parseToken() after rotation error = %v", err)
+		}
+	}
+
+	if err := keys.RemoveEncryptionKey("enc-2"); !errors.Is(err, ErrActiveKeyRemoval) {
+		t.Fatalf("RemoveEncryptionKey() of the active key error = %v, want ErrActiveKeyRemoval", err)
+	}
+
+	if err := keys.RemoveEncryptionKey("enc-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.parseToken(before, accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("parseToken() of a token of a removed key error = %v, want ErrTokenInvalid", err)
+	}
+
+	if _, err := keys.parseToken(after, accessTokenType); err != nil {
+		t.Fatalf("parseToken() of a token of the active key error = %v", err)
+	}
+}
+
+func TestWithEncryptedTokens(t *testing.T) {
+	svc := newTestService(t, WithEncryptedTokens(bytes.Repeat([]byte{2}, EncryptionKeySize)))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	for _, token := range []string{session.AccessToken, session.RefreshToken} {
+		if n := strings.Count(token, "."); n != 4 {
+			t.Fatalf("token %q is not a compact JWE", token)
+		}
+	}
+
+	if state, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil || state.Username != "alice" {
+		t.Fatalf("GetHomeState() = %+v, %v, want alice", state, err)
+	}
+
+	if _, err := svc.Refresh(context.Background(), session.RefreshToken); err != nil {
+		t.Fatalf("Refresh() with an encrypted refresh token error = %v", err)
+	}
+
+	if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithEncryptedTokens([]byte("too short"))); err == nil {
+		t.Fatal("WithEncryptedTokens() accepted a short key")
+	}
+}
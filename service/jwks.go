@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// ErrNoPublicKeys is returned by PublicJWKS when tokens are signed with
+// HS256, whose secrets must never be published.
+var ErrNoPublicKeys = errors.New("signing keys are symmetric and have no public part")
+
+// JSONWebKey is the public half of a signing key as RFC 7517 describes it.
+// RSA keys fill N and E, EC keys Crv, X and Y.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicJWKS returns the public keys of every key still verifying tokens:
+// the active one first, then the keys rotated out but not removed yet, so
+// other services can verify tokens locally by their kid.
+func (u *userService) PublicJWKS(_ context.Context) (JSONWebKeySet, error) {
+	return u.keys.PublicJWKS()
+}
+
+// PublicJWKS returns the public keys of the manager, the active key first and
+// the rest sorted by key ID. It fails with ErrNoPublicKeys for HS256.
+func (k *KeyManager) PublicJWKS() (JSONWebKeySet, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(k.keys))}
+
+	for kid, key := range k.keys {
+		jwk := JSONWebKey{Use: "sig", Alg: k.method.Alg(), Kid: kid}
+
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64URL(public.N.Bytes())
+			jwk.E = base64URL(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64URL(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64URL(public.Y.FillBytes(make([]byte, size)))
+		default:
+			return JSONWebKeySet{}, ErrNoPublicKeys
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		if (set.Keys[i].Kid == k.active) != (set.Keys[j].Kid == k.active) {
+			return set.Keys[i].Kid == k.active
+		}
+
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set, nil
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ecPublicKey rebuilds the P-256 key a JSONWebKey describes.
+func ecPublicKey(t *testing.T, jwk JSONWebKey) *ecdsa.PublicKey {
+	t.Helper()
+
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" {
+		t.Fatalf("key %+v, want an EC P-256 key", jwk)
+	}
+
+	coordinate := func(s string) *big.Int {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return new(big.Int).SetBytes(raw)
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: coordinate(jwk.X), Y: coordinate(jwk.Y)}
+}
+
+func TestPublicJWKSVerifiesIssuedTokens(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewKeyManager(AlgorithmES256, "2024-01", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestService(t, WithKeyManager(keys))
+	mustRegister(t, svc, "alice")
+	before := mustLogin(t, svc, "alice")
+
+	if err := keys.AddSigningKey("2024-02", newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.SetActiveKey("2024-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	after := mustLogin(t, svc, "alice")
+
+	set, err := svc.PublicJWKS(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "2024-02" || set.Keys[1].Kid != "2024-01" {
+		t.Fatalf("PublicJWKS() = %+v, want the active key then the rotated one", set)
+	}
+
+	byKid := make(map[string]JSONWebKey)
+	for _, jwk := range set.Keys {
+		if jwk.Alg != AlgorithmES256 || jwk.Use != "sig" {
+			t.Errorf("key %+v, want alg ES256 and use sig", jwk)
+		}
+
+		byKid[jwk.Kid] = jwk
+	}
+
+	for _, token := range []string{before.AccessToken, after.AccessToken} {
+		_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			jwk, ok := byKid[tokenKeyID(t, token.Raw)]
+			if !ok {
+				return nil, errors.New("unknown kid")
+			}
+
+			return ecPublicKey(t, jwk), nil
+		})
+		if err != nil {
+			t.Fatalf("verifying a token with the published key: %v", err)
+		}
+	}
+}
+
+func TestPublicJWKSWithSymmetricKeys(t *testing.T) {
+	keys, err := NewKeyManager(AlgorithmHS256, defaultKeyID, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestService(t, WithKeyManager(keys))
+
+	if _, err := svc.PublicJWKS(context.Background()); !errors.Is(err, ErrNoPublicKeys) {
+		t.Fatalf("PublicJWKS() error = %v, want ErrNoPublicKeys", err)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultKeyID names the signing key NewUserService falls back to when no
+// KeyManager is configured.
+const defaultKeyID = "default"
+
+// Signing algorithms a KeyManager can be configured with.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+var (
+	// ErrUnknownKeyID is returned when a kid has no key in the KeyManager.
+	ErrUnknownKeyID = errors.New("unknown signing key id")
+	// ErrActiveKeyRemoval is returned by RemoveSigningKey for the active key.
+	ErrActiveKeyRemoval = errors.New("cannot remove the active signing key")
+	// ErrUnsupportedAlgorithm is returned for an algorithm other than HS256,
+	// RS256 and ES256.
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+type signingKey struct {
+	sign   interface{}
+	verify interface{}
+}
+
+// KeyManager holds the keys tokens are signed and verified with, indexed by
+// key ID. Every key uses the manager's algorithm: tokens whose alg header
+// names any other algorithm are rejected, which rules out "none" and HMAC
+// tokens forged with an RSA public key, and so are tokens with a kid naming
+// no key or headers bringing keys of their own. New tokens are signed with
+// the active key and carry its ID in the kid header, so rotating the active
+// key keeps older tokens verifiable until they expire or their key is
+// removed.
+//
+// Encryption keys rotate the same way: once one is active, signed tokens are
+// encrypted with it too, see AddEncryptionKey.
+type KeyManager struct {
+	mu     sync.RWMutex
+	method jwt.SigningMethod
+	keys   map[string]signingKey
+	active string
+	clock  Clock
+
+	encryptionKeys   map[string][]byte
+	activeEncryption string
+}
+
+// NewKeyManager returns a KeyManager for alg whose active key is key under
+// kid. See AddSigningKey for the key types each algorithm takes.
+func NewKeyManager(alg, kid string, key interface{}) (*KeyManager, error) {
+	var method jwt.SigningMethod
+
+	switch alg {
+	case AlgorithmHS256:
+		method = jwt.SigningMethodHS256
+	case AlgorithmRS256:
+		method = jwt.SigningMethodRS256
+	case AlgorithmES256:
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	k := &KeyManager{
+		method: method,
+		keys:   make(map[string]signingKey),
+		clock:  realClock{},
+	}
+
+	if err := k.AddSigningKey(kid, key); err != nil {
+		return nil, err
+	}
+
+	if err := k.SetActiveKey(kid); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *KeyManager) setClock(clock Clock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.clock = clock
+}
+
+func (k *KeyManager) now() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.clock.Now()
+}
+
+// Algorithm returns the algorithm every token is signed with.
+func (k *KeyManager) Algorithm() string {
+	return k.method.Alg()
+}
+
+// AddSigningKey registers key under kid, replacing any key already there.
+// HS256 takes a []byte secret, RS256 an *rsa.PrivateKey and ES256 an
+// *ecdsa.PrivateKey on the P-256 curve. The key only verifies tokens until
+// SetActiveKey makes it the signing key.
+func (k *KeyManager) AddSigningKey(kid string, key interface{}) error {
+	if kid == "" {
+		return fmt.Errorf("signing key id cannot be empty")
+	}
+
+	var stored signingKey
+
+	switch k.method {
+	case jwt.SigningMethodHS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return fmt.Errorf("%s key %q must be a non-empty []byte, got %T", AlgorithmHS256, kid, key)
+		}
+
+		secret = append([]byte(nil), secret...)
+		stored = signingKey{sign: secret, verify: secret}
+	case jwt.SigningMethodRS256:
+		private, ok := key.(*rsa.PrivateKey)
+		if !ok || private == nil {
+			return fmt.Errorf("%s key %q must be an *rsa.PrivateKey, got %T", AlgorithmRS256, kid, key)
+		}
+
+		stored = signingKey{sign: private, verify: &private.PublicKey}
+	case jwt.SigningMethodES256:
+		private, ok := key.(*ecdsa.PrivateKey)
+		if !ok || private == nil || private.Curve != elliptic.P256() {
+			return fmt.Errorf("%s key %q must be a P-256 *ecdsa.PrivateKey, got %T", AlgorithmES256, kid, key)
+		}
+
+		stored = signingKey{sign: private, verify: &private.PublicKey}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[kid] = stored
+
+	return nil
+}
+
+// SetActiveKey makes kid the key new tokens are signed with.
+func (k *KeyManager) SetActiveKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+	}
+
+	k.active = kid
+
+	return nil
+}
+
+// RemoveSigningKey retires kid; tokens signed with it stop verifying.
+func (k *KeyManager) RemoveSigningKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == k.active {
+		return ErrActiveKeyRemoval
+	}
+
+	delete(k.keys, kid)
+
+	return nil
+}
+
+// ParsePrivateKeyPEM decodes a PEM encoded private key for alg, ready to be
+// passed to NewKeyManager or AddSigningKey.
+func ParsePrivateKeyPEM(alg string, pemBytes []byte) (interface{}, error) {
+	switch alg {
+	case AlgorithmRS256:
+		return jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	case AlgorithmES256:
+		return jwt.ParseECPrivateKeyFromPEM(pemBytes)
+	default:
+		return nil, fmt.Errorf("%w for a PEM key: %q", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+func (k *KeyManager) activeKey() (string, interface{}) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active, k.keys[k.active].sign
+}
+
+// verificationKey looks up kid. Tokens issued before key IDs were introduced
+// carry none and are checked against the active key.
+func (k *KeyManager) verificationKey(kid string) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" {
+		kid = k.active
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+	}
+
+	return key.verify, nil
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// tokenKeyID returns the kid header of a signed token.
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+
+	return kid
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	keys, err := NewKeyManager(AlgorithmHS256, "2024-01", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestService(t, WithKeyManager(keys))
+	mustRegister(t, svc, "alice")
+
+	before := mustLogin(t, svc, "alice")
+	if kid := tokenKeyID(t, before.AccessToken); kid != "2024-01" {
+		t.Fatalf("kid = %q, want 2024-01", kid)
+	}
+
+	if err := keys.SetActiveKey("2024-02"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("SetActiveKey() of an unknown kid error = %v, want ErrUnknownKeyID", err)
+	}
+
+	if err := keys.AddSigningKey("2024-02", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.SetActiveKey("2024-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	after := mustLogin(t, svc, "alice")
+	if kid := tokenKeyID(t, after.AccessToken); kid != "2024-02" {
+		t.Fatalf("kid after rotation = %q, want 2024-02", kid)
+	}
+
+	for _, token := range []string{before.AccessToken, after.AccessToken} {
+		if _, err := svc.GetHomeState(context.Background(), token); err != nil {
+			t.Fatalf("GetHomeState() after rotation error = %v", err)
+		}
+	}
+
+	if err := keys.RemoveSigningKey("2024-02"); !errors.Is(err, ErrActiveKeyRemoval) {
+		t.Fatalf("RemoveSigningKey() of the active key error = %v, want ErrActiveKeyRemoval", err)
+	}
+
+	if err := keys.RemoveSigningKey("2024-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), before.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token of a removed key succeeded")
+	}
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alg, key := range map[string]interface{}{
+		AlgorithmHS256: bytes.Repeat([]byte{1}, 32),
+		AlgorithmRS256: rsaKey,
+		AlgorithmES256: ecKey,
+	} {
+		keys, err := NewKeyManager(alg, defaultKeyID, key)
+		if err != nil {
+			t.Fatalf("NewKeyManager(%s) error = %v", alg, err)
+		}
+
+		if keys.Algorithm() != alg {
+			t.Errorf("Algorithm() = %q, want %q", keys.Algorithm(), alg)
+		}
+
+		token, err := keys.CreateToken("s1", "", nil, DefaultTokenTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := keys.parseToken(token, accessTokenType); err != nil {
+			t.Errorf("%s: parseToken() error = %v", alg, err)
+		}
+	}
+
+	if _, err := NewKeyManager(AlgorithmRS256, defaultKeyID, bytes.Repeat([]byte{1}, 32)); err == nil {
+		t.Error("NewKeyManager(RS256) with an HMAC secret succeeded")
+	}
+
+	if _, err := NewKeyManager("HS512", defaultKeyID, bytes.Repeat([]byte{1}, 32)); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("NewKeyManager(HS512) error = %v, want ErrUnsupportedAlgorithm", err)
+	}
+}
+
+// TestWrongAlgorithmRejected forges tokens for an RS256 KeyManager with
+// other algorithms: HS256 keyed with the public key, as in the classic
+// algorithm confusion attack, and none.
+func TestWrongAlgorithmRejected(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewKeyManager(AlgorithmRS256, defaultKeyID, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	claims := customClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		SessionID:      "s1",
+		TokenType:      accessTokenType,
+	}
+
+	forge := func(method jwt.SigningMethod, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = defaultKeyID
+
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signed
+	}
+
+	for name, token := range map[string]string{
+		"HS256 with the public key PEM": forge(jwt.SigningMethodHS256, publicPEM),
+		"HS256 with the public key DER": forge(jwt.SigningMethodHS256, publicDER),
+		"none":                          forge(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+	} {
+		if _, err := keys.parseToken(token, accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("parseToken() of a token signed %s error = %v, want ErrTokenInvalid", name, err)
+		}
+	}
+
+	// The same claims signed properly are accepted.
+	if _, err := keys.parseToken(forge(jwt.SigningMethodRS256, rsaKey), accessTokenType); err != nil {
+		t.Fatalf("parseToken() of a genuine token error = %v", err)
+	}
+}
+
+// TestTamperedTokensRejected takes a genuine HS256 token apart and puts it
+// back together with its header, claims or signature changed.
+func TestTamperedTokensRejected(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	keys, err := NewKeyManager(AlgorithmHS256, defaultKeyID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := customClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		SessionID:      "s1",
+		TokenType:      accessTokenType,
+	}
+
+	// sign returns a token with header and claims, signed with key unless
+	// key is nil.
+	sign := func(header map[string]interface{}, claims customClaims, key []byte) string {
+		segment := func(v interface{}) string {
+			raw, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return base64.RawURLEncoding.EncodeToString(raw)
+		}
+
+		signingInput := segment(header) + "." + segment(claims)
+		if key == nil {
+			return signingInput + "."
+		}
+
+		signature, err := jwt.SigningMethodHS256.Sign(signingInput, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signingInput + "." + signature
+	}
+
+	header := func(alg string, extra ...interface{}) map[string]interface{} {
+		h := map[string]interface{}{"alg": alg, "typ": "JWT", "kid": defaultKeyID}
+		for i := 0; i < len(extra); i += 2 {
+			h[extra[i].(string)] = extra[i+1]
+		}
+
+		return h
+	}
+
+	genuine := sign(header("HS256"), claims, key)
+	if _, err := keys.parseToken(genuine, accessTokenType); err != nil {
+		t.Fatalf("parseToken() of a genuine token error = %v", err)
+	}
+
+	parts := strings.Split(genuine, ".")
+	escalated := claims
+	escalated.SessionID = "someone-else"
+
+	for name, token := range map[string]string{
+		"alg none":              sign(header("none"), claims, nil),
+		"alg None":              sign(header("None"), claims, nil),
+		"unsigned":              parts[0] + "." + parts[1] + ".",
+		"alg none, signature":   sign(header("none"), claims, nil) + parts[2],
+		"alg HS512":             sign(header("HS512"), claims, key),
+		"unknown kid":           sign(header("HS256", "kid", "2099-01"), claims, key),
+		"numeric kid":           sign(header("HS256", "kid", 1), claims, key),
+		"embedded jwk":          sign(header("HS256", "jwk", map[string]string{"kty": "oct", "k": "AQ"}), claims, key),
+		"crit extension":        sign(header("HS256", "crit", []string{"exp"}), claims, key),
+		"claims changed":        strings.Join([]string{parts[0], strings.Split(sign(header("HS256"), escalated, nil), ".")[1], parts[2]}, "."),
+		"signed with other key": sign(header("HS256"), claims, bytes.Repeat([]byte{2}, 32)),
+	} {
+		if _, err := keys.parseToken(token, accessTokenType); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: parseToken() error = %v, want ErrTokenInvalid", name, err)
+		}
+	}
+}
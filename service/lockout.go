@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLockoutThreshold = 5
+	DefaultLockoutDuration  = 15 * time.Minute
+
+	// lockoutPruneInterval is how many new entries are tracked between sweeps
+	// of stale ones, which keeps guessed usernames from piling up forever.
+	lockoutPruneInterval = 1024
+)
+
+// ErrAccountLocked is returned by Login while an account is locked after too
+// many consecutive failed attempts.
+var ErrAccountLocked = errors.New("account temporarily locked after too many failed logins")
+
+type loginAttempt struct {
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// loginAttempts counts consecutive failed logins per username. Unknown
+// usernames are tracked exactly like real ones so lockouts do not reveal
+// which accounts exist.
+type loginAttempts struct {
+	mu        sync.Mutex
+	attempts  map[string]loginAttempt
+	threshold int
+	duration  time.Duration
+	inserts   int
+}
+
+func newLoginAttempts(threshold int, duration time.Duration) *loginAttempts {
+	return &loginAttempts{
+		attempts:  make(map[string]loginAttempt),
+		threshold: threshold,
+		duration:  duration,
+	}
+}
+
+// locked reports whether username is currently locked out.
+func (l *loginAttempts) locked(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempt, ok := l.attempts[username]
+	if !ok {
+		return false
+	}
+
+	if l.stale(attempt, now) {
+		delete(l.attempts, username)
+
+		return false
+	}
+
+	return now.Before(attempt.lockedUntil)
+}
+
+// fail records a failed attempt for username, locking it once the threshold
+// is reached within the lockout window. It reports whether this attempt is
+// the one that locked the account.
+func (l *loginAttempts) fail(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempt, ok := l.attempts[username]
+	if !ok || l.stale(attempt, now) {
+		attempt = loginAttempt{firstFailed: now}
+
+		l.inserts++
+		if l.inserts%lockoutPruneInterval == 0 {
+			l.prune(now)
+		}
+	}
+
+	wasLocked := now.Before(attempt.lockedUntil)
+
+	attempt.failures++
+	if attempt.failures >= l.threshold {
+		attempt.lockedUntil = now.Add(l.duration)
+	}
+
+	l.attempts[username] = attempt
+
+	return !wasLocked && now.Before(attempt.lockedUntil)
+}
+
+// reset clears the counter of username after a successful login.
+func (l *loginAttempts) reset(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, username)
+}
+
+// stale reports whether attempt no longer matters: its window passed without
+// a lock, or its lock already expired.
+func (l *loginAttempts) stale(attempt loginAttempt, now time.Time) bool {
+	if !attempt.lockedUntil.IsZero() {
+		return !now.Before(attempt.lockedUntil)
+	}
+
+	return now.Sub(attempt.firstFailed) >= l.duration
+}
+
+func (l *loginAttempts) prune(now time.Time) {
+	for username, attempt := range l.attempts {
+		if l.stale(attempt, now) {
+			delete(l.attempts, username)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAccountLockout(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithLockoutThreshold(3), WithLockoutDuration(time.Minute))
+	mustRegister(t, svc, "alice")
+
+	for range 3 {
+		if _, err := svc.Login(context.Background(), "alice", "wrong-passw0rd"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login() error = %v, want ErrInvalidCredentials", err)
+		}
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Login() while locked error = %v, want ErrAccountLocked", err)
+	}
+
+	clock.Advance(time.Minute)
+	mustLogin(t, svc, "alice")
+}
+
+func TestSuccessfulLoginResetsFailures(t *testing.T) {
+	svc := newTestService(t, WithLockoutThreshold(3))
+	mustRegister(t, svc, "alice")
+
+	for range 2 {
+		for range 2 {
+			if _, err := svc.Login(context.Background(), "alice", "wrong-passw0rd"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Login() error = %v, want ErrInvalidCredentials", err)
+			}
+		}
+
+		mustLogin(t, svc, "alice")
+	}
+}
+
+// TestLockoutOfUnknownUser checks unknown usernames lock exactly like real
+// ones, so the lockout does not reveal which accounts exist.
+func TestLockoutOfUnknownUser(t *testing.T) {
+	svc := newTestService(t, WithLockoutThreshold(2))
+
+	for _, want := range []error{ErrInvalidCredentials, ErrInvalidCredentials, ErrAccountLocked} {
+		if _, err := svc.Login(context.Background(), "nobody", testPassword); !errors.Is(err, want) {
+			t.Fatalf("Login() of an unknown user error = %v, want %v", err, want)
+		}
+	}
+}
+
+func TestLoginAttemptsWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	attempts := newLoginAttempts(2, time.Minute)
+
+	attempts.fail("alice", now)
+
+	// The first failure left the window: the next one starts over.
+	if attempts.fail("alice", now.Add(time.Minute)) {
+		t.Fatal("a failure outside the window locked the account")
+	}
+
+	if !attempts.fail("alice", now.Add(90*time.Second)) {
+		t.Fatal("the threshold failure did not lock the account")
+	}
+
+	if !attempts.locked("alice", now.Add(2*time.Minute)) {
+		t.Fatal("account not locked within the lockout duration")
+	}
+
+	if attempts.locked("alice", now.Add(3*time.Minute)) {
+		t.Fatal("account still locked after the lockout duration")
+	}
+}
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// Middleware decorates a UserService with cross-cutting behaviour.
+type Middleware func(UserService) UserService
+
+type loggingMiddleware struct {
+	logger log.Logger
+	next   UserService
+	// latencyMS logs durations as a number of milliseconds under latency_ms
+	// instead of a formatted duration under took.
+	latencyMS bool
+}
+
+// NewLoggingMiddleware logs the method, duration and error of every call,
+// plus the request ID recorded by ContextWithRequestID when there is one.
+// Successful calls are logged at info level and failed ones at error level.
+// Passwords, tokens, TOTP codes and secrets are never logged.
+func NewLoggingMiddleware(logger log.Logger) Middleware {
+	return func(next UserService) UserService {
+		return &loggingMiddleware{
+			logger: logger,
+			next:   next,
+		}
+	}
+}
+
+// NewJSONLoggingMiddleware is NewLoggingMiddleware writing one JSON object
+// per call to w, with the latency in milliseconds, for log pipelines that
+// parse their input.
+func NewJSONLoggingMiddleware(w io.Writer) Middleware {
+	logger := log.NewJSONLogger(log.NewSyncWriter(w))
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	return func(next UserService) UserService {
+		return &loggingMiddleware{
+			logger:    logger,
+			next:      next,
+			latencyMS: true,
+		}
+	}
+}
+
+func (mw *loggingMiddleware) log(ctx context.Context, method string, begin time.Time, err error, keyvals ...interface{}) {
+	logger := level.Info(mw.logger)
+	if err != nil {
+		logger = level.Error(mw.logger)
+		keyvals = append(keyvals, "err", err)
+	}
+
+	fields := []interface{}{"method", method}
+	if mw.latencyMS {
+		fields = append(fields, "latency_ms", float64(time.Since(begin).Microseconds())/1000)
+	} else {
+		fields = append(fields, "took", time.Since(begin))
+	}
+
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+
+	_ = logger.Log(append(fields, keyvals...)...)
+}
+
+func (mw *loggingMiddleware) HealthCheck(ctx context.Context) (health HealthStatus) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "HealthCheck", begin, nil, "status", health.Status)
+	}(time.Now())
+
+	return mw.next.HealthCheck(ctx)
+}
+
+func (mw *loggingMiddleware) Liveness() (err error) {
+	defer func(begin time.Time) {
+		mw.log(context.Background(), "Liveness", begin, err)
+	}(time.Now())
+
+	return mw.next.Liveness()
+}
+
+func (mw *loggingMiddleware) Readiness(ctx context.Context) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Readiness", begin, err)
+	}(time.Now())
+
+	return mw.next.Readiness(ctx)
+}
+
+func (mw *loggingMiddleware) BeginShutdown() {
+	defer func(begin time.Time) {
+		mw.log(context.Background(), "BeginShutdown", begin, nil)
+	}(time.Now())
+
+	mw.next.BeginShutdown()
+}
+
+func (mw *loggingMiddleware) SetRegistrationEnabled(enabled bool) {
+	defer func(begin time.Time) {
+		mw.log(context.Background(), "SetRegistrationEnabled", begin, nil, "enabled", enabled)
+	}(time.Now())
+
+	mw.next.SetRegistrationEnabled(enabled)
+}
+
+func (mw *loggingMiddleware) Close(ctx context.Context) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Close", begin, err)
+	}(time.Now())
+
+	return mw.next.Close(ctx)
+}
+
+func (mw *loggingMiddleware) GetHomeState(ctx context.Context, token string) (state HomeState, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GetHomeState", begin, err, "logged_in", state.LoggedIn)
+	}(time.Now())
+
+	return mw.next.GetHomeState(ctx, token)
+}
+
+func (mw *loggingMiddleware) SendMainTemplateData(ctx context.Context, token string) (render TemplateRender, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "SendMainTemplateData", begin, err)
+	}(time.Now())
+
+	return mw.next.SendMainTemplateData(ctx, token)
+}
+
+func (mw *loggingMiddleware) Register(ctx context.Context, user, pass, email string, roles ...string) (response string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Register", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.Register(ctx, user, pass, email, roles...)
+}
+
+func (mw *loggingMiddleware) RegisterWithInvite(ctx context.Context, user, pass, email, code string) (response string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RegisterWithInvite", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.RegisterWithInvite(ctx, user, pass, email, code)
+}
+
+func (mw *loggingMiddleware) RegisterBatch(ctx context.Context, users []NewUser) (result BatchResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RegisterBatch", begin, err, "users", len(users), "created", result.Created)
+	}(time.Now())
+
+	return mw.next.RegisterBatch(ctx, users)
+}
+
+func (mw *loggingMiddleware) IsUsernameAvailable(ctx context.Context, username string) (available bool, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "IsUsernameAvailable", begin, err, "user", username, "available", available)
+	}(time.Now())
+
+	return mw.next.IsUsernameAvailable(ctx, username)
+}
+
+func (mw *loggingMiddleware) CreateUser(ctx context.Context, token, user, pass, email string, roles ...string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "CreateUser", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.CreateUser(ctx, token, user, pass, email, roles...)
+}
+
+func (mw *loggingMiddleware) CreateInvite(ctx context.Context, token string) (code string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "CreateInvite", begin, err)
+	}(time.Now())
+
+	return mw.next.CreateInvite(ctx, token)
+}
+
+func (mw *loggingMiddleware) Login(ctx context.Context, user, pass string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Login", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.Login(ctx, user, pass)
+}
+
+func (mw *loggingMiddleware) LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "LoginWithOptions", begin, err, "user", user, "remember_me", opts.RememberMe, "ttl", opts.TTL, "ip", opts.IP)
+	}(time.Now())
+
+	return mw.next.LoginWithOptions(ctx, user, pass, opts)
+}
+
+func (mw *loggingMiddleware) LoginTOTP(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "LoginTOTP", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.LoginTOTP(ctx, user, pass, code)
+}
+
+func (mw *loggingMiddleware) LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "LoginWithTTL", begin, err, "user", user, "ttl", ttl)
+	}(time.Now())
+
+	return mw.next.LoginWithTTL(ctx, user, pass, ttl)
+}
+
+func (mw *loggingMiddleware) BeginPasskeyRegistration(ctx context.Context, token string) (creation *protocol.CredentialCreation, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "BeginPasskeyRegistration", begin, err)
+	}(time.Now())
+
+	return mw.next.BeginPasskeyRegistration(ctx, token)
+}
+
+func (mw *loggingMiddleware) FinishPasskeyRegistration(ctx context.Context, token string, credential []byte) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "FinishPasskeyRegistration", begin, err)
+	}(time.Now())
+
+	return mw.next.FinishPasskeyRegistration(ctx, token, credential)
+}
+
+func (mw *loggingMiddleware) BeginPasskeyLogin(ctx context.Context, username string) (assertion *protocol.CredentialAssertion, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "BeginPasskeyLogin", begin, err, "user", username)
+	}(time.Now())
+
+	return mw.next.BeginPasskeyLogin(ctx, username)
+}
+
+func (mw *loggingMiddleware) FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "FinishPasskeyLogin", begin, err, "user", username)
+	}(time.Now())
+
+	return mw.next.FinishPasskeyLogin(ctx, username, credential)
+}
+
+func (mw *loggingMiddleware) OAuthLogin(ctx context.Context, provider, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "OAuthLogin", begin, err, "provider", provider)
+	}(time.Now())
+
+	return mw.next.OAuthLogin(ctx, provider, code)
+}
+
+func (mw *loggingMiddleware) EnableTOTP(ctx context.Context, token string) (secret string, otpauthURL string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "EnableTOTP", begin, err)
+	}(time.Now())
+
+	return mw.next.EnableTOTP(ctx, token)
+}
+
+func (mw *loggingMiddleware) ConfirmTOTP(ctx context.Context, token, code string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ConfirmTOTP", begin, err)
+	}(time.Now())
+
+	return mw.next.ConfirmTOTP(ctx, token, code)
+}
+
+func (mw *loggingMiddleware) GenerateRecoveryCodes(ctx context.Context, token string) (codes []string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GenerateRecoveryCodes", begin, err)
+	}(time.Now())
+
+	return mw.next.GenerateRecoveryCodes(ctx, token)
+}
+
+func (mw *loggingMiddleware) LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "LoginWithRecoveryCode", begin, err, "user", user)
+	}(time.Now())
+
+	return mw.next.LoginWithRecoveryCode(ctx, user, pass, code)
+}
+
+func (mw *loggingMiddleware) Refresh(ctx context.Context, refreshToken string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Refresh", begin, err)
+	}(time.Now())
+
+	return mw.next.Refresh(ctx, refreshToken)
+}
+
+func (mw *loggingMiddleware) Logout(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Logout", begin, err)
+	}(time.Now())
+
+	return mw.next.Logout(ctx, token)
+}
+
+func (mw *loggingMiddleware) GetProfile(ctx context.Context, token string) (profile Profile, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GetProfile", begin, err)
+	}(time.Now())
+
+	return mw.next.GetProfile(ctx, token)
+}
+
+func (mw *loggingMiddleware) IntrospectToken(ctx context.Context, token string) (introspection Introspection, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "IntrospectToken", begin, err, "active", introspection.Active)
+	}(time.Now())
+
+	return mw.next.IntrospectToken(ctx, token)
+}
+
+func (mw *loggingMiddleware) PublicJWKS(ctx context.Context) (set JSONWebKeySet, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "PublicJWKS", begin, err, "keys", len(set.Keys))
+	}(time.Now())
+
+	return mw.next.PublicJWKS(ctx)
+}
+
+func (mw *loggingMiddleware) ListSessions(ctx context.Context, token, cursor string, limit int) (page SessionPage, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ListSessions", begin, err, "limit", limit)
+	}(time.Now())
+
+	return mw.next.ListSessions(ctx, token, cursor, limit)
+}
+
+func (mw *loggingMiddleware) RevokeAllSessions(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RevokeAllSessions", begin, err)
+	}(time.Now())
+
+	return mw.next.RevokeAllSessions(ctx, token)
+}
+
+func (mw *loggingMiddleware) RevokeSession(ctx context.Context, token, sessionID string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RevokeSession", begin, err, "session", sessionID)
+	}(time.Now())
+
+	return mw.next.RevokeSession(ctx, token, sessionID)
+}
+
+func (mw *loggingMiddleware) SetUserActive(ctx context.Context, token, username string, active bool) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "SetUserActive", begin, err, "user", username, "active", active)
+	}(time.Now())
+
+	return mw.next.SetUserActive(ctx, token, username, active)
+}
+
+func (mw *loggingMiddleware) ListUsers(ctx context.Context, token string, offset, limit int) (page UserPage, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ListUsers", begin, err, "offset", offset, "limit", limit)
+	}(time.Now())
+
+	return mw.next.ListUsers(ctx, token, offset, limit)
+}
+
+func (mw *loggingMiddleware) SessionStats(ctx context.Context, token string) (stats SessionStats, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "SessionStats", begin, err)
+	}(time.Now())
+
+	return mw.next.SessionStats(ctx, token)
+}
+
+func (mw *loggingMiddleware) ChangePassword(ctx context.Context, token, oldPass, newPass string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ChangePassword", begin, err)
+	}(time.Now())
+
+	return mw.next.ChangePassword(ctx, token, oldPass, newPass)
+}
+
+func (mw *loggingMiddleware) RenameUsername(ctx context.Context, token, newUsername string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RenameUsername", begin, err, "new_user", newUsername)
+	}(time.Now())
+
+	return mw.next.RenameUsername(ctx, token, newUsername)
+}
+
+func (mw *loggingMiddleware) DeleteAccount(ctx context.Context, token, password string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "DeleteAccount", begin, err)
+	}(time.Now())
+
+	return mw.next.DeleteAccount(ctx, token, password)
+}
+
+func (mw *loggingMiddleware) GenerateVerificationToken(ctx context.Context, username string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GenerateVerificationToken", begin, err, "user", username)
+	}(time.Now())
+
+	return mw.next.GenerateVerificationToken(ctx, username)
+}
+
+func (mw *loggingMiddleware) VerifyEmail(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "VerifyEmail", begin, err)
+	}(time.Now())
+
+	return mw.next.VerifyEmail(ctx, token)
+}
+
+func (mw *loggingMiddleware) ResendVerification(ctx context.Context, usernameOrEmail string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ResendVerification", begin, err)
+	}(time.Now())
+
+	return mw.next.ResendVerification(ctx, usernameOrEmail)
+}
+
+func (mw *loggingMiddleware) RequestPasswordReset(ctx context.Context, usernameOrEmail string) (token string, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RequestPasswordReset", begin, err)
+	}(time.Now())
+
+	return mw.next.RequestPasswordReset(ctx, usernameOrEmail)
+}
+
+func (mw *loggingMiddleware) ResetPassword(ctx context.Context, resetToken, newPass string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ResetPassword", begin, err)
+	}(time.Now())
+
+	return mw.next.ResetPassword(ctx, resetToken, newPass)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	svc := NewLoggingMiddleware(log.NewLogfmtLogger(&buf))(newTestService(t))
+	mustRegister(t, svc, "alice")
+
+	buf.Reset()
+	session := mustLogin(t, svc, "alice")
+
+	line := buf.String()
+	logged := line
+
+	for _, want := range []string{"level=info", "method=Login"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("successful Login logged %q, want %s", line, want)
+		}
+	}
+
+	buf.Reset()
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	if _, err := svc.Login(ctx, "alice", "wrong-passw0rd"); err == nil {
+		t.Fatal("Login() with a wrong password succeeded")
+	}
+
+	line = buf.String()
+	logged += line
+
+	for _, want := range []string{"level=error", "method=Login", "err=", "request_id=req-1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("failed Login logged %q, want %s", line, want)
+		}
+	}
+
+	buf.Reset()
+	if err := svc.Logout(context.Background(), session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	logged += buf.String()
+
+	for _, secret := range []string{testPassword, "wrong-passw0rd", session.AccessToken, session.RefreshToken} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log %q contains a secret", logged)
+		}
+	}
+}
+
+func TestJSONLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	svc := NewJSONLoggingMiddleware(&buf)(newTestService(t))
+	mustRegister(t, svc, "alice")
+
+	buf.Reset()
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	session, err := svc.Login(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Logout(ctx, session.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := buf.String()
+
+	lines := strings.Split(strings.TrimSpace(logged), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want one per call: %q", len(lines), logged)
+	}
+
+	for i, method := range []string{"Login", "Logout"} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", lines[i], err)
+		}
+
+		if entry["method"] != method || entry["request_id"] != "req-1" || entry["level"] != "info" {
+			t.Errorf("line %q, want method %s, request_id req-1 and level info", lines[i], method)
+		}
+
+		if _, ok := entry["latency_ms"].(float64); !ok {
+			t.Errorf("line %q has no numeric latency_ms", lines[i])
+		}
+	}
+
+	for _, secret := range []string{testPassword, session.AccessToken, session.RefreshToken} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log %q contains a secret", logged)
+		}
+	}
+}
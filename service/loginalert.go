@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// alertNewLogin mails the owner of session, just started, when none of
+// their other live sessions shares its user agent and IP, see
+// WithNewLoginAlerts. Failures are logged and never fail the login.
+func (u *userService) alertNewLogin(ctx context.Context, userFields UserFields, session Session) {
+	if !u.newLoginAlerts || userFields.Email == "" || (session.UserAgent == "" && session.IP == "") {
+		return
+	}
+
+	sessions, err := u.sessions.ListUserSessions(ctx, userFields.Username)
+	if err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while listing sessions for a login alert", "user", userFields.Username, "err", err)
+
+		return
+	}
+
+	for _, seen := range sessions {
+		if seen.ID != session.ID && seen.UserAgent == session.UserAgent && seen.IP == session.IP {
+			return
+		}
+	}
+
+	subject, body := newLoginMail(userFields.Username, session)
+	u.sendMailAsync(ctx, userFields.Email, subject, body)
+}
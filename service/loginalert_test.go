@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewLoginAlerts(t *testing.T) {
+	mailer := &capturingMailer{}
+	svc := newTestService(t, WithClock(newFakeClock()), WithMailer(mailer), WithNewLoginAlerts())
+	mustRegister(t, svc, "alice")
+
+	laptop := LoginOptions{UserAgent: "Firefox", IP: "192.0.2.1"}
+
+	for _, tt := range []struct {
+		name   string
+		opts   LoginOptions
+		alerts int
+	}{
+		{"first login from a device", laptop, 1},
+		{"same device again", laptop, 0},
+		{"same browser from another IP", LoginOptions{UserAgent: "Firefox", IP: "198.51.100.7"}, 1},
+		{"no device information", LoginOptions{}, 0},
+	} {
+		if _, err := svc.LoginWithOptions(context.Background(), "alice", testPassword, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		sent := mailer.take(svc)
+		if len(sent) != tt.alerts {
+			t.Fatalf("%s: %d alerts sent, want %d", tt.name, len(sent), tt.alerts)
+		}
+
+		if tt.alerts == 0 {
+			continue
+		}
+
+		if sent[0].to != "alice@example.com" {
+			t.Errorf("%s: alert sent to %s, want alice@example.com", tt.name, sent[0].to)
+		}
+
+		for _, want := range []string{"Jan 1, 2024 12:00 UTC", tt.opts.IP, tt.opts.UserAgent} {
+			if !strings.Contains(sent[0].body, want) {
+				t.Errorf("%s: alert %q lacks %q", tt.name, sent[0].body, want)
+			}
+		}
+	}
+}
+
+func TestNewLoginAlertsOff(t *testing.T) {
+	mailer := &capturingMailer{}
+	svc := newTestService(t, WithMailer(mailer))
+	mustRegister(t, svc, "alice")
+
+	if _, err := svc.LoginWithOptions(context.Background(), "alice", testPassword, LoginOptions{UserAgent: "Firefox", IP: "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := mailer.take(svc); len(sent) != 0 {
+		t.Fatalf("%d alerts sent without WithNewLoginAlerts", len(sent))
+	}
+}
+
+// TestNewLoginAlertNeverBlocksLogin logs in while the mail server hangs and
+// then while it fails.
+func TestNewLoginAlertNeverBlocksLogin(t *testing.T) {
+	blocking := &blockingMailer{release: make(chan struct{}), sent: make(chan string, 1)}
+	defer close(blocking.release)
+
+	for _, mailer := range []Mailer{blocking, failingMailer{}} {
+		svc := newTestService(t, WithMailer(mailer), WithNewLoginAlerts(), WithFailurePolicy(IntegrationMailer, FailClosed))
+		mustRegister(t, svc, "alice")
+
+		if _, err := svc.LoginWithOptions(context.Background(), "alice", testPassword, LoginOptions{UserAgent: "Firefox"}); err != nil {
+			t.Fatalf("LoginWithOptions() with a %T error = %v", mailer, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// Mailer delivers the messages the service sends to account owners, such
+// as verification codes and password reset tokens. Those grant control over
+// an account, so implementations must only send them to the given address
+// and never log message bodies.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// nopMailer drops every message. It is the default until WithMailer is
+// used.
+type nopMailer struct{}
+
+func (nopMailer) Send(context.Context, string, string, string) error { return nil }
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer returns a Mailer sending plain text mail from from through
+// the SMTP server at addr, given as host:port. auth may be nil for servers
+// that accept mail without it. The connection is upgraded with STARTTLS
+// whenever the server offers it.
+func NewSMTPMailer(addr, from string, auth smtp.Auth) Mailer {
+	return &smtpMailer{addr: addr, from: from, auth: auth}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, to, subject, body string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// A line break would let the value add headers of its own.
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail headers must not contain line breaks")
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + m.from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("error while sending mail: %w", err)
+	}
+
+	return nil
+}
+
+// sendMail hands a message to the Mailer. When the mailer fails open, as
+// it does by default, the message goes out in the background through
+// sendMailAsync and nil is returned. When it fails closed the message is
+// sent right away and a failure is returned.
+func (u *userService) sendMail(ctx context.Context, to, subject, body string) error {
+	if u.failurePolicies[IntegrationMailer] == FailClosed {
+		return u.deliverMail(ctx, to, subject, body)
+	}
+
+	u.sendMailAsync(ctx, to, subject, body)
+
+	return nil
+}
+
+// sendMailAsync sends a message in the background so that mail latency
+// never holds up the request. Failures are only logged, and Close waits for
+// the messages still being sent.
+func (u *userService) sendMailAsync(ctx context.Context, to, subject, body string) {
+	ctx = context.WithoutCancel(ctx)
+
+	u.mails.Add(1)
+
+	go func() {
+		defer u.mails.Done()
+
+		_ = u.deliverMail(ctx, to, subject, body)
+	}()
+}
+
+func (u *userService) deliverMail(ctx context.Context, to, subject, body string) error {
+	if err := u.mailer.Send(ctx, to, subject, body); err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while sending mail", "subject", subject, "err", err)
+
+		return u.integrationFailed(IntegrationMailer, err)
+	}
+
+	u.integrations.record(IntegrationMailer, nil)
+
+	return nil
+}
+
+func verificationMail(username, token string, ttl time.Duration) (subject, body string) {
+	return "Verify your email address", fmt.Sprintf(
+		"Hi %s,\n\nUse this code to verify your email address:\n\n%s\n\nIt expires in %s. If you did not sign up, you can ignore this message.\n",
+		username, token, ttl)
+}
+
+func newLoginMail(username string, session Session) (subject, body string) {
+	return "New login to your account", fmt.Sprintf(
+		"Hi %s,\n\nYour account was just logged into from a device we have not seen recently:\n\nTime: %s\nIP address: %s\nDevice: %s\n\nIf this was you, there is nothing to do. If not, change your password and log out your other sessions.\n",
+		username, session.CreatedAt.UTC().Format("Jan 2, 2006 15:04 MST"), orUnknown(session.IP), orUnknown(session.UserAgent))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	return s
+}
+
+func passwordResetMail(username, token string, ttl time.Duration) (subject, body string) {
+	return "Reset your password", fmt.Sprintf(
+		"Hi %s,\n\nUse this code to choose a new password:\n\n%s\n\nIt expires in %s. If you did not ask for a reset, you can ignore this message; your password stays the same.\n",
+		username, token, ttl)
+}
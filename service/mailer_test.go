@@ -0,0 +1,190 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPasswordResetIsMailed(t *testing.T) {
+	mailer := &capturingMailer{}
+	svc := newTestService(t, WithMailer(mailer))
+	mustRegister(t, svc, "alice")
+
+	if _, err := svc.RequestPasswordReset(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mailer.take(svc)
+	if len(sent) != 1 || sent[0].to != "alice@example.com" {
+		t.Fatalf("RequestPasswordReset() sent %+v, want one mail to alice@example.com", sent)
+	}
+
+	if err := svc.ResetPassword(context.Background(), mailedToken(t, sent[0]), "n3w-password"); err != nil {
+		t.Fatalf("ResetPassword() with the mailed token error = %v", err)
+	}
+
+	if _, err := svc.RequestPasswordReset(context.Background(), "nobody"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := mailer.take(svc); len(sent) != 0 {
+		t.Fatalf("RequestPasswordReset() of an unknown account sent %+v", sent)
+	}
+}
+
+// blockingMailer holds every message until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan string
+}
+
+func (m *blockingMailer) Send(_ context.Context, to, _, _ string) error {
+	<-m.release
+	m.sent <- to
+
+	return nil
+}
+
+func TestMailIsSentInBackground(t *testing.T) {
+	mailer := &blockingMailer{release: make(chan struct{}), sent: make(chan string, 1)}
+	svc := newTestService(t, WithMailer(mailer))
+	mustRegister(t, svc, "alice")
+
+	// The mail server hangs, yet the request returns.
+	if _, err := svc.RequestPasswordReset(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case to := <-mailer.sent:
+		t.Fatalf("mail to %s sent before the server answered", to)
+	default:
+	}
+
+	close(mailer.release)
+
+	select {
+	case to := <-mailer.sent:
+		if to != "alice@example.com" {
+			t.Fatalf("mail sent to %s, want alice@example.com", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail never sent")
+	}
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, string, string, string) error {
+	return errors.New("connection refused")
+}
+
+func TestMailFailureIsLogged(t *testing.T) {
+	var buf bytes.Buffer
+	svc := newTestService(t, WithMailer(failingMailer{}), WithLogger(log.NewLogfmtLogger(&buf)))
+	mustRegister(t, svc, "alice")
+
+	if _, err := svc.RequestPasswordReset(context.Background(), "alice"); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v, want the mail failure only logged", err)
+	}
+
+	svc.mails.Wait()
+
+	if !strings.Contains(buf.String(), "connection refused") {
+		t.Fatalf("log = %q, want the mail failure", buf.String())
+	}
+}
+
+// serveSMTP answers one SMTP session on l, just well enough for
+// smtp.SendMail, and sends what it was given as DATA to data.
+func serveSMTP(l net.Listener, data chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ready")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(strings.Fields(line + " x")[0]) {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if line == ".\r\n" {
+					break
+				}
+
+				msg.WriteString(line)
+			}
+
+			data <- msg.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make(chan string, 1)
+	go serveSMTP(l, data)
+
+	mailer := NewSMTPMailer(l.Addr().String(), "noreply@example.com", nil)
+	if err := mailer.Send(context.Background(), "alice@example.com", "Reset your password", "line one\nline two"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-data
+	for _, want := range []string{"From: noreply@example.com\r\n", "To: alice@example.com\r\n", "Subject: Reset your password\r\n", "\r\n\r\nline one\r\nline two"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q lacks %q", msg, want)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	mailer := NewSMTPMailer("127.0.0.1:1", "noreply@example.com", nil)
+
+	for _, to := range []string{"alice@example.com\r\nBcc: mallory@example.com", "alice@example.com\n"} {
+		err := mailer.Send(context.Background(), to, "subject", "body")
+		if err == nil || !strings.Contains(err.Error(), "line breaks") {
+			t.Errorf("Send(%q) error = %v, want line breaks rejected", to, err)
+		}
+	}
+}
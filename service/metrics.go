@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+type activeUser struct {
+	tenant   string
+	username string
+}
+
+// activeUsers tracks the users holding at least one session, keyed by
+// tenantKey, so its gauge counts people rather than sessions. It is filled
+// in by WithBusinessMetrics and is nil otherwise.
+type activeUsers struct {
+	mu    sync.Mutex
+	users map[string]activeUser
+	gauge metrics.Gauge
+}
+
+// trackActiveUser re-reads the sessions of username after they changed and
+// updates the active users gauge. It is best effort: a failing SessionStore
+// only leaves the gauge stale until the user's sessions change again.
+func (u *userService) trackActiveUser(ctx context.Context, username string) {
+	if u.activeUsers == nil {
+		return
+	}
+
+	sessions, err := u.sessions.ListUserSessions(ctx, username)
+	if err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while counting active users", "user", username, "err", err)
+
+		return
+	}
+
+	tenant := TenantFromContext(ctx)
+	u.activeUsers.set(tenantKey(tenant, username), activeUser{tenant: tenant, username: username}, len(sessions) > 0)
+}
+
+// recheckActiveUsers tracks every known active user again, dropping the ones
+// whose sessions all expired on their own. The sweeper calls it after every
+// sweep.
+func (u *userService) recheckActiveUsers() {
+	if u.activeUsers == nil {
+		return
+	}
+
+	u.activeUsers.mu.Lock()
+	users := make([]activeUser, 0, len(u.activeUsers.users))
+
+	for _, user := range u.activeUsers.users {
+		users = append(users, user)
+	}
+	u.activeUsers.mu.Unlock()
+
+	// Holding the service lock keeps a concurrent Login from being undone
+	// by a stale session list read here.
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, user := range users {
+		u.trackActiveUser(ContextWithTenant(context.Background(), user.tenant), user.username)
+	}
+}
+
+func (a *activeUsers) set(key string, user activeUser, active bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if active {
+		a.users[key] = user
+	} else {
+		delete(a.users, key)
+	}
+
+	a.gauge.Set(float64(len(a.users)))
+}
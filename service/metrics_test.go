@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusinessMetrics(t *testing.T) {
+	gauge := recordedGauge{newRecordedMetric()}
+	registrations := recordedCounter{newRecordedMetric()}
+
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithSessionTTL(time.Hour), WithBusinessMetrics(gauge, registrations))
+
+	activeUsers := func() float64 {
+		value, _ := gauge.value()
+
+		return value
+	}
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		mustRegister(t, svc, user)
+	}
+
+	if n, _ := registrations.value(); n != 3 {
+		t.Fatalf("registrations = %v, want 3", n)
+	}
+
+	// Five sessions between two users count as two active users.
+	var alice []LoginResult
+	for range 3 {
+		alice = append(alice, mustLogin(t, svc, "alice"))
+	}
+
+	bob := mustLogin(t, svc, "bob")
+	mustLogin(t, svc, "bob")
+
+	if n := activeUsers(); n != 2 {
+		t.Fatalf("active users = %v, want 2", n)
+	}
+
+	// alice stays active until the last of alice's sessions ends.
+	for _, session := range alice[:2] {
+		if err := svc.Logout(context.Background(), session.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := activeUsers(); n != 2 {
+		t.Fatalf("active users with one session of alice left = %v, want 2", n)
+	}
+
+	if err := svc.Logout(context.Background(), alice[2].AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.RevokeAllSessions(context.Background(), bob.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := activeUsers(); n != 0 {
+		t.Fatalf("active users after every session ended = %v, want 0", n)
+	}
+
+	// Users whose sessions expire on their own leave on the next sweep.
+	mustLogin(t, svc, "carol")
+	clock.Advance(2 * time.Hour)
+
+	if _, err := svc.sessions.(ExpiredSessionSweeper).DeleteExpired(clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.recheckActiveUsers()
+
+	if n := activeUsers(); n != 0 {
+		t.Fatalf("active users after carol's session expired = %v, want 0", n)
+	}
+
+	if n, _ := registrations.value(); n != 3 {
+		t.Fatalf("registrations = %v, want the counter unchanged by logins", n)
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DefaultGoogleTokenURL and DefaultGoogleUserInfoURL are the endpoints the
+	// provider returned by NewGoogleOAuthProvider talks to.
+	DefaultGoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	DefaultGoogleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+	// oauthUsernameAttempts bounds how many suffixed usernames OAuthLogin
+	// tries before giving up on provisioning an account.
+	oauthUsernameAttempts = 5
+)
+
+var (
+	// ErrUnknownOAuthProvider is returned by OAuthLogin for a provider not
+	// registered with WithOAuthProvider.
+	ErrUnknownOAuthProvider = errors.New("unknown oauth provider")
+	// ErrOAuthFailed is returned by OAuthLogin when the provider rejects the
+	// code or cannot be reached.
+	ErrOAuthFailed = errors.New("oauth login failed")
+	// ErrOAuthAccountConflict is returned by OAuthLogin when the email the
+	// provider vouches for already belongs to an account that did not sign
+	// up through that provider. Linking them is left to the user, who has to
+	// prove they own the existing account first.
+	ErrOAuthAccountConflict = errors.New("email already belongs to another account")
+)
+
+// OAuthIdentity is what an OAuthProvider learned about the user. Subject is
+// the provider's stable ID for them; the email can change over time.
+type OAuthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthProvider trades the authorization code the identity provider
+// redirected the browser back with for the identity of the user.
+type OAuthProvider interface {
+	Exchange(ctx context.Context, code string) (OAuthIdentity, error)
+}
+
+type googleOAuthProvider struct {
+	client       *http.Client
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	tokenURL     string
+	userInfoURL  string
+}
+
+// NewGoogleOAuthProvider returns an OAuthProvider for Google sign-in.
+// redirectURL must match the one the authorization request was made with. A
+// nil client uses one with a 5 second timeout.
+func NewGoogleOAuthProvider(clientID, clientSecret, redirectURL string, client *http.Client) OAuthProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &googleOAuthProvider{
+		client:       client,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		tokenURL:     DefaultGoogleTokenURL,
+		userInfoURL:  DefaultGoogleUserInfoURL,
+	}
+}
+
+func (g *googleOAuthProvider) Exchange(ctx context.Context, code string) (OAuthIdentity, error) {
+	form := url.Values{
+		"code":          {code},
+		"client_id":     {g.clientID},
+		"client_secret": {g.clientSecret},
+		"redirect_uri":  {g.redirectURL},
+		"grant_type":    {"authorization_code"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OAuthIdentity{}, fmt.Errorf("error while building token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := g.do(req, &token); err != nil {
+		return OAuthIdentity{}, fmt.Errorf("error while exchanging code: %w", err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, g.userInfoURL, nil)
+	if err != nil {
+		return OAuthIdentity{}, fmt.Errorf("error while building userinfo request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := g.do(req, &info); err != nil {
+		return OAuthIdentity{}, fmt.Errorf("error while fetching userinfo: %w", err)
+	}
+
+	return OAuthIdentity{Subject: info.Subject, Email: info.Email, EmailVerified: info.EmailVerified}, nil
+}
+
+func (g *googleOAuthProvider) do(req *http.Request, v interface{}) error {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// OAuthLogin signs a user in through provider, registered with
+// WithOAuthProvider, given the authorization code it redirected back with.
+// The first login provisions an account without a password, named after
+// the email, which can only sign in through that provider; later logins find
+// it by email and check it belongs to the same subject. The provider must
+// vouch for the email, and an email already used by another account fails
+// with ErrOAuthAccountConflict rather than taking that account over.
+func (u *userService) OAuthLogin(ctx context.Context, provider, code string) (LoginResult, error) {
+	oauthProvider, ok := u.oauthProviders[provider]
+	if !ok {
+		return LoginResult{}, fmt.Errorf("%w: %q", ErrUnknownOAuthProvider, provider)
+	}
+
+	// The exchange is a network round trip, so it happens before the lock.
+	identity, err := oauthProvider.Exchange(ctx, code)
+	u.integrations.record(IntegrationOAuth, err)
+
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("%w: %v", ErrOAuthFailed, err)
+	}
+
+	if identity.Subject == "" {
+		return LoginResult{}, fmt.Errorf("%w: provider returned no subject", ErrOAuthFailed)
+	}
+
+	if !identity.EmailVerified {
+		return LoginResult{}, ErrEmailNotVerified
+	}
+
+	email := normalizeEmail(identity.Email)
+	if err := validateEmail(email); err != nil {
+		return LoginResult{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	userFields, err := u.users.GetUserByEmail(ctx, email)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		userFields, err = u.provisionOAuthUser(ctx, provider, identity.Subject, email)
+		if err != nil {
+			return LoginResult{}, err
+		}
+	case err != nil:
+		return LoginResult{}, fmt.Errorf("error while looking up user: %w", err)
+	case userFields.OAuthProvider != provider || userFields.OAuthSubject != identity.Subject:
+		return LoginResult{}, ErrOAuthAccountConflict
+	}
+
+	return u.startSession(ctx, userFields, LoginOptions{})
+}
+
+// provisionOAuthUser creates the account of a first OAuthLogin. It goes
+// through the same gates as Register apart from the password ones.
+func (u *userService) provisionOAuthUser(ctx context.Context, provider, subject, email string) (UserFields, error) {
+	if u.inviteOnly {
+		return UserFields{}, ErrInviteRequired
+	}
+
+	if u.registrationDisabled {
+		return UserFields{}, ErrRegistrationDisabled
+	}
+
+	tenant := TenantFromContext(ctx)
+	if err := validateTenant(tenant); err != nil {
+		return UserFields{}, err
+	}
+
+	if u.emailDomainPolicy != nil {
+		if err := u.emailDomainPolicy.Validate(email); err != nil {
+			return UserFields{}, err
+		}
+	}
+
+	username, err := u.oauthUsername(ctx, email)
+	if err != nil {
+		return UserFields{}, err
+	}
+
+	userFields := UserFields{
+		Username:      username,
+		Tenant:        tenant,
+		Email:         email,
+		EmailVerified: true,
+		Roles:         []string{RoleUser},
+		CreatedAt:     u.clock.Now().UTC(),
+		OAuthProvider: provider,
+		OAuthSubject:  subject,
+	}
+
+	if err := u.users.CreateUser(ctx, userFields); err != nil {
+		return UserFields{}, fmt.Errorf("error while saving user: %w", err)
+	}
+
+	u.audit(ctx, AuditRegister, username)
+	u.registrations.Add(1)
+
+	return userFields, nil
+}
+
+// oauthUsername picks a free username out of the local part of email,
+// adding a random suffix when it is taken.
+func (u *userService) oauthUsername(ctx context.Context, email string) (string, error) {
+	local, _, _ := strings.Cut(email, "@")
+
+	base := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+
+		return '-'
+	}, normalizeUsername(local))
+
+	for attempt := 0; attempt < oauthUsernameAttempts; attempt++ {
+		username := base
+		if attempt > 0 || len(username) < minUsernameLength {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return "", fmt.Errorf("error while generating username: %w", err)
+			}
+
+			username = truncateRunes(base, maxUsernameLength-7) + "-" + hex.EncodeToString(suffix)
+		}
+
+		if err := u.validateUsername(username); err != nil {
+			continue
+		}
+
+		err := u.checkUsernameFree(ctx, username)
+		if err == nil {
+			return username, nil
+		}
+
+		if !errors.Is(err, ErrUserAlreadyExists) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("%w: no free username derived from %q", ErrUserAlreadyExists, email)
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n])
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// oauthProviderFunc adapts a function to OAuthProvider.
+type oauthProviderFunc func(ctx context.Context, code string) (OAuthIdentity, error)
+
+func (f oauthProviderFunc) Exchange(ctx context.Context, code string) (OAuthIdentity, error) {
+	return f(ctx, code)
+}
+
+// mockOAuthProvider answers every code with the identity registered for it.
+func mockOAuthProvider(identities map[string]OAuthIdentity) OAuthProvider {
+	return oauthProviderFunc(func(_ context.Context, code string) (OAuthIdentity, error) {
+		identity, ok := identities[code]
+		if !ok {
+			return OAuthIdentity{}, errors.New("invalid_grant")
+		}
+
+		return identity, nil
+	})
+}
+
+func TestOAuthLoginProvisionsUsers(t *testing.T) {
+	svc := newTestService(t, WithOAuthProvider("mock", mockOAuthProvider(map[string]OAuthIdentity{
+		"carol":       {Subject: "1", Email: "Carol@example.com", EmailVerified: true},
+		"carol-again": {Subject: "1", Email: "carol@example.com", EmailVerified: true},
+		"alice":       {Subject: "2", Email: "alice@other.example", EmailVerified: true},
+	})))
+	mustRegister(t, svc, "alice")
+
+	first, err := svc.OAuthLogin(context.Background(), "mock", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carol := mustGetUser(t, svc, "carol")
+	if !strings.EqualFold(carol.Email, "carol@example.com") || !carol.EmailVerified || carol.HashedPassword != "" || carol.OAuthProvider != "mock" || carol.OAuthSubject != "1" {
+		t.Fatalf("provisioned user = %+v, want an OAuth account without a password", carol)
+	}
+
+	if state, err := svc.GetHomeState(context.Background(), first.AccessToken); err != nil || state.Username != "carol" {
+		t.Fatalf("GetHomeState() = %+v, %v, want carol", state, err)
+	}
+
+	if _, err := svc.Login(context.Background(), "carol", ""); err == nil {
+		t.Fatal("Login() with an empty password into an OAuth account succeeded")
+	}
+
+	// Logging in again finds the same account.
+	again, err := svc.OAuthLogin(context.Background(), "mock", "carol-again")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := svc.GetHomeState(context.Background(), again.AccessToken); err != nil || state.Username != "carol" {
+		t.Fatalf("GetHomeState() after a second OAuth login = %+v, %v, want carol", state, err)
+	}
+
+	// A taken username gets a suffix rather than the existing account.
+	if _, err := svc.OAuthLogin(context.Background(), "mock", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	provisioned, err := svc.users.GetUserByEmail(context.Background(), "alice@other.example")
+	if err != nil || provisioned.Username == "alice" || !strings.HasPrefix(provisioned.Username, "alice") {
+		t.Fatalf("user provisioned for alice@other.example = %q, %v, want a suffixed alice", provisioned.Username, err)
+	}
+
+	if mustGetUser(t, svc, "alice").OAuthSubject != "" {
+		t.Fatal("OAuthLogin() touched the password account named alice")
+	}
+}
+
+func TestOAuthLoginRejections(t *testing.T) {
+	svc := newTestService(t, WithOAuthProvider("mock", mockOAuthProvider(map[string]OAuthIdentity{
+		"password-account": {Subject: "1", Email: "alice@example.com", EmailVerified: true},
+		"carol":            {Subject: "2", Email: "carol@example.com", EmailVerified: true},
+		"other-subject":    {Subject: "3", Email: "carol@example.com", EmailVerified: true},
+		"unverified":       {Subject: "4", Email: "dave@example.com"},
+		"no-subject":       {Email: "erin@example.com", EmailVerified: true},
+	})))
+	mustRegister(t, svc, "alice")
+
+	if _, err := svc.OAuthLogin(context.Background(), "mock", "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	for code, want := range map[string]error{
+		"password-account": ErrOAuthAccountConflict,
+		"other-subject":    ErrOAuthAccountConflict,
+		"unverified":       ErrEmailNotVerified,
+		"no-subject":       ErrOAuthFailed,
+		"unknown-code":     ErrOAuthFailed,
+	} {
+		if _, err := svc.OAuthLogin(context.Background(), "mock", code); !errors.Is(err, want) {
+			t.Errorf("OAuthLogin(%q) error = %v, want %v", code, err, want)
+		}
+	}
+
+	if _, err := svc.OAuthLogin(context.Background(), "github", "carol"); !errors.Is(err, ErrUnknownOAuthProvider) {
+		t.Errorf("OAuthLogin() with an unknown provider error = %v, want ErrUnknownOAuthProvider", err)
+	}
+
+	// alice's account was not taken over.
+	if user := mustGetUser(t, svc, "alice"); user.OAuthProvider != "" {
+		t.Fatalf("alice = %+v, want the password account untouched", user)
+	}
+}
+
+func TestGoogleOAuthProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.FormValue("code") != "good-code" || r.FormValue("client_secret") != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "google-token"})
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer google-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "42", "email": "carol@example.com", "email_verified": true})
+		}
+	}))
+	defer server.Close()
+
+	provider := NewGoogleOAuthProvider("client", "secret", "https://example.com/callback", server.Client())
+	google := provider.(*googleOAuthProvider)
+	google.tokenURL = server.URL + "/token"
+	google.userInfoURL = server.URL + "/userinfo"
+
+	identity, err := provider.Exchange(context.Background(), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity != (OAuthIdentity{Subject: "42", Email: "carol@example.com", EmailVerified: true}) {
+		t.Fatalf("Exchange() = %+v", identity)
+	}
+
+	if _, err := provider.Exchange(context.Background(), "bad-code"); err == nil {
+		t.Fatal("Exchange() of a rejected code succeeded")
+	}
+}
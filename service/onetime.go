@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrOneTimeTokenNotFound is returned by a OneTimeTokenStore when the token is
+// unknown, expired, or was already used.
+var ErrOneTimeTokenNotFound = errors.New("one-time token not found")
+
+// OneTimeTokenStore holds single-use tokens such as email verification links.
+// Keys are hashes of the token, never the token itself, and Take removes the
+// entry so the token cannot be redeemed twice.
+type OneTimeTokenStore interface {
+	Put(ctx context.Context, key, subject string, ttl time.Duration) error
+	Take(ctx context.Context, key string) (string, error)
+}
+
+type memoryOneTimeToken struct {
+	subject   string
+	expiresAt time.Time
+}
+
+type memoryOneTimeTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]memoryOneTimeToken
+	clock  Clock
+}
+
+func NewMemoryOneTimeTokenStore() OneTimeTokenStore {
+	return &memoryOneTimeTokenStore{
+		tokens: make(map[string]memoryOneTimeToken),
+		clock:  realClock{},
+	}
+}
+
+func (m *memoryOneTimeTokenStore) setClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = clock
+}
+
+func (m *memoryOneTimeTokenStore) Put(ctx context.Context, key, subject string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token := memoryOneTimeToken{subject: subject}
+	if ttl > 0 {
+		token.expiresAt = m.clock.Now().Add(ttl)
+	}
+
+	m.tokens[key] = token
+
+	return nil
+}
+
+func (m *memoryOneTimeTokenStore) Take(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[key]
+	if !ok {
+		return "", ErrOneTimeTokenNotFound
+	}
+
+	delete(m.tokens, key)
+
+	if !token.expiresAt.IsZero() && m.clock.Now().After(token.expiresAt) {
+		return "", ErrOneTimeTokenNotFound
+	}
+
+	return token.subject, nil
+}
+
+// newOneTimeToken returns a random URL-safe token suitable for links.
+func newOneTimeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error while generating token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oneTimeTokenKey scopes token to purpose and to the tenant of ctx so a
+// token minted for one flow or tenant can never be redeemed in another.
+func oneTimeTokenKey(ctx context.Context, purpose, token string) string {
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		purpose = tenantKey(tenant, purpose)
+	}
+
+	return hashToken(purpose + ":" + token)
+}
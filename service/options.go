@@ -0,0 +1,720 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultSessionTTL    = 24 * time.Hour
+	DefaultRememberMeTTL = 30 * 24 * time.Hour
+	DefaultMinLoginTTL   = 5 * time.Minute
+	DefaultMaxLoginTTL   = DefaultRememberMeTTL
+)
+
+// Option configures a userService built by NewUserService.
+type Option func(*userService) error
+
+// WithSessionTTL sets how long a session written by Login stays in the
+// SessionStore.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("session ttl must be positive, got %s", ttl)
+		}
+
+		u.sessionTTL = ttl
+
+		return nil
+	}
+}
+
+// WithSessionIdleTimeout turns on sliding expiry: a session unused for idle
+// expires even if its session TTL, which stays the absolute maximum lifetime,
+// has not run out. Every authenticated call pushes the idle deadline back.
+func WithSessionIdleTimeout(idle time.Duration) Option {
+	return func(u *userService) error {
+		if idle <= 0 {
+			return fmt.Errorf("session idle timeout must be positive, got %s", idle)
+		}
+
+		u.idleTimeout = idle
+
+		return nil
+	}
+}
+
+// WithRememberMeTTL sets how long sessions and refresh tokens last when
+// LoginWithOptions is called with RememberMe.
+func WithRememberMeTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("remember me ttl must be positive, got %s", ttl)
+		}
+
+		u.rememberMeTTL = ttl
+
+		return nil
+	}
+}
+
+// WithLoginTTLBounds sets the range a TTL requested through LoginWithTTL is
+// clamped to.
+func WithLoginTTLBounds(lower, upper time.Duration) Option {
+	return func(u *userService) error {
+		if lower <= 0 || upper < lower {
+			return fmt.Errorf("login ttl bounds must be positive and ordered, got %s and %s", lower, upper)
+		}
+
+		u.minLoginTTL, u.maxLoginTTL = lower, upper
+
+		return nil
+	}
+}
+
+// WithWebAuthn turns on passkey registration and login for the relying party
+// rpID, the domain the service is reached on, accepting browser ceremonies
+// from origins such as "https://example.com". rpName is shown by browsers
+// when creating a passkey.
+func WithWebAuthn(rpID, rpName string, origins ...string) Option {
+	return func(u *userService) error {
+		webAuthn, err := webauthn.New(&webauthn.Config{
+			RPID:          rpID,
+			RPDisplayName: rpName,
+			RPOrigins:     origins,
+		})
+		if err != nil {
+			return fmt.Errorf("error while configuring webauthn: %w", err)
+		}
+
+		u.webAuthn = webAuthn
+
+		return nil
+	}
+}
+
+// WithOAuthProvider lets OAuthLogin sign users in through provider under
+// name, such as "google".
+func WithOAuthProvider(name string, provider OAuthProvider) Option {
+	return func(u *userService) error {
+		if name == "" || provider == nil {
+			return fmt.Errorf("oauth provider needs a name and must not be nil")
+		}
+
+		if u.oauthProviders == nil {
+			u.oauthProviders = make(map[string]OAuthProvider)
+		}
+
+		u.oauthProviders[name] = provider
+
+		return nil
+	}
+}
+
+// WithBusinessMetrics reports the number of distinct users holding at least
+// one session through activeUsers, and counts every account created through
+// registrations. Users whose sessions all expire on their own leave the gauge
+// on the next sweep, see WithSessionSweepInterval; with a SessionStore that
+// expires sessions natively they leave it the next time their sessions
+// change.
+func WithBusinessMetrics(activeUsersGauge metrics.Gauge, registrations metrics.Counter) Option {
+	return func(u *userService) error {
+		if activeUsersGauge == nil || registrations == nil {
+			return fmt.Errorf("business metrics must not be nil")
+		}
+
+		u.activeUsers = &activeUsers{users: make(map[string]activeUser), gauge: activeUsersGauge}
+		u.registrations = registrations
+
+		return nil
+	}
+}
+
+// WithSessionSweepInterval sets how often expired sessions are purged from a
+// SessionStore implementing ExpiredSessionSweeper.
+func WithSessionSweepInterval(interval time.Duration) Option {
+	return func(u *userService) error {
+		if interval <= 0 {
+			return fmt.Errorf("session sweep interval must be positive, got %s", interval)
+		}
+
+		u.sweepInterval = interval
+
+		return nil
+	}
+}
+
+// WithTokenTTL sets the lifetime embedded in the exp claim of tokens issued
+// by Login.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("token ttl must be positive, got %s", ttl)
+		}
+
+		u.tokenTTL = ttl
+
+		return nil
+	}
+}
+
+// WithRefreshTokenTTL sets how long refresh tokens issued by Login remain
+// exchangeable for new access tokens.
+func WithRefreshTokenTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("refresh token ttl must be positive, got %s", ttl)
+		}
+
+		u.refreshTTL = ttl
+
+		return nil
+	}
+}
+
+// WithRefreshTokenStore replaces the in-memory RefreshTokenStore, which is
+// needed when several instances must honour each other's refresh tokens.
+func WithRefreshTokenStore(store RefreshTokenStore) Option {
+	return func(u *userService) error {
+		if store == nil {
+			return fmt.Errorf("refresh token store must not be nil")
+		}
+
+		u.refreshTokens = store
+
+		return nil
+	}
+}
+
+// WithDenylist replaces the in-memory Denylist, which is needed when several
+// instances must honour each other's Logouts under WithStatelessSessions.
+func WithDenylist(denylist Denylist) Option {
+	return func(u *userService) error {
+		if denylist == nil {
+			return fmt.Errorf("denylist must not be nil")
+		}
+
+		u.denylist = denylist
+
+		return nil
+	}
+}
+
+// WithKeyManager replaces the single built-in signing key with keys, whose
+// active key can then be rotated at runtime.
+func WithKeyManager(keys *KeyManager) Option {
+	return func(u *userService) error {
+		if keys == nil {
+			return fmt.Errorf("key manager cannot be nil")
+		}
+
+		u.keys = keys
+
+		return nil
+	}
+}
+
+// WithEncryptedTokens signs and then encrypts every token with key, an
+// EncryptionKeySize byte AES key, so clients cannot read the claims they
+// carry; tokens that are only signed stop being accepted. The key is added
+// under the ID "encrypted-tokens" to the KeyManager once every option is
+// applied, so it holds whichever KeyManager is configured; NewUserService
+// fails if that KeyManager already has an encryption key of that ID. To
+// rotate it, add keys to the KeyManager with AddEncryptionKey and
+// SetActiveEncryptionKey instead.
+func WithEncryptedTokens(key []byte) Option {
+	return func(u *userService) error {
+		if len(key) != EncryptionKeySize {
+			return fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+		}
+
+		u.encryptionKey = append([]byte(nil), key...)
+
+		return nil
+	}
+}
+
+// WithLocalizer replaces the built-in English and Spanish messages shown by
+// SendMainTemplateData.
+func WithLocalizer(localizer *Localizer) Option {
+	return func(u *userService) error {
+		if localizer == nil {
+			return fmt.Errorf("localizer must not be nil")
+		}
+
+		u.localizer = localizer
+
+		return nil
+	}
+}
+
+// WithClock replaces the wall clock used for login lockouts, TOTP codes,
+// account timestamps and the session sweeper. The in-memory stores and the
+// KeyManager are switched to clock too, so token, session and one-time token
+// expiry follow it; stores with native expiry, like Redis, keep real time.
+func WithClock(clock Clock) Option {
+	return func(u *userService) error {
+		if clock == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+
+		u.clock = clock
+
+		return nil
+	}
+}
+
+// WithLogger sets where the service reports failures it recovers from on its
+// own, such as a failed background sweep. Nothing is logged by default.
+func WithLogger(logger log.Logger) Option {
+	return func(u *userService) error {
+		if logger == nil {
+			return fmt.Errorf("logger must not be nil")
+		}
+
+		u.logger = logger
+
+		return nil
+	}
+}
+
+// WithBcryptCost sets the cost used when hashing passwords with the default
+// bcrypt Hasher. Lowering it to bcrypt.MinCost is mostly useful to speed up
+// tests.
+func WithBcryptCost(cost int) Option {
+	return func(u *userService) error {
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+		}
+
+		u.bcryptCost = cost
+
+		return nil
+	}
+}
+
+// WithHasher replaces the default bcrypt Hasher for new password hashes.
+// Existing hashes keep verifying whichever Hasher made them, so switching to
+// NewArgon2Hasher needs no migration.
+func WithHasher(hasher Hasher) Option {
+	return func(u *userService) error {
+		if hasher == nil {
+			return fmt.Errorf("hasher must not be nil")
+		}
+
+		u.hasher = hasher
+
+		return nil
+	}
+}
+
+// WithHashConcurrency caps how many password hashes and comparisons run at
+// once, GOMAXPROCS by default, so a burst of logins or registrations queues
+// up instead of thrashing the CPU. Callers waiting for a slot give up when
+// their context ends.
+func WithHashConcurrency(n int) Option {
+	return func(u *userService) error {
+		if n < 1 {
+			return fmt.Errorf("hash concurrency must be at least 1, got %d", n)
+		}
+
+		u.hashConcurrency = n
+
+		return nil
+	}
+}
+
+// WithPepper sets an application-wide secret that passwords are HMAC'd with
+// before hashing, so a leaked users table cannot be brute forced without it.
+// The pepper applies to stored hashes as well: hashes created before it was
+// set, or with a different one, no longer verify.
+func WithPepper(secret []byte) Option {
+	return func(u *userService) error {
+		if len(secret) == 0 {
+			return fmt.Errorf("pepper must not be empty")
+		}
+
+		u.pepper = append([]byte(nil), secret...)
+
+		return nil
+	}
+}
+
+// WithPasswordPolicy replaces DefaultPasswordPolicy for Register and
+// ChangePassword.
+func WithPasswordPolicy(policy PasswordPolicy) Option {
+	return func(u *userService) error {
+		if policy.MinLength < 1 {
+			return fmt.Errorf("password policy min length must be at least 1, got %d", policy.MinLength)
+		}
+
+		if policy.MinLength > maxPasswordBytes {
+			return fmt.Errorf("password policy min length must not exceed %d, got %d", maxPasswordBytes, policy.MinLength)
+		}
+
+		u.passwordPolicy = policy
+
+		return nil
+	}
+}
+
+// WithMaxPasswordAge makes Login flag MustChangePassword on its result for
+// passwords last changed more than d ago, see also WithStrictPasswordExpiry.
+func WithMaxPasswordAge(d time.Duration) Option {
+	return func(u *userService) error {
+		if d <= 0 {
+			return fmt.Errorf("max password age must be positive, got %s", d)
+		}
+
+		u.maxPasswordAge = d
+
+		return nil
+	}
+}
+
+// WithStrictPasswordExpiry makes Login fail with ErrPasswordExpired instead
+// of flagging MustChangePassword once a password is past WithMaxPasswordAge.
+func WithStrictPasswordExpiry() Option {
+	return func(u *userService) error {
+		u.strictPasswordExpiry = true
+
+		return nil
+	}
+}
+
+// WithPasswordHistory makes ChangePassword and ResetPassword reject the
+// current password and the n-1 before it with ErrPasswordReused. Passwords
+// set before the option was given are not remembered.
+func WithPasswordHistory(n int) Option {
+	return func(u *userService) error {
+		if n < 1 || n > maxPasswordHistory {
+			return fmt.Errorf("password history must be between 1 and %d, got %d", maxPasswordHistory, n)
+		}
+
+		u.passwordHistory = n
+
+		return nil
+	}
+}
+
+// WithBreachChecker makes Register, ChangePassword and ResetPassword reject
+// passwords checker has seen in breaches more than threshold times. A
+// threshold of zero rejects any breached password. Passwords are allowed when
+// checker fails, so an outage of the breach API never blocks sign-ups,
+// unless WithFailurePolicy makes the check fail closed.
+func WithBreachChecker(checker BreachChecker, threshold int) Option {
+	return func(u *userService) error {
+		if checker == nil {
+			return fmt.Errorf("breach checker must not be nil")
+		}
+
+		if threshold < 0 {
+			return fmt.Errorf("breach threshold cannot be negative, got %d", threshold)
+		}
+
+		u.breachChecker = checker
+		u.breachThreshold = threshold
+
+		return nil
+	}
+}
+
+// WithEmailDomainPolicy makes Register reject emails whose domain policy
+// does not accept.
+func WithEmailDomainPolicy(policy *EmailDomainPolicy) Option {
+	return func(u *userService) error {
+		if policy == nil {
+			return fmt.Errorf("email domain policy must not be nil")
+		}
+
+		u.emailDomainPolicy = policy
+
+		return nil
+	}
+}
+
+// WithUsernamePolicy makes Register, and the other calls picking a
+// username, reject the names policy does not accept with
+// ErrUsernameNotAllowed.
+func WithUsernamePolicy(policy *UsernamePolicy) Option {
+	return func(u *userService) error {
+		if policy == nil {
+			return fmt.Errorf("username policy must not be nil")
+		}
+
+		u.usernamePolicy = policy
+
+		return nil
+	}
+}
+
+// WithReservedUsernames rejects the given names on Register, e.g. "admin" or
+// "root". Names are normalized the same way usernames are.
+func WithReservedUsernames(names ...string) Option {
+	return func(u *userService) error {
+		for _, name := range names {
+			u.reservedUsernames[normalizeUsername(name)] = struct{}{}
+		}
+
+		return nil
+	}
+}
+
+// WithRequireVerifiedEmail makes Login refuse accounts whose email has not
+// been confirmed through VerifyEmail.
+func WithRequireVerifiedEmail() Option {
+	return func(u *userService) error {
+		u.requireVerifiedEmail = true
+
+		return nil
+	}
+}
+
+// WithNewLoginAlerts mails users through the Mailer when they log in from a
+// user agent and IP that none of their live sessions was started from. The
+// mail carries the time, IP and user agent of the login. Logins whose
+// transport records neither are never alerted on.
+func WithNewLoginAlerts() Option {
+	return func(u *userService) error {
+		u.newLoginAlerts = true
+
+		return nil
+	}
+}
+
+// WithInviteOnly closes open registration: Register fails with
+// ErrInviteRequired and new accounts need a code from CreateInvite passed to
+// RegisterWithInvite.
+func WithInviteOnly() Option {
+	return func(u *userService) error {
+		u.inviteOnly = true
+
+		return nil
+	}
+}
+
+// WithRegistrationEnabled sets whether Register accepts new accounts when
+// the service starts. It defaults to true; SetRegistrationEnabled changes it
+// later.
+func WithRegistrationEnabled(enabled bool) Option {
+	return func(u *userService) error {
+		u.registrationDisabled = !enabled
+
+		return nil
+	}
+}
+
+// WithInviteTTL sets how long codes from CreateInvite can be redeemed.
+func WithInviteTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("invite ttl must be positive, got %s", ttl)
+		}
+
+		u.inviteTTL = ttl
+
+		return nil
+	}
+}
+
+// WithAuditSink sends the audit trail of registrations, logins, lockouts,
+// logouts and password changes to sink. By default it is discarded.
+func WithAuditSink(sink AuditSink) Option {
+	return func(u *userService) error {
+		if sink == nil {
+			return fmt.Errorf("audit sink must not be nil")
+		}
+
+		u.auditSink = sink
+
+		return nil
+	}
+}
+
+// WithMaxSessionsPerUser caps how many sessions one user can hold at once.
+// Login past the cap fails with ErrTooManySessions unless
+// WithEvictOldestSession is also given.
+func WithMaxSessionsPerUser(n int) Option {
+	return func(u *userService) error {
+		if n < 1 {
+			return fmt.Errorf("max sessions per user must be at least 1, got %d", n)
+		}
+
+		u.maxSessionsPerUser = n
+
+		return nil
+	}
+}
+
+// WithEvictOldestSession makes Login past WithMaxSessionsPerUser log out the
+// user's oldest sessions instead of failing.
+func WithEvictOldestSession() Option {
+	return func(u *userService) error {
+		u.evictOldestSession = true
+
+		return nil
+	}
+}
+
+// WithStatelessSessions keeps no server-side state for sessions: Login
+// issues a single access token carrying the username, valid for the whole
+// session TTL since no refresh token is issued, and requests are
+// authenticated from the token's claims alone, without a SessionStore
+// lookup.
+//
+// The price is revocation. Logout puts the token on the Denylist until it
+// expires, which unless WithDenylist shares it only the instance that
+// served the Logout honours. RevokeAllSessions, RevokeSession, ListSessions
+// and SessionStats fail with ErrStatelessSessions. Tokens are bound to the
+// account they were issued for, though, which is looked up on every
+// request: changing the password, suspending, renaming or deleting the
+// account ends every token already issued, and none acts for whoever
+// registers an old username next. Roles are read from the token, so a
+// change only shows up on the next login; keep WithSessionTTL short
+// accordingly.
+// It cannot be combined with WithSessionIdleTimeout, WithMaxSessionsPerUser
+// or WithNewLoginAlerts, which all need the SessionStore.
+func WithStatelessSessions() Option {
+	return func(u *userService) error {
+		u.statelessSessions = true
+
+		return nil
+	}
+}
+
+// WithVerificationTokenTTL sets how long tokens from GenerateVerificationToken
+// can be redeemed.
+func WithVerificationTokenTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("verification token ttl must be positive, got %s", ttl)
+		}
+
+		u.verificationTTL = ttl
+
+		return nil
+	}
+}
+
+// WithVerificationResendInterval sets the least time ResendVerification
+// leaves between two mails to the same account.
+func WithVerificationResendInterval(interval time.Duration) Option {
+	return func(u *userService) error {
+		if interval < 0 {
+			return fmt.Errorf("verification resend interval cannot be negative, got %s", interval)
+		}
+
+		u.verificationResend = interval
+
+		return nil
+	}
+}
+
+// WithMailer sets the Mailer verification codes and password reset tokens
+// are sent through. Without one they are never mailed.
+func WithMailer(mailer Mailer) Option {
+	return func(u *userService) error {
+		if mailer == nil {
+			return fmt.Errorf("mailer must not be nil")
+		}
+
+		u.mailer = mailer
+
+		return nil
+	}
+}
+
+// WithFailurePolicy sets whether requests carry on without integration or
+// fail with ErrIntegrationUnavailable when it fails. By default the breach
+// check and the mailer fail open. Failing closed turns a breach check
+// failure into a failed Register, ChangePassword or ResetPassword, and a
+// mail that cannot be sent into a failed RequestPasswordReset or
+// ResendVerification, which then waits for the mail server and, by
+// failing, reveals that the account exists. New login alerts never fail
+// the login either way. OAuth can only fail closed.
+func WithFailurePolicy(integration Integration, policy FailurePolicy) Option {
+	return func(u *userService) error {
+		if err := validateFailurePolicy(integration, policy); err != nil {
+			return err
+		}
+
+		u.failurePolicies[integration] = policy
+
+		return nil
+	}
+}
+
+// WithSessionIDGenerator replaces the random UUIDs Login gives new
+// sessions with the IDs of generator.
+func WithSessionIDGenerator(generator SessionIDGenerator) Option {
+	return func(u *userService) error {
+		if generator == nil {
+			return fmt.Errorf("session id generator must not be nil")
+		}
+
+		u.sessionIDs = generator
+
+		return nil
+	}
+}
+
+// WithOneTimeTokenStore replaces the in-memory store used for single-use
+// tokens such as email verification.
+func WithOneTimeTokenStore(store OneTimeTokenStore) Option {
+	return func(u *userService) error {
+		if store == nil {
+			return fmt.Errorf("one-time token store must not be nil")
+		}
+
+		u.oneTimeTokens = store
+
+		return nil
+	}
+}
+
+// WithPasswordResetTokenTTL sets how long tokens from RequestPasswordReset
+// can be redeemed.
+func WithPasswordResetTokenTTL(ttl time.Duration) Option {
+	return func(u *userService) error {
+		if ttl <= 0 {
+			return fmt.Errorf("password reset token ttl must be positive, got %s", ttl)
+		}
+
+		u.resetTTL = ttl
+
+		return nil
+	}
+}
+
+// WithLockoutThreshold sets how many consecutive failed logins lock an
+// account.
+func WithLockoutThreshold(threshold int) Option {
+	return func(u *userService) error {
+		if threshold < 1 {
+			return fmt.Errorf("lockout threshold must be at least 1, got %d", threshold)
+		}
+
+		u.loginAttempts.threshold = threshold
+
+		return nil
+	}
+}
+
+// WithLockoutDuration sets both the window in which failed logins are counted
+// and how long an account stays locked once the threshold is reached.
+func WithLockoutDuration(d time.Duration) Option {
+	return func(u *userService) error {
+		if d <= 0 {
+			return fmt.Errorf("lockout duration must be positive, got %s", d)
+		}
+
+		u.loginAttempts.duration = d
+
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithBcryptCost(t *testing.T) {
+	for _, cost := range []int{bcrypt.MinCost - 1, bcrypt.MaxCost + 1} {
+		if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithBcryptCost(cost)); err == nil {
+			t.Errorf("NewUserService() with bcrypt cost %d succeeded", cost)
+		}
+	}
+
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+
+	cost, err := bcrypt.Cost([]byte(mustGetUser(t, svc, "alice").HashedPassword))
+	if err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("hash cost = %d, %v, want %d", cost, err, bcrypt.MinCost)
+	}
+}
+
+func TestDefaultBcryptCost(t *testing.T) {
+	svc, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	if cost := svc.(*userService).bcryptCost; cost != bcrypt.DefaultCost {
+		t.Fatalf("bcryptCost = %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
+
+func TestOptionsApplied(t *testing.T) {
+	hasher, err := NewArgon2Hasher(testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	logger := log.NewLogfmtLogger(io.Discard)
+	policy := PasswordPolicy{MinLength: 20}
+
+	svc := newTestService(t,
+		WithBcryptCost(bcrypt.MinCost+1),
+		WithTokenTTL(time.Minute),
+		WithSessionTTL(time.Hour),
+		WithPasswordPolicy(policy),
+		WithHasher(hasher),
+		WithClock(clock),
+		WithLogger(logger),
+	)
+
+	if svc.bcryptCost != bcrypt.MinCost+1 || svc.tokenTTL != time.Minute || svc.sessionTTL != time.Hour {
+		t.Errorf("bcryptCost, tokenTTL, sessionTTL = %d, %s, %s", svc.bcryptCost, svc.tokenTTL, svc.sessionTTL)
+	}
+
+	if !reflect.DeepEqual(svc.passwordPolicy, policy) {
+		t.Errorf("passwordPolicy = %+v, want %+v", svc.passwordPolicy, policy)
+	}
+
+	if svc.hasher != hasher || svc.clock != Clock(clock) || svc.logger != logger {
+		t.Error("WithHasher, WithClock or WithLogger was not applied")
+	}
+}
+
+func TestOptionsRejectInvalidInput(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"WithTokenTTL":       WithTokenTTL(0),
+		"WithSessionTTL":     WithSessionTTL(-time.Second),
+		"WithPasswordPolicy": WithPasswordPolicy(PasswordPolicy{}),
+		"WithHasher":         WithHasher(nil),
+		"WithClock":          WithClock(nil),
+		"WithLogger":         WithLogger(nil),
+	} {
+		if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), opt); err == nil {
+			t.Errorf("NewUserService() with an invalid %s succeeded", name)
+		}
+	}
+}
+
+// TestNoOptionsDefaults pins the behaviour of a NewUserService call without
+// options.
+func TestNoOptionsDefaults(t *testing.T) {
+	svc, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	u := svc.(*userService)
+
+	if u.tokenTTL != DefaultTokenTTL || u.sessionTTL != DefaultSessionTTL || u.refreshTTL != DefaultRefreshTokenTTL {
+		t.Errorf("tokenTTL, sessionTTL, refreshTTL = %s, %s, %s, want the defaults", u.tokenTTL, u.sessionTTL, u.refreshTTL)
+	}
+
+	if !reflect.DeepEqual(u.passwordPolicy, DefaultPasswordPolicy()) {
+		t.Errorf("passwordPolicy = %+v, want DefaultPasswordPolicy()", u.passwordPolicy)
+	}
+
+	if _, ok := u.hasher.(bcryptHasher); !ok {
+		t.Errorf("hasher = %T, want bcrypt", u.hasher)
+	}
+
+	if _, ok := u.clock.(realClock); !ok {
+		t.Errorf("clock = %T, want the wall clock", u.clock)
+	}
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// DefaultPasskeyChallengeTTL bounds how long a browser has to answer a
+// passkey challenge.
+const DefaultPasskeyChallengeTTL = 5 * time.Minute
+
+const (
+	passkeyRegistrationPurpose = "passkey-registration"
+	passkeyLoginPurpose        = "passkey-login"
+	passkeyUserIDLength        = 64
+)
+
+var (
+	// ErrPasskeysDisabled is returned by the passkey methods when the
+	// service was built without WithWebAuthn.
+	ErrPasskeysDisabled = errors.New("passkeys are not enabled")
+	// ErrInvalidPasskey is returned when a passkey response does not answer
+	// a pending challenge or fails verification.
+	ErrInvalidPasskey = errors.New("invalid passkey")
+)
+
+// passkeyUser exposes a user to the webauthn library.
+type passkeyUser struct {
+	fields UserFields
+}
+
+func (p passkeyUser) WebAuthnID() []byte                         { return p.fields.PasskeyUserID }
+func (p passkeyUser) WebAuthnName() string                       { return p.fields.Username }
+func (p passkeyUser) WebAuthnDisplayName() string                { return p.fields.Username }
+func (p passkeyUser) WebAuthnCredentials() []webauthn.Credential { return p.fields.Passkeys }
+
+// BeginPasskeyRegistration starts adding a passkey to the account owning
+// token. The returned options go to navigator.credentials.create in the
+// browser, and its answer to FinishPasskeyRegistration.
+func (u *userService) BeginPasskeyRegistration(ctx context.Context, token string) (*protocol.CredentialCreation, error) {
+	if u.webAuthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if len(userFields.PasskeyUserID) == 0 {
+		userFields.PasskeyUserID = make([]byte, passkeyUserIDLength)
+		if _, err := rand.Read(userFields.PasskeyUserID); err != nil {
+			return nil, fmt.Errorf("error while generating passkey user id: %w", err)
+		}
+
+		if err := u.users.SaveUser(ctx, userFields); err != nil {
+			return nil, fmt.Errorf("error while saving user: %w", err)
+		}
+	}
+
+	creation, session, err := u.webAuthn.BeginRegistration(
+		passkeyUser{fields: userFields},
+		webauthn.WithExclusions(webauthn.Credentials(userFields.Passkeys).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while starting passkey registration: %w", err)
+	}
+
+	if err := u.putPasskeyChallenge(ctx, passkeyRegistrationPurpose, session); err != nil {
+		return nil, err
+	}
+
+	return creation, nil
+}
+
+// FinishPasskeyRegistration verifies the browser's answer to
+// BeginPasskeyRegistration, given as the JSON it produced, and stores the
+// new credential's public key on the account.
+func (u *userService) FinishPasskeyRegistration(ctx context.Context, token string, credential []byte) error {
+	if u.webAuthn == nil {
+		return ErrPasskeysDisabled
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(credential)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	session, err := u.takePasskeyChallenge(ctx, passkeyRegistrationPurpose, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	created, err := u.webAuthn.CreateCredential(passkeyUser{fields: userFields}, session, parsed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	userFields.Passkeys = append(userFields.Passkeys, *created)
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return nil
+}
+
+// BeginPasskeyLogin starts a passwordless login for username. The returned
+// options go to navigator.credentials.get in the browser, and its answer to
+// FinishPasskeyLogin. Unknown users and users without passkeys both fail
+// with ErrInvalidCredentials.
+func (u *userService) BeginPasskeyLogin(ctx context.Context, username string) (*protocol.CredentialAssertion, error) {
+	if u.webAuthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	username = normalizeUsername(username)
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if errors.Is(err, ErrUserNotFound) || err == nil && len(userFields.Passkeys) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	assertion, session, err := u.webAuthn.BeginLogin(passkeyUser{fields: userFields})
+	if err != nil {
+		return nil, fmt.Errorf("error while starting passkey login: %w", err)
+	}
+
+	if err := u.putPasskeyChallenge(ctx, passkeyLoginPurpose, session); err != nil {
+		return nil, err
+	}
+
+	return assertion, nil
+}
+
+// FinishPasskeyLogin verifies the browser's answer to BeginPasskeyLogin and
+// opens a session as Login does. The passkey stands in for both the password
+// and the second factor.
+func (u *userService) FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (LoginResult, error) {
+	if u.webAuthn == nil {
+		return LoginResult{}, ErrPasskeysDisabled
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(credential)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	username = normalizeUsername(username)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.loginAttempts.locked(tenantKey(TenantFromContext(ctx), username), u.clock.Now()) {
+		u.audit(ctx, AuditFailedLogin, username)
+
+		return LoginResult{}, ErrAccountLocked
+	}
+
+	session, err := u.takePasskeyChallenge(ctx, passkeyLoginPurpose, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		return LoginResult{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	used, err := u.webAuthn.ValidateLogin(passkeyUser{fields: userFields}, session, parsed)
+	if err == nil && used.Authenticator.CloneWarning {
+		err = errors.New("signature counter went backwards, the authenticator may be cloned")
+	}
+
+	if err != nil {
+		u.loginFailed(ctx, username)
+
+		return LoginResult{}, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	// The repository may hand out its own slice; update a copy.
+	userFields.Passkeys = slices.Clone(userFields.Passkeys)
+
+	for i := range userFields.Passkeys {
+		if bytes.Equal(userFields.Passkeys[i].ID, used.ID) {
+			userFields.Passkeys[i] = *used
+		}
+	}
+
+	u.loginAttempts.reset(tenantKey(TenantFromContext(ctx), username))
+
+	// startSession saves userFields along with the last login, which keeps
+	// the updated signature counter.
+	return u.startSession(ctx, userFields, LoginOptions{})
+}
+
+// putPasskeyChallenge keeps session until the browser answers, keyed by its
+// challenge, which the answer echoes back.
+func (u *userService) putPasskeyChallenge(ctx context.Context, purpose string, session *webauthn.SessionData) error {
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("error while encoding passkey challenge: %w", err)
+	}
+
+	if err := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, purpose, session.Challenge), string(encoded), u.passkeyChallengeTTL); err != nil {
+		return fmt.Errorf("error while saving passkey challenge: %w", err)
+	}
+
+	return nil
+}
+
+// takePasskeyChallenge consumes the session stored for challenge, so every
+// challenge is answered at most once.
+func (u *userService) takePasskeyChallenge(ctx context.Context, purpose, challenge string) (webauthn.SessionData, error) {
+	encoded, err := u.oneTimeTokens.Take(ctx, oneTimeTokenKey(ctx, purpose, challenge))
+	if errors.Is(err, ErrOneTimeTokenNotFound) {
+		return webauthn.SessionData{}, fmt.Errorf("%w: challenge unknown or expired", ErrInvalidPasskey)
+	}
+
+	if err != nil {
+		return webauthn.SessionData{}, fmt.Errorf("error while reading passkey challenge: %w", err)
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(encoded), &session); err != nil {
+		return webauthn.SessionData{}, fmt.Errorf("error while decoding passkey challenge: %w", err)
+	}
+
+	return session, nil
+}
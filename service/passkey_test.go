@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testRPID     = "example.com"
+	testRPOrigin = "https://example.com"
+)
+
+// softAuthenticator plays a platform authenticator holding one ES256
+// passkey, answering ceremonies the way a browser would hand them to
+// FinishPasskeyRegistration and FinishPasskeyLogin. It attests with "none".
+type softAuthenticator struct {
+	t       *testing.T
+	key     *ecdsa.PrivateKey
+	id      []byte
+	counter uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	return a.marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testRPOrigin,
+	})
+}
+
+// authData builds the authenticator data with the user present and
+// verified flags, and the attested credential when attested is set.
+func (a *softAuthenticator) authData(attested bool) []byte {
+	a.counter++
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], 0x05)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+
+	if !attested {
+		return data
+	}
+
+	data[32] |= 0x40
+
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1,
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+
+	return append(data, coseKey...)
+}
+
+func (a *softAuthenticator) register(creation *protocol.CredentialCreation) []byte {
+	attestation, err := webauthncbor.Marshal(struct {
+		Fmt      string                 `cbor:"fmt"`
+		AttStmt  map[string]interface{} `cbor:"attStmt"`
+		AuthData []byte                 `cbor:"authData"`
+	}{Fmt: "none", AttStmt: map[string]interface{}{}, AuthData: a.authData(true)})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", creation.Response.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+}
+
+func (a *softAuthenticator) login(assertion *protocol.CredentialAssertion) []byte {
+	clientData := a.clientData("webauthn.get", assertion.Response.Challenge)
+	authData := a.authData(false)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	})
+}
+
+func (a *softAuthenticator) marshal(v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return raw
+}
+
+func TestPasskeyRegisterThenLogin(t *testing.T) {
+	svc := newTestService(t, WithWebAuthn(testRPID, "Example", testRPOrigin))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if _, err := svc.BeginPasskeyLogin(context.Background(), "alice"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("BeginPasskeyLogin() without a passkey error = %v, want ErrInvalidCredentials", err)
+	}
+
+	authenticator := newSoftAuthenticator(t)
+
+	creation, err := svc.BeginPasskeyRegistration(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential := authenticator.register(creation)
+	if err := svc.FinishPasskeyRegistration(context.Background(), session.AccessToken, credential); err != nil {
+		t.Fatal(err)
+	}
+
+	// The challenge is spent: the same answer cannot add the passkey twice.
+	if err := svc.FinishPasskeyRegistration(context.Background(), session.AccessToken, credential); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("FinishPasskeyRegistration() replayed error = %v, want ErrInvalidPasskey", err)
+	}
+
+	assertion, err := svc.BeginPasskeyLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer := authenticator.login(assertion)
+
+	result, err := svc.FinishPasskeyLogin(context.Background(), "alice", answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := svc.GetHomeState(context.Background(), result.AccessToken); err != nil || state.Username != "alice" {
+		t.Fatalf("GetHomeState() with the passkey session = %+v, %v, want alice", state, err)
+	}
+
+	if _, err := svc.FinishPasskeyLogin(context.Background(), "alice", answer); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("FinishPasskeyLogin() replayed error = %v, want ErrInvalidPasskey", err)
+	}
+
+	if counter := mustGetUser(t, svc, "alice").Passkeys[0].Authenticator.SignCount; counter != authenticator.counter {
+		t.Fatalf("stored signature counter = %d, want %d", counter, authenticator.counter)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherKeys(t *testing.T) {
+	svc := newTestService(t, WithWebAuthn(testRPID, "Example", testRPOrigin))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	authenticator := newSoftAuthenticator(t)
+
+	creation, err := svc.BeginPasskeyRegistration(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.FinishPasskeyRegistration(context.Background(), session.AccessToken, authenticator.register(creation)); err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := svc.BeginPasskeyLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An impostor knowing the credential ID signs with its own key.
+	impostor := newSoftAuthenticator(t)
+	impostor.id = authenticator.id
+
+	if _, err := svc.FinishPasskeyLogin(context.Background(), "alice", impostor.login(assertion)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("FinishPasskeyLogin() with another key error = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeysDisabled(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.BeginPasskeyLogin(context.Background(), "alice"); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Fatalf("BeginPasskeyLogin() error = %v, want ErrPasskeysDisabled", err)
+	}
+
+	if _, err := svc.BeginPasskeyRegistration(context.Background(), "token"); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Fatalf("BeginPasskeyRegistration() error = %v, want ErrPasskeysDisabled", err)
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is bcrypt's input limit; anything past it is silently
+// ignored when hashing, so longer passwords are rejected instead.
+const maxPasswordBytes = 72
+
+// ErrWeakPassword is returned when a password does not satisfy the configured
+// PasswordPolicy. The wrapping error names the rule that failed.
+var ErrWeakPassword = errors.New("password does not satisfy the password policy")
+
+// maxPasswordHistory bounds WithPasswordHistory; every entry costs a hash
+// comparison on each password change.
+const maxPasswordHistory = 24
+
+// ErrPasswordReused is returned by ChangePassword and ResetPassword for a
+// password among the ones WithPasswordHistory remembers.
+var ErrPasswordReused = errors.New("password was used recently")
+
+// ErrPasswordExpired is returned by Login under WithStrictPasswordExpiry for
+// passwords older than WithMaxPasswordAge. The user has to go through
+// ResetPassword to get back in.
+var ErrPasswordExpired = errors.New("password expired")
+
+// PasswordPolicy describes the rules a new password must satisfy.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireDigit  bool
+	RequireLetter bool
+}
+
+// DefaultPasswordPolicy requires at least 8 characters including a letter and
+// a digit.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     8,
+		RequireDigit:  true,
+		RequireLetter: true,
+	}
+}
+
+// Validate reports the first rule pass breaks, wrapped around ErrWeakPassword.
+func (p PasswordPolicy) Validate(pass string) error {
+	if utf8.RuneCountInString(pass) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters long", ErrWeakPassword, p.MinLength)
+	}
+
+	if len(pass) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes long", ErrWeakPassword, maxPasswordBytes)
+	}
+
+	var hasDigit, hasLetter bool
+	for _, r := range pass {
+		switch {
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsLetter(r):
+			hasLetter = true
+		}
+	}
+
+	if p.RequireDigit && !hasDigit {
+		return fmt.Errorf("%w: must contain at least one digit", ErrWeakPassword)
+	}
+
+	if p.RequireLetter && !hasLetter {
+		return fmt.Errorf("%w: must contain at least one letter", ErrWeakPassword)
+	}
+
+	return nil
+}
+
+// passwordExpired reports whether the password of userFields is older than
+// WithMaxPasswordAge allows. Accounts from before PasswordChangedAt was
+// tracked count from their creation; without either nothing expires.
+func (u *userService) passwordExpired(userFields UserFields, now time.Time) bool {
+	if u.maxPasswordAge <= 0 {
+		return false
+	}
+
+	changedAt := userFields.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = userFields.CreatedAt
+	}
+
+	return !changedAt.IsZero() && now.Sub(changedAt) > u.maxPasswordAge
+}
+
+// checkPasswordReuse fails with ErrPasswordReused when pass is the current
+// password of userFields or one of those in its history. Without
+// WithPasswordHistory it accepts anything.
+func (u *userService) checkPasswordReuse(ctx context.Context, userFields UserFields, pass string) error {
+	if u.passwordHistory == 0 {
+		return nil
+	}
+
+	hashes := append([]string{userFields.HashedPassword}, userFields.PasswordHistory...)
+
+	for i, hash := range hashes {
+		if i > 0 && hash == userFields.HashedPassword {
+			continue
+		}
+
+		err := u.checkPasswordHash(ctx, pass, hash)
+		if err == nil {
+			return ErrPasswordReused
+		}
+
+		if hashAbandoned(ctx, err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setPassword makes hashedPass the password of userFields as of now,
+// remembering it in the history WithPasswordHistory keeps.
+func (u *userService) setPassword(userFields *UserFields, hashedPass string, now time.Time) {
+	userFields.HashedPassword = hashedPass
+	userFields.PasswordChangedAt = now.UTC()
+
+	if u.passwordHistory == 0 {
+		return
+	}
+
+	history := append([]string{hashedPass}, userFields.PasswordHistory...)
+	userFields.PasswordHistory = history[:min(len(history), u.passwordHistory)]
+}
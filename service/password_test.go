@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy PasswordPolicy
+		pass   string
+		rule   string
+	}{
+		{DefaultPasswordPolicy(), "passw0rd", ""},
+		{DefaultPasswordPolicy(), "a", "at least 8 characters"},
+		{DefaultPasswordPolicy(), "password", "digit"},
+		{DefaultPasswordPolicy(), "12345678", "letter"},
+		{DefaultPasswordPolicy(), "päss1äöü", ""},
+		{DefaultPasswordPolicy(), strings.Repeat("a1", 36), ""},
+		{DefaultPasswordPolicy(), strings.Repeat("a1", 36) + "a", "at most 72 bytes"},
+		{DefaultPasswordPolicy(), strings.Repeat("ä1", 30), "at most 72 bytes"},
+		{PasswordPolicy{MinLength: 4}, "abcd", ""},
+		{PasswordPolicy{MinLength: 4}, "abc", "at least 4 characters"},
+	}
+
+	for _, tc := range cases {
+		err := tc.policy.Validate(tc.pass)
+		if tc.rule == "" {
+			if err != nil {
+				t.Errorf("Validate(%q) error = %v", tc.pass, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrWeakPassword) || !strings.Contains(err.Error(), tc.rule) {
+			t.Errorf("Validate(%q) error = %v, want ErrWeakPassword naming %q", tc.pass, err, tc.rule)
+		}
+	}
+}
+
+func TestRegisterEnforcesPasswordPolicy(t *testing.T) {
+	svc := newTestService(t)
+	if _, err := svc.Register(context.Background(), "alice", "a", "alice@example.com"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("Register() with a weak password error = %v, want ErrWeakPassword", err)
+	}
+
+	svc = newTestService(t, WithPasswordPolicy(PasswordPolicy{MinLength: 1}))
+	if _, err := svc.Register(context.Background(), "alice", "a", "alice@example.com"); err != nil {
+		t.Fatalf("Register() under a relaxed policy error = %v", err)
+	}
+
+	if _, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithPasswordPolicy(PasswordPolicy{MinLength: 73})); err == nil {
+		t.Fatal("NewUserService() accepted a min length past bcrypt's limit")
+	}
+}
+
+func TestMaxPasswordAge(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithMaxPasswordAge(90*24*time.Hour))
+	mustRegister(t, svc, "alice")
+
+	if changedAt := mustGetUser(t, svc, "alice").PasswordChangedAt; !changedAt.Equal(clock.Now()) {
+		t.Fatalf("PasswordChangedAt after Register = %s, want %s", changedAt, clock.Now())
+	}
+
+	clock.Advance(90 * 24 * time.Hour)
+
+	if session := mustLogin(t, svc, "alice"); session.MustChangePassword {
+		t.Fatal("Login() flagged MustChangePassword on the last day of the password's age")
+	}
+
+	clock.Advance(time.Second)
+
+	session := mustLogin(t, svc, "alice")
+	if !session.MustChangePassword {
+		t.Fatal("Login() with an expired password did not flag MustChangePassword")
+	}
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if changedAt := mustGetUser(t, svc, "alice").PasswordChangedAt; !changedAt.Equal(clock.Now()) {
+		t.Fatalf("PasswordChangedAt after ChangePassword = %s, want %s", changedAt, clock.Now())
+	}
+
+	result, err := svc.Login(context.Background(), "alice", "n3w-password")
+	if err != nil || result.MustChangePassword {
+		t.Fatalf("Login() after the change = %+v, %v, want no flag", result, err)
+	}
+}
+
+func TestStrictPasswordExpiry(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithMaxPasswordAge(24*time.Hour), WithStrictPasswordExpiry())
+	mustRegister(t, svc, "alice")
+
+	clock.Advance(25 * time.Hour)
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrPasswordExpired) {
+		t.Fatalf("Login() with an expired password error = %v, want ErrPasswordExpired", err)
+	}
+
+	token, err := svc.RequestPasswordReset(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if changedAt := mustGetUser(t, svc, "alice").PasswordChangedAt; !changedAt.Equal(clock.Now()) {
+		t.Fatalf("PasswordChangedAt after ResetPassword = %s, want %s", changedAt, clock.Now())
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", "n3w-password"); err != nil {
+		t.Fatalf("Login() after the reset error = %v", err)
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	svc := newTestService(t, WithPasswordHistory(3))
+	mustRegister(t, svc, "alice")
+
+	passwords := []string{testPassword, "s3cond-password", "th1rd-password", "f0urth-password"}
+
+	// change logs in with the current password and changes it to next.
+	change := func(current, next string) error {
+		session, err := svc.Login(context.Background(), "alice", current)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return svc.ChangePassword(context.Background(), session.AccessToken, current, next)
+	}
+
+	for i := 1; i < 3; i++ {
+		if err := change(passwords[i-1], passwords[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The current password and the two before it are remembered.
+	for _, pass := range passwords[:3] {
+		if err := change(passwords[2], pass); !errors.Is(err, ErrPasswordReused) && !errors.Is(err, ErrPasswordUnchanged) {
+			t.Fatalf("ChangePassword() back to %q error = %v, want ErrPasswordReused", pass, err)
+		}
+	}
+
+	token, err := svc.RequestPasswordReset(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, passwords[0]); !errors.Is(err, ErrPasswordReused) {
+		t.Fatalf("ResetPassword() to a recent password error = %v, want ErrPasswordReused", err)
+	}
+
+	// A fourth password pushes the first one out of the history.
+	if err := change(passwords[2], passwords[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if history := mustGetUser(t, svc, "alice").PasswordHistory; len(history) != 3 {
+		t.Fatalf("%d hashes in the history, want it trimmed to 3", len(history))
+	}
+
+	if err := change(passwords[3], passwords[0]); err != nil {
+		t.Fatalf("ChangePassword() to the password that left the history error = %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgresMigrations are applied in order on startup. Every statement must be
+// idempotent since they all run on each boot.
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		username        TEXT PRIMARY KEY,
+		hashed_password TEXT NOT NULL
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ`,
+	// Usernames are unique per tenant rather than globally.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_username_key ON users (tenant, username)`,
+	`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS passkey_user_id BYTEA`,
+	// Passkeys are stored as a JSON array of credentials.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS passkeys TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS oauth_provider TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS oauth_subject TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS recovery_codes TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_history TEXT NOT NULL DEFAULT ''`,
+	// Backs GetUserByEmail, which Login goes through for email logins.
+	`CREATE INDEX IF NOT EXISTS users_tenant_email_idx ON users (tenant, lower(email))`,
+	// Emails are unique per tenant. Accounts already sharing an email have
+	// to be told apart by hand before this index can be built.
+	`CREATE UNIQUE INDEX IF NOT EXISTS ` + postgresEmailIndex + ` ON users (tenant, lower(email)) WHERE email <> ''`,
+	`DROP INDEX IF EXISTS users_tenant_email_idx`,
+}
+
+// postgresEmailIndex keeps emails unique, see postgresWriteError.
+const postgresEmailIndex = "users_tenant_email_key"
+
+const postgresUserColumns = `username, tenant, hashed_password, email, email_verified, roles, totp_secret, totp_enabled, created_at, last_login_at, suspended, passkey_user_id, passkeys, oauth_provider, oauth_subject, recovery_codes, password_changed_at, must_change_password, password_history`
+
+// Roles and recovery code hashes are stored comma separated; normalizeRoles
+// never lets a comma into a role name, and the hashes are hex.
+const postgresRoleSeparator = ","
+
+type postgresUserRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresUserRepository returns a UserRepository backed by db, creating
+// the users table if it does not exist yet. db is expected to be opened with
+// the pgx driver (github.com/jackc/pgx/v5/stdlib).
+func NewPostgresUserRepository(db *sql.DB) (UserRepository, error) {
+	for _, migration := range postgresMigrations {
+		if _, err := db.Exec(migration); err != nil {
+			return nil, &RepositoryError{Op: "migrate users table", Err: err}
+		}
+	}
+
+	return &postgresUserRepository{db: db}, nil
+}
+
+func (p *postgresUserRepository) GetUser(ctx context.Context, username string) (UserFields, error) {
+	return p.queryUser(
+		ctx,
+		"get user",
+		`SELECT `+postgresUserColumns+` FROM users WHERE tenant = $1 AND username = $2`,
+		TenantFromContext(ctx), username,
+	)
+}
+
+// GetUserByEmail matches email case-insensitively.
+func (p *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (UserFields, error) {
+	return p.queryUser(
+		ctx,
+		"get user by email",
+		`SELECT `+postgresUserColumns+` FROM users WHERE tenant = $1 AND email <> '' AND lower(email) = lower($2)`,
+		TenantFromContext(ctx), email,
+	)
+}
+
+func (p *postgresUserRepository) queryUser(ctx context.Context, op, query string, args ...interface{}) (UserFields, error) {
+	var (
+		user        UserFields
+		roles       string
+		createdAt   sql.NullTime
+		lastLoginAt sql.NullTime
+		passkeys    string
+		recovery    string
+		passwordAt  sql.NullTime
+		history     string
+	)
+
+	err := p.db.QueryRowContext(ctx, query, args...).Scan(&user.Username, &user.Tenant, &user.HashedPassword, &user.Email, &user.EmailVerified, &roles, &user.TOTPSecret, &user.TOTPEnabled, &createdAt, &lastLoginAt, &user.Suspended, &user.PasskeyUserID, &passkeys, &user.OAuthProvider, &user.OAuthSubject, &recovery, &passwordAt, &user.MustChangePassword, &history)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserFields{}, ErrUserNotFound
+	}
+	if err != nil {
+		return UserFields{}, &RepositoryError{Op: op, Err: err}
+	}
+
+	if roles != "" {
+		user.Roles = strings.Split(roles, postgresRoleSeparator)
+	}
+
+	if createdAt.Valid {
+		user.CreatedAt = createdAt.Time
+	}
+
+	if lastLoginAt.Valid {
+		user.LastLoginAt = lastLoginAt.Time
+	}
+
+	if passwordAt.Valid {
+		user.PasswordChangedAt = passwordAt.Time
+	}
+
+	if passkeys != "" {
+		if err := json.Unmarshal([]byte(passkeys), &user.Passkeys); err != nil {
+			return UserFields{}, &RepositoryError{Op: op, Err: fmt.Errorf("error while decoding passkeys: %w", err)}
+		}
+	}
+
+	if recovery != "" {
+		user.RecoveryCodeHashes = strings.Split(recovery, postgresRoleSeparator)
+	}
+
+	// Argon2id hashes contain commas, so the history is kept as JSON.
+	if history != "" {
+		if err := json.Unmarshal([]byte(history), &user.PasswordHistory); err != nil {
+			return UserFields{}, &RepositoryError{Op: op, Err: fmt.Errorf("error while decoding password history: %w", err)}
+		}
+	}
+
+	return user, nil
+}
+
+func (p *postgresUserRepository) ListUsernames(ctx context.Context, offset, limit int) ([]string, int, error) {
+	tenant := TenantFromContext(ctx)
+
+	var total int
+	if err := p.db.QueryRowContext(ctx, `SELECT count(*) FROM users WHERE tenant = $1`, tenant).Scan(&total); err != nil {
+		return nil, 0, &RepositoryError{Op: "count users", Err: err}
+	}
+
+	rows, err := p.db.QueryContext(ctx, `SELECT username FROM users WHERE tenant = $1 ORDER BY username LIMIT $2 OFFSET $3`, tenant, limit, offset)
+	if err != nil {
+		return nil, 0, &RepositoryError{Op: "list users", Err: err}
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, 0, &RepositoryError{Op: "list users", Err: err}
+		}
+
+		usernames = append(usernames, username)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, &RepositoryError{Op: "list users", Err: err}
+	}
+
+	return usernames, total, nil
+}
+
+// postgresUniqueViolation is the SQLSTATE of an insert or update breaking a
+// unique index.
+const postgresUniqueViolation = "23505"
+
+// CreateUser inserts user, relying on the unique index on (tenant,
+// username) rather than on a lookup beforehand, so two instances racing to
+// register the same name cannot both succeed.
+func (p *postgresUserRepository) CreateUser(ctx context.Context, user UserFields) error {
+	args, err := postgresUserArgs(user)
+	if err != nil {
+		return &RepositoryError{Op: "create user", Err: err}
+	}
+
+	if _, err := p.db.ExecContext(ctx, `INSERT INTO users (`+postgresUserColumns+`) VALUES `+postgresUserValues, args...); err != nil {
+		return postgresWriteError("create user", err)
+	}
+
+	return nil
+}
+
+func (p *postgresUserRepository) SaveUser(ctx context.Context, user UserFields) error {
+	args, err := postgresUserArgs(user)
+	if err != nil {
+		return &RepositoryError{Op: "save user", Err: err}
+	}
+
+	_, err = p.db.ExecContext(
+		ctx,
+		`INSERT INTO users (`+postgresUserColumns+`) VALUES `+postgresUserValues+`
+		ON CONFLICT (tenant, username) DO UPDATE SET
+			hashed_password = EXCLUDED.hashed_password,
+			email = EXCLUDED.email,
+			email_verified = EXCLUDED.email_verified,
+			roles = EXCLUDED.roles,
+			totp_secret = EXCLUDED.totp_secret,
+			totp_enabled = EXCLUDED.totp_enabled,
+			last_login_at = EXCLUDED.last_login_at,
+			suspended = EXCLUDED.suspended,
+			passkey_user_id = EXCLUDED.passkey_user_id,
+			passkeys = EXCLUDED.passkeys,
+			oauth_provider = EXCLUDED.oauth_provider,
+			oauth_subject = EXCLUDED.oauth_subject,
+			recovery_codes = EXCLUDED.recovery_codes,
+			password_changed_at = EXCLUDED.password_changed_at,
+			must_change_password = EXCLUDED.must_change_password,
+			password_history = EXCLUDED.password_history`,
+		args...,
+	)
+	if err != nil {
+		return postgresWriteError("save user", err)
+	}
+
+	return nil
+}
+
+// RenameUser updates the username in place, so the account is never under
+// both names or neither, whatever other instances do meanwhile.
+func (p *postgresUserRepository) RenameUser(ctx context.Context, username, newUsername string) error {
+	res, err := p.db.ExecContext(ctx, `UPDATE users SET username = $3 WHERE tenant = $1 AND username = $2`, TenantFromContext(ctx), username, newUsername)
+	if err != nil {
+		return postgresWriteError("rename user", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &RepositoryError{Op: "rename user", Err: err}
+	}
+
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+const postgresUserValues = `($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`
+
+// postgresUserArgs returns the values of postgresUserColumns for user.
+func postgresUserArgs(user UserFields) ([]interface{}, error) {
+	var passkeys string
+	if len(user.Passkeys) > 0 {
+		encoded, err := json.Marshal(user.Passkeys)
+		if err != nil {
+			return nil, fmt.Errorf("error while encoding passkeys: %w", err)
+		}
+
+		passkeys = string(encoded)
+	}
+
+	var history string
+	if len(user.PasswordHistory) > 0 {
+		encoded, err := json.Marshal(user.PasswordHistory)
+		if err != nil {
+			return nil, fmt.Errorf("error while encoding password history: %w", err)
+		}
+
+		history = string(encoded)
+	}
+
+	return []interface{}{
+		user.Username, user.Tenant, user.HashedPassword, user.Email, user.EmailVerified, strings.Join(user.Roles, postgresRoleSeparator),
+		user.TOTPSecret, user.TOTPEnabled, sql.NullTime{Time: user.CreatedAt, Valid: !user.CreatedAt.IsZero()},
+		sql.NullTime{Time: user.LastLoginAt, Valid: !user.LastLoginAt.IsZero()}, user.Suspended,
+		user.PasskeyUserID, passkeys, user.OAuthProvider, user.OAuthSubject, strings.Join(user.RecoveryCodeHashes, postgresRoleSeparator),
+		sql.NullTime{Time: user.PasswordChangedAt, Valid: !user.PasswordChangedAt.IsZero()}, user.MustChangePassword, history,
+	}, nil
+}
+
+// postgresWriteError maps a unique violation to ErrEmailAlreadyRegistered
+// or ErrUserAlreadyExists, depending on the index, and wraps anything else
+// in a RepositoryError.
+func postgresWriteError(op string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation {
+		if pgErr.ConstraintName == postgresEmailIndex {
+			return ErrEmailAlreadyRegistered
+		}
+
+		return ErrUserAlreadyExists
+	}
+
+	return &RepositoryError{Op: op, Err: err}
+}
+
+func (p *postgresUserRepository) DeleteUser(ctx context.Context, username string) error {
+	res, err := p.db.ExecContext(ctx, `DELETE FROM users WHERE tenant = $1 AND username = $2`, TenantFromContext(ctx), username)
+	if err != nil {
+		return &RepositoryError{Op: "delete user", Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &RepositoryError{Op: "delete user", Err: err}
+	}
+
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (p *postgresUserRepository) Ping(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return &RepositoryError{Op: "ping", Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build integration
+
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// openTestPostgres connects to the throwaway database named by
+// TEST_DATABASE_URL, e.g. one started with
+//
+//	docker run --rm -e POSTGRES_PASSWORD=test -p 5432:5432 postgres
+//
+// and drops the users table so every test starts from the migrations.
+func openTestPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`DROP TABLE IF EXISTS users`); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPostgresUserRepository(t *testing.T) {
+	repo, err := NewPostgresUserRepository(openTestPostgres(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testUserRepository(t, repo)
+}
+
+func TestPostgresMigrationsAreIdempotent(t *testing.T) {
+	db := openTestPostgres(t)
+
+	for range 2 {
+		if _, err := NewPostgresUserRepository(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPostgresConnectionFailureIsRepositoryError(t *testing.T) {
+	db := openTestPostgres(t)
+
+	repo, err := NewPostgresUserRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	_, err = repo.GetUser(context.Background(), "alice")
+
+	var repoErr *RepositoryError
+	if !errors.As(err, &repoErr) || errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUser() on a closed database error = %v, want a RepositoryError", err)
+	}
+}
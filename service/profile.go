@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Profile is the public view of a user. It deliberately leaves out the
+// password hash and TOTP secret kept in UserFields.
+type Profile struct {
+	Username         string    `json:"username"`
+	Email            string    `json:"email,omitempty"`
+	EmailVerified    bool      `json:"email_verified"`
+	Roles            []string  `json:"roles"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at,omitzero"`
+	LastLoginAt      time.Time `json:"last_login_at,omitzero"`
+}
+
+// GetProfile returns the profile of the user owning token. CreatedAt and
+// LastLoginAt are zero for accounts that predate them being recorded.
+func (u *userService) GetProfile(ctx context.Context, token string) (Profile, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return Profile{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	return Profile{
+		Username:         userFields.Username,
+		Email:            userFields.Email,
+		EmailVerified:    userFields.EmailVerified,
+		Roles:            append([]string(nil), userFields.Roles...),
+		TwoFactorEnabled: userFields.TOTPEnabled,
+		CreatedAt:        userFields.CreatedAt,
+		LastLoginAt:      userFields.LastLoginAt,
+	}, nil
+}
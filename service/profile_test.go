@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetProfile(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Minute))
+	mustRegister(t, svc, "alice", RoleUser, RoleAdmin)
+	session := mustLogin(t, svc, "alice")
+
+	profile, err := svc.GetProfile(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile.Username != "alice" || profile.Email != "alice@example.com" || len(profile.Roles) != 2 || !profile.CreatedAt.Equal(clock.Now()) {
+		t.Fatalf("GetProfile() = %+v", profile)
+	}
+
+	hash := mustGetUser(t, svc, "alice").HashedPassword
+
+	raw, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(raw), hash) || strings.Contains(fmt.Sprintf("%+v", profile), hash) {
+		t.Fatalf("profile %s carries the password hash", raw)
+	}
+
+	// Nor can a field added later carry it.
+	profileType := reflect.TypeFor[Profile]()
+	for i := range profileType.NumField() {
+		if name := strings.ToLower(profileType.Field(i).Name); strings.Contains(name, "password") || strings.Contains(name, "secret") {
+			t.Errorf("Profile has a %s field", profileType.Field(i).Name)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	if _, err := svc.GetProfile(context.Background(), session.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("GetProfile() with an expired token error = %v, want ErrTokenExpired", err)
+	}
+
+	revoked := mustLogin(t, svc, "alice")
+	if err := svc.RevokeAllSessions(context.Background(), mustLogin(t, svc, "alice").AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetProfile(context.Background(), revoked.AccessToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("GetProfile() of an ended session error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestLoginTimestamps(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock))
+	registeredAt := clock.Now()
+	mustRegister(t, svc, "alice")
+
+	clock.Advance(time.Hour)
+	first := mustLogin(t, svc, "alice")
+
+	profile, err := svc.GetProfile(context.Background(), first.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !profile.CreatedAt.Equal(registeredAt) || !profile.LastLoginAt.Equal(clock.Now()) {
+		t.Fatalf("after the first login CreatedAt, LastLoginAt = %s, %s", profile.CreatedAt, profile.LastLoginAt)
+	}
+
+	clock.Advance(time.Hour)
+	second := mustLogin(t, svc, "alice")
+
+	profile, err = svc.GetProfile(context.Background(), second.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !profile.CreatedAt.Equal(registeredAt) {
+		t.Errorf("CreatedAt moved to %s, want %s", profile.CreatedAt, registeredAt)
+	}
+
+	if !profile.LastLoginAt.Equal(clock.Now()) {
+		t.Errorf("LastLoginAt = %s, want the second login at %s", profile.LastLoginAt, clock.Now())
+	}
+
+	// A failed login is not a login.
+	clock.Advance(time.Hour)
+	if _, err := svc.Login(context.Background(), "alice", "wrong-passw0rd"); err == nil {
+		t.Fatal("Login() with a wrong password succeeded")
+	}
+
+	if got := mustGetUser(t, svc, "alice").LastLoginAt; got.Equal(clock.Now()) {
+		t.Error("a failed login updated LastLoginAt")
+	}
+}
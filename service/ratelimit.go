@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitPruneInterval is how many new buckets are created between sweeps
+// of idle ones, mirroring how loginAttempts bounds its map.
+const rateLimitPruneInterval = 1024
+
+// ErrRateLimited is returned by the rate limiting middleware when a client
+// IP has used up its allowance for a method.
+var ErrRateLimited = errors.New("too many requests, slow down")
+
+type clientIPContextKey struct{}
+
+// ContextWithClientIP records the address of the client making the request,
+// either bare or as host:port, for per-IP rate limiting. Addresses that do
+// not parse are ignored.
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	if ip = sanitizeIP(ip); ip == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, clientIPContextKey{}, ip)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey{}).(string)
+
+	return ip
+}
+
+// RateLimit is a token bucket refilling at Rate tokens per second up to
+// Burst. The zero value disables limiting.
+type RateLimit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// RateLimits configures the per-IP limit of each rate limited method. Login
+// covers Login, LoginWithOptions, LoginTOTP, LoginWithTTL,
+// LoginWithRecoveryCode, FinishPasskeyLogin and OAuthLogin, which share one
+// bucket. UsernameCheck covers IsUsernameAvailable, which would otherwise
+// let a client enumerate accounts. ResendVerification covers the method of
+// the same name; its per-account interval keeps any one mailbox from being
+// flooded, and this limit keeps one client from mailing many.
+type RateLimits struct {
+	Login              RateLimit
+	Register           RateLimit
+	UsernameCheck      RateLimit
+	ResendVerification RateLimit
+}
+
+// DefaultRateLimits allows bursts of 10 logins refilling one per second, 5
+// registrations refilling one per minute, 20 username checks refilling one
+// every 3 seconds, enough for a user trying names in a form, and 3
+// verification resends refilling one every 10 minutes.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Login:              RateLimit{Rate: rate.Every(time.Second), Burst: 10},
+		Register:           RateLimit{Rate: rate.Every(time.Minute), Burst: 5},
+		UsernameCheck:      RateLimit{Rate: rate.Every(3 * time.Second), Burst: 20},
+		ResendVerification: RateLimit{Rate: rate.Every(10 * time.Minute), Burst: 3},
+	}
+}
+
+type rateLimitBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	// refill is how long the bucket takes to fill up from empty.
+	refill time.Duration
+}
+
+func (b *rateLimitBucket) idle(now time.Time) bool {
+	return now.Sub(b.lastSeen) >= b.refill
+}
+
+// rateLimiter keeps one token bucket per method and client IP.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clock   Clock
+	buckets map[string]*rateLimitBucket
+	inserts int
+}
+
+// allow takes a token from the bucket of method and ip. A bucket idle long
+// enough to have refilled completely behaves exactly like a new one, so it
+// is safe to drop, which is what prune does.
+func (r *rateLimiter) allow(method, ip string, limit RateLimit) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	key := method + "|" + ip
+
+	bucket, ok := r.buckets[key]
+	if !ok || bucket.idle(now) {
+		bucket = &rateLimitBucket{
+			limiter:  rate.NewLimiter(limit.Rate, limit.Burst),
+			lastSeen: now,
+			refill:   time.Duration(float64(limit.Burst) / float64(limit.Rate) * float64(time.Second)),
+		}
+		r.buckets[key] = bucket
+
+		r.inserts++
+		if r.inserts%rateLimitPruneInterval == 0 {
+			r.prune(now)
+		}
+	}
+
+	bucket.lastSeen = now
+
+	return bucket.limiter.AllowN(now, 1)
+}
+
+func (r *rateLimiter) prune(now time.Time) {
+	for key, bucket := range r.buckets {
+		if bucket.idle(now) {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+type rateLimitMiddleware struct {
+	UserService
+
+	limits  RateLimits
+	limiter *rateLimiter
+}
+
+// NewRateLimitMiddleware throttles Login, Register, IsUsernameAvailable and
+// ResendVerification per client IP, as recorded by ContextWithClientIP,
+// failing with ErrRateLimited once an IP's bucket is empty. Requests without
+// a client IP are not limited. A nil clock uses the wall clock. Every other
+// method passes straight through.
+func NewRateLimitMiddleware(limits RateLimits, clock Clock) Middleware {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return func(next UserService) UserService {
+		return &rateLimitMiddleware{
+			UserService: next,
+			limits:      limits,
+			limiter: &rateLimiter{
+				clock:   clock,
+				buckets: make(map[string]*rateLimitBucket),
+			},
+		}
+	}
+}
+
+func (mw *rateLimitMiddleware) check(ctx context.Context, method string, limit RateLimit) error {
+	ip := clientIPFromContext(ctx)
+	if ip == "" || !limit.enabled() {
+		return nil
+	}
+
+	if !mw.limiter.allow(method, ip, limit) {
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+func (mw *rateLimitMiddleware) Register(ctx context.Context, user, pass, email string, roles ...string) (string, error) {
+	if err := mw.check(ctx, "Register", mw.limits.Register); err != nil {
+		return "", err
+	}
+
+	return mw.UserService.Register(ctx, user, pass, email, roles...)
+}
+
+func (mw *rateLimitMiddleware) RegisterWithInvite(ctx context.Context, user, pass, email, code string) (string, error) {
+	if err := mw.check(ctx, "Register", mw.limits.Register); err != nil {
+		return "", err
+	}
+
+	return mw.UserService.RegisterWithInvite(ctx, user, pass, email, code)
+}
+
+func (mw *rateLimitMiddleware) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	if err := mw.check(ctx, "UsernameCheck", mw.limits.UsernameCheck); err != nil {
+		return false, err
+	}
+
+	return mw.UserService.IsUsernameAvailable(ctx, username)
+}
+
+func (mw *rateLimitMiddleware) ResendVerification(ctx context.Context, usernameOrEmail string) error {
+	if err := mw.check(ctx, "ResendVerification", mw.limits.ResendVerification); err != nil {
+		return err
+	}
+
+	return mw.UserService.ResendVerification(ctx, usernameOrEmail)
+}
+
+func (mw *rateLimitMiddleware) Login(ctx context.Context, user, pass string) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.Login(ctx, user, pass)
+}
+
+func (mw *rateLimitMiddleware) LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.LoginWithOptions(ctx, user, pass, opts)
+}
+
+func (mw *rateLimitMiddleware) LoginTOTP(ctx context.Context, user, pass, code string) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.LoginTOTP(ctx, user, pass, code)
+}
+
+func (mw *rateLimitMiddleware) LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.LoginWithRecoveryCode(ctx, user, pass, code)
+}
+
+func (mw *rateLimitMiddleware) FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.FinishPasskeyLogin(ctx, username, credential)
+}
+
+func (mw *rateLimitMiddleware) OAuthLogin(ctx context.Context, provider, code string) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.OAuthLogin(ctx, provider, code)
+}
+
+func (mw *rateLimitMiddleware) LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (LoginResult, error) {
+	if err := mw.check(ctx, "Login", mw.limits.Login); err != nil {
+		return LoginResult{}, err
+	}
+
+	return mw.UserService.LoginWithTTL(ctx, user, pass, ttl)
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock))
+	mustRegister(t, svc, "alice")
+
+	limits := RateLimits{Login: RateLimit{Rate: rate.Every(time.Second), Burst: 2}}
+	limited := NewRateLimitMiddleware(limits, clock)(svc)
+
+	client := ContextWithClientIP(context.Background(), "192.0.2.1:4000")
+
+	for i := range 2 {
+		if _, err := limited.Login(client, "alice", testPassword); err != nil {
+			t.Fatalf("login %d error = %v", i+1, err)
+		}
+	}
+
+	if _, err := limited.Login(client, "alice", testPassword); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Login() past the burst error = %v, want ErrRateLimited", err)
+	}
+
+	// Other clients, requests without a client IP and methods without a
+	// limit are unaffected.
+	if _, err := limited.Login(ContextWithClientIP(context.Background(), "192.0.2.2"), "alice", testPassword); err != nil {
+		t.Fatalf("Login() from another IP error = %v", err)
+	}
+
+	if _, err := limited.Login(context.Background(), "alice", testPassword); err != nil {
+		t.Fatalf("Login() without a client IP error = %v", err)
+	}
+
+	if _, err := limited.Register(client, "bob", testPassword, "bob@example.com"); err != nil {
+		t.Fatalf("Register() without a limit error = %v", err)
+	}
+
+	clock.Advance(time.Second)
+
+	if _, err := limited.Login(client, "alice", testPassword); err != nil {
+		t.Fatalf("Login() after the refill interval error = %v", err)
+	}
+
+	if _, err := limited.Login(client, "alice", testPassword); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Login() past the refilled token error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestRateLimiterPrunesIdleBuckets(t *testing.T) {
+	clock := newFakeClock()
+	limiter := &rateLimiter{clock: clock, buckets: make(map[string]*rateLimitBucket)}
+	limit := RateLimit{Rate: rate.Every(time.Second), Burst: 1}
+
+	for i := range rateLimitPruneInterval - 1 {
+		limiter.allow("Login", fmt.Sprintf("192.0.2.%d", i), limit)
+	}
+
+	clock.Advance(time.Second)
+
+	// This insert triggers a prune of every bucket that refilled meanwhile.
+	limiter.allow("Login", "198.51.100.1", limit)
+
+	if n := len(limiter.buckets); n != 1 {
+		t.Fatalf("%d buckets left after pruning, want 1", n)
+	}
+}
+
+func TestRateLimitMiddlewareLimitsUsernameChecks(t *testing.T) {
+	clock := newFakeClock()
+	limits := RateLimits{UsernameCheck: RateLimit{Rate: rate.Every(time.Minute), Burst: 3}}
+	limited := NewRateLimitMiddleware(limits, clock)(newTestService(t, WithClock(clock)))
+
+	client := ContextWithClientIP(context.Background(), "192.0.2.1")
+
+	for i := range 3 {
+		if _, err := limited.IsUsernameAvailable(client, fmt.Sprintf("user%d", i)); err != nil {
+			t.Fatalf("check %d error = %v", i+1, err)
+		}
+	}
+
+	if _, err := limited.IsUsernameAvailable(client, "user3"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("IsUsernameAvailable() past the burst error = %v, want ErrRateLimited", err)
+	}
+}
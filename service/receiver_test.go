@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestNewUserServiceReturnsPointer(t *testing.T) {
+	svc, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	if _, ok := svc.(*userService); !ok {
+		t.Fatalf("NewUserService() returned %T, want *userService", svc)
+	}
+}
+
+// lockingService embeds a sync.Mutex the way userService holds one. Its
+// methods have pointer receivers, so go vet's copylocks check passes and
+// every copy of the UserService it is handed out as shares the one lock.
+type lockingService struct {
+	sync.Mutex
+	UserService
+	registered int
+}
+
+func (s *lockingService) Register(ctx context.Context, user, pass, email string, roles ...string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.registered++
+
+	return s.UserService.Register(ctx, user, pass, email, roles...)
+}
+
+// TestCopiesShareLock registers through copies of UserService values, the
+// service's own and one wrapping it, from many goroutines: every copy must
+// reach the same state.
+func TestCopiesShareLock(t *testing.T) {
+	locking := &lockingService{UserService: newTestService(t)}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		var svc UserService = locking
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := svc.Register(context.Background(), fmt.Sprintf("user%d", i), testPassword, fmt.Sprintf("user%d@example.com", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if locking.registered != 20 {
+		t.Fatalf("%d registrations counted, want 20", locking.registered)
+	}
+
+	for i := range 20 {
+		mustGetUser(t, locking.UserService.(*userService), fmt.Sprintf("user%d", i))
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// RecoveryCodeCount is how many codes GenerateRecoveryCodes issues.
+	RecoveryCodeCount = 10
+	// recoveryCodeBytes gives every code 40 bits of entropy, 8 base32
+	// characters shown in two groups of four.
+	recoveryCodeBytes = 5
+)
+
+var (
+	// ErrTOTPNotEnabled is returned by GenerateRecoveryCodes for accounts
+	// without confirmed two-factor authentication, which have nothing for
+	// the codes to stand in for.
+	ErrTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrInvalidRecoveryCode is returned when a recovery code is unknown or
+	// was already used.
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes issues RecoveryCodeCount single-use codes that stand
+// in for a two-factor code at login, replacing any issued before. Only their
+// hashes are kept, so the plaintext returned here cannot be shown again.
+func (u *userService) GenerateRecoveryCodes(ctx context.Context, token string) ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if !userFields.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("error while generating recovery code: %w", err)
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(code)
+	}
+
+	userFields.RecoveryCodeHashes = hashes
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return nil, fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return codes, nil
+}
+
+// LoginWithRecoveryCode is LoginTOTP for users who lost their authenticator:
+// code is one of the codes from GenerateRecoveryCodes, and is used up.
+func (u *userService) LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (LoginResult, error) {
+	return u.LoginWithOptions(ctx, user, pass, LoginOptions{RecoveryCode: code})
+}
+
+// consumeRecoveryCode removes code from the codes of userFields and saves
+// them, so it cannot be used again even if the login fails further on.
+// Codes are compared without their dash and case.
+func (u *userService) consumeRecoveryCode(ctx context.Context, userFields UserFields, code string) (UserFields, error) {
+	hash := hashToken(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+
+	match := -1
+	for i, stored := range userFields.RecoveryCodeHashes {
+		if SecureEqual(stored, hash) {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return userFields, ErrInvalidRecoveryCode
+	}
+
+	userFields.RecoveryCodeHashes = slices.Delete(slices.Clone(userFields.RecoveryCodeHashes), match, match+1)
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return userFields, fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return userFields, nil
+}
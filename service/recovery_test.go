@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if _, err := svc.GenerateRecoveryCodes(context.Background(), session.AccessToken); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Fatalf("GenerateRecoveryCodes() without TOTP error = %v, want ErrTOTPNotEnabled", err)
+	}
+
+	secret, _, err := svc.EnableTOTP(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ConfirmTOTP(context.Background(), session.AccessToken, mustTOTPCode(t, secret, clock.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := svc.GenerateRecoveryCodes(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	// Only hashes are stored.
+	stored := strings.Join(mustGetUser(t, svc, "alice").RecoveryCodeHashes, " ")
+	for _, code := range codes {
+		if strings.Contains(stored, strings.ReplaceAll(code, "-", "")) || strings.Contains(stored, code) {
+			t.Fatalf("recovery code %q is stored in plain text", code)
+		}
+	}
+
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", "wrong-passw0rd", codes[0]); err == nil {
+		t.Fatal("LoginWithRecoveryCode() with a wrong password succeeded")
+	}
+
+	// Codes are accepted in any case, and each one exactly once.
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", testPassword, strings.ToLower(codes[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", testPassword, codes[0]); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("LoginWithRecoveryCode() reusing a code error = %v, want ErrInvalidRecoveryCode", err)
+	}
+
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", testPassword, "AAAA-AAAA"); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("LoginWithRecoveryCode() with an unknown code error = %v, want ErrInvalidRecoveryCode", err)
+	}
+
+	// Regenerating invalidates the old set.
+	fresh, err := svc.GenerateRecoveryCodes(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", testPassword, codes[1]); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("LoginWithRecoveryCode() with a code of the old set error = %v, want ErrInvalidRecoveryCode", err)
+	}
+
+	if _, err := svc.LoginWithRecoveryCode(context.Background(), "alice", testPassword, fresh[1]); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisSessionPrefix     = "session:"
+	redisUserSessionPrefix = "user-sessions:"
+	// redisScanCount is the COUNT hint of the SCANs walking the per-user
+	// session sets.
+	redisScanCount = 500
+)
+
+type redisSessionStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisSessionStore returns a SessionStore that keeps sessions in Redis so
+// every service instance sharing client sees the same auth state.
+func NewRedisSessionStore(client redis.UniversalClient) SessionStore {
+	return &redisSessionStore{client: client}
+}
+
+// Sessions are stored as JSON under session:<id>.
+func (r *redisSessionStore) Get(ctx context.Context, sessionID string) (Session, error) {
+	raw, err := r.client.Get(ctx, redisSessionPrefix+sessionID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("error while reading session from redis: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return Session{}, fmt.Errorf("error while decoding session from redis: %w", err)
+	}
+
+	return session, nil
+}
+
+// Set also records the session ID in a per-user set so DeleteUserSessions
+// and ListUserSessions do not have to scan the keyspace. The set's expiry is
+// only ever pushed forward, so it outlives every session it indexes.
+func (r *redisSessionStore) Set(ctx context.Context, session Session, ttl time.Duration) error {
+	userKey := redisUserSessionPrefix + tenantKey(session.Tenant, session.Username)
+
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("error while encoding session: %w", err)
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisSessionPrefix+session.ID, raw, ttl)
+		pipe.SAdd(ctx, userKey, session.ID)
+
+		if ttl > 0 {
+			pipe.ExpireNX(ctx, userKey, ttl)
+			pipe.ExpireGT(ctx, userKey, ttl)
+		} else {
+			pipe.Persist(ctx, userKey)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while writing session to redis: %w", err)
+	}
+
+	return nil
+}
+
+// Touch only changes the expiry with EXPIRE or PERSIST, both of which leave
+// a missing key missing.
+func (r *redisSessionStore) Touch(ctx context.Context, session Session, ttl time.Duration) error {
+	sessionKey := redisSessionPrefix + session.ID
+	userKey := redisUserSessionPrefix + tenantKey(session.Tenant, session.Username)
+
+	var exists *redis.IntCmd
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, sessionKey)
+
+		if ttl > 0 {
+			pipe.Expire(ctx, sessionKey, ttl)
+			pipe.ExpireGT(ctx, userKey, ttl)
+		} else {
+			pipe.Persist(ctx, sessionKey)
+			pipe.Persist(ctx, userKey)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while extending session in redis: %w", err)
+	}
+
+	if exists.Val() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (r *redisSessionStore) Delete(ctx context.Context, sessionID string) error {
+	session, err := r.Get(ctx, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisSessionPrefix+sessionID)
+		pipe.SRem(ctx, redisUserSessionPrefix+tenantKey(session.Tenant, session.Username), sessionID)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while deleting session from redis: %w", err)
+	}
+
+	return nil
+}
+
+func (r *redisSessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	userKey := redisUserSessionPrefix + tenantKey(TenantFromContext(ctx), username)
+
+	sessionIDs, err := r.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error while listing user sessions from redis: %w", err)
+	}
+
+	keys := make([]string, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, redisSessionPrefix+sessionID)
+	}
+
+	var deleted *redis.IntCmd
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(keys) > 0 {
+			deleted = pipe.Del(ctx, keys...)
+		}
+		pipe.Del(ctx, userKey)
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting user sessions from redis: %w", err)
+	}
+
+	if deleted == nil {
+		return 0, nil
+	}
+
+	return int(deleted.Val()), nil
+}
+
+// ListUserSessions also drops IDs of sessions that already expired from the
+// per-user set.
+func (r *redisSessionStore) ListUserSessions(ctx context.Context, username string) ([]Session, error) {
+	sessions, err := r.userSessions(ctx, redisUserSessionPrefix+tenantKey(TenantFromContext(ctx), username))
+	if err != nil {
+		return nil, err
+	}
+
+	sortSessions(sessions)
+
+	return sessions, nil
+}
+
+// SessionStats walks the per-user session sets of the tenant rather than
+// every session key, reading each set with the same two round trips as
+// ListUserSessions.
+func (r *redisSessionStore) SessionStats(ctx context.Context, since time.Time) (SessionStats, error) {
+	tenant := TenantFromContext(ctx)
+
+	pattern := redisUserSessionPrefix + "*"
+	if tenant != "" {
+		pattern = redisUserSessionPrefix + tenant + "/*"
+	}
+
+	stats := SessionStats{Since: since}
+	iter := r.client.Scan(ctx, 0, pattern, redisScanCount).Iterator()
+
+	for iter.Next(ctx) {
+		userKey := iter.Val()
+
+		// Keys of other tenants match the default tenant's pattern too.
+		if tenant == "" && strings.Contains(strings.TrimPrefix(userKey, redisUserSessionPrefix), "/") {
+			continue
+		}
+
+		sessions, err := r.userSessions(ctx, userKey)
+		if err != nil {
+			return SessionStats{}, err
+		}
+
+		if len(sessions) == 0 {
+			continue
+		}
+
+		stats.Sessions += len(sessions)
+		stats.Users++
+
+		for _, session := range sessions {
+			if session.CreatedAt.After(since) {
+				stats.CreatedSince++
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return SessionStats{}, fmt.Errorf("error while scanning user sessions in redis: %w", err)
+	}
+
+	return stats, nil
+}
+
+// userSessions reads the sessions indexed by the per-user set userKey,
+// dropping the IDs of sessions that already expired from it.
+func (r *redisSessionStore) userSessions(ctx context.Context, userKey string) ([]Session, error) {
+	sessionIDs, err := r.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error while listing user sessions from redis: %w", err)
+	}
+
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, redisSessionPrefix+sessionID)
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading user sessions from redis: %w", err)
+	}
+
+	var (
+		sessions []Session
+		expired  []interface{}
+	)
+
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			expired = append(expired, sessionIDs[i])
+
+			continue
+		}
+
+		var session Session
+		if err := json.Unmarshal([]byte(raw), &session); err != nil {
+			return nil, fmt.Errorf("error while decoding session from redis: %w", err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if len(expired) > 0 {
+		if err := r.client.SRem(ctx, userKey, expired...).Err(); err != nil {
+			return nil, fmt.Errorf("error while pruning user sessions from redis: %w", err)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (r *redisSessionStore) Ping(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error while pinging redis: %w", err)
+	}
+
+	return nil
+}
+
+const redisRefreshPrefix = "refresh:"
+
+type redisRefreshTokenStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisRefreshTokenStore returns a RefreshTokenStore kept in Redis.
+func NewRedisRefreshTokenStore(client redis.UniversalClient) RefreshTokenStore {
+	return &redisRefreshTokenStore{client: client}
+}
+
+func (r *redisRefreshTokenStore) Get(ctx context.Context, sessionID string) (string, error) {
+	tokenHash, err := r.client.Get(ctx, redisRefreshPrefix+sessionID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while reading refresh token from redis: %w", err)
+	}
+
+	return tokenHash, nil
+}
+
+func (r *redisRefreshTokenStore) Set(ctx context.Context, sessionID, tokenHash string, ttl time.Duration) error {
+	if err := r.client.Set(ctx, redisRefreshPrefix+sessionID, tokenHash, ttl).Err(); err != nil {
+		return fmt.Errorf("error while writing refresh token to redis: %w", err)
+	}
+
+	return nil
+}
+
+func (r *redisRefreshTokenStore) Delete(ctx context.Context, sessionID string) error {
+	if err := r.client.Del(ctx, redisRefreshPrefix+sessionID).Err(); err != nil {
+		return fmt.Errorf("error while deleting refresh token from redis: %w", err)
+	}
+
+	return nil
+}
+
+const redisOneTimeTokenPrefix = "one-time-token:"
+
+type redisOneTimeTokenStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisOneTimeTokenStore returns a OneTimeTokenStore kept in Redis. Take
+// relies on GETDEL, so it needs Redis 6.2 or newer.
+func NewRedisOneTimeTokenStore(client redis.UniversalClient) OneTimeTokenStore {
+	return &redisOneTimeTokenStore{client: client}
+}
+
+func (r *redisOneTimeTokenStore) Put(ctx context.Context, key, subject string, ttl time.Duration) error {
+	if err := r.client.Set(ctx, redisOneTimeTokenPrefix+key, subject, ttl).Err(); err != nil {
+		return fmt.Errorf("error while writing one-time token to redis: %w", err)
+	}
+
+	return nil
+}
+
+func (r *redisOneTimeTokenStore) Take(ctx context.Context, key string) (string, error) {
+	subject, err := r.client.GetDel(ctx, redisOneTimeTokenPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrOneTimeTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while reading one-time token from redis: %w", err)
+	}
+
+	return subject, nil
+}
+
+const redisDenylistPrefix = "denylist:"
+
+type redisDenylist struct {
+	client redis.UniversalClient
+}
+
+// NewRedisDenylist returns a Denylist kept in Redis, whose own key expiry
+// drops entries once their token expired.
+func NewRedisDenylist(client redis.UniversalClient) Denylist {
+	return &redisDenylist{client: client}
+}
+
+func (r *redisDenylist) Add(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := r.client.Set(ctx, redisDenylistPrefix+jti, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("error while writing denylist entry to redis: %w", err)
+	}
+
+	return nil
+}
+
+func (r *redisDenylist) Contains(ctx context.Context, jti string) (bool, error) {
+	n, err := r.client.Exists(ctx, redisDenylistPrefix+jti).Result()
+	if err != nil {
+		return false, fmt.Errorf("error while reading denylist entry from redis: %w", err)
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// openTestRedis starts an in-process Redis and returns a client for it,
+// along with the server so tests can fast-forward its TTL clock.
+func openTestRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return client, server
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	client, server := openTestRedis(t)
+	testSessionStore(t, NewRedisSessionStore(client), server.FastForward)
+}
+
+func TestRedisRefreshTokenStore(t *testing.T) {
+	client, _ := openTestRedis(t)
+	testRefreshTokenStore(t, NewRedisRefreshTokenStore(client))
+}
+
+func TestRedisDenylist(t *testing.T) {
+	client, server := openTestRedis(t)
+	testDenylist(t, NewRedisDenylist(client), server.FastForward)
+}
+
+// unreachableRedis returns a client for a port nothing listens on.
+func unreachableRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// TestRedisSessionStoreUnreachable checks a Redis outage is an error of its
+// own rather than a missing session, which would log users out.
+func TestRedisSessionStoreUnreachable(t *testing.T) {
+	store := NewRedisSessionStore(unreachableRedis(t))
+
+	_, err := store.Get(context.Background(), "s1")
+	if err == nil || errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Get() error = %v, want a connection error", err)
+	}
+
+	if err := store.Set(context.Background(), Session{ID: "s1", Username: "alice"}, time.Minute); err == nil {
+		t.Fatal("Set() succeeded without Redis")
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultRefreshTokenTTL = 24 * time.Hour
+
+// ErrRefreshTokenNotFound is returned by a RefreshTokenStore when no refresh
+// token is registered for the session, either because it expired or because
+// it was revoked on logout.
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// RefreshTokenStore keeps the hash of the refresh token issued for each
+// session, apart from the SessionStore, so refresh tokens can be revoked
+// independently of the access session.
+type RefreshTokenStore interface {
+	Get(ctx context.Context, sessionID string) (string, error)
+	Set(ctx context.Context, sessionID, tokenHash string, ttl time.Duration) error
+	Delete(ctx context.Context, sessionID string) error
+}
+
+type memoryRefreshToken struct {
+	tokenHash string
+	expiresAt time.Time
+}
+
+type memoryRefreshTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]memoryRefreshToken
+	clock  Clock
+}
+
+func NewMemoryRefreshTokenStore() RefreshTokenStore {
+	return &memoryRefreshTokenStore{
+		tokens: make(map[string]memoryRefreshToken),
+		clock:  realClock{},
+	}
+}
+
+func (m *memoryRefreshTokenStore) setClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = clock
+}
+
+func (m *memoryRefreshTokenStore) Get(ctx context.Context, sessionID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.tokens[sessionID]
+	if !ok || (!token.expiresAt.IsZero() && m.clock.Now().After(token.expiresAt)) {
+		return "", ErrRefreshTokenNotFound
+	}
+
+	return token.tokenHash, nil
+}
+
+func (m *memoryRefreshTokenStore) Set(ctx context.Context, sessionID, tokenHash string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token := memoryRefreshToken{tokenHash: tokenHash}
+	if ttl > 0 {
+		token.expiresAt = m.clock.Now().Add(ttl)
+	}
+
+	m.tokens[sessionID] = token
+
+	return nil
+}
+
+func (m *memoryRefreshTokenStore) Delete(ctx context.Context, sessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tokens, sessionID)
+
+	return nil
+}
+
+// hashToken is what gets persisted instead of the refresh token itself, so a
+// leaked store does not hand out usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
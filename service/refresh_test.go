@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithRefreshTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+
+	t.Run("valid", func(t *testing.T) {
+		session := mustLogin(t, svc, "alice")
+
+		token, err := svc.Refresh(context.Background(), session.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state, err := svc.GetHomeState(context.Background(), token)
+		if err != nil || state.Username != "alice" {
+			t.Fatalf("GetHomeState() with the refreshed token = %+v, %v", state, err)
+		}
+
+		// The refresh token is not rotated: it keeps working.
+		if _, err := svc.Refresh(context.Background(), session.RefreshToken); err != nil {
+			t.Fatalf("second Refresh() error = %v", err)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		session := mustLogin(t, svc, "alice")
+		if err := svc.Logout(context.Background(), session.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := svc.Refresh(context.Background(), session.RefreshToken); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Fatalf("Refresh() after Logout error = %v, want ErrRefreshTokenNotFound", err)
+		}
+	})
+
+	t.Run("access token", func(t *testing.T) {
+		session := mustLogin(t, svc, "alice")
+		if _, err := svc.Refresh(context.Background(), session.AccessToken); !errors.Is(err, ErrTokenInvalid) {
+			t.Fatalf("Refresh() with an access token error = %v, want ErrTokenInvalid", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		session := mustLogin(t, svc, "alice")
+		clock.Advance(time.Hour + time.Second)
+
+		if _, err := svc.Refresh(context.Background(), session.RefreshToken); !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("Refresh() error = %v, want ErrTokenExpired", err)
+		}
+	})
+}
+
+// testRefreshTokenStore runs the RefreshTokenStore contract against store,
+// which must start empty.
+func testRefreshTokenStore(t *testing.T, store RefreshTokenStore) {
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "s1"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("Get() error = %v, want ErrRefreshTokenNotFound", err)
+	}
+
+	if err := store.Set(ctx, "s1", "hash", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if hash, err := store.Get(ctx, "s1"); err != nil || hash != "hash" {
+		t.Fatalf("Get() = %q, %v, want hash", hash, err)
+	}
+
+	if err := store.Delete(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(ctx, "s1"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("Get() after Delete error = %v, want ErrRefreshTokenNotFound", err)
+	}
+}
+
+func TestMemoryRefreshTokenStore(t *testing.T) {
+	testRefreshTokenStore(t, NewMemoryRefreshTokenStore())
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUserNotFound is returned by a UserRepository when the requested user
+// does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// RepositoryError wraps a failure of the underlying storage, as opposed to a
+// missing record, so callers can tell "not found" apart from "unavailable".
+type RepositoryError struct {
+	Op  string
+	Err error
+}
+
+func (e *RepositoryError) Error() string {
+	return fmt.Sprintf("repository error during %s: %v", e.Op, e.Err)
+}
+
+func (e *RepositoryError) Unwrap() error {
+	return e.Err
+}
+
+// ListUsernames returns up to limit usernames sorted ascending, skipping the
+// first offset, along with the total number of users.
+//
+// Users are keyed by tenant and username: every lookup, listing, deletion
+// and rename only sees the tenant of ctx (see ContextWithTenant), while
+// CreateUser and SaveUser store the user under its own Tenant field.
+//
+// CreateUser only inserts: it fails with ErrUserAlreadyExists when the
+// username is taken, even by a write of another instance, where SaveUser
+// would overwrite it. Emails are unique per tenant too, ignoring case: both
+// fail with ErrEmailAlreadyRegistered for an email of another user.
+// RenameUser moves username to newUsername in one step, failing with
+// ErrUserAlreadyExists when newUsername is taken.
+type UserRepository interface {
+	GetUser(ctx context.Context, username string) (UserFields, error)
+	GetUserByEmail(ctx context.Context, email string) (UserFields, error)
+	ListUsernames(ctx context.Context, offset, limit int) ([]string, int, error)
+	CreateUser(ctx context.Context, user UserFields) error
+	SaveUser(ctx context.Context, user UserFields) error
+	RenameUser(ctx context.Context, username, newUsername string) error
+	DeleteUser(ctx context.Context, username string) error
+	Ping(ctx context.Context) error
+}
+
+type memoryUserKey struct {
+	tenant   string
+	username string
+}
+
+// memoryEmailKey is an email of a tenant, lowercased.
+type memoryEmailKey struct {
+	tenant string
+	email  string
+}
+
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[memoryUserKey]UserFields
+	// emails indexes the username holding each email, so GetUserByEmail
+	// does not have to scan every user.
+	emails map[memoryEmailKey]string
+}
+
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		users:  make(map[memoryUserKey]UserFields),
+		emails: make(map[memoryEmailKey]string),
+	}
+}
+
+func (m *memoryUserRepository) GetUser(ctx context.Context, username string) (UserFields, error) {
+	if err := ctx.Err(); err != nil {
+		return UserFields{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[memoryUserKey{tenant: TenantFromContext(ctx), username: username}]
+	if !ok {
+		return UserFields{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail matches email case-insensitively.
+func (m *memoryUserRepository) GetUserByEmail(ctx context.Context, email string) (UserFields, error) {
+	if err := ctx.Err(); err != nil {
+		return UserFields{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant := TenantFromContext(ctx)
+
+	username, ok := m.emails[memoryEmailKey{tenant: tenant, email: strings.ToLower(email)}]
+	if !ok {
+		return UserFields{}, ErrUserNotFound
+	}
+
+	return m.users[memoryUserKey{tenant: tenant, username: username}], nil
+}
+
+func (m *memoryUserRepository) ListUsernames(ctx context.Context, offset, limit int) ([]string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant := TenantFromContext(ctx)
+
+	usernames := make([]string, 0, len(m.users))
+	for key := range m.users {
+		if key.tenant == tenant {
+			usernames = append(usernames, key.username)
+		}
+	}
+
+	sort.Strings(usernames)
+
+	total := len(usernames)
+	if offset >= total {
+		return []string{}, total, nil
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	return usernames[offset:end], total, nil
+}
+
+func (m *memoryUserRepository) CreateUser(ctx context.Context, user UserFields) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryUserKey{tenant: user.Tenant, username: user.Username}
+	if _, ok := m.users[key]; ok {
+		return ErrUserAlreadyExists
+	}
+
+	if m.emailTaken(user) {
+		return ErrEmailAlreadyRegistered
+	}
+
+	m.users[key] = user
+	m.indexEmail(user)
+
+	return nil
+}
+
+func (m *memoryUserRepository) SaveUser(ctx context.Context, user UserFields) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user) {
+		return ErrEmailAlreadyRegistered
+	}
+
+	key := memoryUserKey{tenant: user.Tenant, username: user.Username}
+	if previous, ok := m.users[key]; ok {
+		m.unindexEmail(previous)
+	}
+
+	m.users[key] = user
+	m.indexEmail(user)
+
+	return nil
+}
+
+func (m *memoryUserRepository) RenameUser(ctx context.Context, username, newUsername string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant := TenantFromContext(ctx)
+
+	key := memoryUserKey{tenant: tenant, username: username}
+	user, ok := m.users[key]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	newKey := memoryUserKey{tenant: tenant, username: newUsername}
+	if _, ok := m.users[newKey]; ok {
+		return ErrUserAlreadyExists
+	}
+
+	m.unindexEmail(user)
+	delete(m.users, key)
+
+	user.Username = newUsername
+	m.users[newKey] = user
+	m.indexEmail(user)
+
+	return nil
+}
+
+func (m *memoryUserRepository) DeleteUser(ctx context.Context, username string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryUserKey{tenant: TenantFromContext(ctx), username: username}
+	if _, ok := m.users[key]; !ok {
+		return ErrUserNotFound
+	}
+
+	m.unindexEmail(m.users[key])
+	delete(m.users, key)
+
+	return nil
+}
+
+// emailTaken reports whether another user of the tenant of user holds its
+// email. The caller holds the lock.
+func (m *memoryUserRepository) emailTaken(user UserFields) bool {
+	if user.Email == "" {
+		return false
+	}
+
+	holder, ok := m.emails[memoryEmailKey{tenant: user.Tenant, email: strings.ToLower(user.Email)}]
+
+	return ok && holder != user.Username
+}
+
+// indexEmail and unindexEmail keep the emails index in step with users.
+// The caller holds the write lock.
+func (m *memoryUserRepository) indexEmail(user UserFields) {
+	if user.Email == "" {
+		return
+	}
+
+	m.emails[memoryEmailKey{tenant: user.Tenant, email: strings.ToLower(user.Email)}] = user.Username
+}
+
+func (m *memoryUserRepository) unindexEmail(user UserFields) {
+	key := memoryEmailKey{tenant: user.Tenant, email: strings.ToLower(user.Email)}
+	if m.emails[key] == user.Username {
+		delete(m.emails, key)
+	}
+}
+
+func (m *memoryUserRepository) Ping(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// testUserRepository runs the UserRepository contract against repo, which
+// must start empty.
+func testUserRepository(t *testing.T, repo UserRepository) {
+	ctx := context.Background()
+
+	t.Run("GetUser unknown", func(t *testing.T) {
+		if _, err := repo.GetUser(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUser() error = %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("CreateUser", func(t *testing.T) {
+		if err := repo.CreateUser(ctx, UserFields{Username: "alice", HashedPassword: "first", Email: "alice@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := repo.CreateUser(ctx, UserFields{Username: "alice", HashedPassword: "second", Email: "other@example.com"})
+		if !errors.Is(err, ErrUserAlreadyExists) {
+			t.Fatalf("CreateUser() of a taken username error = %v, want ErrUserAlreadyExists", err)
+		}
+
+		user, err := repo.GetUser(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.HashedPassword != "first" {
+			t.Fatalf("HashedPassword = %q, the second CreateUser overwrote the account", user.HashedPassword)
+		}
+	})
+
+	t.Run("SaveUser updates", func(t *testing.T) {
+		user, err := repo.GetUser(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user.Roles = []string{RoleUser, RoleAdmin}
+		if err := repo.SaveUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+
+		user, err = repo.GetUser(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(user.Roles) != 2 {
+			t.Fatalf("Roles = %v, want the saved ones", user.Roles)
+		}
+	})
+
+	t.Run("GetUserByEmail", func(t *testing.T) {
+		user, err := repo.GetUserByEmail(ctx, "ALICE@example.com")
+		if err != nil || user.Username != "alice" {
+			t.Fatalf("GetUserByEmail() = %q, %v, want alice", user.Username, err)
+		}
+	})
+
+	t.Run("unique emails", func(t *testing.T) {
+		err := repo.CreateUser(ctx, UserFields{Username: "mallory", HashedPassword: "x", Email: "Alice@Example.com"})
+		if !errors.Is(err, ErrEmailAlreadyRegistered) {
+			t.Fatalf("CreateUser() with a taken email error = %v, want ErrEmailAlreadyRegistered", err)
+		}
+
+		if err := repo.CreateUser(ctx, UserFields{Username: "mallory", HashedPassword: "x", Email: "mallory@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+
+		mallory, err := repo.GetUser(ctx, "mallory")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mallory.Email = "alice@example.com"
+		if err := repo.SaveUser(ctx, mallory); !errors.Is(err, ErrEmailAlreadyRegistered) {
+			t.Fatalf("SaveUser() with a taken email error = %v, want ErrEmailAlreadyRegistered", err)
+		}
+
+		user, err := repo.GetUserByEmail(ctx, "alice@example.com")
+		if err != nil || user.Username != "alice" {
+			t.Fatalf("GetUserByEmail() = %q, %v, want alice", user.Username, err)
+		}
+
+		if err := repo.DeleteUser(ctx, "mallory"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("RenameUser", func(t *testing.T) {
+		if err := repo.CreateUser(ctx, UserFields{Username: "bob", HashedPassword: "bob", Email: "bob@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.RenameUser(ctx, "alice", "bob"); !errors.Is(err, ErrUserAlreadyExists) {
+			t.Fatalf("RenameUser() onto a taken username error = %v, want ErrUserAlreadyExists", err)
+		}
+
+		if err := repo.RenameUser(ctx, "nobody", "carol"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("RenameUser() of an unknown user error = %v, want ErrUserNotFound", err)
+		}
+
+		if err := repo.RenameUser(ctx, "alice", "alicia"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.GetUser(ctx, "alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUser() of the old name error = %v, want ErrUserNotFound", err)
+		}
+
+		user, err := repo.GetUser(ctx, "alicia")
+		if err != nil || user.HashedPassword != "first" {
+			t.Fatalf("GetUser() of the new name = %+v, %v", user, err)
+		}
+
+		user, err = repo.GetUserByEmail(ctx, "alice@example.com")
+		if err != nil || user.Username != "alicia" {
+			t.Fatalf("GetUserByEmail() after rename = %q, %v, want alicia", user.Username, err)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		other := ContextWithTenant(ctx, "other")
+
+		if _, err := repo.GetUser(other, "alicia"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("GetUser() in another tenant error = %v, want ErrUserNotFound", err)
+		}
+
+		if err := repo.CreateUser(ctx, UserFields{Username: "alicia", Tenant: "other", HashedPassword: "x"}); err != nil {
+			t.Fatalf("CreateUser() of the same name in another tenant error = %v", err)
+		}
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		if err := repo.DeleteUser(ctx, "bob"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.DeleteUser(ctx, "bob"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("DeleteUser() twice error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestMemoryUserRepository(t *testing.T) {
+	testUserRepository(t, NewMemoryUserRepository())
+}
+
+// TestRegisterRaceAcrossInstances registers the same username through two
+// services sharing a repository, as two replicas would: only one may win,
+// and the loser must not overwrite the winner's password.
+func TestRegisterRaceAcrossInstances(t *testing.T) {
+	users := NewMemoryUserRepository()
+	first := newTestServiceWithRepository(t, users)
+	second := newTestServiceWithRepository(t, users)
+
+	errs := make(chan error, 2)
+	for i, svc := range []UserService{first, second} {
+		go func() {
+			_, err := svc.Register(context.Background(), "alice", testPassword+string(rune('a'+i)), "alice@example.com")
+			errs <- err
+		}()
+	}
+
+	var failed int
+	for range 2 {
+		if err := <-errs; err != nil {
+			if !errors.Is(err, ErrUserAlreadyExists) {
+				t.Fatalf("Register() error = %v, want ErrUserAlreadyExists", err)
+			}
+
+			failed++
+		}
+	}
+
+	if failed != 1 {
+		t.Fatalf("%d registrations failed, want exactly 1", 2-failed)
+	}
+
+	// Whichever won, its password must still work.
+	var loggedIn int
+	for _, pass := range []string{testPassword + "a", testPassword + "b"} {
+		if _, err := first.Login(context.Background(), "alice", pass); err == nil {
+			loggedIn++
+		}
+	}
+
+	if loggedIn != 1 {
+		t.Fatalf("%d passwords log in, want exactly 1", loggedIn)
+	}
+}
+
+func TestRenameUsernameOntoNameTakenByAnotherInstance(t *testing.T) {
+	users := NewMemoryUserRepository()
+	first := newTestServiceWithRepository(t, users)
+	second := newTestServiceWithRepository(t, users)
+
+	mustRegister(t, first, "alice")
+	session := mustLogin(t, first, "alice")
+
+	// bob is registered through the other instance, behind the back of the
+	// first one.
+	mustRegister(t, second, "bob")
+
+	if err := first.RenameUsername(context.Background(), session.AccessToken, "bob"); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("RenameUsername() error = %v, want ErrUserAlreadyExists", err)
+	}
+
+	if _, err := first.Login(context.Background(), "alice", testPassword); err != nil {
+		t.Fatalf("Login() as alice after the failed rename: %v", err)
+	}
+
+	if _, err := second.Login(context.Background(), "bob", testPassword); err != nil {
+		t.Fatalf("Login() as bob after the failed rename: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength bounds request IDs taken from clients, which end up in
+// every log line of the request.
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// ContextWithRequestID records the ID correlating every log line of a
+// request. id usually comes from an X-Request-ID header; when it is empty,
+// too long, or holds anything but printable ASCII, a new random ID is used
+// instead so clients cannot forge log lines through it.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	if !validRequestID(id) {
+		id = uuid.New().String()
+	}
+
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the ID recorded by ContextWithRequestID, or
+// an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPasswordResetTokenTTL = 15 * time.Minute
+
+	passwordResetTokenPurpose = "password-reset"
+)
+
+// RequestPasswordReset mints a single-use token that lets the owner of
+// usernameOrEmail choose a new password through ResetPassword. To avoid
+// revealing which accounts exist it answers the same way for unknown users,
+// returning a token that simply cannot be redeemed.
+//
+// The token is mailed to the account's address through the Mailer. It
+// grants control over the account, so it must never be handed back to
+// whoever asked for the reset.
+func (u *userService) RequestPasswordReset(ctx context.Context, usernameOrEmail string) (string, error) {
+	token, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	userFields, err := u.savePasswordResetToken(ctx, usernameOrEmail, token)
+	if errors.Is(err, ErrUserNotFound) {
+		return token, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if userFields.Email != "" {
+		subject, body := passwordResetMail(userFields.Username, token, u.resetTTL)
+		if err := u.sendMail(ctx, userFields.Email, subject, body); err != nil {
+			return "", err
+		}
+	}
+
+	return token, nil
+}
+
+// savePasswordResetToken makes token redeemable for the owner of
+// usernameOrEmail, whom it returns.
+func (u *userService) savePasswordResetToken(ctx context.Context, usernameOrEmail, token string) (UserFields, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	userFields, err := u.findUser(ctx, usernameOrEmail)
+	if errors.Is(err, ErrUserNotFound) {
+		return UserFields{}, err
+	}
+
+	if err != nil {
+		return UserFields{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if err := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, passwordResetTokenPurpose, token), userFields.Username, u.resetTTL); err != nil {
+		return UserFields{}, fmt.Errorf("error while saving reset token: %w", err)
+	}
+
+	return userFields, nil
+}
+
+// ResetPassword redeems a token from RequestPasswordReset, sets newPass as
+// the user's password and logs the user out of every session.
+func (u *userService) ResetPassword(ctx context.Context, resetToken, newPass string) error {
+	if err := u.passwordPolicy.Validate(newPass); err != nil {
+		return err
+	}
+
+	if err := u.checkBreached(ctx, newPass); err != nil {
+		return err
+	}
+
+	u.mu.RLock()
+	userFields, err := u.takeResetToken(ctx, resetToken)
+	u.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	username := userFields.Username
+
+	if err := u.checkPasswordReuse(ctx, userFields, newPass); err != nil {
+		// Give the token back so the user can pick another password.
+		if putErr := u.oneTimeTokens.Put(ctx, oneTimeTokenKey(ctx, passwordResetTokenPurpose, resetToken), username, u.resetTTL); putErr != nil {
+			return fmt.Errorf("error while restoring reset token: %w", putErr)
+		}
+
+		return err
+	}
+
+	hashedPass, err := u.hashValue(ctx, newPass)
+	if err != nil {
+		return fmt.Errorf("error while hashing pass: %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Read again under the lock so changes made while hashing are kept.
+	userFields, err = u.users.GetUser(ctx, username)
+	if err != nil {
+		return fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	u.setPassword(&userFields, hashedPass, u.clock.Now())
+	userFields.MustChangePassword = false
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	u.audit(ctx, AuditPasswordChange, username)
+
+	if _, err := u.revokeUserSessions(ctx, username); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// takeResetToken redeems resetToken and returns the account it was issued
+// for.
+func (u *userService) takeResetToken(ctx context.Context, resetToken string) (UserFields, error) {
+	username, err := u.oneTimeTokens.Take(ctx, oneTimeTokenKey(ctx, passwordResetTokenPurpose, resetToken))
+	if err != nil {
+		return UserFields{}, fmt.Errorf("invalid reset token: %w", err)
+	}
+
+	userFields, err := u.users.GetUser(ctx, username)
+	if err != nil {
+		return UserFields{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	return userFields, nil
+}
+
+// findUser looks usernameOrEmail up as an email when it contains an @ and
+// as a username otherwise. Usernames cannot contain an @, so the two never
+// collide. Emails match case-insensitively.
+func (u *userService) findUser(ctx context.Context, usernameOrEmail string) (UserFields, error) {
+	if strings.Contains(usernameOrEmail, "@") {
+		return u.users.GetUserByEmail(ctx, normalizeLogin(usernameOrEmail))
+	}
+
+	return u.users.GetUser(ctx, normalizeLogin(usernameOrEmail))
+}
+
+// normalizeLogin normalizes usernameOrEmail the way findUser reads it.
+func normalizeLogin(usernameOrEmail string) string {
+	if strings.Contains(usernameOrEmail, "@") {
+		return normalizeEmail(usernameOrEmail)
+	}
+
+	return normalizeUsername(usernameOrEmail)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResetPassword(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	token, err := svc.RequestPasswordReset(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, "weak"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("ResetPassword() with a weak password error = %v, want ErrWeakPassword", err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, "an0ther-password"); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("ResetPassword() with a used token error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", "n3w-password"); err != nil {
+		t.Fatalf("Login() with the new password error = %v", err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token issued before the reset succeeded")
+	}
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithPasswordResetTokenTTL(time.Minute))
+	mustRegister(t, svc, "alice")
+
+	token, err := svc.RequestPasswordReset(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute + time.Second)
+
+	if err := svc.ResetPassword(context.Background(), token, "n3w-password"); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("ResetPassword() with an expired token error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+}
+
+// TestRequestPasswordResetUnknownUser checks unknown users get a token too,
+// so the answer does not tell which accounts exist, but it redeems nothing.
+func TestRequestPasswordResetUnknownUser(t *testing.T) {
+	svc := newTestService(t)
+
+	token, err := svc.RequestPasswordReset(context.Background(), "nobody@example.com")
+	if err != nil || token == "" {
+		t.Fatalf("RequestPasswordReset() = %q, %v, want a token", token, err)
+	}
+
+	if err := svc.ResetPassword(context.Background(), token, "n3w-password"); !errors.Is(err, ErrOneTimeTokenNotFound) {
+		t.Fatalf("ResetPassword() error = %v, want ErrOneTimeTokenNotFound", err)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+// Resetter is implemented by the in-memory stores and by the UserService
+// NewUserService returns. Reset drops everything they hold, so that tests
+// can share one instance between cases without leaking users or sessions
+// from one into the next. It is meant for tests only; nothing in the
+// service calls it.
+type Resetter interface {
+	Reset()
+}
+
+// Reset returns the service to the state NewUserService left it in: users,
+// sessions, refresh tokens, one-time tokens and the denylist are dropped
+// from every store implementing Resetter, along with lockouts and the
+// integration health the service tracks itself, and registration is turned
+// back on or off as WithRegistrationEnabled set it. Stores that do not
+// implement Resetter, like the Postgres and Redis ones, keep their data.
+// It is meant for tests only.
+func (u *userService) Reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, store := range []any{u.users, u.sessions, u.refreshTokens, u.oneTimeTokens, u.denylist} {
+		if resetter, ok := store.(Resetter); ok {
+			resetter.Reset()
+		}
+	}
+
+	u.loginAttempts.resetAll()
+	u.integrations.reset()
+	u.registrationDisabled = u.registrationDisabledAtStart
+
+	if u.activeUsers != nil {
+		u.activeUsers.reset()
+	}
+}
+
+func (m *memoryUserRepository) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.users)
+	clear(m.emails)
+}
+
+func (m *memorySessionStore) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.sessions)
+}
+
+func (m *memoryRefreshTokenStore) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.tokens)
+}
+
+func (m *memoryOneTimeTokenStore) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.tokens)
+}
+
+func (m *memoryDenylist) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.entries)
+}
+
+func (l *loginAttempts) resetAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.attempts)
+	l.inserts = 0
+}
+
+func (m *integrationMonitor) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.health)
+}
+
+func (a *activeUsers) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clear(a.users)
+	a.gauge.Set(0)
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoresReset(t *testing.T) {
+	ctx := context.Background()
+
+	users := NewMemoryUserRepository()
+	if err := users.CreateUser(ctx, UserFields{Username: "alice", HashedPassword: "x", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := NewMemorySessionStore()
+	if err := sessions.Set(ctx, Session{ID: "s1", Username: "alice"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	users.(Resetter).Reset()
+	sessions.(Resetter).Reset()
+
+	if _, err := users.GetUser(ctx, "alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUser() after Reset error = %v, want ErrUserNotFound", err)
+	}
+
+	if _, err := users.GetUserByEmail(ctx, "alice@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUserByEmail() after Reset error = %v, want ErrUserNotFound", err)
+	}
+
+	if _, err := sessions.Get(ctx, "s1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Get() after Reset error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestServiceReset(t *testing.T) {
+	svc := newTestService(t, WithLockoutThreshold(1))
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+	session := mustLogin(t, svc, "alice")
+
+	if _, err := svc.Login(context.Background(), "bob", "wrong-passw0rd"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(context.Background(), "bob", testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Login() error = %v, want ErrAccountLocked", err)
+	}
+
+	var resetter Resetter = svc
+	resetter.Reset()
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() after Reset error = %v, want ErrInvalidCredentials", err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token from before Reset succeeded")
+	}
+
+	// The names, emails and lockouts of the old accounts are free again.
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+	mustLogin(t, svc, "bob")
+}
+
+func TestUserServiceResetRestoresRegistration(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetRegistrationEnabled(false)
+	svc.Reset()
+	mustRegister(t, svc, "alice")
+
+	closed := newTestService(t, WithRegistrationEnabled(false))
+	closed.SetRegistrationEnabled(true)
+	closed.Reset()
+
+	if _, err := closed.Register(context.Background(), "alice", testPassword, "alice@example.com"); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Fatalf("Register() after Reset error = %v, want ErrRegistrationDisabled", err)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy tells NewRetryUserRepository how often and how patiently to
+// retry. Attempts counts the first call too, so 1 means no retries. Delays
+// grow exponentially from BaseDelay up to MaxDelay, with full jitter so
+// instances recovering from the same outage do not retry in lockstep.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable decides which errors are worth another attempt. Nil means
+	// IsTransientError.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy makes up to 3 attempts, sleeping up to 50ms before the
+// second and up to 100ms before the third.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:  3,
+		BaseDelay: 50 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+}
+
+// IsTransientError reports whether err looks like a passing network or
+// connection failure rather than an answer from the storage, e.g. a timeout
+// or a reset connection. ErrUserNotFound, ErrUserAlreadyExists and context
+// errors never are.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUserAlreadyExists) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+type retryUserRepository struct {
+	next   UserRepository
+	policy RetryPolicy
+}
+
+// NewRetryUserRepository retries the calls to next that fail with a
+// retryable error, sleeping between attempts. It gives up early, returning
+// the last error, once ctx is done or its deadline would pass during the
+// next sleep. SaveUser is an upsert and safe to repeat; a retried
+// CreateUser, RenameUser or DeleteUser whose first attempt actually went
+// through reports ErrUserAlreadyExists or ErrUserNotFound.
+func NewRetryUserRepository(next UserRepository, policy RetryPolicy) (UserRepository, error) {
+	if policy.Attempts < 1 {
+		return nil, fmt.Errorf("retry attempts must be at least 1, got %d", policy.Attempts)
+	}
+
+	if policy.BaseDelay <= 0 || policy.MaxDelay < policy.BaseDelay {
+		return nil, fmt.Errorf("retry delays must satisfy 0 < base <= max, got %s and %s", policy.BaseDelay, policy.MaxDelay)
+	}
+
+	if policy.Retryable == nil {
+		policy.Retryable = IsTransientError
+	}
+
+	return &retryUserRepository{next: next, policy: policy}, nil
+}
+
+func (r *retryUserRepository) do(ctx context.Context, call func() error) error {
+	var err error
+
+	for attempt := 0; attempt < r.policy.Attempts; attempt++ {
+		if attempt > 0 {
+			if !r.sleep(ctx, attempt) {
+				return err
+			}
+		}
+
+		err = call()
+		if err == nil || !r.policy.Retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// sleep waits out the backoff before retry number attempt and reports
+// whether it is still worth retrying afterwards.
+func (r *retryUserRepository) sleep(ctx context.Context, attempt int) bool {
+	backoff := r.policy.BaseDelay << (attempt - 1)
+	if backoff > r.policy.MaxDelay || backoff <= 0 {
+		backoff = r.policy.MaxDelay
+	}
+
+	delay := rand.N(backoff) + 1
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (r *retryUserRepository) GetUser(ctx context.Context, username string) (UserFields, error) {
+	var user UserFields
+
+	err := r.do(ctx, func() (err error) {
+		user, err = r.next.GetUser(ctx, username)
+
+		return err
+	})
+
+	return user, err
+}
+
+func (r *retryUserRepository) GetUserByEmail(ctx context.Context, email string) (UserFields, error) {
+	var user UserFields
+
+	err := r.do(ctx, func() (err error) {
+		user, err = r.next.GetUserByEmail(ctx, email)
+
+		return err
+	})
+
+	return user, err
+}
+
+func (r *retryUserRepository) ListUsernames(ctx context.Context, offset, limit int) ([]string, int, error) {
+	var (
+		usernames []string
+		total     int
+	)
+
+	err := r.do(ctx, func() (err error) {
+		usernames, total, err = r.next.ListUsernames(ctx, offset, limit)
+
+		return err
+	})
+
+	return usernames, total, err
+}
+
+func (r *retryUserRepository) CreateUser(ctx context.Context, user UserFields) error {
+	return r.do(ctx, func() error {
+		return r.next.CreateUser(ctx, user)
+	})
+}
+
+func (r *retryUserRepository) RenameUser(ctx context.Context, username, newUsername string) error {
+	return r.do(ctx, func() error {
+		return r.next.RenameUser(ctx, username, newUsername)
+	})
+}
+
+func (r *retryUserRepository) SaveUser(ctx context.Context, user UserFields) error {
+	return r.do(ctx, func() error {
+		return r.next.SaveUser(ctx, user)
+	})
+}
+
+func (r *retryUserRepository) DeleteUser(ctx context.Context, username string) error {
+	return r.do(ctx, func() error {
+		return r.next.DeleteUser(ctx, username)
+	})
+}
+
+// Ping is not retried: health checks should see failures as they happen.
+func (r *retryUserRepository) Ping(ctx context.Context) error {
+	return r.next.Ping(ctx)
+}
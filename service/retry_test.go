@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyUserRepository fails GetUser with err for its first failures calls.
+type flakyUserRepository struct {
+	UserRepository
+	err      error
+	failures int
+	calls    int
+}
+
+func (r *flakyUserRepository) GetUser(ctx context.Context, username string) (UserFields, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return UserFields{}, r.err
+	}
+
+	return r.UserRepository.GetUser(ctx, username)
+}
+
+func newFlakyUserRepository(t *testing.T, err error, failures int) *flakyUserRepository {
+	users := NewMemoryUserRepository()
+	if err := users.CreateUser(context.Background(), UserFields{Username: "alice", HashedPassword: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &flakyUserRepository{UserRepository: users, err: err, failures: failures}
+}
+
+func mustRetryUserRepository(t *testing.T, next UserRepository) UserRepository {
+	repo, err := NewRetryUserRepository(next, RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestRetryUserRepositoryRetriesTransientErrors(t *testing.T) {
+	flaky := newFlakyUserRepository(t, syscall.ECONNRESET, 2)
+
+	user, err := mustRetryUserRepository(t, flaky).GetUser(context.Background(), "alice")
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("GetUser() = %q, %v, want alice", user.Username, err)
+	}
+
+	if flaky.calls != 3 {
+		t.Fatalf("%d calls reached the repository, want 3", flaky.calls)
+	}
+
+	// A third failure exhausts the attempts.
+	flaky = newFlakyUserRepository(t, syscall.ECONNRESET, 3)
+	if _, err := mustRetryUserRepository(t, flaky).GetUser(context.Background(), "alice"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("GetUser() error = %v, want ECONNRESET", err)
+	}
+}
+
+func TestRetryUserRepositoryReturnsPermanentErrors(t *testing.T) {
+	for _, permanent := range []error{ErrUserNotFound, errors.New("syntax error")} {
+		flaky := newFlakyUserRepository(t, permanent, 3)
+
+		if _, err := mustRetryUserRepository(t, flaky).GetUser(context.Background(), "alice"); !errors.Is(err, permanent) {
+			t.Fatalf("GetUser() error = %v, want %v", err, permanent)
+		}
+
+		if flaky.calls != 1 {
+			t.Fatalf("%v: %d calls reached the repository, want 1", permanent, flaky.calls)
+		}
+	}
+}
+
+func TestRetryUserRepositoryStopsWhenCancelled(t *testing.T) {
+	flaky := newFlakyUserRepository(t, syscall.ECONNRESET, 3)
+
+	repo, err := NewRetryUserRepository(flaky, RetryPolicy{Attempts: 3, BaseDelay: 1000 * time.Hour, MaxDelay: 1000 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := repo.GetUser(ctx, "alice"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("GetUser() error = %v, want ECONNRESET", err)
+	}
+
+	if flaky.calls != 1 {
+		t.Fatalf("%d calls reached the repository after cancelling, want 1", flaky.calls)
+	}
+
+	// A deadline closer than the backoff ends the retries without sleeping.
+	flaky.calls = 0
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	begin := time.Now()
+	if _, err := repo.GetUser(ctx, "alice"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("GetUser() error = %v, want ECONNRESET", err)
+	}
+
+	if took := time.Since(begin); took > time.Second/2 || flaky.calls != 1 {
+		t.Fatalf("GetUser() took %s over %d calls, want it to give up before the deadline", took, flaky.calls)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	for err, want := range map[error]bool{
+		nil:                      false,
+		ErrUserNotFound:          false,
+		context.DeadlineExceeded: false,
+		syscall.ECONNREFUSED:     true,
+		errors.New("boom"):       false,
+	} {
+		if got := IsTransientError(err); got != want {
+			t.Errorf("IsTransientError(%v) = %t, want %t", err, got, want)
+		}
+	}
+}
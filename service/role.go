@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RoleUser is given to every account registered without explicit roles.
+	RoleUser = "user"
+	// RoleAdmin guards administrative operations.
+	RoleAdmin = "admin"
+)
+
+// ErrInvalidRole is returned by Register when a role is empty or contains
+// anything other than lowercase letters, digits, '_' and '-'.
+var ErrInvalidRole = errors.New("invalid role")
+
+// normalizeRoles lowercases and deduplicates roles, keeping their order, and
+// falls back to RoleUser when none are given.
+func normalizeRoles(roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return []string{RoleUser}, nil
+	}
+
+	seen := make(map[string]struct{}, len(roles))
+	normalized := make([]string, 0, len(roles))
+
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if role == "" {
+			return nil, fmt.Errorf("%w: role cannot be empty", ErrInvalidRole)
+		}
+
+		for _, r := range role {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' && r != '-' {
+				return nil, fmt.Errorf("%w: character %q is not allowed in %q", ErrInvalidRole, r, role)
+			}
+		}
+
+		if _, ok := seen[role]; ok {
+			continue
+		}
+
+		seen[role] = struct{}{}
+		normalized = append(normalized, role)
+	}
+
+	return normalized, nil
+}
+
+func containsRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
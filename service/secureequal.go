@@ -0,0 +1,12 @@
+package service
+
+import "crypto/subtle"
+
+// SecureEqual reports whether a and b are equal in time that depends on
+// their length only, never on where they first differ, so comparing a
+// secret against attacker supplied input does not leak how much of it was
+// guessed right. Every comparison of tokens, codes or their hashes goes
+// through it, including the CSRF check of the transport package.
+func SecureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecureEqual(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"token", "token", true},
+		{"token", "tokeN", false},
+		{"token", "Token", false},
+		{"token", "token2", false},
+		{"token", "", false},
+	}
+
+	for _, tc := range cases {
+		if got := SecureEqual(tc.a, tc.b); got != tc.want {
+			t.Errorf("SecureEqual(%q, %q) = %t, want %t", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkSecureEqual compares equal length inputs differing in the first
+// and in the last byte, which take the same time: nothing returns early at
+// the first difference.
+func BenchmarkSecureEqual(b *testing.B) {
+	secret := strings.Repeat("a", 64)
+
+	for _, bench := range []struct {
+		name  string
+		input string
+	}{
+		{"equal", secret},
+		{"first byte differs", "b" + secret[1:]},
+		{"last byte differs", secret[:63] + "b"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				SecureEqual(secret, bench.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "passw0rd-1"
+
+// fakeClock is a Clock tests move forward by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// newTestService returns a service backed by the in-memory stores, hashing
+// at bcrypt's minimum cost so tests stay fast. It is closed when the test
+// ends.
+func newTestService(t testing.TB, opts ...Option) *userService {
+	t.Helper()
+
+	return newTestServiceWithRepository(t, NewMemoryUserRepository(), opts...)
+}
+
+// newTestServiceWithRepository is newTestService storing users in users,
+// which several services may share like replicas sharing a database.
+func newTestServiceWithRepository(t testing.TB, users UserRepository, opts ...Option) *userService {
+	t.Helper()
+
+	opts = append([]Option{WithBcryptCost(bcrypt.MinCost)}, opts...)
+
+	svc, err := NewUserService(users, NewMemorySessionStore(), opts...)
+	if err != nil {
+		t.Fatalf("NewUserService: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := svc.Close(context.Background()); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+
+	return svc.(*userService)
+}
+
+// mustRegister registers user with testPassword and user@example.com.
+func mustRegister(t testing.TB, svc UserService, user string, roles ...string) {
+	t.Helper()
+
+	if _, err := svc.Register(context.Background(), user, testPassword, user+"@example.com", roles...); err != nil {
+		t.Fatalf("Register(%q): %v", user, err)
+	}
+}
+
+// mustLogin logs user in with testPassword.
+func mustLogin(t testing.TB, svc UserService, user string) LoginResult {
+	t.Helper()
+
+	result, err := svc.Login(context.Background(), user, testPassword)
+	if err != nil {
+		t.Fatalf("Login(%q): %v", user, err)
+	}
+
+	return result
+}
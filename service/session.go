@@ -0,0 +1,657 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// MaxUserAgentLength caps, in bytes, the user agent kept on a session.
+	MaxUserAgentLength = 256
+
+	// DefaultSessionPageLimit is used by ListSessions when limit is zero.
+	DefaultSessionPageLimit = 20
+	// MaxSessionPageLimit is the largest page ListSessions will return.
+	MaxSessionPageLimit = 100
+)
+
+var (
+	// ErrSessionNotFound is returned by a SessionStore when the session does
+	// not exist or has already expired.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrTooManySessions is returned by Login when the user already holds
+	// the maximum number of sessions and eviction is off.
+	ErrTooManySessions = errors.New("too many active sessions")
+	// ErrUnknownSession is returned by RevokeSession for a session the
+	// caller does not own.
+	ErrUnknownSession = errors.New("unknown session")
+)
+
+// Session is what a SessionStore keeps for every logged in session.
+// ExpiresAt is the absolute deadline set at login, which activity never
+// extends.
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Tenant    string    `json:"tenant,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Label     string    `json:"label,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// SessionStore keeps sessions by ID. A ttl of zero passed to Set means the
+// session never expires on its own. Touch resets the ttl of a stored
+// session the same way, but fails with ErrSessionNotFound instead of bringing
+// back one that was deleted or expired meanwhile.
+// DeleteUserSessions removes every session owned by username and reports how
+// many were removed, and ListUserSessions returns the live ones ordered by
+// creation time. SessionStats counts the live sessions, see SessionStats.
+// All three only consider sessions of the tenant of ctx, see
+// ContextWithTenant.
+type SessionStore interface {
+	Get(ctx context.Context, sessionID string) (Session, error)
+	Set(ctx context.Context, session Session, ttl time.Duration) error
+	Touch(ctx context.Context, session Session, ttl time.Duration) error
+	Delete(ctx context.Context, sessionID string) error
+	DeleteUserSessions(ctx context.Context, username string) (int, error)
+	ListUserSessions(ctx context.Context, username string) ([]Session, error)
+	SessionStats(ctx context.Context, since time.Time) (SessionStats, error)
+	Ping(ctx context.Context) error
+}
+
+// SessionStats summarizes the live sessions of a tenant: how many there are,
+// how many distinct users hold them, and how many were created after Since.
+type SessionStats struct {
+	Sessions     int       `json:"sessions"`
+	Users        int       `json:"users"`
+	Since        time.Time `json:"since"`
+	CreatedSince int       `json:"created_since"`
+}
+
+type memorySession struct {
+	Session
+	expiresAt time.Time
+}
+
+func (s memorySession) expired(now time.Time) bool {
+	return !s.expiresAt.IsZero() && now.After(s.expiresAt)
+}
+
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]memorySession
+	clock    Clock
+}
+
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions: make(map[string]memorySession),
+		clock:    realClock{},
+	}
+}
+
+func (m *memorySessionStore) setClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = clock
+}
+
+func (m *memorySessionStore) Get(ctx context.Context, sessionID string) (Session, error) {
+	if err := ctx.Err(); err != nil {
+		return Session{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.expired(m.clock.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	return session.Session, nil
+}
+
+func (m *memorySessionStore) Set(ctx context.Context, session Session, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := memorySession{Session: session}
+	if ttl > 0 {
+		stored.expiresAt = m.clock.Now().Add(ttl)
+	}
+
+	m.sessions[session.ID] = stored
+
+	return nil
+}
+
+func (m *memorySessionStore) Touch(ctx context.Context, session Session, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+
+	stored, ok := m.sessions[session.ID]
+	if !ok || stored.expired(now) {
+		return ErrSessionNotFound
+	}
+
+	stored.expiresAt = time.Time{}
+	if ttl > 0 {
+		stored.expiresAt = now.Add(ttl)
+	}
+
+	m.sessions[session.ID] = stored
+
+	return nil
+}
+
+func (m *memorySessionStore) Delete(ctx context.Context, sessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sessionID)
+
+	return nil
+}
+
+func (m *memorySessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant := TenantFromContext(ctx)
+	deleted := 0
+
+	for sessionID, session := range m.sessions {
+		if session.Username == username && session.Tenant == tenant {
+			delete(m.sessions, sessionID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *memorySessionStore) ListUserSessions(ctx context.Context, username string) ([]Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.clock.Now()
+	tenant := TenantFromContext(ctx)
+
+	var sessions []Session
+	for _, session := range m.sessions {
+		if session.Username == username && session.Tenant == tenant && !session.expired(now) {
+			sessions = append(sessions, session.Session)
+		}
+	}
+
+	sortSessions(sessions)
+
+	return sessions, nil
+}
+
+func (m *memorySessionStore) SessionStats(ctx context.Context, since time.Time) (SessionStats, error) {
+	if err := ctx.Err(); err != nil {
+		return SessionStats{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.clock.Now()
+	tenant := TenantFromContext(ctx)
+	users := make(map[string]struct{})
+	stats := SessionStats{Since: since}
+
+	for _, session := range m.sessions {
+		if session.Tenant != tenant || session.expired(now) {
+			continue
+		}
+
+		stats.Sessions++
+		users[session.Username] = struct{}{}
+
+		if session.CreatedAt.After(since) {
+			stats.CreatedSince++
+		}
+	}
+
+	stats.Users = len(users)
+
+	return stats, nil
+}
+
+// DeleteExpired implements ExpiredSessionSweeper.
+func (m *memorySessionStore) DeleteExpired(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+
+	for sessionID, session := range m.sessions {
+		if session.expired(now) {
+			delete(m.sessions, sessionID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *memorySessionStore) Ping(_ context.Context) error {
+	return nil
+}
+
+// sortSessions orders sessions oldest first, breaking ties by ID so the
+// order is stable.
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+
+		return sessions[i].ID < sessions[j].ID
+	})
+}
+
+// sanitizeUserAgent strips control characters and invalid UTF-8 from ua and
+// truncates it to MaxUserAgentLength without splitting a character.
+func sanitizeUserAgent(ua string) string {
+	ua = strings.TrimSpace(strings.ToValidUTF8(ua, ""))
+	ua = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, ua)
+
+	if len(ua) <= MaxUserAgentLength {
+		return ua
+	}
+
+	cut := MaxUserAgentLength
+	for cut > 0 && !utf8.RuneStart(ua[cut]) {
+		cut--
+	}
+
+	return ua[:cut]
+}
+
+// sanitizeIP returns the canonical form of the address in ip, which may be a
+// bare address or host:port as found in http.Request.RemoteAddr. Anything
+// that does not parse as an IP is dropped rather than stored verbatim.
+func sanitizeIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(ip)
+		if err != nil {
+			return ""
+		}
+
+		addr = addrPort.Addr()
+	}
+
+	return addr.Unmap().WithZone("").String()
+}
+
+// SessionInfo describes one of a user's active sessions. Current is set on
+// the session the request was made with.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Label     string    `json:"label,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Current   bool      `json:"current"`
+}
+
+// SessionPage is one page of ListSessions. NextCursor fetches the next page
+// and is empty on the last one.
+type SessionPage struct {
+	Sessions   []SessionInfo `json:"sessions"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ListSessions pages through the active sessions of the user owning token,
+// ordered by creation time and then ID. An empty cursor starts from the
+// oldest session, and a limit of zero selects DefaultSessionPageLimit.
+// Pages resume after the last session of the previous one rather than at an
+// offset, so sessions starting or ending between requests never make a page
+// skip or repeat any other.
+func (u *userService) ListSessions(ctx context.Context, token, cursor string, limit int) (SessionPage, error) {
+	if u.statelessSessions {
+		return SessionPage{}, ErrStatelessSessions
+	}
+
+	if limit == 0 {
+		limit = DefaultSessionPageLimit
+	}
+
+	if limit < 0 || limit > MaxSessionPageLimit {
+		return SessionPage{}, fmt.Errorf("%w: limit must be between 1 and %d, got %d", ErrInvalidPage, MaxSessionPageLimit, limit)
+	}
+
+	after, err := decodeSessionCursor(cursor)
+	if err != nil {
+		return SessionPage{}, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	claims, err := u.parseToken(ctx, token, accessTokenType)
+	if errors.Is(err, ErrTokenExpired) {
+		return SessionPage{}, fmt.Errorf("session expired: %w", err)
+	}
+
+	if err != nil {
+		return SessionPage{}, fmt.Errorf("error while parsing token: %w", err)
+	}
+
+	sessionID := claims.SessionID
+
+	current, err := u.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return SessionPage{}, fmt.Errorf("session not registered: %w", err)
+	}
+
+	u.touchSession(ctx, current)
+
+	sessions, err := u.sessions.ListUserSessions(ctx, current.Username)
+	if err != nil {
+		return SessionPage{}, fmt.Errorf("error while listing sessions: %w", err)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessionCursorOf(sessions[i]).before(sessionCursorOf(sessions[j]))
+	})
+
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(sessions), func(i int) bool {
+			return after.before(sessionCursorOf(sessions[i]))
+		})
+	}
+
+	end := min(start+limit, len(sessions))
+
+	var page SessionPage
+	if end < len(sessions) {
+		page.NextCursor = sessionCursorOf(sessions[end-1]).encode()
+	}
+
+	page.Sessions = make([]SessionInfo, 0, end-start)
+	for _, session := range sessions[start:end] {
+		page.Sessions = append(page.Sessions, SessionInfo{
+			ID:        session.ID,
+			CreatedAt: session.CreatedAt,
+			Label:     session.Label,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			Current:   session.ID == sessionID,
+		})
+	}
+
+	return page, nil
+}
+
+// sessionCursor is the position of a session in the order ListSessions
+// pages through.
+type sessionCursor struct {
+	createdAt int64
+	id        string
+}
+
+func sessionCursorOf(session Session) sessionCursor {
+	return sessionCursor{createdAt: session.CreatedAt.UnixNano(), id: session.ID}
+}
+
+func (c sessionCursor) before(other sessionCursor) bool {
+	if c.createdAt != other.createdAt {
+		return c.createdAt < other.createdAt
+	}
+
+	return c.id < other.id
+}
+
+// encode makes c opaque to clients, who are only meant to hand it back.
+func (c sessionCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.createdAt, 10) + ":" + c.id))
+}
+
+func decodeSessionCursor(cursor string) (sessionCursor, error) {
+	if cursor == "" {
+		return sessionCursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return sessionCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), ":")
+	nanos, err := strconv.ParseInt(createdAt, 10, 64)
+	if !ok || err != nil || id == "" {
+		return sessionCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+
+	return sessionCursor{createdAt: nanos, id: id}, nil
+}
+
+// RevokeAllSessions logs the user owning token out everywhere, including the
+// session token belongs to.
+func (u *userService) RevokeAllSessions(ctx context.Context, token string) error {
+	if u.statelessSessions {
+		return ErrStatelessSessions
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if _, err := u.revokeUserSessions(ctx, user); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeSession logs out sessionID, one of the sessions ListSessions returns
+// to the user owning token. A session of anyone else fails with
+// ErrUnknownSession, just like one that does not exist, so session IDs of
+// other users cannot be probed. Revoking the session of token itself is a
+// Logout.
+func (u *userService) RevokeSession(ctx context.Context, token, sessionID string) error {
+	if u.statelessSessions {
+		return ErrStatelessSessions
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	claims, err := u.parseToken(ctx, token, accessTokenType)
+	if errors.Is(err, ErrTokenExpired) {
+		return fmt.Errorf("session expired: %w", err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while parsing token: %w", err)
+	}
+
+	current, err := u.claimsSession(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	if sessionID == current.ID {
+		return u.logoutSession(ctx, claims, current)
+	}
+
+	u.touchSession(ctx, current)
+
+	target, err := u.sessions.Get(ctx, sessionID)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("error while looking up session: %w", err)
+	}
+
+	if err != nil || target.Username != current.Username || target.Tenant != current.Tenant {
+		return ErrUnknownSession
+	}
+
+	if err := u.endSession(ctx, target.ID); err != nil {
+		return err
+	}
+
+	u.trackActiveUser(ctx, target.Username)
+	u.audit(ctx, AuditLogout, target.Username)
+
+	return nil
+}
+
+// endSession deletes sessionID and its refresh token.
+func (u *userService) endSession(ctx context.Context, sessionID string) error {
+	if err := u.sessions.Delete(ctx, sessionID); err != nil {
+		return fmt.Errorf("error while deleting session: %w", err)
+	}
+
+	if err := u.refreshTokens.Delete(ctx, sessionID); err != nil {
+		return fmt.Errorf("error while revoking refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// sessionStoreTTL is how long a session is kept in the store from now: the
+// idle timeout when one is set, but never past the absolute deadline. A
+// non-positive result means the session is already past it.
+func (u *userService) sessionStoreTTL(session Session, now time.Time) time.Duration {
+	remaining := session.ExpiresAt.Sub(now)
+	if u.idleTimeout > 0 && u.idleTimeout < remaining {
+		return u.idleTimeout
+	}
+
+	return remaining
+}
+
+// touchSession pushes the idle deadline of session back after it was used.
+// It does nothing without an idle timeout. Like rehash it is best
+// effort: the request already authenticated, so a failure is only logged. A
+// session logged out meanwhile stays gone.
+func (u *userService) touchSession(ctx context.Context, session Session) {
+	if u.idleTimeout <= 0 || session.ExpiresAt.IsZero() {
+		return
+	}
+
+	ttl := u.sessionStoreTTL(session, u.clock.Now())
+	if ttl <= 0 {
+		return
+	}
+
+	err := u.sessions.Touch(ctx, session, ttl)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		_ = level.Warn(u.logger).Log("msg", "error while extending session", "session", session.ID, "err", err)
+	}
+}
+
+// enforceSessionLimit makes room for one more session of username, either by
+// failing with ErrTooManySessions or by logging out its oldest sessions.
+func (u *userService) enforceSessionLimit(ctx context.Context, username string) error {
+	if u.maxSessionsPerUser <= 0 {
+		return nil
+	}
+
+	sessions, err := u.sessions.ListUserSessions(ctx, username)
+	if err != nil {
+		return fmt.Errorf("error while listing user sessions: %w", err)
+	}
+
+	excess := len(sessions) - u.maxSessionsPerUser + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	if !u.evictOldestSession {
+		return ErrTooManySessions
+	}
+
+	// ListUserSessions returns the oldest first.
+	for _, session := range sessions[:excess] {
+		if err := u.refreshTokens.Delete(ctx, session.ID); err != nil {
+			return fmt.Errorf("error while revoking refresh token: %w", err)
+		}
+
+		if err := u.sessions.Delete(ctx, session.ID); err != nil {
+			return fmt.Errorf("error while evicting session: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// revokeUserSessions deletes every session of username along with their
+// refresh tokens and reports how many sessions were removed.
+func (u *userService) revokeUserSessions(ctx context.Context, username string) (int, error) {
+	sessions, err := u.sessions.ListUserSessions(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("error while listing user sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if err := u.refreshTokens.Delete(ctx, session.ID); err != nil {
+			return 0, fmt.Errorf("error while revoking refresh token: %w", err)
+		}
+	}
+
+	n, err := u.sessions.DeleteUserSessions(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting user sessions: %w", err)
+	}
+
+	u.trackActiveUser(ctx, username)
+
+	return n, nil
+}
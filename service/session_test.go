@@ -0,0 +1,577 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSessionStore runs the SessionStore contract against store, which must
+// start empty. advance moves the store's clock forward.
+func testSessionStore(t *testing.T, store SessionStore, advance func(time.Duration)) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("Get unknown", func(t *testing.T) {
+		if _, err := store.Get(ctx, "nope"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() error = %v, want ErrSessionNotFound", err)
+		}
+	})
+
+	t.Run("Set and Get", func(t *testing.T) {
+		want := Session{ID: "s1", Username: "alice", CreatedAt: now, Label: "laptop", IP: "192.0.2.1"}
+		if err := store.Set(ctx, want, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.Get(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Username != want.Username || got.Label != want.Label || got.IP != want.IP || !got.CreatedAt.Equal(want.CreatedAt) {
+			t.Fatalf("Get() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Set(ctx, Session{ID: "s2", Username: "alice", CreatedAt: now}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Delete(ctx, "s2"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.Get(ctx, "s2"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() after Delete error = %v, want ErrSessionNotFound", err)
+		}
+
+		if err := store.Delete(ctx, "s2"); err != nil {
+			t.Fatalf("Delete() of a missing session error = %v", err)
+		}
+	})
+
+	t.Run("ListUserSessions", func(t *testing.T) {
+		for i, id := range []string{"s4", "s3"} {
+			session := Session{ID: id, Username: "alice", CreatedAt: now.Add(time.Duration(i+1) * time.Minute)}
+			if err := store.Set(ctx, session, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.Set(ctx, Session{ID: "b1", Username: "bob", CreatedAt: now}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := store.ListUserSessions(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, session := range sessions {
+			ids = append(ids, session.ID)
+		}
+
+		if len(ids) != 3 || ids[0] != "s1" || ids[1] != "s4" || ids[2] != "s3" {
+			t.Fatalf("ListUserSessions() = %v, want [s1 s4 s3]", ids)
+		}
+	})
+
+	t.Run("SessionStats", func(t *testing.T) {
+		stats, err := store.SessionStats(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Sessions != 4 || stats.Users != 2 || stats.CreatedSince != 2 {
+			t.Fatalf("SessionStats() = %+v, want 4 sessions of 2 users, 2 created since", stats)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		other := ContextWithTenant(ctx, "other")
+		if err := store.Set(other, Session{ID: "o1", Username: "alice", Tenant: "other", CreatedAt: now}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := store.ListUserSessions(other, "alice")
+		if err != nil || len(sessions) != 1 || sessions[0].ID != "o1" {
+			t.Fatalf("ListUserSessions() in another tenant = %+v, %v, want only o1", sessions, err)
+		}
+
+		if n, err := store.DeleteUserSessions(other, "alice"); err != nil || n != 1 {
+			t.Fatalf("DeleteUserSessions() in another tenant = %d, %v, want 1", n, err)
+		}
+	})
+
+	t.Run("DeleteUserSessions", func(t *testing.T) {
+		n, err := store.DeleteUserSessions(ctx, "alice")
+		if err != nil || n != 3 {
+			t.Fatalf("DeleteUserSessions() = %d, %v, want 3", n, err)
+		}
+
+		if _, err := store.Get(ctx, "s1"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() after DeleteUserSessions error = %v, want ErrSessionNotFound", err)
+		}
+
+		if _, err := store.Get(ctx, "b1"); err != nil {
+			t.Fatalf("DeleteUserSessions() removed another user's session: %v", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		if err := store.Set(ctx, Session{ID: "short", Username: "carol", CreatedAt: now}, time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Set(ctx, Session{ID: "forever", Username: "carol", CreatedAt: now}, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		advance(2 * time.Second)
+
+		if _, err := store.Get(ctx, "short"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() of an expired session error = %v, want ErrSessionNotFound", err)
+		}
+
+		if _, err := store.Get(ctx, "forever"); err != nil {
+			t.Fatalf("Get() of a session without ttl error = %v", err)
+		}
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		session := Session{ID: "touched", Username: "dave", CreatedAt: now}
+		if err := store.Set(ctx, session, time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Touch(ctx, session, time.Hour); err != nil {
+			t.Fatalf("Touch() error = %v", err)
+		}
+
+		advance(2 * time.Second)
+
+		if _, err := store.Get(ctx, "touched"); err != nil {
+			t.Fatalf("Get() of a touched session error = %v", err)
+		}
+
+		if err := store.Delete(ctx, "touched"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Touch(ctx, session, time.Hour); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Touch() of a deleted session error = %v, want ErrSessionNotFound", err)
+		}
+
+		if _, err := store.Get(ctx, "touched"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Get() after Touch() of a deleted session error = %v, want ErrSessionNotFound", err)
+		}
+	})
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	clock := newFakeClock()
+
+	store := NewMemorySessionStore()
+	store.(*memorySessionStore).setClock(clock)
+
+	testSessionStore(t, store, clock.Advance)
+}
+
+// TestServiceUsesInjectedRepository logs in as a user written straight to
+// the repository the service was built with.
+func TestServiceUsesInjectedRepository(t *testing.T) {
+	users := NewMemoryUserRepository()
+	svc := newTestServiceWithRepository(t, users)
+
+	hash, err := svc.hashValue(context.Background(), testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.CreateUser(context.Background(), UserFields{Username: "alice", HashedPassword: hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	mustLogin(t, svc, "alice")
+}
+
+func TestListSessions(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	laptop := mustLogin(t, svc, "alice")
+	phone := mustLogin(t, svc, "alice")
+
+	mustLogin(t, svc, "bob")
+
+	page, err := svc.ListSessions(context.Background(), laptop.AccessToken, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Sessions) != 2 || page.NextCursor != "" {
+		t.Fatalf("ListSessions() = %+v, want alice's two sessions on one page", page)
+	}
+
+	var current, other SessionInfo
+	for _, session := range page.Sessions {
+		if session.ID == "" || session.CreatedAt.IsZero() {
+			t.Errorf("session %+v has no ID or creation time", session)
+		}
+
+		if session.Current {
+			current = session
+		} else {
+			other = session
+		}
+	}
+
+	if current.ID == "" || current.ID == other.ID {
+		t.Fatalf("ListSessions() = %+v, want exactly one current session", page.Sessions)
+	}
+
+	// The same sessions seen from the phone.
+	page, err = svc.ListSessions(context.Background(), phone.AccessToken, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range page.Sessions {
+		if session.Current != (session.ID == other.ID) {
+			t.Errorf("session %s Current = %t seen from the phone", session.ID, session.Current)
+		}
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	first := mustLogin(t, svc, "alice")
+	second := mustLogin(t, svc, "alice")
+	bob := mustLogin(t, svc, "bob")
+
+	if err := svc.RevokeAllSessions(context.Background(), first.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		render, err := svc.SendMainTemplateData(context.Background(), token)
+		if err == nil || render.Variables.User != "" {
+			t.Fatalf("SendMainTemplateData() after RevokeAllSessions = %+v, %v, want an error", render.Variables, err)
+		}
+	}
+
+	if render, err := svc.SendMainTemplateData(context.Background(), bob.AccessToken); err != nil || render.Variables.User != "bob" {
+		t.Fatalf("SendMainTemplateData() for another user = %+v, %v, want bob still logged in", render.Variables, err)
+	}
+
+	if err := svc.RevokeAllSessions(context.Background(), first.AccessToken); err == nil {
+		t.Fatal("RevokeAllSessions() with a revoked token succeeded")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	laptop := mustLogin(t, svc, "alice")
+	phone := mustLogin(t, svc, "alice")
+	bob := mustLogin(t, svc, "bob")
+
+	page, err := svc.ListSessions(context.Background(), laptop.AccessToken, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var phoneID string
+	for _, session := range page.Sessions {
+		if !session.Current {
+			phoneID = session.ID
+		}
+	}
+
+	// bob cannot end alice's session, and the answer is the same as for an
+	// ID that does not exist.
+	for _, sessionID := range []string{phoneID, "no-such-session"} {
+		if err := svc.RevokeSession(context.Background(), bob.AccessToken, sessionID); !errors.Is(err, ErrUnknownSession) {
+			t.Fatalf("RevokeSession(%q) as bob error = %v, want ErrUnknownSession", sessionID, err)
+		}
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), phone.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() after bob's attempt error = %v, want alice's phone still logged in", err)
+	}
+
+	if err := svc.RevokeSession(context.Background(), laptop.AccessToken, phoneID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), phone.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with the revoked session succeeded")
+	}
+
+	if _, err := svc.Refresh(context.Background(), phone.RefreshToken); err == nil {
+		t.Fatal("Refresh() of the revoked session succeeded")
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), laptop.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() with the revoking session error = %v", err)
+	}
+
+	// Revoking the current session logs it out.
+	page, err = svc.ListSessions(context.Background(), laptop.AccessToken, "", 0)
+	if err != nil || len(page.Sessions) != 1 {
+		t.Fatalf("ListSessions() = %+v, %v, want the laptop alone", page, err)
+	}
+
+	if err := svc.RevokeSession(context.Background(), laptop.AccessToken, page.Sessions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), laptop.AccessToken); err == nil {
+		t.Fatal("GetHomeState() after revoking the current session succeeded")
+	}
+}
+
+func TestLoginSessionMetadata(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+
+	tests := []struct {
+		userAgent, ip  string
+		wantUA, wantIP string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64)", "192.0.2.7", "Mozilla/5.0 (X11; Linux x86_64)", "192.0.2.7"},
+		{"curl/8.0\r\nX-Injected: 1", "[2001:db8::1]:443", "curl/8.0X-Injected: 1", "2001:db8::1"},
+		{strings.Repeat("é", MaxUserAgentLength), "not an ip", strings.Repeat("é", MaxUserAgentLength/2), ""},
+	}
+
+	for _, tt := range tests {
+		result, err := svc.LoginWithOptions(context.Background(), "alice", testPassword, LoginOptions{UserAgent: tt.userAgent, IP: tt.ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := svc.ListSessions(context.Background(), result.AccessToken, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, session := range page.Sessions {
+			if !session.Current {
+				continue
+			}
+
+			if session.UserAgent != tt.wantUA || session.IP != tt.wantIP {
+				t.Errorf("Login(%q, %q) stored %q, %q; want %q, %q", tt.userAgent, tt.ip, session.UserAgent, session.IP, tt.wantUA, tt.wantIP)
+			}
+		}
+	}
+}
+
+func TestMaxSessionsPerUserRejects(t *testing.T) {
+	svc := newTestService(t, WithMaxSessionsPerUser(3))
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	for range 3 {
+		mustLogin(t, svc, "alice")
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("Login() past the limit error = %v, want ErrTooManySessions", err)
+	}
+
+	// The limit is per user.
+	mustLogin(t, svc, "bob")
+}
+
+func TestMaxSessionsPerUserEvictsOldest(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithMaxSessionsPerUser(3), WithEvictOldestSession())
+	mustRegister(t, svc, "alice")
+
+	var sessions []LoginResult
+	for range 4 {
+		sessions = append(sessions, mustLogin(t, svc, "alice"))
+		clock.Advance(time.Second)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), sessions[0].AccessToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("GetHomeState() of the oldest session error = %v, want ErrSessionNotFound", err)
+	}
+
+	if _, err := svc.Refresh(context.Background(), sessions[0].RefreshToken); err == nil {
+		t.Fatal("Refresh() of the evicted session succeeded")
+	}
+
+	for _, session := range sessions[1:] {
+		if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil {
+			t.Fatalf("GetHomeState() of a newer session error = %v", err)
+		}
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(2*time.Hour), WithSessionTTL(time.Hour), WithSessionIdleTimeout(10*time.Minute))
+	mustRegister(t, svc, "alice")
+
+	active := mustLogin(t, svc, "alice")
+	idle := mustLogin(t, svc, "alice")
+
+	// Activity every 5 minutes keeps the session alive past the idle window.
+	for elapsed := 5 * time.Minute; elapsed < time.Hour; elapsed += 5 * time.Minute {
+		clock.Advance(5 * time.Minute)
+
+		if _, err := svc.SendMainTemplateData(context.Background(), active.AccessToken); err != nil {
+			t.Fatalf("SendMainTemplateData() after %s of activity error = %v", elapsed, err)
+		}
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), idle.AccessToken); err == nil {
+		t.Fatal("GetHomeState() of a session idle for 55 minutes succeeded")
+	}
+
+	// Activity does not extend the session past its absolute lifetime.
+	clock.Advance(5*time.Minute + time.Second)
+
+	if _, err := svc.SendMainTemplateData(context.Background(), active.AccessToken); err == nil {
+		t.Fatal("SendMainTemplateData() past the session TTL succeeded")
+	}
+}
+
+// loggedOutElsewhereStore deletes every session right after reading it, as
+// if another instance logged it out while the request was being served.
+type loggedOutElsewhereStore struct {
+	SessionStore
+}
+
+func (s loggedOutElsewhereStore) Get(ctx context.Context, sessionID string) (Session, error) {
+	session, err := s.SessionStore.Get(ctx, sessionID)
+	if err == nil {
+		err = s.SessionStore.Delete(ctx, sessionID)
+	}
+
+	return session, err
+}
+
+// TestSessionIdleTimeoutKeepsLogout checks extending the idle deadline of a
+// session does not bring it back after it was logged out concurrently.
+func TestSessionIdleTimeoutKeepsLogout(t *testing.T) {
+	svc := newTestService(t, WithSessionIdleTimeout(10*time.Minute))
+	mustRegister(t, svc, "alice")
+	login := mustLogin(t, svc, "alice")
+
+	store := svc.sessions
+	svc.sessions = loggedOutElsewhereStore{SessionStore: store}
+
+	if _, err := svc.SendMainTemplateData(context.Background(), login.AccessToken); err != nil {
+		t.Fatalf("SendMainTemplateData() error = %v", err)
+	}
+
+	if n := storedSessions(store); n != 0 {
+		t.Fatalf("%d sessions stored after the logout, want 0", n)
+	}
+}
+
+// TestListSessionsPages pages through 50 sessions, two of them started in
+// each second so the order has ties to break, while sessions start and end
+// between pages.
+func TestListSessionsPages(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(24*time.Hour))
+	mustRegister(t, svc, "alice")
+
+	sessions := make([]LoginResult, 50)
+	for i := range sessions {
+		sessions[i] = mustLogin(t, svc, "alice")
+
+		if i%2 == 1 {
+			clock.Advance(time.Second)
+		}
+	}
+
+	token := sessions[len(sessions)-1].AccessToken
+
+	all, err := svc.ListSessions(context.Background(), token, "", MaxSessionPageLimit)
+	if err != nil || len(all.Sessions) != len(sessions) || all.NextCursor != "" {
+		t.Fatalf("ListSessions() of one large page = %d sessions, %v, want all %d", len(all.Sessions), err, len(sessions))
+	}
+
+	var listed []SessionInfo
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages == 10 {
+			t.Fatal("ListSessions() kept returning a next cursor")
+		}
+
+		page, err := svc.ListSessions(context.Background(), token, cursor, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Sessions) > 7 {
+			t.Fatalf("page of %d sessions, want at most 7", len(page.Sessions))
+		}
+
+		listed = append(listed, page.Sessions...)
+
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+
+		// A session already listed ends and a new one starts: neither may
+		// shift the remaining pages.
+		if err := svc.Logout(context.Background(), sessions[pages].AccessToken); err != nil {
+			t.Fatal(err)
+		}
+
+		mustLogin(t, svc, "alice")
+	}
+
+	seen := make(map[string]bool)
+	for i, session := range listed {
+		if seen[session.ID] {
+			t.Fatalf("session %s listed twice", session.ID)
+		}
+
+		seen[session.ID] = true
+
+		if i == 0 {
+			continue
+		}
+
+		prev := listed[i-1]
+		if session.CreatedAt.Before(prev.CreatedAt) || (session.CreatedAt.Equal(prev.CreatedAt) && session.ID < prev.ID) {
+			t.Fatalf("session %s listed after %s, out of order", session.ID, prev.ID)
+		}
+	}
+
+	// Every session started before paging began is listed, even those that
+	// ended after their page was fetched.
+	for _, session := range all.Sessions {
+		if !seen[session.ID] {
+			t.Fatalf("session %s skipped", session.ID)
+		}
+	}
+
+	for _, limit := range []int{-1, MaxSessionPageLimit + 1} {
+		if _, err := svc.ListSessions(context.Background(), token, "", limit); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("ListSessions() with limit %d error = %v, want ErrInvalidPage", limit, err)
+		}
+	}
+
+	if _, err := svc.ListSessions(context.Background(), token, "not a cursor!", 2); !errors.Is(err, ErrInvalidPage) {
+		t.Errorf("ListSessions() with a malformed cursor error = %v, want ErrInvalidPage", err)
+	}
+}
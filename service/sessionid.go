@@ -0,0 +1,42 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SessionIDGenerator mints the IDs of new sessions. Holding a session ID is
+// not enough to use the session, but IDs are how ListSessions and
+// RevokeSession name sessions and how stores key them, so implementations
+// must draw them from a cryptographically secure source and make them long
+// enough never to collide.
+type SessionIDGenerator interface {
+	NewID() (string, error)
+}
+
+// uuidSessionIDGenerator mints random (version 4) UUIDs. It is the default.
+type uuidSessionIDGenerator struct{}
+
+func (uuidSessionIDGenerator) NewID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("error while generating session id: %w", err)
+	}
+
+	return id.String(), nil
+}
+
+// newSessionID mints a session ID through the configured generator.
+func (u *userService) newSessionID() (string, error) {
+	id, err := u.sessionIDs.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	if id == "" {
+		return "", fmt.Errorf("session id generator returned an empty id")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// sequentialSessionIDs hands out session-1, session-2 and so on.
+type sequentialSessionIDs struct {
+	next int
+}
+
+func (g *sequentialSessionIDs) NewID() (string, error) {
+	g.next++
+
+	return fmt.Sprintf("session-%d", g.next), nil
+}
+
+// sessionIDGeneratorFunc adapts a function to SessionIDGenerator.
+type sessionIDGeneratorFunc func() (string, error)
+
+func (f sessionIDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+func TestWithSessionIDGenerator(t *testing.T) {
+	svc := newTestService(t, WithSessionIDGenerator(&sequentialSessionIDs{}))
+	mustRegister(t, svc, "alice")
+
+	mustLogin(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	page, err := svc.ListSessions(context.Background(), session.AccessToken, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for _, s := range page.Sessions {
+		ids[s.ID] = s.Current
+	}
+
+	if len(ids) != 2 || ids["session-1"] || !ids["session-2"] {
+		t.Fatalf("sessions = %v, want session-1 and the current session-2", ids)
+	}
+
+	// The predictable ID names the session to revoke.
+	if err := svc.RevokeSession(context.Background(), session.AccessToken, "session-1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionIDGeneratorFailureFailsLogin(t *testing.T) {
+	for name, generator := range map[string]SessionIDGenerator{
+		"error": sessionIDGeneratorFunc(func() (string, error) { return "", errors.New("entropy exhausted") }),
+		"empty": sessionIDGeneratorFunc(func() (string, error) { return "", nil }),
+	} {
+		svc := newTestService(t, WithSessionIDGenerator(generator))
+		mustRegister(t, svc, "alice")
+
+		if _, err := svc.Login(context.Background(), "alice", testPassword); err == nil {
+			t.Errorf("%s: Login() succeeded without a session ID", name)
+		}
+	}
+}
+
+func TestDefaultSessionIDsAreRandomUUIDs(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for range 100 {
+		id, err := uuidSessionIDGenerator{}.NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := uuid.Parse(id)
+		if err != nil || parsed.Version() != 4 {
+			t.Fatalf("NewID() = %q, want a version 4 UUID", id)
+		}
+
+		if seen[id] {
+			t.Fatalf("NewID() returned %q twice", id)
+		}
+
+		seen[id] = true
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStatelessSessions is returned under WithStatelessSessions by the
+// operations that need a SessionStore to list or revoke sessions.
+var ErrStatelessSessions = errors.New("not available with stateless sessions")
+
+// startStatelessSession is startSession under WithStatelessSessions: nothing
+// is stored, and the single token issued lasts the whole session since there
+// is no refresh token to renew it with.
+func (u *userService) startStatelessSession(ctx context.Context, userFields UserFields, sessionTTL time.Duration) (LoginResult, error) {
+	tokenType, roles := accessTokenType, userFields.Roles
+	if userFields.MustChangePassword {
+		tokenType, roles = passwordChangeTokenType, nil
+	}
+
+	now := u.clock.Now()
+
+	token, err := u.keys.createStatelessToken(userFields, TenantFromContext(ctx), tokenType, roles, sessionTTL)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while creating token: %w", err)
+	}
+
+	u.recordLogin(ctx, userFields, now)
+	u.audit(ctx, AuditLogin, userFields.Username)
+
+	return LoginResult{AccessToken: token, ExpiresAt: now.Add(sessionTTL), MustChangePassword: userFields.MustChangePassword}, nil
+}
+
+// statelessSession rebuilds the session of a verified token from its claims.
+// The session ID is the token's jti. The account is looked up to check the
+// token was issued for it, and not for an account deleted or renamed since
+// whose username it now holds, that its password did not change since and
+// that it is not suspended. That lookup is the one piece of server-side
+// state stateless sessions keep.
+func (u *userService) statelessSession(ctx context.Context, claims *customClaims) (Session, error) {
+	if claims.Subject == "" {
+		return Session{}, fmt.Errorf("%w: not issued for a stateless session", ErrTokenInvalid)
+	}
+
+	userFields, err := u.users.GetUser(ctx, claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		return Session{}, fmt.Errorf("%w: account no longer exists", ErrTokenInvalid)
+	}
+
+	if err != nil {
+		return Session{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if unixMicro(userFields.CreatedAt) != claims.UserCreatedAt {
+		return Session{}, fmt.Errorf("%w: issued for another account", ErrTokenInvalid)
+	}
+
+	if unixMicro(userFields.PasswordChangedAt) != claims.PasswordChangedAt {
+		return Session{}, fmt.Errorf("%w: password changed since", ErrTokenInvalid)
+	}
+
+	if userFields.Suspended {
+		return Session{}, ErrAccountSuspended
+	}
+
+	return Session{
+		ID:        claims.Id,
+		Username:  claims.Subject,
+		Tenant:    claims.Tenant,
+		CreatedAt: time.Unix(claims.IssuedAt, 0).UTC(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}, nil
+}
+
+// statelessLogout denies the token of claims until it expires.
+func (u *userService) statelessLogout(ctx context.Context, claims *customClaims) error {
+	session, err := u.statelessSession(ctx, claims)
+	if err != nil {
+		return fmt.Errorf("session not registered during logout: %w", err)
+	}
+
+	if err := u.denyToken(ctx, claims); err != nil {
+		return err
+	}
+
+	u.audit(ctx, AuditLogout, session.Username)
+
+	return nil
+}
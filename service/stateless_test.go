@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestStatelessTokenDoesNotOutliveItsAccount deletes an account and
+// registers its username again: the old account's token must not act for
+// the new one.
+func TestStatelessTokenDoesNotOutliveItsAccount(t *testing.T) {
+	svc := newTestService(t, WithStatelessSessions())
+	mustRegister(t, svc, "alice")
+	old := mustLogin(t, svc, "alice")
+
+	if _, err := svc.GetHomeState(context.Background(), old.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() error = %v", err)
+	}
+
+	if err := svc.DeleteAccount(context.Background(), old.AccessToken, testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), old.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with the token of a deleted account succeeded")
+	}
+
+	if _, err := svc.Register(context.Background(), "alice", testPassword, "new-alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), old.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with the token of the deleted account acted for the new one")
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), mustLogin(t, svc, "alice").AccessToken); err != nil {
+		t.Fatalf("GetHomeState() with the new account's token error = %v", err)
+	}
+}
+
+func TestStatelessTokenEndsWithRenameAndPasswordChange(t *testing.T) {
+	svc := newTestService(t, WithStatelessSessions())
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	renamed := mustLogin(t, svc, "alice")
+	if err := svc.RenameUsername(context.Background(), renamed.AccessToken, "alicia"); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob takes the freed name: alice's token names it but was not issued
+	// for bob.
+	bob := mustLogin(t, svc, "bob")
+	if err := svc.RenameUsername(context.Background(), bob.AccessToken, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), renamed.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token issued before the rename succeeded")
+	}
+
+	session, err := svc.Login(context.Background(), "alicia", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with a token issued before the password change succeeded")
+	}
+}
+
+func TestStatelessTokenOfSuspendedAccountIsInactive(t *testing.T) {
+	svc := newTestService(t, WithStatelessSessions())
+	mustRegister(t, svc, "admin", RoleUser, RoleAdmin)
+	mustRegister(t, svc, "alice")
+
+	admin := mustLogin(t, svc, "admin")
+	session := mustLogin(t, svc, "alice")
+
+	if err := svc.SetUserActive(context.Background(), admin.AccessToken, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); !errors.Is(err, ErrAccountSuspended) {
+		t.Fatalf("GetHomeState() error = %v, want ErrAccountSuspended", err)
+	}
+
+	introspection, err := svc.IntrospectToken(context.Background(), session.AccessToken)
+	if err != nil || introspection.Active {
+		t.Fatalf("IntrospectToken() = %+v, %v, want inactive", introspection, err)
+	}
+}
+
+// TestStatefulAndStatelessSessions runs the same operations with and
+// without a session store: they behave alike except that nothing is stored,
+// no refresh token is issued and the session listing fails.
+func TestStatefulAndStatelessSessions(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		var opts []Option
+		if stateless {
+			opts = append(opts, WithStatelessSessions())
+		}
+
+		svc := newTestService(t, opts...)
+		mustRegister(t, svc, "alice")
+		session := mustLogin(t, svc, "alice")
+
+		if got := session.RefreshToken != ""; got == stateless {
+			t.Errorf("stateless %t: refresh token issued %t", stateless, got)
+		}
+
+		stored := 1
+		if stateless {
+			stored = 0
+		}
+
+		if n := storedSessions(svc.sessions); n != stored {
+			t.Errorf("stateless %t: %d sessions stored, want %d", stateless, n, stored)
+		}
+
+		if _, err := svc.SendMainTemplateData(context.Background(), session.AccessToken); err != nil {
+			t.Fatalf("stateless %t: SendMainTemplateData() error = %v", stateless, err)
+		}
+
+		if introspection, err := svc.IntrospectToken(context.Background(), session.AccessToken); err != nil || !introspection.Active || introspection.Username != "alice" {
+			t.Fatalf("stateless %t: IntrospectToken() = %+v, %v, want alice active", stateless, introspection, err)
+		}
+
+		_, err := svc.ListSessions(context.Background(), session.AccessToken, "", 0)
+		if stateless && !errors.Is(err, ErrStatelessSessions) {
+			t.Errorf("ListSessions() with stateless sessions error = %v, want ErrStatelessSessions", err)
+		}
+
+		if !stateless && err != nil {
+			t.Errorf("ListSessions() error = %v", err)
+		}
+
+		if err := svc.Logout(context.Background(), session.AccessToken); err != nil {
+			t.Fatalf("stateless %t: Logout() error = %v", stateless, err)
+		}
+
+		if _, err := svc.SendMainTemplateData(context.Background(), session.AccessToken); err == nil {
+			t.Errorf("stateless %t: SendMainTemplateData() after Logout succeeded", stateless)
+		}
+
+		if introspection, err := svc.IntrospectToken(context.Background(), session.AccessToken); err != nil || introspection.Active {
+			t.Errorf("stateless %t: IntrospectToken() after Logout = %+v, %v, want inactive", stateless, introspection, err)
+		}
+
+		if err := svc.Logout(context.Background(), session.AccessToken); err == nil {
+			t.Errorf("stateless %t: Logout() twice succeeded", stateless)
+		}
+	}
+}
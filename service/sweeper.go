@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const DefaultSessionSweepInterval = time.Minute
+
+// ExpiredSessionSweeper is implemented by SessionStores that only expire
+// sessions lazily. NewUserService calls DeleteExpired every sweep interval so
+// abandoned sessions do not pile up; stores with native expiry, like Redis,
+// do not need it.
+type ExpiredSessionSweeper interface {
+	DeleteExpired(now time.Time) (int, error)
+}
+
+// startSweeper runs the session sweeper until Close is called. It is a no-op
+// when the SessionStore does not implement ExpiredSessionSweeper.
+func (u *userService) startSweeper() {
+	sweeper, ok := u.sessions.(ExpiredSessionSweeper)
+	if !ok {
+		close(u.sweeperDone)
+
+		return
+	}
+
+	go func() {
+		defer close(u.sweeperDone)
+
+		ticker := time.NewTicker(u.sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+				// A failed sweep is retried on the next tick; Get already
+				// hides expired sessions in the meantime.
+				if _, err := sweeper.DeleteExpired(u.clock.Now()); err != nil {
+					_ = level.Warn(u.logger).Log("msg", "error while sweeping expired sessions", "err", err)
+				}
+
+				u.recheckActiveUsers()
+			}
+		}
+	}()
+}
+
+// Close shuts the service down: Readiness starts failing as with
+// BeginShutdown, the background session sweeper is stopped, mail still
+// being sent goes out, and an AuditFlusher sink gets to flush its buffered
+// events. It returns once all
+// of that is done, or with ctx's error once ctx expires first. It is safe to
+// call more than once.
+func (u *userService) Close(ctx context.Context) error {
+	u.BeginShutdown()
+
+	u.closeOnce.Do(func() {
+		close(u.stop)
+	})
+
+	select {
+	case <-u.sweeperDone:
+	case <-ctx.Done():
+		return fmt.Errorf("error while waiting for the session sweeper: %w", ctx.Err())
+	}
+
+	mailsSent := make(chan struct{})
+	go func() {
+		u.mails.Wait()
+		close(mailsSent)
+	}()
+
+	select {
+	case <-mailsSent:
+	case <-ctx.Done():
+		return fmt.Errorf("error while waiting for mail to be sent: %w", ctx.Err())
+	}
+
+	if flusher, ok := u.auditSink.(AuditFlusher); ok {
+		if err := flusher.Flush(ctx); err != nil {
+			return fmt.Errorf("error while flushing audit events: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// storedSessions counts the sessions held by store, expired or not.
+func storedSessions(store SessionStore) int {
+	m := store.(*memorySessionStore)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.sessions)
+}
+
+func TestMemorySessionStoreDeleteExpired(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemorySessionStore()
+	store.(*memorySessionStore).setClock(clock)
+
+	for id, ttl := range map[string]time.Duration{"short": time.Minute, "long": time.Hour, "forever": 0} {
+		if err := store.Set(context.Background(), Session{ID: id, Username: "alice"}, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	deleted, err := store.(ExpiredSessionSweeper).DeleteExpired(clock.Now())
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteExpired() = %d, %v, want 1", deleted, err)
+	}
+
+	if n := storedSessions(store); n != 2 {
+		t.Fatalf("%d sessions left, want 2", n)
+	}
+}
+
+func TestSweeperRemovesExpiredSessions(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithSessionTTL(time.Hour), WithSessionSweepInterval(time.Millisecond))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	if n := storedSessions(svc.sessions); n != 1 {
+		t.Fatalf("%d sessions stored before expiry, want 1", n)
+	}
+
+	clock.Advance(2 * time.Hour)
+
+	// Readers see the session gone whether or not a sweep has run yet.
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+		t.Fatal("GetHomeState() with an expired session succeeded")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for storedSessions(svc.sessions) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the sweeper did not remove the expired session")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// flushingAuditSink is a MemoryAuditSink that counts flushes, blocking in
+// Flush until ctx is done while block is set.
+type flushingAuditSink struct {
+	MemoryAuditSink
+	block   bool
+	flushes int
+}
+
+func (s *flushingAuditSink) Flush(ctx context.Context) error {
+	s.flushes++
+	if s.block {
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+func TestCloseStopsSweeperAndFlushesAudit(t *testing.T) {
+	sink := &flushingAuditSink{}
+
+	svc, err := NewUserService(NewMemoryUserRepository(), NewMemorySessionStore(), WithSessionSweepInterval(time.Millisecond), WithAuditSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sweeperDone := svc.(*userService).sweeperDone
+
+	select {
+	case <-sweeperDone:
+		t.Fatal("no session sweeper running after NewUserService")
+	default:
+	}
+
+	if err := svc.Readiness(context.Background()); err != nil {
+		t.Fatalf("Readiness() before Close error = %v", err)
+	}
+
+	if err := svc.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-sweeperDone:
+	default:
+		t.Fatal("Close returned before the sweeper exited")
+	}
+
+	if sink.flushes != 1 {
+		t.Fatalf("audit sink flushed %d times, want 1", sink.flushes)
+	}
+
+	if err := svc.Readiness(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Readiness() after Close error = %v, want ErrShuttingDown", err)
+	}
+
+	// Closing again is harmless.
+	if err := svc.Close(context.Background()); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+}
+
+func TestCloseGivesUpWhenContextExpires(t *testing.T) {
+	sink := &flushingAuditSink{block: true}
+	svc := newTestService(t, WithAuditSink(sink))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := svc.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close() error = %v, want DeadlineExceeded", err)
+	}
+
+	sink.block = false
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxTenantIDLength bounds tenant IDs, which end up in storage keys.
+const maxTenantIDLength = 64
+
+// ErrInvalidTenant is returned by Register for a tenant ID that breaks the
+// validation rules.
+var ErrInvalidTenant = errors.New("invalid tenant id")
+
+type tenantContextKey struct{}
+
+// ContextWithTenant scopes every call made with the returned context to
+// tenant: usernames only have to be unique within a tenant, and tokens are
+// only accepted under the tenant they were issued for. Requests without a
+// tenant belong to the default tenant, the empty string, so single tenant
+// deployments need not set one. tenant is usually taken from an X-Tenant-ID
+// header and is trimmed and lowercased.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, normalizeTenant(tenant))
+}
+
+// TenantFromContext returns the tenant recorded by ContextWithTenant, or the
+// default tenant.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+
+	return tenant
+}
+
+func normalizeTenant(tenant string) string {
+	return strings.ToLower(strings.TrimSpace(tenant))
+}
+
+// validateTenant accepts the default tenant and IDs made of ASCII letters,
+// digits, '.', '_' and '-'.
+func validateTenant(tenant string) error {
+	if len(tenant) > maxTenantIDLength {
+		return fmt.Errorf("%w: must be at most %d characters long", ErrInvalidTenant, maxTenantIDLength)
+	}
+
+	for i := 0; i < len(tenant); i++ {
+		c := tenant[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '.' && c != '_' && c != '-' {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidTenant, c)
+		}
+	}
+
+	return nil
+}
+
+// tenantKey qualifies username with tenant for the per-user state kept
+// outside the UserRepository, such as lockouts. '/' can appear in neither a
+// username nor a tenant ID, and default tenant keys are the bare username so
+// they match what was stored before tenants existed.
+func tenantKey(tenant, username string) string {
+	if tenant == "" {
+		return username
+	}
+
+	return tenant + "/" + username
+}
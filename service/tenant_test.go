@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTenantsAreIsolated(t *testing.T) {
+	svc := newTestService(t)
+
+	acme := ContextWithTenant(context.Background(), "Acme ")
+	globex := ContextWithTenant(context.Background(), "globex")
+
+	if _, err := svc.Register(acme, "alice", testPassword, "alice@acme.example"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Register(globex, "alice", "0ther-passw0rd", "alice@globex.example"); err != nil {
+		t.Fatalf("Register() of alice in a second tenant error = %v", err)
+	}
+
+	if _, err := svc.Register(ContextWithTenant(context.Background(), "acme"), "alice", testPassword, "alice2@acme.example"); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("Register() of alice twice in one tenant error = %v, want ErrUserAlreadyExists", err)
+	}
+
+	// Each alice logs in with their own password only.
+	if _, err := svc.Login(acme, "alice", "0ther-passw0rd"); err == nil {
+		t.Fatal("Login() to acme with the globex password succeeded")
+	}
+
+	acmeSession, err := svc.Login(acme, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globexSession, err := svc.Login(globex, "alice", "0ther-passw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); err == nil {
+		t.Fatal("Login() to the default tenant succeeded for a user of another tenant")
+	}
+
+	profile, err := svc.GetProfile(acme, acmeSession.AccessToken)
+	if err != nil || profile.Email != "alice@acme.example" {
+		t.Fatalf("GetProfile() in acme = %+v, %v, want acme's alice", profile, err)
+	}
+
+	// Tokens are only accepted under the tenant they were issued for.
+	for _, ctx := range []context.Context{globex, context.Background()} {
+		if _, err := svc.GetHomeState(ctx, acmeSession.AccessToken); err == nil {
+			t.Fatalf("GetHomeState() with an acme token under tenant %q succeeded", TenantFromContext(ctx))
+		}
+
+		if introspection, err := svc.IntrospectToken(ctx, acmeSession.AccessToken); err != nil || introspection.Active {
+			t.Fatalf("IntrospectToken() of an acme token under tenant %q = %+v, %v, want inactive", TenantFromContext(ctx), introspection, err)
+		}
+	}
+
+	if _, err := svc.GetHomeState(globex, globexSession.AccessToken); err != nil {
+		t.Fatalf("GetHomeState() in globex error = %v", err)
+	}
+}
+
+func TestRegisterRejectsInvalidTenants(t *testing.T) {
+	svc := newTestService(t)
+
+	for _, tenant := range []string{"a/b", "acme corp", strings.Repeat("a", maxTenantIDLength+1)} {
+		ctx := ContextWithTenant(context.Background(), tenant)
+
+		if _, err := svc.Register(ctx, "alice", testPassword, "alice@example.com"); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("Register() in tenant %q error = %v, want ErrInvalidTenant", tenant, err)
+		}
+	}
+}
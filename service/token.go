@@ -1,60 +1,261 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"time"
 )
 
+// key is the signing key used when no KeyManager is configured.
 const key = "abc123"
 
+// DefaultTokenTTL is the lifetime given to access tokens when no other is
+// configured. It is kept short because a refresh token can mint a new one.
+const DefaultTokenTTL = 15 * time.Minute
+
+const (
+	accessTokenType         = "access"
+	refreshTokenType        = "refresh"
+	passwordChangeTokenType = "password-change"
+)
+
+var (
+	// ErrTokenExpired is returned by ParseToken when the token's exp claim is
+	// in the past.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenInvalid is returned by ParseToken for any other token that does
+	// not verify: malformed, badly signed, signed with an unknown key or
+	// algorithm, or of the wrong type.
+	ErrTokenInvalid = errors.New("invalid token")
+	// ErrPasswordChangeRequired is returned by ParseToken for the tokens of
+	// CreatePasswordChangeToken, which are only good for ChangePassword.
+	ErrPasswordChangeRequired = errors.New("password change required")
+)
+
+// customClaims name the session a token belongs to in SessionID, or under
+// WithStatelessSessions the user itself in Subject. Every token gets a
+// unique ID in the jti claim.
+//
+// Stateless tokens also carry when the account was created and when its
+// password last changed, in microseconds, which is what the repositories
+// keep. Together with the username they pin the token to one account and
+// one password of it, see userService.statelessSession.
 type customClaims struct {
 	jwt.StandardClaims
-	SessionID string
+	SessionID         string
+	TokenType         string
+	Tenant            string   `json:",omitempty"`
+	Roles             []string `json:",omitempty"`
+	UserCreatedAt     int64    `json:",omitempty"`
+	PasswordChangedAt int64    `json:",omitempty"`
+}
+
+// CreateToken issues an access token for sessionID of tenant valid for ttl,
+// carrying the roles of the session's user.
+func (k *KeyManager) CreateToken(sessionID, tenant string, roles []string, ttl time.Duration) (string, error) {
+	return k.createToken(customClaims{SessionID: sessionID, TokenType: accessTokenType, Tenant: tenant, Roles: roles}, ttl)
+}
+
+// CreateRefreshToken issues a refresh token for sessionID of tenant valid
+// for ttl. It is rejected by ParseToken, so it can only be exchanged for
+// access tokens.
+func (k *KeyManager) CreateRefreshToken(sessionID, tenant string, ttl time.Duration) (string, error) {
+	return k.createToken(customClaims{SessionID: sessionID, TokenType: refreshTokenType, Tenant: tenant}, ttl)
+}
+
+// CreatePasswordChangeToken issues a token for sessionID of tenant valid for
+// ttl that is rejected everywhere but by ParsePasswordChangeToken, for users
+// who must change their password before doing anything else.
+func (k *KeyManager) CreatePasswordChangeToken(sessionID, tenant string, ttl time.Duration) (string, error) {
+	return k.createToken(customClaims{SessionID: sessionID, TokenType: passwordChangeTokenType, Tenant: tenant}, ttl)
+}
+
+// ParsePasswordChangeToken validates a token from CreatePasswordChangeToken
+// issued for tenant and returns its session ID.
+func (k *KeyManager) ParsePasswordChangeToken(token, tenant string) (string, error) {
+	claims, err := k.parseTenantToken(token, tenant, passwordChangeTokenType)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.SessionID, nil
+}
+
+// ParseToken validates an access token issued for tenant and returns its
+// session ID. A token of another tenant fails with ErrTokenInvalid.
+func (k *KeyManager) ParseToken(token, tenant string) (string, error) {
+	claims, err := k.parseTenantToken(token, tenant, accessTokenType)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.SessionID, nil
 }
 
-func CreateToken(sessionID string) (string, error) {
-	claims := &customClaims{
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
-		},
-		SessionID: sessionID,
+// ParseRefreshToken validates a refresh token issued for tenant and returns
+// its session ID.
+func (k *KeyManager) ParseRefreshToken(token, tenant string) (string, error) {
+	claims, err := k.parseTenantToken(token, tenant, refreshTokenType)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return claims.SessionID, nil
+}
 
-	signedToken, err := token.SignedString([]byte(key))
+func (k *KeyManager) parseTenantToken(token, tenant, tokenType string) (*customClaims, error) {
+	claims, err := k.parseToken(token, tokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Tenant != tenant {
+		return nil, fmt.Errorf("%w: issued for another tenant", ErrTokenInvalid)
+	}
+
+	return claims, nil
+}
+
+// hasRole validates an access token of tenant and reports whether it was
+// issued to a user holding role. Roles are read from the token, so a change
+// only shows up once the user gets a new access token. It does not check the
+// Denylist or the session; go through userService.requireRole, which does.
+func (k *KeyManager) hasRole(token, tenant, role string) (bool, error) {
+	claims, err := k.parseTenantToken(token, tenant, accessTokenType)
+	if err != nil {
+		return false, err
+	}
+
+	return containsRole(claims.Roles, role), nil
+}
+
+// createStatelessToken issues a token of tokenType for the account of
+// userFields in tenant that names the user instead of a session, see
+// WithStatelessSessions.
+func (k *KeyManager) createStatelessToken(userFields UserFields, tenant, tokenType string, roles []string, ttl time.Duration) (string, error) {
+	claims := customClaims{
+		StandardClaims:    jwt.StandardClaims{Subject: userFields.Username},
+		TokenType:         tokenType,
+		Tenant:            tenant,
+		Roles:             roles,
+		UserCreatedAt:     unixMicro(userFields.CreatedAt),
+		PasswordChangedAt: unixMicro(userFields.PasswordChangedAt),
+	}
+
+	return k.createToken(claims, ttl)
+}
+
+// unixMicro is t in microseconds since the epoch, or 0 for the zero time.
+func unixMicro(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMicro()
+}
+
+func (k *KeyManager) createToken(claims customClaims, ttl time.Duration) (string, error) {
+	kid, signingKey := k.activeKey()
+
+	now := k.now()
+	claims.Id = uuid.New().String()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	token := jwt.NewWithClaims(k.method, &claims)
+	token.Header["kid"] = kid
+
+	signedToken, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", fmt.Errorf("error while signing JWT: %w", err)
 	}
 
-	return signedToken, nil
+	encryptedToken, err := k.encryptToken(signedToken)
+	if err != nil {
+		return "", fmt.Errorf("error while encrypting JWT: %w", err)
+	}
+
+	return encryptedToken, nil
 }
 
-func ParseToken(token string) (string, error) {
-	parsedToken, err := jwt.ParseWithClaims(token, &customClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("invalid signing method")
+// forbiddenHeaders name keys, or where to fetch them, that the token brings
+// along itself, and extensions the token insists on being understood. Keys
+// only ever come from the KeyManager and no extension is supported, so
+// tokens carrying any of them are forged or meant for someone else.
+var forbiddenHeaders = []string{"jwk", "jku", "x5c", "x5u", "crit"}
+
+// checkHeader vets the header of a token before its signature is checked
+// and returns its kid. The alg must be the manager's own, which rules out
+// "none", and kid, when present, must be a string naming a known key.
+func (k *KeyManager) checkHeader(header map[string]interface{}) (string, error) {
+	if alg, _ := header["alg"].(string); alg != k.method.Alg() {
+		return "", fmt.Errorf("unexpected signing method %q", header["alg"])
+	}
+
+	for _, name := range forbiddenHeaders {
+		if _, ok := header[name]; ok {
+			return "", fmt.Errorf("unexpected %q header", name)
 		}
+	}
 
-		return []byte(key), nil
+	raw, ok := header["kid"]
+	if !ok {
+		return "", nil
+	}
+
+	kid, isString := raw.(string)
+	if !isString || kid == "" {
+		return "", fmt.Errorf("malformed kid header %v", raw)
+	}
+
+	return kid, nil
+}
+
+func (k *KeyManager) parseToken(token, tokenType string) (*customClaims, error) {
+	token, err := k.decryptToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+
+	// jwt-go checks exp against the wall clock, so claims validation is
+	// skipped here and expiry is checked below against the KeyManager's clock.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	parsedToken, err := parser.ParseWithClaims(token, &customClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, err := k.checkHeader(t.Header)
+		if err != nil {
+			return nil, err
+		}
+
+		return k.verificationKey(kid)
 	})
+
 	if err != nil {
-		return "", fmt.Errorf("error while parsing JWT: %s", err)
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
 	}
 
 	if !parsedToken.Valid {
-		return "", fmt.Errorf("invalid token")
+		return nil, ErrTokenInvalid
 	}
 
 	claims, ok := parsedToken.Claims.(*customClaims)
 	if !ok {
-		return "", fmt.Errorf("could not obtain token claims: %T", parsedToken.Claims)
+		return nil, fmt.Errorf("%w: could not obtain token claims: %T", ErrTokenInvalid, parsedToken.Claims)
 	}
 
-	if time.Now().Unix() > claims.ExpiresAt {
-		return "", fmt.Errorf("token expired")
+	if k.now().Unix() > claims.ExpiresAt {
+		return nil, ErrTokenExpired
 	}
 
-	return claims.SessionID, nil
+	if claims.TokenType == passwordChangeTokenType && tokenType != passwordChangeTokenType {
+		return nil, ErrPasswordChangeRequired
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("%w: unexpected token type %q", ErrTokenInvalid, claims.TokenType)
+	}
+
+	return claims, nil
 }
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestExpiredToken(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Millisecond))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if _, err := svc.keys.parseToken(session.AccessToken, accessTokenType); err != nil {
+		t.Fatalf("parseToken() of a fresh token error = %v", err)
+	}
+
+	// exp has a resolution of one second.
+	clock.Advance(time.Second + time.Millisecond)
+
+	if _, err := svc.keys.parseToken(session.AccessToken, accessTokenType); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("parseToken() error = %v, want ErrTokenExpired", err)
+	}
+
+	state, err := svc.GetHomeState(context.Background(), session.AccessToken)
+	if !errors.Is(err, ErrTokenExpired) || !state.SessionExpired {
+		t.Fatalf("GetHomeState() = %+v, %v, want an expired session", state, err)
+	}
+
+	render, _ := svc.SendMainTemplateData(context.Background(), session.AccessToken)
+	if want := defaultCatalogs[language.English][MessageSessionExpired]; render.Variables.LoginMessage != want {
+		t.Fatalf("LoginMessage = %q, want %q", render.Variables.LoginMessage, want)
+	}
+
+	if err := svc.Logout(context.Background(), session.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Logout() error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestTokenTTL(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	claims, err := svc.keys.parseToken(session.AccessToken, accessTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := time.Unix(claims.ExpiresAt, 0), clock.Now().Add(time.Hour); !got.Equal(want) {
+		t.Fatalf("exp = %s, want %s", got, want)
+	}
+}
+
+func TestLoginWithTTL(t *testing.T) {
+	for requested, want := range map[time.Duration]time.Duration{
+		30 * time.Second: time.Minute,
+		2 * time.Hour:    2 * time.Hour,
+		48 * time.Hour:   24 * time.Hour,
+	} {
+		clock := newFakeClock()
+		svc := newTestService(t, WithClock(clock), WithLoginTTLBounds(time.Minute, 24*time.Hour))
+		mustRegister(t, svc, "alice")
+
+		session, err := svc.LoginWithTTL(context.Background(), "alice", testPassword, requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := svc.keys.parseToken(session.AccessToken, accessTokenType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expires := clock.Now().Add(want)
+		if got := time.Unix(claims.ExpiresAt, 0); !got.Equal(expires) || !session.ExpiresAt.Equal(expires) {
+			t.Errorf("ttl %s: exp = %s and ExpiresAt = %s, want %s", requested, got, session.ExpiresAt, expires)
+		}
+
+		// The session lives exactly as long as the token.
+		clock.Advance(want - time.Second)
+
+		if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil {
+			t.Errorf("ttl %s: GetHomeState() just before expiry error = %v", requested, err)
+		}
+
+		if _, err := svc.sessions.Get(context.Background(), claims.SessionID); err != nil {
+			t.Errorf("ttl %s: session just before expiry error = %v", requested, err)
+		}
+
+		clock.Advance(2 * time.Second)
+
+		if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err == nil {
+			t.Errorf("ttl %s: GetHomeState() after expiry succeeded", requested)
+		}
+
+		if _, err := svc.sessions.Get(context.Background(), claims.SessionID); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("ttl %s: session after expiry error = %v, want ErrSessionNotFound", requested, err)
+		}
+	}
+}
+
+func TestLoginWithTTLRejectsNonPositive(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+
+	for _, ttl := range []time.Duration{0, -time.Minute} {
+		if _, err := svc.LoginWithTTL(context.Background(), "alice", testPassword, ttl); !errors.Is(err, ErrInvalidTTL) {
+			t.Errorf("LoginWithTTL(%s) error = %v, want ErrInvalidTTL", ttl, err)
+		}
+	}
+}
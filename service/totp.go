@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// totpIssuer is shown by authenticator apps next to the account name.
+const totpIssuer = "gokit-auth"
+
+var (
+	// ErrTOTPRequired is returned by Login when the password is right but the
+	// account has two-factor authentication on; retry with LoginTOTP.
+	ErrTOTPRequired = errors.New("two-factor code required")
+	// ErrInvalidTOTPCode is returned when a two-factor code does not match.
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrTOTPAlreadyEnabled is returned by EnableTOTP once ConfirmTOTP has
+	// succeeded for the account.
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	// ErrTOTPNotPending is returned by ConfirmTOTP when EnableTOTP was not
+	// called first.
+	ErrTOTPNotPending = errors.New("two-factor authentication not pending confirmation")
+)
+
+// totpValidateOpts accepts codes from one 30 second step either side of now
+// to absorb clock skew between the server and the authenticator.
+var totpValidateOpts = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// EnableTOTP generates a new secret for the user owning token and stores it
+// as pending. Two-factor authentication only starts being enforced once the
+// secret is confirmed with ConfirmTOTP. otpauthURL is meant to be rendered as
+// a QR code for authenticator apps.
+func (u *userService) EnableTOTP(ctx context.Context, token string) (secret string, otpauthURL string, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return "", "", fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if userFields.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error while generating totp secret: %w", err)
+	}
+
+	userFields.TOTPSecret = key.Secret()
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return "", "", fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return key.Secret(), key.URL(), nil
+}
+
+// ConfirmTOTP activates the secret stored by EnableTOTP once the user proves
+// their authenticator produces matching codes.
+func (u *userService) ConfirmTOTP(ctx context.Context, token, code string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	if userFields.TOTPEnabled {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	if userFields.TOTPSecret == "" {
+		return ErrTOTPNotPending
+	}
+
+	if !validateTOTP(code, userFields.TOTPSecret, u.clock.Now()) {
+		return ErrInvalidTOTPCode
+	}
+
+	userFields.TOTPEnabled = true
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	return nil
+}
+
+// LoginTOTP is the second step of Login for accounts with two-factor
+// authentication on.
+func (u *userService) LoginTOTP(ctx context.Context, user, pass, code string) (LoginResult, error) {
+	return u.LoginWithOptions(ctx, user, pass, LoginOptions{TOTPCode: code})
+}
+
+func validateTOTP(code, secret string, now time.Time) bool {
+	ok, err := totp.ValidateCustom(code, secret, now, totpValidateOpts)
+
+	return err == nil && ok
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// mustTOTPCode generates the code an authenticator holding secret shows at
+// at.
+func mustTOTPCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.GenerateCode(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestTOTP(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	if err := svc.ConfirmTOTP(context.Background(), session.AccessToken, "123456"); !errors.Is(err, ErrTOTPNotPending) {
+		t.Fatalf("ConfirmTOTP() before EnableTOTP error = %v, want ErrTOTPNotPending", err)
+	}
+
+	secret, url, err := svc.EnableTOTP(context.Background(), session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(url, "otpauth://totp/") || !strings.Contains(url, "secret="+secret) {
+		t.Fatalf("EnableTOTP() url = %q, want an otpauth URL carrying the secret", url)
+	}
+
+	// A pending secret is not enforced yet.
+	mustLogin(t, svc, "alice")
+
+	if err := svc.ConfirmTOTP(context.Background(), session.AccessToken, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("ConfirmTOTP() with a wrong code error = %v, want ErrInvalidTOTPCode", err)
+	}
+
+	if err := svc.ConfirmTOTP(context.Background(), session.AccessToken, mustTOTPCode(t, secret, clock.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := svc.EnableTOTP(context.Background(), session.AccessToken); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("EnableTOTP() once enabled error = %v, want ErrTOTPAlreadyEnabled", err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("Login() without a code error = %v, want ErrTOTPRequired", err)
+	}
+
+	if _, err := svc.LoginTOTP(context.Background(), "alice", testPassword, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("LoginTOTP() with a wrong code error = %v, want ErrInvalidTOTPCode", err)
+	}
+
+	clock.Advance(time.Minute)
+
+	for _, skew := range []time.Duration{0, -30 * time.Second, 30 * time.Second} {
+		if _, err := svc.LoginTOTP(context.Background(), "alice", testPassword, mustTOTPCode(t, secret, clock.Now().Add(skew))); err != nil {
+			t.Errorf("LoginTOTP() with a code %s off error = %v", skew, err)
+		}
+	}
+
+	if _, err := svc.LoginTOTP(context.Background(), "alice", testPassword, mustTOTPCode(t, secret, clock.Now().Add(-90*time.Second))); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("LoginTOTP() with a code three steps old error = %v, want ErrInvalidTOTPCode", err)
+	}
+}
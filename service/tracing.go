@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingMiddleware struct {
+	tracer trace.Tracer
+	next   UserService
+}
+
+// NewTracingMiddleware starts an OpenTelemetry span named after each method
+// as a child of the span carried by the call's context, and hands the new
+// span's context on to the next UserService. Only the method name is
+// recorded; passwords and tokens never become span attributes.
+func NewTracingMiddleware(tracer trace.Tracer) Middleware {
+	return func(next UserService) UserService {
+		return &tracingMiddleware{
+			tracer: tracer,
+			next:   next,
+		}
+	}
+}
+
+func (mw *tracingMiddleware) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return mw.tracer.Start(
+		ctx,
+		"UserService."+method,
+		trace.WithAttributes(attribute.String("method", method)),
+	)
+}
+
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+func (mw *tracingMiddleware) HealthCheck(ctx context.Context) HealthStatus {
+	ctx, span := mw.start(ctx, "HealthCheck")
+	defer finishSpan(span, nil)
+
+	return mw.next.HealthCheck(ctx)
+}
+
+func (mw *tracingMiddleware) Liveness() (err error) {
+	_, span := mw.start(context.Background(), "Liveness")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Liveness()
+}
+
+func (mw *tracingMiddleware) Readiness(ctx context.Context) (err error) {
+	ctx, span := mw.start(ctx, "Readiness")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Readiness(ctx)
+}
+
+func (mw *tracingMiddleware) BeginShutdown() {
+	mw.next.BeginShutdown()
+}
+
+func (mw *tracingMiddleware) SetRegistrationEnabled(enabled bool) {
+	mw.next.SetRegistrationEnabled(enabled)
+}
+
+func (mw *tracingMiddleware) Close(ctx context.Context) error {
+	return mw.next.Close(ctx)
+}
+
+func (mw *tracingMiddleware) GetHomeState(ctx context.Context, token string) (state HomeState, err error) {
+	ctx, span := mw.start(ctx, "GetHomeState")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.GetHomeState(ctx, token)
+}
+
+func (mw *tracingMiddleware) SendMainTemplateData(ctx context.Context, token string) (render TemplateRender, err error) {
+	ctx, span := mw.start(ctx, "SendMainTemplateData")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.SendMainTemplateData(ctx, token)
+}
+
+func (mw *tracingMiddleware) Register(ctx context.Context, user, pass, email string, roles ...string) (response string, err error) {
+	ctx, span := mw.start(ctx, "Register")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Register(ctx, user, pass, email, roles...)
+}
+
+func (mw *tracingMiddleware) RegisterWithInvite(ctx context.Context, user, pass, email, code string) (response string, err error) {
+	ctx, span := mw.start(ctx, "RegisterWithInvite")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RegisterWithInvite(ctx, user, pass, email, code)
+}
+
+func (mw *tracingMiddleware) RegisterBatch(ctx context.Context, users []NewUser) (result BatchResult, err error) {
+	ctx, span := mw.start(ctx, "RegisterBatch")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RegisterBatch(ctx, users)
+}
+
+func (mw *tracingMiddleware) IsUsernameAvailable(ctx context.Context, username string) (available bool, err error) {
+	ctx, span := mw.start(ctx, "IsUsernameAvailable")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.IsUsernameAvailable(ctx, username)
+}
+
+func (mw *tracingMiddleware) CreateUser(ctx context.Context, token, user, pass, email string, roles ...string) (err error) {
+	ctx, span := mw.start(ctx, "CreateUser")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.CreateUser(ctx, token, user, pass, email, roles...)
+}
+
+func (mw *tracingMiddleware) CreateInvite(ctx context.Context, token string) (code string, err error) {
+	ctx, span := mw.start(ctx, "CreateInvite")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.CreateInvite(ctx, token)
+}
+
+func (mw *tracingMiddleware) Login(ctx context.Context, user, pass string) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "Login")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Login(ctx, user, pass)
+}
+
+func (mw *tracingMiddleware) LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "LoginWithOptions")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.LoginWithOptions(ctx, user, pass, opts)
+}
+
+func (mw *tracingMiddleware) LoginTOTP(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "LoginTOTP")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.LoginTOTP(ctx, user, pass, code)
+}
+
+func (mw *tracingMiddleware) LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "LoginWithTTL")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.LoginWithTTL(ctx, user, pass, ttl)
+}
+
+func (mw *tracingMiddleware) BeginPasskeyRegistration(ctx context.Context, token string) (creation *protocol.CredentialCreation, err error) {
+	ctx, span := mw.start(ctx, "BeginPasskeyRegistration")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.BeginPasskeyRegistration(ctx, token)
+}
+
+func (mw *tracingMiddleware) FinishPasskeyRegistration(ctx context.Context, token string, credential []byte) (err error) {
+	ctx, span := mw.start(ctx, "FinishPasskeyRegistration")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.FinishPasskeyRegistration(ctx, token, credential)
+}
+
+func (mw *tracingMiddleware) BeginPasskeyLogin(ctx context.Context, username string) (assertion *protocol.CredentialAssertion, err error) {
+	ctx, span := mw.start(ctx, "BeginPasskeyLogin")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.BeginPasskeyLogin(ctx, username)
+}
+
+func (mw *tracingMiddleware) FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "FinishPasskeyLogin")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.FinishPasskeyLogin(ctx, username, credential)
+}
+
+func (mw *tracingMiddleware) OAuthLogin(ctx context.Context, provider, code string) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "OAuthLogin")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.OAuthLogin(ctx, provider, code)
+}
+
+func (mw *tracingMiddleware) EnableTOTP(ctx context.Context, token string) (secret string, otpauthURL string, err error) {
+	ctx, span := mw.start(ctx, "EnableTOTP")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.EnableTOTP(ctx, token)
+}
+
+func (mw *tracingMiddleware) ConfirmTOTP(ctx context.Context, token, code string) (err error) {
+	ctx, span := mw.start(ctx, "ConfirmTOTP")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ConfirmTOTP(ctx, token, code)
+}
+
+func (mw *tracingMiddleware) GenerateRecoveryCodes(ctx context.Context, token string) (codes []string, err error) {
+	ctx, span := mw.start(ctx, "GenerateRecoveryCodes")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.GenerateRecoveryCodes(ctx, token)
+}
+
+func (mw *tracingMiddleware) LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (result LoginResult, err error) {
+	ctx, span := mw.start(ctx, "LoginWithRecoveryCode")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.LoginWithRecoveryCode(ctx, user, pass, code)
+}
+
+func (mw *tracingMiddleware) Refresh(ctx context.Context, refreshToken string) (token string, err error) {
+	ctx, span := mw.start(ctx, "Refresh")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Refresh(ctx, refreshToken)
+}
+
+func (mw *tracingMiddleware) Logout(ctx context.Context, token string) (err error) {
+	ctx, span := mw.start(ctx, "Logout")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.Logout(ctx, token)
+}
+
+func (mw *tracingMiddleware) GetProfile(ctx context.Context, token string) (profile Profile, err error) {
+	ctx, span := mw.start(ctx, "GetProfile")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.GetProfile(ctx, token)
+}
+
+func (mw *tracingMiddleware) IntrospectToken(ctx context.Context, token string) (introspection Introspection, err error) {
+	ctx, span := mw.start(ctx, "IntrospectToken")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.IntrospectToken(ctx, token)
+}
+
+func (mw *tracingMiddleware) PublicJWKS(ctx context.Context) (set JSONWebKeySet, err error) {
+	ctx, span := mw.start(ctx, "PublicJWKS")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.PublicJWKS(ctx)
+}
+
+func (mw *tracingMiddleware) ListSessions(ctx context.Context, token, cursor string, limit int) (page SessionPage, err error) {
+	ctx, span := mw.start(ctx, "ListSessions")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ListSessions(ctx, token, cursor, limit)
+}
+
+func (mw *tracingMiddleware) RevokeAllSessions(ctx context.Context, token string) (err error) {
+	ctx, span := mw.start(ctx, "RevokeAllSessions")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RevokeAllSessions(ctx, token)
+}
+
+func (mw *tracingMiddleware) RevokeSession(ctx context.Context, token, sessionID string) (err error) {
+	ctx, span := mw.start(ctx, "RevokeSession")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RevokeSession(ctx, token, sessionID)
+}
+
+func (mw *tracingMiddleware) SetUserActive(ctx context.Context, token, username string, active bool) (err error) {
+	ctx, span := mw.start(ctx, "SetUserActive")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.SetUserActive(ctx, token, username, active)
+}
+
+func (mw *tracingMiddleware) ListUsers(ctx context.Context, token string, offset, limit int) (page UserPage, err error) {
+	ctx, span := mw.start(ctx, "ListUsers")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ListUsers(ctx, token, offset, limit)
+}
+
+func (mw *tracingMiddleware) SessionStats(ctx context.Context, token string) (stats SessionStats, err error) {
+	ctx, span := mw.start(ctx, "SessionStats")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.SessionStats(ctx, token)
+}
+
+func (mw *tracingMiddleware) ChangePassword(ctx context.Context, token, oldPass, newPass string) (err error) {
+	ctx, span := mw.start(ctx, "ChangePassword")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ChangePassword(ctx, token, oldPass, newPass)
+}
+
+func (mw *tracingMiddleware) RenameUsername(ctx context.Context, token, newUsername string) (err error) {
+	ctx, span := mw.start(ctx, "RenameUsername")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RenameUsername(ctx, token, newUsername)
+}
+
+func (mw *tracingMiddleware) DeleteAccount(ctx context.Context, token, password string) (err error) {
+	ctx, span := mw.start(ctx, "DeleteAccount")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.DeleteAccount(ctx, token, password)
+}
+
+func (mw *tracingMiddleware) GenerateVerificationToken(ctx context.Context, username string) (token string, err error) {
+	ctx, span := mw.start(ctx, "GenerateVerificationToken")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.GenerateVerificationToken(ctx, username)
+}
+
+func (mw *tracingMiddleware) VerifyEmail(ctx context.Context, token string) (err error) {
+	ctx, span := mw.start(ctx, "VerifyEmail")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.VerifyEmail(ctx, token)
+}
+
+func (mw *tracingMiddleware) ResendVerification(ctx context.Context, usernameOrEmail string) (err error) {
+	ctx, span := mw.start(ctx, "ResendVerification")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ResendVerification(ctx, usernameOrEmail)
+}
+
+func (mw *tracingMiddleware) RequestPasswordReset(ctx context.Context, usernameOrEmail string) (token string, err error) {
+	ctx, span := mw.start(ctx, "RequestPasswordReset")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.RequestPasswordReset(ctx, usernameOrEmail)
+}
+
+func (mw *tracingMiddleware) ResetPassword(ctx context.Context, resetToken, newPass string) (err error) {
+	ctx, span := mw.start(ctx, "ResetPassword")
+	defer func() { finishSpan(span, err) }()
+
+	return mw.next.ResetPassword(ctx, resetToken, newPass)
+}
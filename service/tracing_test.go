@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordedSpan is what recordingTracer keeps of an ended span.
+type recordedSpan struct {
+	name       string
+	attributes []attribute.KeyValue
+	status     codes.Code
+	parent     trace.SpanContext
+}
+
+// recordingTracer records every span it starts once it ends, standing in
+// for an SDK tracer with an in-memory exporter.
+type recordingTracer struct {
+	embedded.Tracer
+
+	mu    sync.Mutex
+	ended []recordedSpan
+	next  byte
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tr.mu.Lock()
+	tr.next++
+	id := tr.next
+	tr.mu.Unlock()
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{id}})
+	config := trace.NewSpanStartConfig(opts...)
+
+	span := &recordingSpan{
+		Span:   noop.Span{},
+		tracer: tr,
+		id:     spanContext,
+		span: recordedSpan{
+			name:       name,
+			attributes: config.Attributes(),
+			parent:     trace.SpanContextFromContext(ctx),
+		},
+	}
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (tr *recordingTracer) spans() []recordedSpan {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return append([]recordedSpan(nil), tr.ended...)
+}
+
+type recordingSpan struct {
+	trace.Span
+
+	tracer *recordingTracer
+	id     trace.SpanContext
+	span   recordedSpan
+}
+
+func (s *recordingSpan) SpanContext() trace.SpanContext { return s.id }
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) { s.span.status = code }
+
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.span.attributes = append(s.span.attributes, kv...)
+}
+
+func (s *recordingSpan) End(...trace.SpanEndOption) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.tracer.ended = append(s.tracer.ended, s.span)
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	tracer := &recordingTracer{}
+	svc := NewTracingMiddleware(tracer)(newTestService(t))
+
+	parentCtx, parent := tracer.Start(context.Background(), "request")
+
+	if _, err := svc.Register(parentCtx, "alice", testPassword, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(parentCtx, "alice", "wrong-passw0rd"); err == nil {
+		t.Fatal("Login() with a wrong password succeeded")
+	}
+
+	spans := tracer.spans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended, want one per call", len(spans))
+	}
+
+	for i, want := range []struct {
+		name   string
+		status codes.Code
+	}{
+		{"UserService.Register", codes.Unset},
+		{"UserService.Login", codes.Error},
+	} {
+		span := spans[i]
+		if span.name != want.name || span.status != want.status {
+			t.Errorf("span %d = %s with status %s, want %s with status %s", i, span.name, span.status, want.name, want.status)
+		}
+
+		if !span.parent.Equal(parent.SpanContext()) {
+			t.Errorf("span %s is not a child of the caller's span", span.name)
+		}
+
+		for _, kv := range span.attributes {
+			if value := kv.Value.Emit(); strings.Contains(value, testPassword) || strings.Contains(value, "wrong-passw0rd") {
+				t.Errorf("span %s attribute %s = %q leaks the password", span.name, kv.Key, value)
+			}
+		}
+	}
+}
@@ -1,31 +1,266 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const MainTemplate = "main.gohtml"
 
+var (
+	// ErrIncorrectPassword is returned when a password supplied to confirm
+	// an operation does not match the stored hash.
+	ErrIncorrectPassword = errors.New("incorrect password")
+	// ErrPasswordUnchanged is returned by ChangePassword when the new
+	// password is the same as the current one.
+	ErrPasswordUnchanged = errors.New("new password must differ from the current one")
+	// ErrUserAlreadyExists is returned by Register when the username is
+	// taken, and by UserRepository.CreateUser and RenameUser.
+	ErrUserAlreadyExists = errors.New("user already registered")
+	// ErrEmailAlreadyRegistered is returned by Register when another account
+	// of the tenant holds the email, and by UserRepository.CreateUser and
+	// SaveUser. Emails are unique so that logging in, resetting a password
+	// or signing in through OAuth by email can only ever mean one account.
+	ErrEmailAlreadyRegistered = errors.New("email already registered")
+	// ErrRegistrationDisabled is returned by Register and RegisterWithInvite
+	// while SetRegistrationEnabled(false) is in effect.
+	ErrRegistrationDisabled = errors.New("registration is disabled")
+	// ErrInvalidCredentials is returned by Login when the username or the
+	// password is wrong, without saying which.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrInvalidTTL is returned by LoginWithTTL for a TTL that is not
+	// positive.
+	ErrInvalidTTL = errors.New("invalid ttl")
+)
+
 type UserService interface {
-	HealthCheck() string
-	SendMainTemplateData(token string) (TemplateRender, error)
-	Register(user, pass string) (string, error)
-	Login(user, pass string) (string, error)
-	Logout(token string) error
+	HealthCheck(ctx context.Context) HealthStatus
+	Liveness() error
+	Readiness(ctx context.Context) error
+	BeginShutdown()
+	Close(ctx context.Context) error
+	SetRegistrationEnabled(enabled bool)
+	SendMainTemplateData(ctx context.Context, token string) (TemplateRender, error)
+	GetHomeState(ctx context.Context, token string) (HomeState, error)
+	Register(ctx context.Context, user, pass, email string, roles ...string) (string, error)
+	RegisterWithInvite(ctx context.Context, user, pass, email, code string) (string, error)
+	RegisterBatch(ctx context.Context, users []NewUser) (BatchResult, error)
+	IsUsernameAvailable(ctx context.Context, username string) (bool, error)
+	CreateUser(ctx context.Context, token, user, pass, email string, roles ...string) error
+	CreateInvite(ctx context.Context, token string) (string, error)
+	Login(ctx context.Context, user, pass string) (LoginResult, error)
+	LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (LoginResult, error)
+	LoginTOTP(ctx context.Context, user, pass, code string) (LoginResult, error)
+	LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (LoginResult, error)
+	EnableTOTP(ctx context.Context, token string) (secret string, otpauthURL string, err error)
+	BeginPasskeyRegistration(ctx context.Context, token string) (*protocol.CredentialCreation, error)
+	FinishPasskeyRegistration(ctx context.Context, token string, credential []byte) error
+	BeginPasskeyLogin(ctx context.Context, username string) (*protocol.CredentialAssertion, error)
+	FinishPasskeyLogin(ctx context.Context, username string, credential []byte) (LoginResult, error)
+	OAuthLogin(ctx context.Context, provider, code string) (LoginResult, error)
+	ConfirmTOTP(ctx context.Context, token, code string) error
+	GenerateRecoveryCodes(ctx context.Context, token string) ([]string, error)
+	LoginWithRecoveryCode(ctx context.Context, user, pass, code string) (LoginResult, error)
+	Refresh(ctx context.Context, refreshToken string) (string, error)
+	Logout(ctx context.Context, token string) error
+	GetProfile(ctx context.Context, token string) (Profile, error)
+	IntrospectToken(ctx context.Context, token string) (Introspection, error)
+	PublicJWKS(ctx context.Context) (JSONWebKeySet, error)
+	ListSessions(ctx context.Context, token, cursor string, limit int) (SessionPage, error)
+	RevokeAllSessions(ctx context.Context, token string) error
+	RevokeSession(ctx context.Context, token, sessionID string) error
+	ListUsers(ctx context.Context, token string, offset, limit int) (UserPage, error)
+	SessionStats(ctx context.Context, token string) (SessionStats, error)
+	SetUserActive(ctx context.Context, token, username string, active bool) error
+	ChangePassword(ctx context.Context, token, oldPass, newPass string) error
+	DeleteAccount(ctx context.Context, token, password string) error
+	RenameUsername(ctx context.Context, token, newUsername string) error
+	GenerateVerificationToken(ctx context.Context, username string) (string, error)
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, usernameOrEmail string) error
+	RequestPasswordReset(ctx context.Context, usernameOrEmail string) (string, error)
+	ResetPassword(ctx context.Context, resetToken, newPass string) error
 }
 
 type userService struct {
-	users    map[string]UserFields
-	sessions map[string]string
+	mu             sync.RWMutex
+	users          UserRepository
+	sessions       SessionStore
+	refreshTokens  RefreshTokenStore
+	oneTimeTokens  OneTimeTokenStore
+	mailer         Mailer
+	sessionIDs     SessionIDGenerator
+	webAuthn       *webauthn.WebAuthn
+	oauthProviders map[string]OAuthProvider
+	keys           *KeyManager
+	encryptionKey  []byte
+	localizer      *Localizer
+	clock          Clock
+	logger         log.Logger
+	auditSink      AuditSink
+
+	sessionTTL          time.Duration
+	idleTimeout         time.Duration
+	tokenTTL            time.Duration
+	refreshTTL          time.Duration
+	rememberMeTTL       time.Duration
+	minLoginTTL         time.Duration
+	maxLoginTTL         time.Duration
+	verificationTTL     time.Duration
+	verificationResend  time.Duration
+	resetTTL            time.Duration
+	passkeyChallengeTTL time.Duration
+	inviteTTL           time.Duration
+	bcryptCost          int
+	hasher              Hasher
+	// hashSlots bounds how many hashes run at once, see WithHashConcurrency.
+	hashSlots       chan struct{}
+	hashConcurrency int
+	pepper          []byte
+
+	// dummyHash is compared against when Login is given an unknown username
+	// so that it takes as long as a wrong password would.
+	dummyHash string
+
+	passwordPolicy    PasswordPolicy
+	emailDomainPolicy *EmailDomainPolicy
+	breachChecker     BreachChecker
+	breachThreshold   int
+	reservedUsernames map[string]struct{}
+	usernamePolicy    *UsernamePolicy
+
+	failurePolicies map[Integration]FailurePolicy
+	integrations    *integrationMonitor
+
+	maxPasswordAge       time.Duration
+	strictPasswordExpiry bool
+	passwordHistory      int
+
+	requireVerifiedEmail bool
+	newLoginAlerts       bool
+	inviteOnly           bool
+	registrationDisabled bool
+	// registrationDisabledAtStart is what WithRegistrationEnabled set, for
+	// Reset to restore.
+	registrationDisabledAtStart bool
+
+	maxSessionsPerUser int
+	evictOldestSession bool
+
+	statelessSessions bool
+	denylist          Denylist
+
+	loginAttempts *loginAttempts
+
+	activeUsers   *activeUsers
+	registrations metrics.Counter
+
+	shuttingDown atomic.Bool
+
+	sweepInterval time.Duration
+	stop          chan struct{}
+	closeOnce     sync.Once
+	sweeperDone   chan struct{}
+	mails         sync.WaitGroup
 }
 
 type UserFields struct {
-	Username       string
+	Username string
+	// Tenant scopes Username, see ContextWithTenant.
+	Tenant         string
 	HashedPassword string
+	Email          string
+	EmailVerified  bool
+	Roles          []string
+	// TOTPSecret is set by EnableTOTP; it is only enforced at login once
+	// TOTPEnabled is set by ConfirmTOTP.
+	TOTPSecret  string
+	TOTPEnabled bool
+	CreatedAt   time.Time
+	// LastLoginAt is updated by every successful Login.
+	LastLoginAt time.Time
+	// Suspended accounts cannot log in, see SetUserActive.
+	Suspended bool
+	// PasskeyUserID is the WebAuthn user handle, generated by the first
+	// BeginPasskeyRegistration, and Passkeys the credentials registered
+	// since.
+	PasskeyUserID []byte
+	Passkeys      []webauthn.Credential
+	// OAuthProvider and OAuthSubject are set on accounts provisioned by
+	// OAuthLogin, which have no password.
+	OAuthProvider string
+	OAuthSubject  string
+	// RecoveryCodeHashes hold the hashes of the recovery codes from
+	// GenerateRecoveryCodes not used yet.
+	RecoveryCodeHashes []string
+	// PasswordChangedAt is set whenever the password is, see
+	// WithMaxPasswordAge.
+	PasswordChangedAt time.Time
+	// PasswordHistory holds the hashes of the latest passwords, newest first
+	// and the current one included, see WithPasswordHistory.
+	PasswordHistory []string
+	// MustChangePassword is set on accounts created by an admin with a
+	// temporary password and cleared by ChangePassword. Until then Login
+	// only hands out a token ChangePassword accepts.
+	MustChangePassword bool
+}
+
+// LoginResult carries the tokens issued by a successful Login. AccessToken is
+// short-lived; RefreshToken can be exchanged through Refresh for a new
+// AccessToken until it expires or the session is logged out. ExpiresAt is
+// when both the session and RefreshToken run out. MustChangePassword is set
+// when the password is older than WithMaxPasswordAge, or is a temporary one
+// set by an admin; clients should send the user to ChangePassword. In the
+// latter case AccessToken is only good for ChangePassword and there is no
+// RefreshToken.
+type LoginResult struct {
+	AccessToken        string
+	RefreshToken       string
+	ExpiresAt          time.Time
+	MustChangePassword bool
+}
+
+// LoginOptions tunes a single LoginWithOptions call. RememberMe keeps the
+// session and its refresh token for the remember-me TTL instead of the
+// regular session TTL; access tokens stay short-lived either way. TOTPCode is
+// required for accounts with two-factor authentication enabled, unless
+// RecoveryCode carries one of their recovery codes instead. UserAgent and
+// IP describe the client and are kept on the session so ListSessions can tell
+// devices apart; IP may carry a port and is dropped if it does not parse.
+// TTL, when set, is how long the session, its refresh token and its access
+// token all last, clamped to the bounds of WithLoginTTLBounds; it takes
+// precedence over RememberMe.
+type LoginOptions struct {
+	RememberMe   bool
+	TOTPCode     string
+	RecoveryCode string
+	UserAgent    string
+	IP           string
+	TTL          time.Duration
+}
+
+// HomeState is what the main page shows, free of any template: whether
+// token resolved to a live session and whose it is. SessionExpired is set
+// when the token was valid but has run out, so clients can ask the user to
+// log in again.
+type HomeState struct {
+	LoggedIn       bool   `json:"logged_in"`
+	Username       string `json:"username,omitempty"`
+	SessionExpired bool   `json:"session_expired,omitempty"`
 }
 
 type TemplateRender struct {
@@ -37,87 +272,616 @@ type TemplateMetadata struct {
 	Name string
 }
 
+// TemplateVariables are plain text: User and the messages may echo user
+// input, so none of them is template.HTML and html/template escapes them all
+// for the context they are rendered in.
 type TemplateVariables struct {
 	Name         string
 	LoginMessage string
 	ErrorMessage error
 	Session      string
 	User         string
+	// CSRFToken is filled in by the transport, not the service, and must be
+	// echoed by every form that posts back.
+	CSRFToken string
 }
 
-func NewUserService() UserService {
-	return &userService{
-		users:    make(map[string]UserFields),
-		sessions: make(map[string]string),
+func NewUserService(users UserRepository, sessions SessionStore, opts ...Option) (UserService, error) {
+	keys, err := NewKeyManager(AlgorithmHS256, defaultKeyID, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating key manager: %w", err)
 	}
+
+	svc := &userService{
+		users:         users,
+		sessions:      sessions,
+		refreshTokens: NewMemoryRefreshTokenStore(),
+		oneTimeTokens: NewMemoryOneTimeTokenStore(),
+		mailer:        nopMailer{},
+		sessionIDs:    uuidSessionIDGenerator{},
+		integrations:  newIntegrationMonitor(),
+		denylist:      NewMemoryDenylist(),
+		keys:          keys,
+		localizer:     DefaultLocalizer(),
+		clock:         realClock{},
+		logger:        log.NewNopLogger(),
+		auditSink:     nopAuditSink{},
+		registrations: discard.NewCounter(),
+
+		sessionTTL:          DefaultSessionTTL,
+		tokenTTL:            DefaultTokenTTL,
+		refreshTTL:          DefaultRefreshTokenTTL,
+		rememberMeTTL:       DefaultRememberMeTTL,
+		minLoginTTL:         DefaultMinLoginTTL,
+		maxLoginTTL:         DefaultMaxLoginTTL,
+		verificationTTL:     DefaultVerificationTokenTTL,
+		verificationResend:  DefaultVerificationResendInterval,
+		resetTTL:            DefaultPasswordResetTokenTTL,
+		passkeyChallengeTTL: DefaultPasskeyChallengeTTL,
+		inviteTTL:           DefaultInviteTTL,
+		bcryptCost:          bcrypt.DefaultCost,
+		hashConcurrency:     runtime.GOMAXPROCS(0),
+
+		passwordPolicy:    DefaultPasswordPolicy(),
+		reservedUsernames: make(map[string]struct{}),
+		failurePolicies:   defaultFailurePolicies(),
+
+		loginAttempts: newLoginAttempts(DefaultLockoutThreshold, DefaultLockoutDuration),
+
+		sweepInterval: DefaultSessionSweepInterval,
+		stop:          make(chan struct{}),
+		sweeperDone:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if err := opt(svc); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	if svc.statelessSessions && (svc.idleTimeout > 0 || svc.maxSessionsPerUser > 0 || svc.newLoginAlerts) {
+		return nil, errors.New("stateless sessions cannot be combined with an idle timeout, a session limit or new login alerts")
+	}
+
+	svc.registrationDisabledAtStart = svc.registrationDisabled
+
+	if svc.hasher == nil {
+		svc.hasher = bcryptHasher{cost: svc.bcryptCost}
+	}
+
+	svc.hashSlots = make(chan struct{}, svc.hashConcurrency)
+
+	dummyHash, err := svc.hashValue(context.Background(), "gokit-auth dummy password")
+	if err != nil {
+		return nil, fmt.Errorf("error while hashing dummy password: %w", err)
+	}
+
+	svc.dummyHash = dummyHash
+
+	if svc.encryptionKey != nil {
+		if svc.keys.hasEncryptionKey(encryptionKeyID) {
+			return nil, fmt.Errorf("encryption key id %q is already registered with the KeyManager", encryptionKeyID)
+		}
+
+		if err := svc.keys.AddEncryptionKey(encryptionKeyID, svc.encryptionKey); err != nil {
+			return nil, fmt.Errorf("error while adding encryption key: %w", err)
+		}
+
+		if err := svc.keys.SetActiveEncryptionKey(encryptionKeyID); err != nil {
+			return nil, fmt.Errorf("error while adding encryption key: %w", err)
+		}
+	}
+
+	shareClock(svc.clock, svc.sessions, svc.refreshTokens, svc.oneTimeTokens, svc.denylist, svc.keys, svc.integrations)
+
+	svc.startSweeper()
+
+	return svc, nil
 }
 
-func (u userService) HealthCheck() string {
-	return "ok"
+// SendMainTemplateData renders the main page from GetHomeState, showing the
+// flash message of ContextWithFlash unless the session just expired. Flash
+// keys the Localizer does not know are ignored, so a forged cookie cannot
+// put text of its choosing on the page.
+func (u *userService) SendMainTemplateData(ctx context.Context, token string) (TemplateRender, error) {
+	state, err := u.GetHomeState(ctx, token)
+
+	render := newAnonymousRender()
+	if state.LoggedIn {
+		render = newAuthenticatedRender(token, state.Username)
+	}
+
+	switch flash := FlashFromContext(ctx); {
+	case state.SessionExpired:
+		render.Variables.LoginMessage = u.localizer.Localize(ctx, MessageSessionExpired)
+	case flash != "" && u.localizer.has(flash):
+		render.Variables.LoginMessage = u.localizer.Localize(ctx, flash)
+	}
+
+	return render, err
+}
+
+// newAnonymousRender is the main page for a visitor without a session. It
+// and newAuthenticatedRender are the only places a TemplateRender is built,
+// so fields every render needs go here.
+func newAnonymousRender() TemplateRender {
+	return TemplateRender{Metadata: TemplateMetadata{Name: MainTemplate}}
 }
 
-func (u userService) SendMainTemplateData(token string) (TemplateRender, error) {
+// newAuthenticatedRender is the main page for user, logged in with token.
+func newAuthenticatedRender(token, user string) TemplateRender {
+	render := newAnonymousRender()
+	render.Variables.Session = token
+	render.Variables.User = user
+
+	return render
+}
+
+// GetHomeState resolves token to the session it belongs to. An empty token
+// is an anonymous visitor and not an error; any other token that does not
+// resolve fails, along with a HomeState telling whether it expired.
+func (u *userService) GetHomeState(ctx context.Context, token string) (HomeState, error) {
 	if strings.TrimSpace(token) == "" {
-		return TemplateRender{
-			Metadata:  TemplateMetadata{Name: MainTemplate},
-			Variables: TemplateVariables{},
-		}, nil
+		return HomeState{}, nil
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	claims, err := u.parseToken(ctx, token, accessTokenType)
+	if errors.Is(err, ErrTokenExpired) {
+		return HomeState{SessionExpired: true}, fmt.Errorf("session expired: %w", err)
 	}
 
-	sessionID, err := ParseToken(token)
 	if err != nil {
-		return TemplateRender{
-			Metadata:  TemplateMetadata{Name: MainTemplate},
-			Variables: TemplateVariables{},
-		}, fmt.Errorf("error while parsing token: %w", err)
+		return HomeState{}, fmt.Errorf("error while parsing token: %w", err)
 	}
 
-	user, ok := u.sessions[sessionID]
-	if !ok {
-		return TemplateRender{
-			Metadata:  TemplateMetadata{Name: MainTemplate},
-			Variables: TemplateVariables{},
-		}, fmt.Errorf("session not registered")
+	session, err := u.claimsSession(ctx, claims)
+	if err != nil {
+		return HomeState{}, err
 	}
 
-	return TemplateRender{
-		Metadata:  TemplateMetadata{Name: MainTemplate},
-		Variables: TemplateVariables{Session: token, User: user},
-	}, nil
+	u.touchSession(ctx, session)
+
+	return HomeState{LoggedIn: true, Username: session.Username}, nil
 }
 
-func (u *userService) Register(user, pass string) (string, error) {
-	if _, ok := u.users[user]; ok {
-		return "", fmt.Errorf("user already registered")
+// Register creates an account holding roles, or only RoleUser when none are
+// given. The username and, ignoring case, the email must not be taken by
+// another account of the tenant. While WithInviteOnly is set it fails with
+// ErrInviteRequired and accounts go through RegisterWithInvite instead.
+func (u *userService) Register(ctx context.Context, user, pass, email string, roles ...string) (string, error) {
+	if u.inviteOnly {
+		return "", ErrInviteRequired
 	}
 
-	hashedPass, err := u.hashValue(pass)
+	return u.register(ctx, user, pass, email, "", roles)
+}
+
+// SetRegistrationEnabled turns new registrations on or off at runtime, e.g.
+// during an incident. Existing accounts are unaffected and can still log in.
+func (u *userService) SetRegistrationEnabled(enabled bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.registrationDisabled = !enabled
+}
+
+// register creates an account, redeeming inviteCode first unless it is
+// empty.
+func (u *userService) register(ctx context.Context, user, pass, email, inviteCode string, roles []string) (string, error) {
+	userFields, err := u.newUser(ctx, user, pass, email, roles)
 	if err != nil {
-		return "", fmt.Errorf("error while hashing pass: %w", err)
+		return "", err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.registrationDisabled {
+		return "", ErrRegistrationDisabled
 	}
 
-	u.users[user] = UserFields{
-		Username:       user,
-		HashedPassword: hashedPass,
+	if err := u.checkUsernameFree(ctx, userFields.Username); err != nil {
+		return "", err
+	}
+
+	if err := u.checkEmailFree(ctx, userFields.Email); err != nil {
+		return "", err
+	}
+
+	if inviteCode != "" {
+		if err := u.redeemInvite(ctx, inviteCode); err != nil {
+			return "", err
+		}
+	}
+
+	if err := u.saveNewUser(ctx, userFields); err != nil {
+		return "", err
 	}
 
 	return "REGISTER SUCCESSFUL", nil
 }
 
-func (u userService) Login(user, pass string) (string, error) {
-	userFields, ok := u.users[user]
-	if !ok {
-		return "", fmt.Errorf("user not registered")
+// saveNewUser stores userFields, hashed by newUser, as a new account. The
+// caller holds the write lock and checked the username and email are free.
+func (u *userService) saveNewUser(ctx context.Context, userFields UserFields) error {
+	userFields.CreatedAt = u.clock.Now().UTC()
+	u.setPassword(&userFields, userFields.HashedPassword, userFields.CreatedAt)
+
+	// Another instance may have taken the username or the email since they
+	// were checked.
+	err := u.users.CreateUser(ctx, userFields)
+	if errors.Is(err, ErrUserAlreadyExists) || errors.Is(err, ErrEmailAlreadyRegistered) {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	u.audit(ctx, AuditRegister, userFields.Username)
+	u.registrations.Add(1)
+
+	return nil
+}
+
+// newUser runs every check a registration must pass and hashes pass before
+// the lock is taken, and returns the normalized account.
+func (u *userService) newUser(ctx context.Context, user, pass, email string, roles []string) (UserFields, error) {
+	tenant := TenantFromContext(ctx)
+	if err := validateTenant(tenant); err != nil {
+		return UserFields{}, err
+	}
+
+	user = normalizeUsername(user)
+	if err := u.validateUsername(user); err != nil {
+		return UserFields{}, err
+	}
+
+	email = normalizeEmail(email)
+	if err := validateEmail(email); err != nil {
+		return UserFields{}, err
+	}
+
+	if u.emailDomainPolicy != nil {
+		if err := u.emailDomainPolicy.Validate(email); err != nil {
+			return UserFields{}, err
+		}
+	}
+
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		return UserFields{}, err
+	}
+
+	if err := u.passwordPolicy.Validate(pass); err != nil {
+		return UserFields{}, err
+	}
+
+	if err := u.checkBreached(ctx, pass); err != nil {
+		return UserFields{}, err
+	}
+
+	hashedPass, err := u.hashValue(ctx, pass)
+	if err != nil {
+		return UserFields{}, fmt.Errorf("error while hashing pass: %w", err)
+	}
+
+	return UserFields{Username: user, Tenant: tenant, Email: email, Roles: roles, HashedPassword: hashedPass}, nil
+}
+
+// checkUsernameFree fails with ErrUserAlreadyExists when username is taken.
+// It must be called with u.mu held.
+func (u *userService) checkUsernameFree(ctx context.Context, username string) error {
+	_, err := u.users.GetUser(ctx, username)
+	if err == nil {
+		return ErrUserAlreadyExists
+	}
+
+	if !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("error while looking up user: %w", err)
 	}
 
-	if err := u.checkPasswordHash(pass, userFields.HashedPassword); err != nil {
-		return "", fmt.Errorf("error while checking passwords: %w", err)
+	return nil
+}
+
+// checkEmailFree fails with ErrEmailAlreadyRegistered when another account
+// of the tenant holds email. It must be called with u.mu held.
+func (u *userService) checkEmailFree(ctx context.Context, email string) error {
+	_, err := u.users.GetUserByEmail(ctx, email)
+	if err == nil {
+		return ErrEmailAlreadyRegistered
 	}
 
-	sessionID := uuid.New().String()
-	u.sessions[sessionID] = user
+	if !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("error while looking up email: %w", err)
+	}
+
+	return nil
+}
+
+// Login authenticates user, which may be a username or the account's email,
+// see findUser. Both fail the same way for an unknown account.
+func (u *userService) Login(ctx context.Context, user, pass string) (LoginResult, error) {
+	return u.LoginWithOptions(ctx, user, pass, LoginOptions{})
+}
+
+// LoginWithTTL is Login for clients wanting their own session length, such
+// as a CLI holding a token for days. ttl is clamped to the bounds of
+// WithLoginTTLBounds and fails with ErrInvalidTTL unless positive.
+func (u *userService) LoginWithTTL(ctx context.Context, user, pass string, ttl time.Duration) (LoginResult, error) {
+	if ttl <= 0 {
+		return LoginResult{}, fmt.Errorf("%w: must be positive, got %s", ErrInvalidTTL, ttl)
+	}
+
+	return u.LoginWithOptions(ctx, user, pass, LoginOptions{TTL: ttl})
+}
+
+func (u *userService) LoginWithOptions(ctx context.Context, user, pass string, opts LoginOptions) (LoginResult, error) {
+	if opts.TTL < 0 {
+		return LoginResult{}, fmt.Errorf("%w: must be positive, got %s", ErrInvalidTTL, opts.TTL)
+	}
+
+	tenant := TenantFromContext(ctx)
+
+	// The password is checked before the write lock is taken, so hashing
+	// holds up neither other logins nor readers, and the account is read
+	// again under the lock below.
+	u.mu.RLock()
+	userFields, err := u.findUser(ctx, user)
+	u.mu.RUnlock()
+
+	// Failed attempts are counted under the canonical username once the
+	// account is found, so logging in by email shares its lockout.
+	if err == nil {
+		user = userFields.Username
+	} else {
+		user = normalizeLogin(user)
+	}
+
+	if u.loginAttempts.locked(tenantKey(tenant, user), u.clock.Now()) {
+		u.audit(ctx, AuditFailedLogin, user)
+
+		return LoginResult{}, ErrAccountLocked
+	}
+
+	// An unknown username and a wrong password must be indistinguishable,
+	// both in the error returned and in how long it takes to return it.
+	if errors.Is(err, ErrUserNotFound) {
+		if err := u.checkPasswordHash(ctx, pass, u.dummyHash); hashAbandoned(ctx, err) {
+			return LoginResult{}, err
+		}
+
+		u.loginFailed(ctx, user)
+
+		return LoginResult{}, ErrInvalidCredentials
+	}
 
-	token, err := CreateToken(sessionID)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	// Accounts provisioned by OAuthLogin have no password to log in with.
+	if userFields.HashedPassword == "" {
+		if err := u.checkPasswordHash(ctx, pass, u.dummyHash); hashAbandoned(ctx, err) {
+			return LoginResult{}, err
+		}
+
+		u.loginFailed(ctx, user)
+
+		return LoginResult{}, ErrInvalidCredentials
+	}
+
+	if err := u.checkPasswordHash(ctx, pass, userFields.HashedPassword); err != nil {
+		if hashAbandoned(ctx, err) {
+			return LoginResult{}, err
+		}
+
+		u.loginFailed(ctx, user)
+
+		return LoginResult{}, ErrInvalidCredentials
+	}
+
+	rehashed := u.rehash(ctx, userFields, pass)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// A password changed, or an account deleted, while the old password was
+	// being checked must not let the login through.
+	current, err := u.users.GetUser(ctx, userFields.Username)
+	if errors.Is(err, ErrUserNotFound) || (err == nil && current.HashedPassword != userFields.HashedPassword) {
+		u.loginFailed(ctx, user)
+
+		return LoginResult{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	userFields = u.saveRehash(ctx, current, rehashed)
+
+	passwordExpired := u.passwordExpired(userFields, u.clock.Now())
+	if passwordExpired && u.strictPasswordExpiry {
+		u.audit(ctx, AuditFailedLogin, user)
+
+		return LoginResult{}, ErrPasswordExpired
+	}
+
+	if userFields.TOTPEnabled {
+		switch {
+		case opts.RecoveryCode != "":
+			userFields, err = u.consumeRecoveryCode(ctx, userFields, opts.RecoveryCode)
+			if errors.Is(err, ErrInvalidRecoveryCode) {
+				u.loginFailed(ctx, user)
+			}
+
+			if err != nil {
+				return LoginResult{}, err
+			}
+		case opts.TOTPCode == "":
+			return LoginResult{}, ErrTOTPRequired
+		case !validateTOTP(opts.TOTPCode, userFields.TOTPSecret, u.clock.Now()):
+			u.loginFailed(ctx, user)
+
+			return LoginResult{}, ErrInvalidTOTPCode
+		}
+	}
+
+	u.loginAttempts.reset(tenantKey(tenant, user))
+
+	result, err := u.startSession(ctx, userFields, opts)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	if passwordExpired {
+		result.MustChangePassword = true
+	}
+
+	return result, nil
+}
+
+// startSession opens a session for userFields once they proved who they
+// are, by password or otherwise, and issues its tokens. Suspended accounts
+// and, with WithRequireVerifiedEmail, unverified ones are still turned away.
+// The caller holds the write lock.
+func (u *userService) startSession(ctx context.Context, userFields UserFields, opts LoginOptions) (LoginResult, error) {
+	user := userFields.Username
+
+	if userFields.Suspended {
+		u.audit(ctx, AuditFailedLogin, user)
+
+		return LoginResult{}, ErrAccountSuspended
+	}
+
+	if u.requireVerifiedEmail && !userFields.EmailVerified {
+		return LoginResult{}, ErrEmailNotVerified
+	}
+
+	if err := u.enforceSessionLimit(ctx, user); err != nil {
+		return LoginResult{}, err
+	}
+
+	sessionTTL, refreshTTL, tokenTTL := u.sessionTTL, u.refreshTTL, u.tokenTTL
+
+	switch {
+	case userFields.MustChangePassword:
+		// The restricted session only has to last for ChangePassword.
+		sessionTTL = tokenTTL
+	case opts.TTL > 0:
+		ttl := min(max(opts.TTL, u.minLoginTTL), u.maxLoginTTL)
+		sessionTTL, refreshTTL, tokenTTL = ttl, ttl, ttl
+	case opts.RememberMe:
+		sessionTTL, refreshTTL = u.rememberMeTTL, u.rememberMeTTL
+	}
+
+	if u.statelessSessions {
+		return u.startStatelessSession(ctx, userFields, sessionTTL)
+	}
+
+	sessionID, err := u.newSessionID()
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	now := u.clock.Now()
+	session := Session{
+		ID:        sessionID,
+		Username:  user,
+		Tenant:    TenantFromContext(ctx),
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+		UserAgent: sanitizeUserAgent(opts.UserAgent),
+		IP:        sanitizeIP(opts.IP),
+	}
+	if err := u.sessions.Set(ctx, session, u.sessionStoreTTL(session, now)); err != nil {
+		return LoginResult{}, fmt.Errorf("error while saving session: %w", err)
+	}
+
+	u.trackActiveUser(ctx, user)
+	u.alertNewLogin(ctx, userFields, session)
+
+	if userFields.MustChangePassword {
+		token, err := u.keys.CreatePasswordChangeToken(sessionID, session.Tenant, tokenTTL)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf("error while creating token: %w", err)
+		}
+
+		u.recordLogin(ctx, userFields, now)
+		u.audit(ctx, AuditLogin, user)
+
+		return LoginResult{AccessToken: token, ExpiresAt: now.Add(sessionTTL), MustChangePassword: true}, nil
+	}
+
+	token, err := u.keys.CreateToken(sessionID, session.Tenant, userFields.Roles, tokenTTL)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while creating token: %w", err)
+	}
+
+	refreshToken, err := u.keys.CreateRefreshToken(sessionID, session.Tenant, refreshTTL)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error while creating refresh token: %w", err)
+	}
+
+	if err := u.refreshTokens.Set(ctx, sessionID, hashToken(refreshToken), refreshTTL); err != nil {
+		return LoginResult{}, fmt.Errorf("error while saving refresh token: %w", err)
+	}
+
+	u.recordLogin(ctx, userFields, now)
+	u.audit(ctx, AuditLogin, user)
+
+	expiresAt := now.Add(sessionTTL)
+	if refreshTTL < sessionTTL {
+		expiresAt = now.Add(refreshTTL)
+	}
+
+	return LoginResult{AccessToken: token, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token on the same
+// session. The refresh token itself is not rotated: it stays valid until its
+// own exp or until Logout revokes it, and every call returns a fresh access
+// token.
+func (u *userService) Refresh(ctx context.Context, refreshToken string) (string, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	sessionID, err := u.keys.ParseRefreshToken(refreshToken, TenantFromContext(ctx))
+	if errors.Is(err, ErrTokenExpired) {
+		return "", fmt.Errorf("refresh token expired: %w", err)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error while parsing refresh token: %w", err)
+	}
+
+	storedHash, err := u.refreshTokens.Get(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("refresh token revoked: %w", err)
+	}
+
+	if !SecureEqual(storedHash, hashToken(refreshToken)) {
+		return "", fmt.Errorf("refresh token revoked: %w", ErrRefreshTokenNotFound)
+	}
+
+	session, err := u.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("session not registered: %w", err)
+	}
+
+	u.touchSession(ctx, session)
+
+	// Roles are looked up again so changes apply from the next refresh.
+	userFields, err := u.users.GetUser(ctx, session.Username)
+	if err != nil {
+		return "", fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	token, err := u.keys.CreateToken(sessionID, session.Tenant, userFields.Roles, u.tokenTTL)
 	if err != nil {
 		return "", fmt.Errorf("error while creating token: %w", err)
 	}
@@ -125,30 +889,348 @@ func (u userService) Login(user, pass string) (string, error) {
 	return token, nil
 }
 
-func (u userService) Logout(token string) error {
-	sessionID, err := ParseToken(token)
+func (u *userService) Logout(ctx context.Context, token string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	claims, err := u.parseToken(ctx, token, accessTokenType)
+	if errors.Is(err, ErrTokenExpired) {
+		return fmt.Errorf("session expired: %w", err)
+	}
+
 	if err != nil {
 		return fmt.Errorf("error while parsing token: %w", err)
 	}
 
-	if _, ok := u.sessions[sessionID]; !ok {
-		return fmt.Errorf("session not registered during logout")
+	if u.statelessSessions {
+		return u.statelessLogout(ctx, claims)
 	}
 
-	delete(u.sessions, sessionID)
+	session, err := u.sessions.Get(ctx, claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("session not registered during logout: %w", err)
+	}
+
+	return u.logoutSession(ctx, claims, session)
+}
+
+// logoutSession ends session, which the token of claims belongs to.
+func (u *userService) logoutSession(ctx context.Context, claims *customClaims, session Session) error {
+	if err := u.endSession(ctx, session.ID); err != nil {
+		return err
+	}
+
+	// Deleting the session already ends it. The Denylist entry is for
+	// services that verify tokens themselves against a shared Denylist.
+	if err := u.denyToken(ctx, claims); err != nil {
+		return err
+	}
+
+	u.trackActiveUser(ctx, session.Username)
+	u.audit(ctx, AuditLogout, session.Username)
+
+	return nil
+}
+
+// ChangePassword replaces the password of the user owning token's session
+// after confirming oldPass, and logs every session of the user out. token
+// may be the restricted one Login hands out under MustChangePassword, and
+// the change clears that flag.
+func (u *userService) ChangePassword(ctx context.Context, token, oldPass, newPass string) error {
+	u.mu.RLock()
+	userFields, err := u.passwordChangeUser(ctx, token)
+	u.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = u.checkPasswordHash(ctx, oldPass, userFields.HashedPassword)
+	if hashAbandoned(ctx, err) {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while checking passwords: %w", ErrIncorrectPassword)
+	}
+
+	if oldPass == newPass {
+		return ErrPasswordUnchanged
+	}
+
+	if err := u.checkPasswordReuse(ctx, userFields, newPass); err != nil {
+		return err
+	}
+
+	if err := u.passwordPolicy.Validate(newPass); err != nil {
+		return err
+	}
+
+	if err := u.checkBreached(ctx, newPass); err != nil {
+		return err
+	}
+
+	hashedPass, err := u.hashValue(ctx, newPass)
+	if err != nil {
+		return fmt.Errorf("error while hashing pass: %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Read again under the lock: the session may have ended or the password
+	// changed while the passwords were being hashed.
+	current, err := u.passwordChangeUser(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if current.HashedPassword != userFields.HashedPassword {
+		return fmt.Errorf("error while checking passwords: %w", ErrIncorrectPassword)
+	}
+
+	u.setPassword(&current, hashedPass, u.clock.Now())
+	current.MustChangePassword = false
+
+	if err := u.users.SaveUser(ctx, current); err != nil {
+		return fmt.Errorf("error while saving user: %w", err)
+	}
+
+	u.audit(ctx, AuditPasswordChange, current.Username)
+
+	if _, err := u.revokeUserSessions(ctx, current.Username); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (u userService) hashValue(v string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(v), bcrypt.DefaultCost)
+// passwordChangeUser returns the account owning token's session, token being
+// any token ChangePassword takes.
+func (u *userService) passwordChangeUser(ctx context.Context, token string) (UserFields, error) {
+	user, err := u.authenticatePasswordChange(ctx, token)
+	if err != nil {
+		return UserFields{}, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if err != nil {
+		return UserFields{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	return userFields, nil
+}
+
+// DeleteAccount removes the user owning token's session after confirming
+// password, and logs them out of every session, not only the current one.
+func (u *userService) DeleteAccount(ctx context.Context, token, password string) error {
+	u.mu.RLock()
+	userFields, err := u.tokenUser(ctx, token)
+	u.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = u.checkPasswordHash(ctx, password, userFields.HashedPassword)
+	if hashAbandoned(ctx, err) {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while checking passwords: %w", ErrIncorrectPassword)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Read again under the lock, like ChangePassword does.
+	current, err := u.tokenUser(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if current.HashedPassword != userFields.HashedPassword {
+		return fmt.Errorf("error while checking passwords: %w", ErrIncorrectPassword)
+	}
+
+	if err := u.users.DeleteUser(ctx, current.Username); err != nil {
+		return fmt.Errorf("error while deleting user: %w", err)
+	}
+
+	if _, err := u.revokeUserSessions(ctx, current.Username); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// tokenUser returns the account owning token's session.
+func (u *userService) tokenUser(ctx context.Context, token string) (UserFields, error) {
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return UserFields{}, err
+	}
+
+	userFields, err := u.users.GetUser(ctx, user)
+	if errors.Is(err, ErrUserNotFound) {
+		return UserFields{}, fmt.Errorf("user not registered: %w", err)
+	}
+
+	if err != nil {
+		return UserFields{}, fmt.Errorf("error while looking up user: %w", err)
+	}
+
+	return userFields, nil
+}
+
+// authenticate resolves token to the username owning its session.
+func (u *userService) authenticate(ctx context.Context, token string) (string, error) {
+	claims, err := u.parseToken(ctx, token, accessTokenType)
+	if errors.Is(err, ErrTokenExpired) {
+		return "", fmt.Errorf("session expired: %w", err)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error while parsing token: %w", err)
+	}
+
+	return u.sessionUser(ctx, claims)
+}
+
+// authenticatePasswordChange is authenticate for ChangePassword, which also
+// takes the tokens of CreatePasswordChangeToken.
+func (u *userService) authenticatePasswordChange(ctx context.Context, token string) (string, error) {
+	claims, err := u.parseToken(ctx, token, passwordChangeTokenType)
+	if err != nil {
+		return u.authenticate(ctx, token)
+	}
+
+	return u.sessionUser(ctx, claims)
+}
+
+func (u *userService) sessionUser(ctx context.Context, claims *customClaims) (string, error) {
+	session, err := u.claimsSession(ctx, claims)
 	if err != nil {
 		return "", err
 	}
 
-	return string(hash), nil
+	u.touchSession(ctx, session)
+
+	return session.Username, nil
+}
+
+// claimsSession returns the session the verified claims of a token belong
+// to, read from the SessionStore or, under WithStatelessSessions, rebuilt
+// from the claims themselves.
+func (u *userService) claimsSession(ctx context.Context, claims *customClaims) (Session, error) {
+	if u.statelessSessions {
+		session, err := u.statelessSession(ctx, claims)
+		if err != nil {
+			return Session{}, fmt.Errorf("session not registered: %w", err)
+		}
+
+		return session, nil
+	}
+
+	session, err := u.sessions.Get(ctx, claims.SessionID)
+	if err != nil {
+		return Session{}, fmt.Errorf("session not registered: %w", err)
+	}
+
+	return session, nil
+}
+
+func (u *userService) hashValue(ctx context.Context, v string) (string, error) {
+	release, err := u.acquireHashSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return u.hasher.Hash(pepperPassword(u.pepper, v))
+}
+
+// acquireHashSlot waits for a free hash slot, giving up with ctx's error once
+// ctx ends so abandoned requests do not queue up for CPU they will never
+// use. The returned func gives the slot back.
+func (u *userService) acquireHashSlot(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case u.hashSlots <- struct{}{}:
+		return func() { <-u.hashSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// hashAbandoned reports whether err is ctx ending while waiting for a hash
+// slot, rather than a password that did not match.
+func hashAbandoned(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
+
+// rehash re-hashes pass with the configured Hasher when the stored hash is
+// weaker, e.g. after the bcrypt cost was raised or the Hasher was switched,
+// and returns the new hash for saveRehash, or "" when there is none. It is
+// best effort: on any failure the old hash is kept and the login goes on.
+// Like every hash it runs without u.mu held.
+func (u *userService) rehash(ctx context.Context, userFields UserFields, pass string) string {
+	checker, ok := u.hasher.(RehashChecker)
+	if !ok || !checker.NeedsRehash(userFields.HashedPassword) {
+		return ""
+	}
+
+	hashedPass, err := u.hashValue(ctx, pass)
+	if err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while rehashing password", "user", userFields.Username, "err", err)
+
+		return ""
+	}
+
+	return hashedPass
 }
 
-func (u userService) checkPasswordHash(pass, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+// saveRehash stores hashedPass from rehash as the password of userFields.
+// The caller holds the write lock.
+func (u *userService) saveRehash(ctx context.Context, userFields UserFields, hashedPass string) UserFields {
+	if hashedPass == "" {
+		return userFields
+	}
+
+	updated := userFields
+	updated.HashedPassword = hashedPass
+
+	if err := u.users.SaveUser(ctx, updated); err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while saving rehashed password", "user", userFields.Username, "err", err)
+
+		return userFields
+	}
+
+	return updated
+}
+
+// recordLogin stores now as the user's last login. Like rehash it is
+// best effort: the session is already issued, so a failure is only logged.
+func (u *userService) recordLogin(ctx context.Context, userFields UserFields, now time.Time) {
+	userFields.LastLoginAt = now.UTC()
+
+	if err := u.users.SaveUser(ctx, userFields); err != nil {
+		_ = level.Warn(u.logger).Log("msg", "error while recording last login", "user", userFields.Username, "err", err)
+	}
+}
+
+// checkPasswordHash verifies pass with whichever Hasher produced hash, not
+// necessarily the configured one. It shares the hash slots of hashValue.
+func (u *userService) checkPasswordHash(ctx context.Context, pass, hash string) error {
+	release, err := u.acquireHashSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return hasherFor(hash).Compare(pepperPassword(u.pepper, pass), hash)
 }
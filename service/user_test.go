@@ -0,0 +1,587 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashSlotCancelledContext(t *testing.T) {
+	svc := newTestService(t, WithHashConcurrency(1))
+
+	release, err := svc.acquireHashSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if _, err := svc.hashValue(ctx, testPassword); !errors.Is(err, context.Canceled) {
+		t.Fatalf("hashValue() error = %v, want context.Canceled", err)
+	}
+
+	if err := svc.checkPasswordHash(ctx, testPassword, svc.dummyHash); !errors.Is(err, context.Canceled) {
+		t.Fatalf("checkPasswordHash() error = %v, want context.Canceled", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("cancelled hashes took %s", elapsed)
+	}
+}
+
+func TestLoginWaitingForHashSlotAbandonsOnCancel(t *testing.T) {
+	svc := newTestService(t, WithHashConcurrency(1))
+	mustRegister(t, svc, "alice")
+
+	release, err := svc.acquireHashSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := svc.Login(ctx, "alice", testPassword); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Login() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// An abandoned login is not a failed attempt.
+	if svc.loginAttempts.locked(tenantKey("", "alice"), svc.clock.Now()) {
+		t.Fatal("abandoned login counted towards the lockout")
+	}
+}
+
+// TestHashingDoesNotHoldTheServiceLock checks a login waiting on a hash
+// leaves the service lock alone, so readers are not stuck behind it.
+func TestHashingDoesNotHoldTheServiceLock(t *testing.T) {
+	svc := newTestService(t, WithHashConcurrency(1))
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+	session := mustLogin(t, svc, "bob")
+
+	release, err := svc.acquireHashSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loggedIn := make(chan error, 1)
+	go func() {
+		_, err := svc.Login(context.Background(), "alice", testPassword)
+		loggedIn <- err
+	}()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := svc.GetHomeState(context.Background(), session.AccessToken)
+		read <- err
+	}()
+
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatalf("GetHomeState() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetHomeState blocked behind a login waiting for a hash")
+	}
+
+	release()
+
+	if err := <-loggedIn; err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+}
+
+func TestLoginRejectsPasswordChangedWhileHashing(t *testing.T) {
+	svc := newTestService(t, WithHashConcurrency(1))
+	mustRegister(t, svc, "alice")
+
+	newHash, err := svc.hashValue(context.Background(), "n3w-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookedUp := make(chan struct{})
+	svc.users = &lookupSignallingRepository{UserRepository: svc.users, lookedUp: lookedUp}
+
+	release, err := svc.acquireHashSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loggedIn := make(chan error, 1)
+	go func() {
+		_, err := svc.Login(context.Background(), "alice", testPassword)
+		loggedIn <- err
+	}()
+
+	// The login read the account and waits for the hash slot: change the
+	// password under it.
+	<-lookedUp
+
+	userFields := mustGetUser(t, svc, "alice")
+	userFields.HashedPassword = newHash
+	if err := svc.users.SaveUser(context.Background(), userFields); err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if err := <-loggedIn; !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// lookupSignallingRepository closes lookedUp on the first GetUser.
+type lookupSignallingRepository struct {
+	UserRepository
+	lookedUp chan struct{}
+	once     sync.Once
+}
+
+func (r *lookupSignallingRepository) GetUser(ctx context.Context, username string) (UserFields, error) {
+	defer r.once.Do(func() { close(r.lookedUp) })
+
+	return r.UserRepository.GetUser(ctx, username)
+}
+
+func mustGetUser(t testing.TB, svc *userService, username string) UserFields {
+	t.Helper()
+
+	userFields, err := svc.users.GetUser(context.Background(), username)
+	if err != nil {
+		t.Fatalf("GetUser(%q): %v", username, err)
+	}
+
+	return userFields
+}
+
+func BenchmarkConcurrentLogin(b *testing.B) {
+	svc := newTestService(b)
+	for i := range 8 {
+		mustRegister(b, svc, fmt.Sprintf("user%d", i))
+	}
+
+	var next atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		user := fmt.Sprintf("user%d", next.Add(1)%8)
+		for pb.Next() {
+			if _, err := svc.Login(context.Background(), user, testPassword); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestLoginByUsernameOrEmail(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+
+	for _, login := range []string{"alice", "ALICE", "alice@example.com", "Alice@Example.COM"} {
+		if _, err := svc.Login(context.Background(), login, testPassword); err != nil {
+			t.Errorf("Login(%q) error = %v", login, err)
+		}
+	}
+
+	// An unknown email fails exactly like an unknown username or a wrong
+	// password, not telling which one was tried.
+	for _, login := range []string{"nobody", "nobody@example.com"} {
+		if _, err := svc.Login(context.Background(), login, testPassword); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q) error = %v, want ErrInvalidCredentials", login, err)
+		}
+	}
+
+	if _, err := svc.Login(context.Background(), "alice@example.com", "wrong-passw0rd"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login() with a wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLoginByEmailSharesLockout(t *testing.T) {
+	svc := newTestService(t, WithLockoutThreshold(2))
+	mustRegister(t, svc, "alice")
+
+	for _, login := range []string{"alice", "alice@example.com"} {
+		if _, err := svc.Login(context.Background(), login, "wrong-passw0rd"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login(%q) error = %v, want ErrInvalidCredentials", login, err)
+		}
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Login() error = %v, want ErrAccountLocked", err)
+	}
+}
+
+func TestRegisterRejectsTakenEmail(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.Register(context.Background(), "mallory", testPassword, "Victim@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := svc.Register(context.Background(), "victim", testPassword, "victim@example.com")
+	if !errors.Is(err, ErrEmailAlreadyRegistered) {
+		t.Fatalf("Register() with a taken email error = %v, want ErrEmailAlreadyRegistered", err)
+	}
+
+	// The same email in another tenant is another account.
+	if _, err := svc.Register(ContextWithTenant(context.Background(), "other"), "victim", testPassword, "victim@example.com"); err != nil {
+		t.Fatalf("Register() in another tenant error = %v", err)
+	}
+}
+
+// TestConcurrentUse hammers every path touching the shared stores from 100
+// goroutines; run with -race to prove the service lock guards them.
+func TestConcurrentUse(t *testing.T) {
+	svc := newTestService(t)
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Go(func() {
+			ctx := context.Background()
+			user := fmt.Sprintf("user%d", i%10)
+
+			_, err := svc.Register(ctx, user, testPassword, user+"@example.com")
+			if err != nil && !errors.Is(err, ErrUserAlreadyExists) && !errors.Is(err, ErrEmailAlreadyRegistered) {
+				t.Errorf("Register(%q) error = %v", user, err)
+			}
+
+			result, err := svc.Login(ctx, user, testPassword)
+			if err != nil {
+				t.Errorf("Login(%q) error = %v", user, err)
+
+				return
+			}
+
+			if _, err := svc.SendMainTemplateData(ctx, result.AccessToken); err != nil {
+				t.Errorf("SendMainTemplateData() error = %v", err)
+			}
+
+			if _, err := svc.GetHomeState(ctx, result.AccessToken); err != nil {
+				t.Errorf("GetHomeState() error = %v", err)
+			}
+
+			if err := svc.Logout(ctx, result.AccessToken); err != nil {
+				t.Errorf("Logout() error = %v", err)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	for i := range 10 {
+		mustLogin(t, svc, fmt.Sprintf("user%d", i))
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+	other := mustLogin(t, svc, "alice")
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, "wrong-passw0rd", "n3w-password"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatalf("ChangePassword() with a wrong old password error = %v, want ErrIncorrectPassword", err)
+	}
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, testPassword); !errors.Is(err, ErrPasswordUnchanged) {
+		t.Fatalf("ChangePassword() to the same password error = %v, want ErrPasswordUnchanged", err)
+	}
+
+	if err := svc.ChangePassword(context.Background(), session.AccessToken, testPassword, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() with the old password error = %v, want ErrInvalidCredentials", err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", "n3w-password"); err != nil {
+		t.Fatalf("Login() with the new password error = %v", err)
+	}
+
+	// Changing the password logs every session out.
+	for _, token := range []string{session.AccessToken, other.AccessToken} {
+		if _, err := svc.GetHomeState(context.Background(), token); err == nil {
+			t.Fatal("GetHomeState() with a token issued before the change succeeded")
+		}
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+	secondDevice := mustLogin(t, svc, "alice")
+
+	if err := svc.DeleteAccount(context.Background(), session.AccessToken, "wrong-passw0rd"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatalf("DeleteAccount() with a wrong password error = %v, want ErrIncorrectPassword", err)
+	}
+
+	if err := svc.DeleteAccount(context.Background(), session.AccessToken, testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), secondDevice.AccessToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("GetHomeState() from the second device error = %v, want ErrSessionNotFound", err)
+	}
+
+	if err := svc.DeleteAccount(context.Background(), secondDevice.AccessToken, testPassword); err == nil {
+		t.Fatal("DeleteAccount() of a deleted account succeeded")
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() after DeleteAccount error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLoginRememberMe(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithSessionTTL(time.Hour), WithRefreshTokenTTL(time.Hour), WithRememberMeTTL(30*24*time.Hour))
+	mustRegister(t, svc, "alice")
+
+	short := mustLogin(t, svc, "alice")
+
+	long, err := svc.LoginWithOptions(context.Background(), "alice", testPassword, LoginOptions{RememberMe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := clock.Now().Add(time.Hour); !short.ExpiresAt.Equal(want) {
+		t.Errorf("Login() ExpiresAt = %s, want %s", short.ExpiresAt, want)
+	}
+
+	if want := clock.Now().Add(30 * 24 * time.Hour); !long.ExpiresAt.Equal(want) {
+		t.Errorf("LoginWithOptions(RememberMe) ExpiresAt = %s, want %s", long.ExpiresAt, want)
+	}
+
+	// Past the regular TTL only the remembered session can still be renewed.
+	clock.Advance(2 * time.Hour)
+
+	if _, err := svc.Refresh(context.Background(), short.RefreshToken); err == nil {
+		t.Error("Refresh() of the regular session past its TTL succeeded")
+	}
+
+	token, err := svc.Refresh(context.Background(), long.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() of the remembered session error = %v", err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), token); err != nil {
+		t.Fatalf("GetHomeState() with the remembered session error = %v", err)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := svc.Register(ctx, "bob", testPassword, "bob@example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Register() error = %v, want context.Canceled", err)
+	}
+
+	if _, err := svc.Login(ctx, "alice", testPassword); !errors.Is(err, context.Canceled) {
+		t.Errorf("Login() error = %v, want context.Canceled", err)
+	}
+
+	if _, err := svc.GetHomeState(ctx, session.AccessToken); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetHomeState() error = %v, want context.Canceled", err)
+	}
+
+	if err := svc.Logout(ctx, session.AccessToken); !errors.Is(err, context.Canceled) {
+		t.Errorf("Logout() error = %v, want context.Canceled", err)
+	}
+
+	// Nothing was done on behalf of the cancelled calls.
+	if _, err := svc.users.GetUser(context.Background(), "bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser() of the cancelled registration error = %v, want ErrUserNotFound", err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), session.AccessToken); err != nil {
+		t.Errorf("GetHomeState() after the cancelled Logout error = %v", err)
+	}
+}
+
+func TestLoginDoesNotRevealUnknownUsers(t *testing.T) {
+	svc := newTestService(t, WithHashConcurrency(1))
+	mustRegister(t, svc, "alice")
+
+	_, unknownErr := svc.Login(context.Background(), "nobody", testPassword)
+	_, wrongErr := svc.Login(context.Background(), "alice", "wrong-passw0rd")
+
+	if !errors.Is(unknownErr, ErrInvalidCredentials) || !errors.Is(wrongErr, ErrInvalidCredentials) {
+		t.Fatalf("Login() errors = %v and %v, want ErrInvalidCredentials", unknownErr, wrongErr)
+	}
+
+	if unknownErr.Error() != wrongErr.Error() {
+		t.Fatalf("unknown user error %q differs from wrong password error %q", unknownErr, wrongErr)
+	}
+
+	// An unknown user still costs a hash comparison: with the only hash slot
+	// taken, its login has to wait for one like any other.
+	release, err := svc.acquireHashSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := svc.Login(ctx, "nobody", testPassword); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Login() of an unknown user error = %v, want it to wait for a hash slot", err)
+	}
+}
+
+func TestSetRegistrationEnabled(t *testing.T) {
+	svc := newTestService(t, WithRegistrationEnabled(false))
+
+	if _, err := svc.Register(context.Background(), "alice", testPassword, "alice@example.com"); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Fatalf("Register() while disabled error = %v, want ErrRegistrationDisabled", err)
+	}
+
+	svc.SetRegistrationEnabled(true)
+	mustRegister(t, svc, "alice")
+
+	svc.SetRegistrationEnabled(false)
+
+	if _, err := svc.Register(context.Background(), "bob", testPassword, "bob@example.com"); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Fatalf("Register() after disabling error = %v, want ErrRegistrationDisabled", err)
+	}
+
+	mustLogin(t, svc, "alice")
+
+	// Toggling while registering must not race, see go test -race.
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			svc.SetRegistrationEnabled(i%2 == 0)
+		}()
+
+		go func() {
+			defer wg.Done()
+			_, _ = svc.Register(context.Background(), fmt.Sprintf("user%d", i), testPassword, fmt.Sprintf("user%d@example.com", i))
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestGetHomeState(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+	session := mustLogin(t, svc, "alice")
+
+	for name, tc := range map[string]struct {
+		token string
+		want  HomeState
+	}{
+		"anonymous": {token: "", want: HomeState{}},
+		"valid":     {token: session.AccessToken, want: HomeState{LoggedIn: true, Username: "alice"}},
+	} {
+		state, err := svc.GetHomeState(context.Background(), tc.token)
+		if err != nil || state != tc.want {
+			t.Errorf("%s: GetHomeState() = %+v, %v, want %+v", name, state, err, tc.want)
+		}
+
+		// The template flow shows the same user.
+		render, err := svc.SendMainTemplateData(context.Background(), tc.token)
+		if err != nil || render.Variables.User != tc.want.Username {
+			t.Errorf("%s: SendMainTemplateData() user = %q, want %q", name, render.Variables.User, tc.want.Username)
+		}
+	}
+
+	clock.Advance(time.Hour + time.Second)
+
+	state, err := svc.GetHomeState(context.Background(), session.AccessToken)
+	if !errors.Is(err, ErrTokenExpired) || state != (HomeState{SessionExpired: true}) {
+		t.Fatalf("GetHomeState() of an expired token = %+v, %v, want SessionExpired and ErrTokenExpired", state, err)
+	}
+
+	if _, err := svc.GetHomeState(context.Background(), "not-a-token"); err == nil {
+		t.Fatal("GetHomeState() of a malformed token succeeded")
+	}
+}
+
+func TestSendMainTemplateData(t *testing.T) {
+	clock := newFakeClock()
+	svc := newTestService(t, WithClock(clock), WithTokenTTL(time.Hour))
+	mustRegister(t, svc, "alice")
+
+	expired := mustLogin(t, svc, "alice")
+	clock.Advance(30 * time.Minute)
+
+	revoked := mustLogin(t, svc, "alice")
+	if err := svc.RevokeAllSessions(context.Background(), revoked.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := mustLogin(t, svc, "alice")
+	clock.Advance(30*time.Minute + time.Second)
+
+	anonymous := TemplateRender{Metadata: TemplateMetadata{Name: MainTemplate}}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    TemplateRender
+		wantErr error
+	}{
+		{
+			name:  "anonymous",
+			token: "",
+			want:  anonymous,
+		},
+		{
+			name:  "valid",
+			token: valid.AccessToken,
+			want:  TemplateRender{Metadata: TemplateMetadata{Name: MainTemplate}, Variables: TemplateVariables{Session: valid.AccessToken, User: "alice"}},
+		},
+		{
+			name:    "invalid token",
+			token:   "not-a-token",
+			want:    anonymous,
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "missing session",
+			token:   revoked.AccessToken,
+			want:    anonymous,
+			wantErr: ErrSessionNotFound,
+		},
+		{
+			name:    "expired token",
+			token:   expired.AccessToken,
+			want:    TemplateRender{Metadata: TemplateMetadata{Name: MainTemplate}, Variables: TemplateVariables{LoginMessage: svc.localizer.Localize(context.Background(), MessageSessionExpired)}},
+			wantErr: ErrTokenExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		render, err := svc.SendMainTemplateData(context.Background(), tt.token)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: SendMainTemplateData() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+
+		if render != tt.want {
+			t.Errorf("%s: SendMainTemplateData() = %+v, want %+v", tt.name, render, tt.want)
+		}
+	}
+}
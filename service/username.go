@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+// ErrInvalidUsername is returned when a username breaks the validation rules
+// or is on the reserved list. The wrapping error says which rule failed.
+var ErrInvalidUsername = errors.New("invalid username")
+
+// normalizeUsername maps the different ways a user may type the same name
+// (surrounding spaces, case, composed vs decomposed accents) to one key.
+func normalizeUsername(username string) string {
+	return norm.NFC.String(strings.ToLower(strings.TrimSpace(username)))
+}
+
+// validateUsername expects an already normalized username.
+func (u *userService) validateUsername(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < minUsernameLength || length > maxUsernameLength {
+		return fmt.Errorf("%w: must be between %d and %d characters long", ErrInvalidUsername, minUsernameLength, maxUsernameLength)
+	}
+
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '_' && r != '-' {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidUsername, r)
+		}
+	}
+
+	if _, ok := u.reservedUsernames[username]; ok {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidUsername, username)
+	}
+
+	if u.usernamePolicy != nil {
+		if err := u.usernamePolicy.Validate(username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsUsernameAvailable reports whether Register would accept username, after
+// normalizing it as Register does, without creating anything. Invalid names
+// fail with ErrInvalidUsername. Taken and free names cost the same single
+// lookup, so response times do not tell them apart; the answer itself still
+// tells anyone which accounts exist, which is the price of checking names
+// before submit. Keep it behind the rate limiting middleware.
+func (u *userService) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	username = normalizeUsername(username)
+	if err := u.validateUsername(username); err != nil {
+		return false, err
+	}
+
+	if err := validateTenant(TenantFromContext(ctx)); err != nil {
+		return false, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	err := u.checkUsernameFree(ctx, username)
+	if errors.Is(err, ErrUserAlreadyExists) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RenameUsername moves the account owning token to newUsername. The
+// repository renames the account in one step, so another instance taking
+// newUsername meanwhile fails the rename with ErrUserAlreadyExists rather
+// than being overwritten. Sessions, and so every token already issued,
+// follow the account. Pending verification and reset tokens
+// still name the old username and stop working.
+func (u *userService) RenameUsername(ctx context.Context, token, newUsername string) error {
+	newUsername = normalizeUsername(newUsername)
+	if err := u.validateUsername(newUsername); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, err := u.authenticate(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if newUsername == user {
+		return nil
+	}
+
+	err = u.users.RenameUser(ctx, user, newUsername)
+	if errors.Is(err, ErrUserAlreadyExists) {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while renaming user: %w", err)
+	}
+
+	if err := u.moveSessions(ctx, user, newUsername); err != nil {
+		return err
+	}
+
+	u.audit(ctx, AuditRename, newUsername)
+
+	return nil
+}
+
+// moveSessions rewrites every session of username to point to newUsername.
+// Sessions are re-stored rather than updated in place so stores indexing
+// them by username, like Redis, pick the new name up.
+func (u *userService) moveSessions(ctx context.Context, username, newUsername string) error {
+	sessions, err := u.sessions.ListUserSessions(ctx, username)
+	if err != nil {
+		return fmt.Errorf("error while listing user sessions: %w", err)
+	}
+
+	if _, err := u.sessions.DeleteUserSessions(ctx, username); err != nil {
+		return fmt.Errorf("error while deleting user sessions: %w", err)
+	}
+
+	now := u.clock.Now()
+
+	for _, session := range sessions {
+		if session.ExpiresAt.IsZero() {
+			session.ExpiresAt = session.CreatedAt.Add(u.sessionTTL)
+		}
+
+		ttl := u.sessionStoreTTL(session, now)
+		if ttl <= 0 {
+			continue
+		}
+
+		session.Username = newUsername
+
+		if err := u.sessions.Set(ctx, session, ttl); err != nil {
+			return fmt.Errorf("error while moving session: %w", err)
+		}
+	}
+
+	u.trackActiveUser(ctx, username)
+	u.trackActiveUser(ctx, newUsername)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	for in, want := range map[string]string{
+		" alice ":      "alice",
+		"Alice":        "alice",
+		"JOS\u00c9":    "jos\u00e9",
+		"jose\u0301":   "jos\u00e9",
+		"\tbob.smith ": "bob.smith",
+	} {
+		if got := normalizeUsername(in); got != want {
+			t.Errorf("normalizeUsername(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRegisterThenLoginWithDifferentSpelling(t *testing.T) {
+	svc := newTestService(t)
+
+	if _, err := svc.Register(context.Background(), "Alice", testPassword, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(context.Background(), " alice ", testPassword); err != nil {
+		t.Fatalf("Login(%q) error = %v", " alice ", err)
+	}
+
+	if _, err := svc.Register(context.Background(), "ALICE", testPassword, "other@example.com"); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("Register() of another spelling error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func TestRegisterValidatesUsername(t *testing.T) {
+	svc := newTestService(t, WithReservedUsernames("admin", "Root"))
+
+	for _, username := range []string{"ab", "this-username-is-way-too-long-to-be-ok", "bob smith", "bob@home", "admin", "ROOT"} {
+		if _, err := svc.Register(context.Background(), username, testPassword, "user@example.com"); !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("Register(%q) error = %v, want ErrInvalidUsername", username, err)
+		}
+	}
+
+	if _, err := svc.Register(context.Background(), "bob_smith-2.0", testPassword, "bob@example.com"); err != nil {
+		t.Fatalf("Register() of a valid username error = %v", err)
+	}
+}
+
+func TestRenameUsernameMigratesSessions(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+	mustRegister(t, svc, "bob")
+
+	session := mustLogin(t, svc, "alice")
+	other := mustLogin(t, svc, "alice")
+
+	if err := svc.RenameUsername(context.Background(), session.AccessToken, "bob"); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("RenameUsername() onto a taken username error = %v, want ErrUserAlreadyExists", err)
+	}
+
+	if err := svc.RenameUsername(context.Background(), session.AccessToken, "a/b"); err == nil {
+		t.Fatal("RenameUsername() to an invalid username succeeded")
+	}
+
+	if err := svc.RenameUsername(context.Background(), session.AccessToken, " Alicia "); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{session.AccessToken, other.AccessToken} {
+		state, err := svc.GetHomeState(context.Background(), token)
+		if err != nil || state.Username != "alicia" {
+			t.Fatalf("GetHomeState() after the rename = %+v, %v, want alicia", state, err)
+		}
+	}
+
+	if _, err := svc.users.GetUser(context.Background(), "alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUser() of the old username error = %v, want ErrUserNotFound", err)
+	}
+
+	if sessions, err := svc.sessions.ListUserSessions(context.Background(), "alice"); err != nil || len(sessions) != 0 {
+		t.Fatalf("sessions left under the old username = %+v, %v, want none", sessions, err)
+	}
+
+	if _, err := svc.Login(context.Background(), "alice", testPassword); err == nil {
+		t.Fatal("Login() with the old username succeeded")
+	}
+
+	mustLogin(t, svc, "alicia")
+}
+
+func TestIsUsernameAvailable(t *testing.T) {
+	svc := newTestService(t)
+	mustRegister(t, svc, "alice")
+
+	for name, want := range map[string]bool{"alice": false, " ALICE ": false, "bob": true} {
+		available, err := svc.IsUsernameAvailable(context.Background(), name)
+		if err != nil || available != want {
+			t.Errorf("IsUsernameAvailable(%q) = %t, %v, want %t", name, available, err, want)
+		}
+	}
+
+	for _, name := range []string{"", "a/b", "x"} {
+		if _, err := svc.IsUsernameAvailable(context.Background(), name); !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("IsUsernameAvailable(%q) error = %v, want ErrInvalidUsername", name, err)
+		}
+	}
+
+	// Checking creates nothing.
+	if _, err := svc.users.GetUser(context.Background(), "bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetUser(bob) after the check error = %v, want ErrUserNotFound", err)
+	}
+
+	// Another tenant has its own names.
+	if available, err := svc.IsUsernameAvailable(ContextWithTenant(context.Background(), "other"), "alice"); err != nil || !available {
+		t.Fatalf("IsUsernameAvailable() in another tenant = %t, %v, want true", available, err)
+	}
+}
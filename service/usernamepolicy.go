@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUsernameNotAllowed is returned by Register for usernames the
+// UsernamePolicy rejects.
+var ErrUsernameNotAllowed = errors.New("username not allowed")
+
+// DefaultReservedUsernames are the names NewUsernamePolicy reserves when
+// given none.
+var DefaultReservedUsernames = []string{"admin", "support", "api"}
+
+// UsernamePolicy keeps offensive and reserved names out of Register, on top
+// of the character rules every username follows. Names are compared after
+// folding case, dropping the '.', '_' and '-' separators and undoing common
+// leetspeak, so "S.u-p_p0rt" is as reserved as "support". Reserved and
+// blocked names only match whole usernames, blocked substrings match
+// anywhere in them. Keep substrings long enough not to catch innocent names
+// that merely contain them.
+type UsernamePolicy struct {
+	reserved   map[string]struct{}
+	blocked    map[string]struct{}
+	substrings map[string]struct{}
+	// longestSubstring bounds the substrings Validate has to look up.
+	longestSubstring int
+}
+
+// NewUsernamePolicy returns a policy rejecting the names in reserved, or
+// DefaultReservedUsernames when it is empty, the names in blocked and any
+// name containing one of blockedSubstrings.
+func NewUsernamePolicy(reserved, blocked, blockedSubstrings []string) *UsernamePolicy {
+	if len(reserved) == 0 {
+		reserved = DefaultReservedUsernames
+	}
+
+	policy := &UsernamePolicy{
+		reserved:   usernameSet(reserved),
+		blocked:    usernameSet(blocked),
+		substrings: usernameSet(blockedSubstrings),
+	}
+
+	for substring := range policy.substrings {
+		policy.longestSubstring = max(policy.longestSubstring, len([]rune(substring)))
+	}
+
+	return policy
+}
+
+// Validate checks username, which must already be normalized. Every
+// substring of the folded name up to the longest blocked substring is
+// looked up in a set, so the cost depends on the length of the name rather
+// than on the size of the blocklist.
+func (p *UsernamePolicy) Validate(username string) error {
+	folded := foldUsername(username)
+
+	if _, ok := p.reserved[folded]; ok {
+		return fmt.Errorf("%w: %q is reserved", ErrUsernameNotAllowed, username)
+	}
+
+	if _, ok := p.blocked[folded]; ok {
+		return fmt.Errorf("%w: %q is blocked", ErrUsernameNotAllowed, username)
+	}
+
+	runes := []rune(folded)
+	for start := range runes {
+		for end := start + 1; end <= min(len(runes), start+p.longestSubstring); end++ {
+			if _, ok := p.substrings[string(runes[start:end])]; ok {
+				return fmt.Errorf("%w: %q contains a blocked word", ErrUsernameNotAllowed, username)
+			}
+		}
+	}
+
+	return nil
+}
+
+func usernameSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if name = foldUsername(normalizeUsername(name)); name != "" {
+			set[name] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+// leetReplacer undoes the character swaps people use to sneak a word past a
+// filter. 'l' and '1' both fold to 'i' since either may stand for the other.
+var leetReplacer = strings.NewReplacer(
+	".", "", "_", "", "-", "",
+	"0", "o", "1", "i", "l", "i", "!", "i", "3", "e", "4", "a", "@", "a",
+	"5", "s", "$", "s", "7", "t", "+", "t", "8", "b", "9", "g",
+)
+
+// foldUsername maps a normalized username to the form UsernamePolicy
+// compares.
+func foldUsername(username string) string {
+	return leetReplacer.Replace(username)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestUsernamePolicyValidate(t *testing.T) {
+	policy := NewUsernamePolicy(nil, []string{"Badword"}, []string{"darn"})
+
+	for _, tt := range []struct {
+		username string
+		allowed  bool
+	}{
+		// Reserved and blocked names match whole usernames only.
+		{"admin", false},
+		{"support", false},
+		{"badword", false},
+		{"badwords", true},
+		{"admins", true},
+		// Blocked substrings match anywhere.
+		{"darn", false},
+		{"mydarnname", false},
+		// Separators, case and leetspeak are folded away.
+		{"s.u-p_p0rt", false},
+		{"4dm1n", false},
+		{"b4dw0rd", false},
+		{"x_d@rn_x", false},
+		{"d.a.r.n", false},
+		{"alice", true},
+		{"bob_smith", true},
+		{"dean", true},
+	} {
+		err := policy.Validate(tt.username)
+		if tt.allowed && err != nil {
+			t.Errorf("Validate(%q) error = %v, want allowed", tt.username, err)
+		}
+
+		if !tt.allowed && !errors.Is(err, ErrUsernameNotAllowed) {
+			t.Errorf("Validate(%q) error = %v, want ErrUsernameNotAllowed", tt.username, err)
+		}
+	}
+}
+
+func TestNewUsernamePolicyReserved(t *testing.T) {
+	policy := NewUsernamePolicy([]string{"Root"}, nil, nil)
+
+	if err := policy.Validate("r00t"); !errors.Is(err, ErrUsernameNotAllowed) {
+		t.Fatalf("Validate() of a reserved name error = %v, want ErrUsernameNotAllowed", err)
+	}
+
+	// Reserving names replaces the defaults rather than adding to them.
+	if err := policy.Validate("admin"); err != nil {
+		t.Fatalf("Validate(%q) error = %v, want allowed", "admin", err)
+	}
+}
+
+func TestRegisterChecksUsernamePolicy(t *testing.T) {
+	svc := newTestService(t, WithUsernamePolicy(NewUsernamePolicy(nil, nil, []string{"darn"})))
+
+	for _, username := range []string{"S.u-p_p0rt", "xD4rnx"} {
+		if _, err := svc.Register(context.Background(), username, testPassword, "user@example.com"); !errors.Is(err, ErrUsernameNotAllowed) {
+			t.Errorf("Register(%q) error = %v, want ErrUsernameNotAllowed", username, err)
+		}
+	}
+
+	mustRegister(t, svc, "alice")
+}
+
+func BenchmarkUsernamePolicyValidate(b *testing.B) {
+	// A large blocklist must not slow down every registration.
+	blocked := make([]string, 10000)
+	for i := range blocked {
+		blocked[i] = fmt.Sprintf("blocked%d", i)
+	}
+
+	policy := NewUsernamePolicy(nil, blocked, blocked)
+
+	for b.Loop() {
+		if err := policy.Validate("a-perfectly-fine-name"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	var reached bool
+	h := CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	tests := []struct {
+		name      string
+		cookie    string
+		submitted string
+		want      int
+	}{
+		{"missing", "", "", http.StatusForbidden},
+		{"missing cookie", "", "token", http.StatusForbidden},
+		{"missing field", "token", "", http.StatusForbidden},
+		{"wrong", "token", "other", http.StatusForbidden},
+		{"correct", "token", "token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		reached = false
+
+		form := url.Values{"user": {"alice"}}
+		if tt.submitted != "" {
+			form.Set(CSRFFormField, tt.submitted)
+		}
+
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want || reached != (tt.want == http.StatusOK) {
+			t.Errorf("%s token: status %d, handler reached %t; want %d", tt.name, rec.Code, reached, tt.want)
+		}
+	}
+
+	reached = false
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !reached {
+		t.Error("GET was blocked")
+	}
+}
+
+func TestCSRFTokenRoundTrip(t *testing.T) {
+	// A first visit mints a token and sets it as a cookie.
+	ctx := PopulateCSRFToken(context.Background(), httptest.NewRequest("GET", "/", nil))
+
+	rec := httptest.NewRecorder()
+	token := setCSRFCookie(ctx, rec)
+
+	cookie, ok := responseCookies(rec)[csrfCookieName]
+	if !ok || token == "" || cookie.Value != token || !cookie.HttpOnly {
+		t.Fatalf("first visit: token %q, cookie %+v; want the token set as an HttpOnly cookie", token, cookie)
+	}
+
+	// Later visits reuse the cookie without setting it again.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+
+	rec = httptest.NewRecorder()
+	if again := setCSRFCookie(PopulateCSRFToken(context.Background(), req), rec); again != token {
+		t.Fatalf("second visit token = %q, want %q", again, token)
+	}
+
+	if _, ok := responseCookies(rec)[csrfCookieName]; ok {
+		t.Fatal("second visit set the CSRF cookie again")
+	}
+}
@@ -0,0 +1,344 @@
+// Package grpc exposes the UserService endpoints over gRPC using the
+// messages defined in package pb.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/pb"
+	"github.com/francisco-serrano/gokit-auth/service"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	pb.UnimplementedUserServiceServer
+
+	healthCheck grpctransport.Handler
+	register    grpctransport.Handler
+	login       grpctransport.Handler
+	logout      grpctransport.Handler
+}
+
+// NewGRPCServer binds endpoints to the pb.UserServiceServer interface.
+func NewGRPCServer(endpoints endpoint.Endpoints) pb.UserServiceServer {
+	opts := []grpctransport.ServerOption{
+		grpctransport.ServerBefore(populateRequestID, populateClientIP, populateTenant),
+		grpctransport.ServerAfter(setRequestIDHeader),
+	}
+
+	return &grpcServer{
+		healthCheck: grpctransport.NewServer(
+			endpoints.HealthEndpoint,
+			decodeHealthCheckRequest,
+			encodeHealthCheckResponse,
+			opts...,
+		),
+		register: grpctransport.NewServer(
+			endpoints.RegisterEndpoint,
+			decodeRegisterRequest,
+			encodeRegisterResponse,
+			opts...,
+		),
+		login: grpctransport.NewServer(
+			endpoints.LoginEndpoint,
+			decodeLoginRequest,
+			encodeLoginResponse,
+			opts...,
+		),
+		logout: grpctransport.NewServer(
+			endpoints.LogoutEndpoint,
+			decodeLogoutRequest,
+			encodeLogoutResponse,
+			opts...,
+		),
+	}
+}
+
+const (
+	// requestIDMetadataKey carries the request ID in both directions.
+	requestIDMetadataKey = "x-request-id"
+	// tenantMetadataKey names the tenant a call is scoped to.
+	tenantMetadataKey = "x-tenant-id"
+)
+
+// populateRequestID adopts the caller's request ID or mints one.
+func populateRequestID(ctx context.Context, md metadata.MD) context.Context {
+	var id string
+	if ids := md.Get(requestIDMetadataKey); len(ids) > 0 {
+		id = ids[0]
+	}
+
+	return service.ContextWithRequestID(ctx, id)
+}
+
+// setRequestIDHeader echoes the request ID so callers can quote it. go-kit
+// hands it a nil header when nothing was set before.
+func setRequestIDHeader(ctx context.Context, header *metadata.MD, _ *metadata.MD) context.Context {
+	if id := service.RequestIDFromContext(ctx); id != "" {
+		if *header == nil {
+			*header = metadata.MD{}
+		}
+
+		header.Set(requestIDMetadataKey, id)
+	}
+
+	return ctx
+}
+
+// populateClientIP records the peer address for per-IP rate limiting.
+func populateClientIP(ctx context.Context, _ metadata.MD) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ctx
+	}
+
+	return service.ContextWithClientIP(ctx, p.Addr.String())
+}
+
+// populateTenant scopes the call to the tenant in its metadata.
+func populateTenant(ctx context.Context, md metadata.MD) context.Context {
+	var tenant string
+	if tenants := md.Get(tenantMetadataKey); len(tenants) > 0 {
+		tenant = tenants[0]
+	}
+
+	return service.ContextWithTenant(ctx, tenant)
+}
+
+func (s *grpcServer) HealthCheck(ctx context.Context, req *pb.HealthCheckRequest) (*pb.HealthCheckReply, error) {
+	_, rep, err := s.healthCheck.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return rep.(*pb.HealthCheckReply), nil
+}
+
+func (s *grpcServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterReply, error) {
+	_, rep, err := s.register.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return rep.(*pb.RegisterReply), nil
+}
+
+func (s *grpcServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginReply, error) {
+	_, rep, err := s.login.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return rep.(*pb.LoginReply), nil
+}
+
+func (s *grpcServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutReply, error) {
+	_, rep, err := s.logout.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return rep.(*pb.LogoutReply), nil
+}
+
+func decodeHealthCheckRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return endpoint.HealthRequest{}, nil
+}
+
+func encodeHealthCheckResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp, ok := response.(endpoint.HealthResponse)
+	if !ok {
+		return nil, fmt.Errorf("error while casting health response: %T", response)
+	}
+
+	return &pb.HealthCheckReply{Message: resp.Message}, nil
+}
+
+func decodeRegisterRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req, ok := grpcReq.(*pb.RegisterRequest)
+	if !ok {
+		return nil, fmt.Errorf("error while casting register request: %T", grpcReq)
+	}
+
+	return endpoint.RegisterRequest{
+		User:       req.GetUser(),
+		Pass:       req.GetPass(),
+		Email:      req.GetEmail(),
+		InviteCode: req.GetInviteCode(),
+	}, nil
+}
+
+func encodeRegisterResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp, ok := response.(endpoint.RegisterResponse)
+	if !ok {
+		return nil, fmt.Errorf("error while casting register response: %T", response)
+	}
+
+	if resp.Err != nil {
+		return nil, toStatus(resp.Err)
+	}
+
+	return &pb.RegisterReply{Message: resp.Message}, nil
+}
+
+func decodeLoginRequest(ctx context.Context, grpcReq interface{}) (interface{}, error) {
+	req, ok := grpcReq.(*pb.LoginRequest)
+	if !ok {
+		return nil, fmt.Errorf("error while casting login request: %T", grpcReq)
+	}
+
+	loginReq := endpoint.LoginRequest{
+		User:       req.GetUser(),
+		Pass:       req.GetPass(),
+		RememberMe: req.GetRememberMe(),
+		TOTPCode:   req.GetTotpCode(),
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+			loginReq.UserAgent = userAgent[0]
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		loginReq.IP = p.Addr.String()
+	}
+
+	return loginReq, nil
+}
+
+func encodeLoginResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp, ok := response.(endpoint.LoginResponse)
+	if !ok {
+		return nil, fmt.Errorf("error while casting login response: %T", response)
+	}
+
+	if resp.Err != nil {
+		return nil, toStatus(resp.Err)
+	}
+
+	return &pb.LoginReply{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+	}, nil
+}
+
+func decodeLogoutRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req, ok := grpcReq.(*pb.LogoutRequest)
+	if !ok {
+		return nil, fmt.Errorf("error while casting logout request: %T", grpcReq)
+	}
+
+	return endpoint.LogoutRequest{Token: req.GetToken()}, nil
+}
+
+func encodeLogoutResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp, ok := response.(endpoint.LogoutResponse)
+	if !ok {
+		return nil, fmt.Errorf("error while casting logout response: %T", response)
+	}
+
+	if resp.Err != nil {
+		return nil, toStatus(resp.Err)
+	}
+
+	return &pb.LogoutReply{}, nil
+}
+
+// toStatus maps service errors onto the closest gRPC status code. Errors
+// that already are a status are passed through. Internal and Unavailable
+// statuses carry only a generic message so store and integration failures
+// do not leak to clients; the logging middleware records the error itself.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch code := codeFrom(err); code {
+	case codes.Internal:
+		return status.Error(code, "internal error")
+	case codes.Unavailable:
+		return status.Error(code, "service unavailable")
+	default:
+		return status.Error(code, err.Error())
+	}
+}
+
+func codeFrom(err error) codes.Code {
+	var validationErr endpoint.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		return codes.InvalidArgument
+	case errors.Is(err, service.ErrUserAlreadyExists),
+		errors.Is(err, service.ErrEmailAlreadyRegistered),
+		errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrOAuthAccountConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrIncorrectPassword),
+		errors.Is(err, service.ErrTokenExpired),
+		errors.Is(err, service.ErrTokenInvalid),
+		errors.Is(err, service.ErrSessionNotFound),
+		errors.Is(err, service.ErrRefreshTokenNotFound),
+		errors.Is(err, service.ErrTOTPRequired),
+		errors.Is(err, service.ErrInvalidTOTPCode),
+		errors.Is(err, service.ErrInvalidRecoveryCode),
+		errors.Is(err, service.ErrTokenRevoked),
+		errors.Is(err, service.ErrInvalidPasskey),
+		errors.Is(err, service.ErrOAuthFailed):
+		return codes.Unauthenticated
+	case errors.Is(err, service.ErrEmailNotVerified),
+		errors.Is(err, service.ErrForbidden),
+		errors.Is(err, service.ErrInviteRequired),
+		errors.Is(err, service.ErrRegistrationDisabled),
+		errors.Is(err, service.ErrAccountSuspended),
+		errors.Is(err, service.ErrPasswordExpired),
+		errors.Is(err, service.ErrPasswordChangeRequired):
+		return codes.PermissionDenied
+	case errors.Is(err, service.ErrAccountLocked),
+		errors.Is(err, service.ErrRateLimited),
+		errors.Is(err, service.ErrTooManySessions):
+		return codes.ResourceExhausted
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrNoPublicKeys),
+		errors.Is(err, service.ErrPasskeysDisabled),
+		errors.Is(err, service.ErrUnknownSession),
+		errors.Is(err, service.ErrStatelessSessions),
+		errors.Is(err, service.ErrUnknownOAuthProvider):
+		return codes.NotFound
+	case errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrBreachedPassword),
+		errors.Is(err, service.ErrInvalidUsername),
+		errors.Is(err, service.ErrUsernameNotAllowed),
+		errors.Is(err, service.ErrInvalidTenant),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidPage),
+		errors.Is(err, service.ErrInvalidTTL),
+		errors.Is(err, service.ErrTOTPNotPending),
+		errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrEmailDomainNotAllowed),
+		errors.Is(err, service.ErrPasswordUnchanged),
+		errors.Is(err, service.ErrPasswordReused),
+		errors.Is(err, service.ErrOneTimeTokenNotFound),
+		errors.Is(err, service.ErrInvalidInvite):
+		return codes.InvalidArgument
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, service.ErrStoreUnavailable),
+		errors.Is(err, service.ErrIntegrationUnavailable):
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
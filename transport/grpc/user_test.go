@@ -0,0 +1,129 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/pb"
+	"github.com/francisco-serrano/gokit-auth/service"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testPassword = "passw0rd-1"
+
+// dialTestServer serves a service backed by the in-memory stores on an
+// in-process bufconn listener and returns a client connected to it.
+func dialTestServer(t *testing.T) pb.UserServiceClient {
+	t.Helper()
+
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore(), service.WithBcryptCost(bcrypt.MinCost))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { svc.Close(context.Background()) })
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer()
+	pb.RegisterUserServiceServer(server, NewGRPCServer(endpoint.MakeServerEndpoints(svc)))
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewUserServiceClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	client := dialTestServer(t)
+	ctx := context.Background()
+
+	var header metadata.MD
+
+	health, err := client.HealthCheck(ctx, &pb.HealthCheckRequest{}, grpc.Header(&header))
+	if err != nil || health.GetMessage() != service.HealthStatusOK {
+		t.Fatalf("HealthCheck() = %v, %v, want ok", health, err)
+	}
+
+	if ids := header.Get(requestIDMetadataKey); len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("response header %s = %v, want the minted request ID", requestIDMetadataKey, ids)
+	}
+
+	register := &pb.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}
+	if _, err := client.Register(ctx, register); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if _, err := client.Register(ctx, register); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Register() of a taken username error = %v, want AlreadyExists", err)
+	}
+
+	if _, err := client.Login(ctx, &pb.LoginRequest{User: "alice", Pass: "wrong-passw0rd"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Login() with a wrong password error = %v, want Unauthenticated", err)
+	}
+
+	login, err := client.Login(ctx, &pb.LoginRequest{User: "alice", Pass: testPassword})
+	if err != nil || login.GetAccessToken() == "" {
+		t.Fatalf("Login() = %v, %v, want a token", login, err)
+	}
+
+	if _, err := client.Logout(ctx, &pb.LogoutRequest{Token: login.GetAccessToken()}); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if _, err := client.Logout(ctx, &pb.LogoutRequest{Token: login.GetAccessToken()}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("second Logout() error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestToStatusHidesServerErrors(t *testing.T) {
+	cases := []struct {
+		err         error
+		wantCode    codes.Code
+		wantMessage string
+	}{
+		{errors.New("pq: connection refused to 10.0.0.5"), codes.Internal, "internal error"},
+		{fmt.Errorf("redis 10.0.0.6: %w", service.ErrStoreUnavailable), codes.Unavailable, "service unavailable"},
+		{service.ErrInvalidCredentials, codes.Unauthenticated, service.ErrInvalidCredentials.Error()},
+	}
+
+	for _, tc := range cases {
+		st, _ := status.FromError(toStatus(tc.err))
+		if st.Code() != tc.wantCode || st.Message() != tc.wantMessage {
+			t.Errorf("toStatus(%v) = %s %q, want %s %q", tc.err, st.Code(), st.Message(), tc.wantCode, tc.wantMessage)
+		}
+	}
+}
+
+func TestSetRequestIDHeader(t *testing.T) {
+	ctx := service.ContextWithRequestID(context.Background(), "req-1")
+
+	var header, trailer metadata.MD
+	setRequestIDHeader(ctx, &header, &trailer)
+
+	if got := header.Get(requestIDMetadataKey); len(got) != 1 || got[0] != "req-1" {
+		t.Fatalf("header %s = %v, want req-1", requestIDMetadataKey, got)
+	}
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+// CookieConfig describes the cookie the JSON API stores the access token in
+// on login. The cookie is always HttpOnly so scripts cannot read the token.
+type CookieConfig struct {
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// DefaultCookieConfig returns a Secure, SameSite=Strict cookie named
+// access_token scoped to the whole host. Browsers drop Secure cookies over
+// plain HTTP other than on localhost, so turn Secure off only for local
+// development without TLS.
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     "access_token",
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// HandlerOption configures NewHTTPHandler.
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	cookie CookieConfig
+	logger log.Logger
+}
+
+// WithCookieConfig replaces DefaultCookieConfig.
+func WithCookieConfig(cookie CookieConfig) HandlerOption {
+	return func(c *handlerConfig) {
+		c.cookie = cookie
+	}
+}
+
+// WithLogger logs the errors behind 5xx responses, whose bodies only carry
+// the status text. Without it they are dropped.
+func WithLogger(logger log.Logger) HandlerOption {
+	return func(c *handlerConfig) {
+		c.logger = logger
+	}
+}
+
+func (c CookieConfig) cookie(value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Expires:  expires,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
+
+type cookieTokenContextKey struct{}
+
+// populateCookieToken is a ServerBefore hook recording the token cookie, if
+// any, for requestToken to fall back on.
+func (c CookieConfig) populateCookieToken(ctx context.Context, r *http.Request) context.Context {
+	cookie, err := r.Cookie(c.Name)
+	if err != nil || cookie.Value == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, cookieTokenContextKey{}, cookie.Value)
+}
+
+// requestToken prefers the Authorization header and falls back to the token
+// cookie set on login.
+func requestToken(ctx context.Context, r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+
+	token, _ := ctx.Value(cookieTokenContextKey{}).(string)
+
+	return token
+}
+
+// encodeLoginResponse sets the token cookie before writing the usual JSON
+// body. Without remember me the cookie ends with the browser session.
+func (c CookieConfig) encodeLoginResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if resp, ok := response.(endpoint.LoginResponse); ok && resp.Err == nil {
+		var expires time.Time
+		if resp.RememberMe {
+			expires = resp.ExpiresAt
+		}
+
+		http.SetCookie(w, c.cookie(resp.AccessToken, expires))
+	}
+
+	return EncodeResponse(ctx, w, response)
+}
+
+// encodeLogoutResponse expires the token cookie whether or not the logout
+// itself succeeded, since the token is useless either way.
+func (c CookieConfig) encodeLogoutResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	cookie := c.cookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+
+	return EncodeResponse(ctx, w, response)
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginCookie(t *testing.T) {
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore(), service.WithBcryptCost(bcrypt.MinCost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	config := CookieConfig{Name: "auth", Domain: "example.com", Path: "/api", Secure: true, SameSite: http.SameSiteLaxMode}
+	h := NewHTTPHandler(endpoint.MakeServerEndpoints(svc), WithCookieConfig(config))
+
+	if rec := do(t, h, "POST", "/register", endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /register = %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword}, "", nil)
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "auth" {
+			cookie = c
+		}
+	}
+
+	if cookie == nil || cookie.Value == "" {
+		t.Fatalf("login set no auth cookie: %v", rec.Header()["Set-Cookie"])
+	}
+
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.Domain != "example.com" || cookie.Path != "/api" {
+		t.Errorf("cookie = %+v, want the configured attributes and HttpOnly", cookie)
+	}
+
+	if !cookie.Expires.IsZero() {
+		t.Errorf("cookie expires %s, want a browser session cookie without remember me", cookie.Expires)
+	}
+
+	// The cookie alone authenticates.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: cookie.Value})
+
+	main := httptest.NewRecorder()
+	h.ServeHTTP(main, req)
+
+	if main.Code != http.StatusOK || !strings.Contains(main.Body.String(), "alice") {
+		t.Fatalf("GET / with the cookie = %d %s, want alice's page", main.Code, main.Body)
+	}
+
+	req = httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: cookie.Value})
+
+	logout := httptest.NewRecorder()
+	h.ServeHTTP(logout, req)
+
+	cleared := logout.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != "auth" || cleared[0].Value != "" || cleared[0].MaxAge >= 0 || cleared[0].Path != "/api" {
+		t.Fatalf("logout cookies = %+v, want the auth cookie expired", cleared)
+	}
+}
+
+func TestLoginCookieRememberMe(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rec := do(t, h, "POST", "/register", endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /register = %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword, RememberMe: true}, "", nil)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultCookieConfig().Name || cookies[0].Expires.IsZero() {
+		t.Fatalf("remember me cookies = %+v, want a persistent %s cookie", cookies, DefaultCookieConfig().Name)
+	}
+}
@@ -0,0 +1,809 @@
+// Package http exposes the UserService endpoints as a JSON API. Unlike the
+// parent transport package, which drives the HTML form flow with cookies and
+// redirects, every route here speaks JSON and reports failures with a status
+// code and an {"error": "..."} body.
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// errBadRequest marks failures to decode a request, which are the client's
+// fault rather than the service's.
+type errBadRequest struct {
+	err error
+}
+
+func (e errBadRequest) Error() string { return e.err.Error() }
+
+func (e errBadRequest) Unwrap() error { return e.err }
+
+// populateRequestID adopts the caller's X-Request-ID or mints one.
+func populateRequestID(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithRequestID(ctx, r.Header.Get("X-Request-ID"))
+}
+
+// setRequestIDHeader echoes the request ID so callers can quote it.
+func setRequestIDHeader(ctx context.Context, w http.ResponseWriter) context.Context {
+	if id := service.RequestIDFromContext(ctx); id != "" {
+		w.Header().Set("X-Request-ID", id)
+	}
+
+	return ctx
+}
+
+func populateClientIP(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithClientIP(ctx, r.RemoteAddr)
+}
+
+// populateTenant scopes the request to the tenant in its X-Tenant-ID header.
+func populateTenant(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithTenant(ctx, r.Header.Get("X-Tenant-ID"))
+}
+
+type loggerKey struct{}
+
+// populateLogger hands the handler's logger to EncodeError.
+func (c handlerConfig) populateLogger(ctx context.Context, _ *http.Request) context.Context {
+	return context.WithValue(ctx, loggerKey{}, c.logger)
+}
+
+// NewHTTPHandler routes the JSON API onto endpoints. Login also sets the
+// access token as a cookie and logout clears it; routes needing a token read
+// it from the Authorization header, or from that cookie when the header is
+// missing.
+func NewHTTPHandler(endpoints endpoint.Endpoints, options ...HandlerOption) http.Handler {
+	cfg := handlerConfig{cookie: DefaultCookieConfig(), logger: log.NewNopLogger()}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	opts := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(EncodeError),
+		httptransport.ServerBefore(populateRequestID, populateClientIP, populateTenant, cfg.cookie.populateCookieToken, cfg.populateLogger),
+		httptransport.ServerAfter(setRequestIDHeader),
+	}
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /health", httptransport.NewServer(
+		endpoints.HealthEndpoint,
+		DecodeHealthRequest,
+		EncodeHealthResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /{$}", httptransport.NewServer(
+		endpoints.MainEndpoint,
+		DecodeMainRequest,
+		EncodeMainResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /home", httptransport.NewServer(
+		endpoints.HomeStateEndpoint,
+		DecodeHomeStateRequest,
+		EncodeHomeStateResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /register", httptransport.NewServer(
+		endpoints.RegisterEndpoint,
+		DecodeRegisterRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /available", httptransport.NewServer(
+		endpoints.UsernameAvailableEndpoint,
+		DecodeUsernameAvailableRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /login", httptransport.NewServer(
+		endpoints.LoginEndpoint,
+		DecodeLoginRequest,
+		cfg.cookie.encodeLoginResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /verification/resend", httptransport.NewServer(
+		endpoints.ResendVerificationEndpoint,
+		DecodeResendVerificationRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /passkeys/register/begin", httptransport.NewServer(
+		endpoints.BeginPasskeyRegistrationEndpoint,
+		DecodeBeginPasskeyRegistrationRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /passkeys/register/finish", httptransport.NewServer(
+		endpoints.FinishPasskeyRegistrationEndpoint,
+		DecodeFinishPasskeyRegistrationRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /passkeys/login/begin", httptransport.NewServer(
+		endpoints.BeginPasskeyLoginEndpoint,
+		DecodeBeginPasskeyLoginRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /passkeys/login/finish", httptransport.NewServer(
+		endpoints.FinishPasskeyLoginEndpoint,
+		DecodeFinishPasskeyLoginRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /oauth/{provider}/login", httptransport.NewServer(
+		endpoints.OAuthLoginEndpoint,
+		DecodeOAuthLoginRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /totp", httptransport.NewServer(
+		endpoints.EnableTOTPEndpoint,
+		DecodeEnableTOTPRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /totp/confirm", httptransport.NewServer(
+		endpoints.ConfirmTOTPEndpoint,
+		DecodeConfirmTOTPRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /totp/recovery-codes", httptransport.NewServer(
+		endpoints.GenerateRecoveryCodesEndpoint,
+		DecodeGenerateRecoveryCodesRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /logout", httptransport.NewServer(
+		endpoints.LogoutEndpoint,
+		DecodeLogoutRequest,
+		cfg.cookie.encodeLogoutResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /profile", httptransport.NewServer(
+		endpoints.GetProfileEndpoint,
+		DecodeGetProfileRequest,
+		EncodeGetProfileResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /.well-known/jwks.json", httptransport.NewServer(
+		endpoints.PublicJWKSEndpoint,
+		DecodePublicJWKSRequest,
+		EncodePublicJWKSResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /introspect", httptransport.NewServer(
+		endpoints.IntrospectTokenEndpoint,
+		DecodeIntrospectTokenRequest,
+		EncodeIntrospectTokenResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /sessions", httptransport.NewServer(
+		endpoints.ListSessionsEndpoint,
+		DecodeListSessionsRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("DELETE /sessions", httptransport.NewServer(
+		endpoints.RevokeAllSessionsEndpoint,
+		DecodeRevokeAllSessionsRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("DELETE /sessions/{id}", httptransport.NewServer(
+		endpoints.RevokeSessionEndpoint,
+		DecodeRevokeSessionRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /sessions/stats", httptransport.NewServer(
+		endpoints.SessionStatsEndpoint,
+		DecodeSessionStatsRequest,
+		EncodeSessionStatsResponse,
+		opts...,
+	))
+
+	mux.Handle("GET /users", httptransport.NewServer(
+		endpoints.ListUsersEndpoint,
+		DecodeListUsersRequest,
+		EncodeListUsersResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /users", httptransport.NewServer(
+		endpoints.CreateUserEndpoint,
+		DecodeCreateUserRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("PUT /users/{username}/active", httptransport.NewServer(
+		endpoints.SetUserActiveEndpoint,
+		DecodeSetUserActiveRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("PUT /username", httptransport.NewServer(
+		endpoints.RenameUsernameEndpoint,
+		DecodeRenameUsernameRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	mux.Handle("POST /invites", httptransport.NewServer(
+		endpoints.CreateInviteEndpoint,
+		DecodeCreateInviteRequest,
+		EncodeResponse,
+		opts...,
+	))
+
+	return mux
+}
+
+// NewProbeHandler serves the Kubernetes probes: GET /healthz for liveness
+// and GET /readyz for readiness. Both answer 200 or 503 with a JSON body.
+func NewProbeHandler(endpoints endpoint.Endpoints) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /healthz", httptransport.NewServer(
+		endpoints.LivenessEndpoint,
+		DecodeProbeRequest,
+		EncodeProbeResponse,
+	))
+
+	mux.Handle("GET /readyz", httptransport.NewServer(
+		endpoints.ReadinessEndpoint,
+		DecodeProbeRequest,
+		EncodeProbeResponse,
+	))
+
+	return mux
+}
+
+func DecodeProbeRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return endpoint.ProbeRequest{}, nil
+}
+
+func EncodeProbeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return json.NewEncoder(w).Encode(map[string]string{"status": f.Failed().Error()})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]string{"status": service.HealthStatusOK})
+}
+
+func DecodeHealthRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return endpoint.HealthRequest{}, nil
+}
+
+func DecodeMainRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.MainRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeHomeStateRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.HomeStateRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	return req, nil
+}
+
+func DecodeUsernameAvailableRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.UsernameAvailableRequest{User: r.URL.Query().Get("username")}, nil
+}
+
+func DecodeLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.UserAgent = r.UserAgent()
+	req.IP = r.RemoteAddr
+
+	return req, nil
+}
+
+func DecodeResendVerificationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	return req, nil
+}
+
+func DecodeBeginPasskeyRegistrationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.BeginPasskeyRegistrationRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeFinishPasskeyRegistrationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.FinishPasskeyRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Token = requestToken(ctx, r)
+
+	return req, nil
+}
+
+func DecodeBeginPasskeyLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.BeginPasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	return req, nil
+}
+
+func DecodeFinishPasskeyLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.FinishPasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	return req, nil
+}
+
+func DecodeOAuthLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.OAuthLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Provider = r.PathValue("provider")
+
+	return req, nil
+}
+
+func DecodeEnableTOTPRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.EnableTOTPRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeConfirmTOTPRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Token = requestToken(ctx, r)
+
+	return req, nil
+}
+
+func DecodeGenerateRecoveryCodesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.GenerateRecoveryCodesRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeLogoutRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.LogoutRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeGetProfileRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.GetProfileRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodePublicJWKSRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return endpoint.PublicJWKSRequest{}, nil
+}
+
+func DecodeIntrospectTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.IntrospectTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	return req, nil
+}
+
+// DecodeListSessionsRequest reads the optional cursor and limit query
+// parameters.
+func DecodeListSessionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoint.ListSessionsRequest{
+		Token:  requestToken(ctx, r),
+		Cursor: r.URL.Query().Get("cursor"),
+		Limit:  limit,
+	}, nil
+}
+
+func DecodeRevokeAllSessionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.RevokeAllSessionsRequest{Token: requestToken(ctx, r)}, nil
+}
+
+func DecodeRevokeSessionRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.RevokeSessionRequest{Token: requestToken(ctx, r), SessionID: r.PathValue("id")}, nil
+}
+
+func DecodeSessionStatsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.SessionStatsRequest{Token: requestToken(ctx, r)}, nil
+}
+
+// DecodeListUsersRequest reads the optional offset and limit query
+// parameters; missing ones are left at zero.
+func DecodeListUsersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoint.ListUsersRequest{
+		Token:  requestToken(ctx, r),
+		Offset: offset,
+		Limit:  limit,
+	}, nil
+}
+
+func DecodeCreateUserRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Token = requestToken(ctx, r)
+
+	return req, nil
+}
+
+func DecodeSetUserActiveRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.SetUserActiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Token = requestToken(ctx, r)
+	req.Username = r.PathValue("username")
+
+	return req, nil
+}
+
+func DecodeRenameUsernameRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.RenameUsernameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest{err: err}
+	}
+
+	req.Token = requestToken(ctx, r)
+
+	return req, nil
+}
+
+func DecodeCreateInviteRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.CreateInviteRequest{Token: requestToken(ctx, r)}, nil
+}
+
+// EncodeResponse writes response as JSON, or hands it to EncodeError when it
+// carries a business failure.
+func EncodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		EncodeError(ctx, f.Failed(), w)
+
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+// EncodeHealthResponse answers 503 while any dependency is down so load
+// balancers can act on the status code alone.
+func EncodeHealthResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if resp, ok := response.(endpoint.HealthResponse); ok && resp.Message != service.HealthStatusOK {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return json.NewEncoder(w).Encode(resp)
+	}
+
+	return EncodeResponse(ctx, w, response)
+}
+
+type mainResponse struct {
+	User string `json:"user,omitempty"`
+}
+
+// EncodeMainResponse reports who the bearer token belongs to instead of the
+// template data the HTML flow renders.
+func EncodeMainResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.MainResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, mainResponse{User: resp.Render.Variables.User})
+}
+
+// EncodeHomeStateResponse writes the state itself rather than wrapping it.
+func EncodeHomeStateResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.HomeStateResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, resp.State)
+}
+
+// EncodeGetProfileResponse writes the profile itself rather than wrapping it.
+func EncodeGetProfileResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.GetProfileResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, resp.Profile)
+}
+
+// EncodePublicJWKSResponse writes the key set itself so standard JWKS
+// clients can read it, and lets caches keep it for a few minutes.
+func EncodePublicJWKSResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.PublicJWKSResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return EncodeResponse(ctx, w, resp.Set)
+}
+
+// EncodeIntrospectTokenResponse writes the introspection itself, which is
+// {"active": false} for any token that is not valid.
+func EncodeIntrospectTokenResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.IntrospectTokenResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, resp.Introspection)
+}
+
+// EncodeListUsersResponse writes the page itself rather than wrapping it.
+func EncodeListUsersResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.ListUsersResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, resp.Page)
+}
+
+// EncodeSessionStatsResponse writes the stats themselves rather than
+// wrapping them.
+func EncodeSessionStatsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.SessionStatsResponse)
+	if !ok {
+		return EncodeResponse(ctx, w, response)
+	}
+
+	if resp.Err != nil {
+		EncodeError(ctx, resp.Err, w)
+
+		return nil
+	}
+
+	return EncodeResponse(ctx, w, resp.Stats)
+}
+
+// EncodeError writes err as {"error": "..."} with the status code matching
+// the service error it wraps. An endpoint.ValidationError also lists the
+// offending fields under "fields". 5xx responses only carry the status text,
+// so store and integration failures do not leak to clients; err itself goes
+// to the logger of WithLogger.
+func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	code := codeFrom(err)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+
+	if code >= http.StatusInternalServerError {
+		if logger, ok := ctx.Value(loggerKey{}).(log.Logger); ok {
+			_ = logger.Log("request_id", service.RequestIDFromContext(ctx), "status", code, "err", err)
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": strings.ToLower(http.StatusText(code))})
+
+		return
+	}
+
+	var validationErr endpoint.ValidationError
+	if errors.As(err, &validationErr) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "fields": validationErr.Fields})
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func codeFrom(err error) int {
+	var (
+		badRequest    errBadRequest
+		validationErr endpoint.ValidationError
+	)
+
+	switch {
+	case errors.As(err, &badRequest),
+		errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUserAlreadyExists),
+		errors.Is(err, service.ErrEmailAlreadyRegistered),
+		errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrOAuthAccountConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrIncorrectPassword),
+		errors.Is(err, service.ErrTokenExpired),
+		errors.Is(err, service.ErrTokenInvalid),
+		errors.Is(err, service.ErrSessionNotFound),
+		errors.Is(err, service.ErrRefreshTokenNotFound),
+		errors.Is(err, service.ErrTOTPRequired),
+		errors.Is(err, service.ErrInvalidTOTPCode),
+		errors.Is(err, service.ErrInvalidRecoveryCode),
+		errors.Is(err, service.ErrTokenRevoked),
+		errors.Is(err, service.ErrInvalidPasskey),
+		errors.Is(err, service.ErrOAuthFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrEmailNotVerified),
+		errors.Is(err, service.ErrForbidden),
+		errors.Is(err, service.ErrInviteRequired),
+		errors.Is(err, service.ErrRegistrationDisabled),
+		errors.Is(err, service.ErrAccountSuspended),
+		errors.Is(err, service.ErrPasswordExpired),
+		errors.Is(err, service.ErrPasswordChangeRequired):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrAccountLocked),
+		errors.Is(err, service.ErrRateLimited),
+		errors.Is(err, service.ErrTooManySessions):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrNoPublicKeys),
+		errors.Is(err, service.ErrPasskeysDisabled),
+		errors.Is(err, service.ErrUnknownSession),
+		errors.Is(err, service.ErrStatelessSessions),
+		errors.Is(err, service.ErrUnknownOAuthProvider):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrBreachedPassword),
+		errors.Is(err, service.ErrInvalidUsername),
+		errors.Is(err, service.ErrUsernameNotAllowed),
+		errors.Is(err, service.ErrInvalidTenant),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidPage),
+		errors.Is(err, service.ErrInvalidTTL),
+		errors.Is(err, service.ErrTOTPNotPending),
+		errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrEmailDomainNotAllowed),
+		errors.Is(err, service.ErrPasswordUnchanged),
+		errors.Is(err, service.ErrPasswordReused),
+		errors.Is(err, service.ErrOneTimeTokenNotFound),
+		errors.Is(err, service.ErrInvalidInvite):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, service.ErrStoreUnavailable),
+		errors.Is(err, service.ErrIntegrationUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// queryInt parses the query parameter name, returning zero when it is absent.
+func queryInt(r *http.Request, name string) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errBadRequest{err: fmt.Errorf("invalid %s: %w", name, err)}
+	}
+
+	return n, nil
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
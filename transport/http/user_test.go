@@ -0,0 +1,340 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "passw0rd-1"
+
+// newTestHandler returns the JSON API of a service backed by the in-memory
+// stores.
+func newTestHandler(t *testing.T, opts ...service.Option) http.Handler {
+	t.Helper()
+
+	opts = append([]service.Option{service.WithBcryptCost(bcrypt.MinCost)}, opts...)
+
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { svc.Close(context.Background()) })
+
+	return NewHTTPHandler(endpoint.MakeServerEndpoints(svc))
+}
+
+// do sends a request with body, if any, as JSON and token, if any, as a
+// bearer token, and decodes the JSON answer into out when it is not nil.
+func do(t *testing.T, h http.Handler, method, path string, body interface{}, token string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+
+	return rec
+}
+
+func TestRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	register := endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}
+
+	if rec := do(t, h, "GET", "/health", nil, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("GET /health = %d, want 200", rec.Code)
+	}
+
+	if rec := do(t, h, "POST", "/register", register, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /register = %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	var failure map[string]string
+	if rec := do(t, h, "POST", "/register", register, "", &failure); rec.Code != http.StatusConflict || failure["error"] == "" {
+		t.Fatalf("POST /register of a taken username = %d %v, want 409 with an error", rec.Code, failure)
+	}
+
+	if rec := do(t, h, "POST", "/register", "not an object", "", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /register with a malformed body = %d, want 400", rec.Code)
+	}
+
+	if rec := do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: "wrong-passw0rd"}, "", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("POST /login with a wrong password = %d, want 401", rec.Code)
+	}
+
+	var login endpoint.LoginResponse
+	if rec := do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword}, "", &login); rec.Code != http.StatusOK || login.AccessToken == "" {
+		t.Fatalf("POST /login = %d %+v, want 200 with a token", rec.Code, login)
+	}
+
+	var main struct{ User string }
+	if rec := do(t, h, "GET", "/", nil, login.AccessToken, &main); rec.Code != http.StatusOK || main.User != "alice" {
+		t.Fatalf("GET / = %d %+v, want alice", rec.Code, main)
+	}
+
+	if rec := do(t, h, "POST", "/logout", nil, login.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /logout = %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, h, "GET", "/", nil, login.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET / after logout = %d, want 401", rec.Code)
+	}
+}
+
+func TestEncodeErrorHidesServerErrors(t *testing.T) {
+	cases := []struct {
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{fmt.Errorf("error while saving user: %w", errors.New("pq: connection refused to 10.0.0.5")), http.StatusInternalServerError, "internal server error"},
+		{fmt.Errorf("redis 10.0.0.6: %w", service.ErrStoreUnavailable), http.StatusServiceUnavailable, "service unavailable"},
+		{service.ErrInvalidCredentials, http.StatusUnauthorized, service.ErrInvalidCredentials.Error()},
+	}
+
+	for _, tc := range cases {
+		var logged bytes.Buffer
+		ctx := context.WithValue(context.Background(), loggerKey{}, log.NewLogfmtLogger(&logged))
+
+		rec := httptest.NewRecorder()
+		EncodeError(ctx, tc.err, rec)
+
+		var body map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Code != tc.wantCode || body["error"] != tc.wantBody {
+			t.Errorf("EncodeError(%v) = %d %q, want %d %q", tc.err, rec.Code, body["error"], tc.wantCode, tc.wantBody)
+		}
+
+		if serverError := tc.wantCode >= 500; serverError != strings.Contains(logged.String(), "10.0.0") {
+			t.Errorf("EncodeError(%v) logged %q", tc.err, logged.String())
+		}
+	}
+}
+
+func TestEncodeErrorWithoutLogger(t *testing.T) {
+	rec := httptest.NewRecorder()
+	EncodeError(context.Background(), errors.New("boom"), rec)
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "boom") {
+		t.Fatalf("EncodeError() = %d %q, want a generic 500", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	h := NewProbeHandler(endpoint.MakeServerEndpoints(svc))
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		var probe map[string]string
+		if rec := do(t, h, "GET", path, nil, "", &probe); rec.Code != http.StatusOK || probe["status"] != service.HealthStatusOK {
+			t.Fatalf("GET %s = %d %v, want 200 ok", path, rec.Code, probe)
+		}
+	}
+
+	svc.BeginShutdown()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		var probe map[string]string
+		if rec := do(t, h, "GET", path, nil, "", &probe); rec.Code != http.StatusServiceUnavailable || probe["status"] != service.ErrShuttingDown.Error() {
+			t.Fatalf("GET %s while shutting down = %d %v, want 503", path, rec.Code, probe)
+		}
+	}
+}
+
+func TestCodeFrom(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("wrapped: %w", service.ErrUserAlreadyExists), http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", service.ErrInvalidCredentials), http.StatusUnauthorized},
+		{fmt.Errorf("wrapped: %w", service.ErrSessionNotFound), http.StatusUnauthorized},
+		{fmt.Errorf("wrapped: %w", service.ErrTokenInvalid), http.StatusUnauthorized},
+		{fmt.Errorf("wrapped: %w", service.ErrForbidden), http.StatusForbidden},
+		{fmt.Errorf("wrapped: %w", service.ErrUserNotFound), http.StatusNotFound},
+		{fmt.Errorf("wrapped: %w", service.ErrWeakPassword), http.StatusBadRequest},
+		{fmt.Errorf("wrapped: %w", service.ErrAccountLocked), http.StatusTooManyRequests},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{endpoint.ErrTimeout, http.StatusGatewayTimeout},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := codeFrom(tt.err); got != tt.want {
+			t.Errorf("codeFrom(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestJWKSRoute(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := service.NewKeyManager(service.AlgorithmRS256, "2024-01", rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestHandler(t, service.WithKeyManager(keys))
+
+	if rec := do(t, h, "POST", "/register", endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /register = %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	var login endpoint.LoginResponse
+	do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword}, "", &login)
+
+	var set service.JSONWebKeySet
+	if rec := do(t, h, "GET", "/.well-known/jwks.json", nil, "", &set); rec.Code != http.StatusOK {
+		t.Fatalf("GET /.well-known/jwks.json = %d, want 200", rec.Code)
+	}
+
+	_, err = jwt.Parse(login.AccessToken, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range set.Keys {
+			if jwk.Kid != token.Header["kid"] {
+				continue
+			}
+
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+		}
+
+		return nil, fmt.Errorf("no key with kid %v", token.Header["kid"])
+	})
+	if err != nil {
+		t.Fatalf("verifying the access token with the published key: %v", err)
+	}
+}
+
+func TestHomeStateRoute(t *testing.T) {
+	h := newTestHandler(t)
+
+	var state service.HomeState
+	if rec := do(t, h, "GET", "/home", nil, "", &state); rec.Code != http.StatusOK || state != (service.HomeState{}) {
+		t.Fatalf("GET /home anonymously = %d %+v, want 200 and an empty state", rec.Code, state)
+	}
+
+	do(t, h, "POST", "/register", endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}, "", nil)
+
+	var login endpoint.LoginResponse
+	do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword}, "", &login)
+
+	if rec := do(t, h, "GET", "/home", nil, login.AccessToken, &state); rec.Code != http.StatusOK || state != (service.HomeState{LoggedIn: true, Username: "alice"}) {
+		t.Fatalf("GET /home = %d %+v, want alice logged in", rec.Code, state)
+	}
+
+	if rec := do(t, h, "GET", "/home", nil, "not-a-token", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /home with a malformed token = %d, want 401", rec.Code)
+	}
+}
+
+func TestUsernameAvailableRoute(t *testing.T) {
+	h := newTestHandler(t)
+	do(t, h, "POST", "/register", endpoint.RegisterRequest{User: "alice", Pass: testPassword, Email: "alice@example.com"}, "", nil)
+
+	for username, want := range map[string]bool{"alice": false, "bob": true} {
+		var resp endpoint.UsernameAvailableResponse
+		if rec := do(t, h, "GET", "/available?username="+username, nil, "", &resp); rec.Code != http.StatusOK || resp.Available != want {
+			t.Errorf("GET /available?username=%s = %d %+v, want available %t", username, rec.Code, resp, want)
+		}
+	}
+
+	if rec := do(t, h, "GET", "/available?username=a%2Fb", nil, "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /available with an invalid username = %d, want 400", rec.Code)
+	}
+}
+
+func TestValidationErrorRoute(t *testing.T) {
+	h := newTestHandler(t)
+
+	var failure struct {
+		Error  string                `json:"error"`
+		Fields []endpoint.FieldError `json:"fields"`
+	}
+
+	rec := do(t, h, "POST", "/login", endpoint.LoginRequest{Pass: testPassword}, "", &failure)
+	if rec.Code != http.StatusBadRequest || len(failure.Fields) != 1 || failure.Fields[0].Field != "user" {
+		t.Fatalf("POST /login without a username = %d %+v, want 400 naming the user field", rec.Code, failure)
+	}
+}
+
+// panickingService panics in LoginWithOptions, below every endpoint
+// middleware.
+type panickingService struct {
+	service.UserService
+}
+
+func (panickingService) LoginWithOptions(context.Context, string, string, service.LoginOptions) (service.LoginResult, error) {
+	panic("database password is hunter2")
+}
+
+func TestPanicAnsweredWith500(t *testing.T) {
+	endpoints := endpoint.MakeServerEndpoints(panickingService{}).Wrap(endpoint.RecoveringMiddleware(log.NewNopLogger(), discard.NewCounter()))
+	h := NewHTTPHandler(endpoints)
+
+	rec := do(t, h, "POST", "/login", endpoint.LoginRequest{User: "alice", Pass: testPassword}, "", nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("POST /login = %d, want 500", rec.Code)
+	}
+
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Fatalf("response %q leaks the panic", rec.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+)
+
+// TemplateManager parses every .gohtml template once, so syntax errors show
+// up at startup instead of on the first request, and renders them by file
+// name. It must stay on html/template: text/template would render the
+// user-controlled TemplateVariables unescaped.
+type TemplateManager struct {
+	templates *template.Template
+}
+
+// NewTemplateManager parses all .gohtml files in dir.
+func NewTemplateManager(dir string) (*TemplateManager, error) {
+	templates, err := template.ParseGlob(filepath.Join(dir, "*.gohtml"))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing templates: %w", err)
+	}
+
+	return &TemplateManager{templates: templates}, nil
+}
+
+// Render executes the template called name, e.g. service.MainTemplate.
+func (m *TemplateManager) Render(w io.Writer, name string, vars service.TemplateVariables) error {
+	if err := m.templates.ExecuteTemplate(w, name, vars); err != nil {
+		return fmt.Errorf("error while executing template %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// SetMainResponse renders the template named by the response's render, with
+// the CSRF token from PopulateCSRFToken embedded for its forms. The flash
+// message from PopulateFlash is consumed here.
+func (m *TemplateManager) SetMainResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	resp, ok := response.(endpoint.MainResponse)
+	if !ok {
+		return fmt.Errorf("error while casting template response: %T", response)
+	}
+
+	resp.Render.Variables.CSRFToken = setCSRFCookie(ctx, w)
+
+	if service.FlashFromContext(ctx) != "" {
+		clearFlash(w)
+	}
+
+	return m.Render(w, resp.Render.Metadata.Name, resp.Render.Variables)
+}
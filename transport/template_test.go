@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestTemplateManager(t *testing.T) *TemplateManager {
+	t.Helper()
+
+	templates, err := NewTemplateManager(filepath.Join("..", "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return templates
+}
+
+func TestTemplateManagerRender(t *testing.T) {
+	templates := newTestTemplateManager(t)
+
+	vars := service.TemplateVariables{LoginMessage: "welcome back", Session: "session-token", User: "alice", CSRFToken: "csrf-token"}
+
+	var buf bytes.Buffer
+	if err := templates.Render(&buf, service.MainTemplate, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"<div>welcome back</div>", "Session Cookie session-token", "Username alice", `value="csrf-token"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("rendered page lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	if err := templates.Render(&buf, "missing.gohtml", vars); err == nil {
+		t.Error("Render() of an unknown template succeeded")
+	}
+}
+
+func TestNewTemplateManagerRejectsSyntaxErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.gohtml"), []byte("{{.User"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTemplateManager(dir); err == nil {
+		t.Fatal("NewTemplateManager() of a broken template succeeded")
+	}
+
+	if _, err := NewTemplateManager(t.TempDir()); err == nil {
+		t.Fatal("NewTemplateManager() of a directory without templates succeeded")
+	}
+}
+
+func TestTemplateManagerEscapesVariables(t *testing.T) {
+	templates := newTestTemplateManager(t)
+
+	// Register already refuses such usernames, see
+	// TestRegisterValidatesUsername; the page must hold up regardless.
+	const script = "<script>alert(1)</script>"
+
+	vars := service.TemplateVariables{LoginMessage: script, Session: script, User: script, CSRFToken: `"><script>alert(1)</script>`}
+
+	var buf bytes.Buffer
+	if err := templates.Render(&buf, service.MainTemplate, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "<script>") {
+		t.Fatalf("rendered page contains an unescaped script:\n%s", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Fatalf("rendered page lacks the escaped username:\n%s", buf.String())
+	}
+}
+
+// TestFlashShowsOnce follows a logout's redirect to the main page twice: the
+// flash left by the logout shows on the first render only.
+func TestFlashShowsOnce(t *testing.T) {
+	svc, err := service.NewUserService(service.NewMemoryUserRepository(), service.NewMemorySessionStore(), service.WithBcryptCost(bcrypt.MinCost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	main := httptransport.NewServer(
+		endpoint.MakeServerEndpoints(svc).MainEndpoint,
+		DecodeMainRequest,
+		newTestTemplateManager(t).SetMainResponse,
+		httptransport.ServerBefore(PopulateCSRFToken, PopulateFlash),
+	)
+
+	logout := httptest.NewRecorder()
+	if err := DefaultTokenCookieConfig().SetLogoutResponse(context.Background(), logout, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	flash := responseCookies(logout)[flashCookieName]
+	if flash == nil || flash.Value != service.MessageLoggedOut {
+		t.Fatalf("logout flash cookie = %+v, want %s", flash, service.MessageLoggedOut)
+	}
+
+	message := service.DefaultLocalizer().Localize(context.Background(), service.MessageLoggedOut)
+
+	// render requests the main page with cookies and returns the response.
+	render := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		rec := httptest.NewRecorder()
+		main.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	first := render(flash)
+	if !strings.Contains(first.Body.String(), message) {
+		t.Fatalf("first render %q does not show %q", first.Body, message)
+	}
+
+	if cleared := responseCookies(first)[flashCookieName]; cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("first render flash cookie = %+v, want it cleared", cleared)
+	}
+
+	// The browser dropped the cleared cookie.
+	if second := render(); strings.Contains(second.Body.String(), message) {
+		t.Fatalf("second render %q shows the flash again", second.Body)
+	}
+}
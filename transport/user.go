@@ -4,122 +4,122 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/francisco-serrano/gokit-auth/endpoint"
 	"github.com/francisco-serrano/gokit-auth/service"
-	"github.com/go-kit/kit/endpoint"
-	"html/template"
-	"log"
+	"golang.org/x/text/language"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
 
-type healthCheckResponse struct {
-	Message string `json:"message"`
-}
+// PopulateLanguage is a ServerBefore hook recording the Accept-Language
+// preferences in the context for the service to localize messages with.
+func PopulateLanguage(ctx context.Context, r *http.Request) context.Context {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return ctx
+	}
 
-type loginRegisterRequest struct {
-	User string
-	Pass string
+	return service.ContextWithLanguages(ctx, tags)
 }
 
-func MakeHealthEndpoint(svc service.UserService) endpoint.Endpoint {
-	return func(_ context.Context, _ interface{}) (interface{}, error) {
-		return healthCheckResponse{Message: svc.HealthCheck()}, nil
-	}
+// PopulateRequestID is a ServerBefore hook adopting the caller's
+// X-Request-ID, or minting one, to correlate the request's log lines.
+func PopulateRequestID(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithRequestID(ctx, r.Header.Get("X-Request-ID"))
 }
 
-func MakeMainEndpoint(svc service.UserService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		c, ok := request.(*http.Cookie)
-		if !ok {
-			return nil, fmt.Errorf("could not obtain cookie from request: %T", request)
-		}
-
-		render, err := svc.SendMainTemplateData(c.Value)
-		if err != nil {
-			log.Print(fmt.Errorf("error while obtaining render: %w", err))
-		}
-
-		return render, nil
+// SetRequestIDHeader is a ServerAfter hook echoing the request ID so users
+// can quote it when reporting a problem.
+func SetRequestIDHeader(ctx context.Context, w http.ResponseWriter) context.Context {
+	if id := service.RequestIDFromContext(ctx); id != "" {
+		w.Header().Set("X-Request-ID", id)
 	}
+
+	return ctx
 }
 
-func MakeRegisterEndpoint(svc service.UserService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		userData, ok := request.(loginRegisterRequest)
-		if !ok {
-			return nil, fmt.Errorf("error while casting to register request: %T", request)
-		}
+// The cookies the form handlers keep the access and refresh tokens in.
+const (
+	sessionCookieName = "session"
+	refreshCookieName = "refresh"
+)
 
-		response, err := svc.Register(userData.User, userData.Pass)
-		if err != nil {
-			return nil, fmt.Errorf("error while registering email: %w", err)
-		}
+// flashCookieName holds the message key a form handler leaves for the page
+// it redirects to, see PopulateFlash.
+const flashCookieName = "flash"
 
-		return response, nil
+// PopulateFlash is a ServerBefore hook for the main page handing the flash
+// message left by the previous request to the service. SetMainResponse
+// clears the cookie, so the message shows exactly once.
+func PopulateFlash(ctx context.Context, r *http.Request) context.Context {
+	if key := cookieValue(r, flashCookieName); key != "" {
+		return service.ContextWithFlash(ctx, key)
 	}
-}
-
-func MakeLoginEndpoint(svc service.UserService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		userData, ok := request.(loginRegisterRequest)
-		if !ok {
-			log.Print(fmt.Errorf("error while casting to register request: %T", request))
 
-			return "", nil
-		}
+	return ctx
+}
 
-		token, err := svc.Login(userData.User, userData.Pass)
-		if err != nil {
-			log.Print(fmt.Errorf("error during login: %w", err))
+// PopulateTenant is a ServerBefore hook scoping the request to the tenant
+// named by its X-Tenant-ID header, or the default tenant without one.
+func PopulateTenant(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithTenant(ctx, r.Header.Get("X-Tenant-ID"))
+}
 
-			return "", nil
-		}
+// PopulateClientIP is a ServerBefore hook recording the client address in
+// the context for per-IP rate limiting.
+func PopulateClientIP(ctx context.Context, r *http.Request) context.Context {
+	return service.ContextWithClientIP(ctx, r.RemoteAddr)
+}
 
-		return token, nil
-	}
+func DecodeHealthRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return endpoint.HealthRequest{}, nil
 }
 
-func MakeLogoutEndpoint(svc service.UserService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		c, ok := request.(*http.Cookie)
-		if !ok {
-			return nil, fmt.Errorf("could not obtain cookie from request: %T", request)
-		}
+func DecodeMainRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.MainRequest{Token: cookieValue(r, sessionCookieName)}, nil
+}
 
-		if err := svc.Logout(c.Value); err != nil {
-			log.Print(fmt.Errorf("error while logging out: %w", err))
-		}
+func DecodeLogoutRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.LogoutRequest{Token: cookieValue(r, sessionCookieName)}, nil
+}
 
-		return nil, nil
-	}
+func DecodeRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.RefreshRequest{RefreshToken: cookieValue(r, refreshCookieName)}, nil
 }
 
-func DecodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	c, err := r.Cookie("session")
+func DecodeLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	user, pass, err := decodeCredentials(r)
 	if err != nil {
-		c = &http.Cookie{}
+		return nil, err
 	}
 
-	return c, nil
+	return endpoint.LoginRequest{
+		User:       user,
+		Pass:       pass,
+		RememberMe: r.FormValue("remember_me") != "",
+		TOTPCode:   strings.TrimSpace(r.FormValue("totp_code")),
+		UserAgent:  r.UserAgent(),
+		IP:         r.RemoteAddr,
+	}, nil
 }
 
-func DecodeLoginRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	user := r.FormValue("user")
-	if strings.TrimSpace(user) == "" {
-		return nil, fmt.Errorf("cannot register an empty user")
+func DecodeRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	user, pass, err := decodeCredentials(r)
+	if err != nil {
+		return nil, err
 	}
 
-	pass := r.FormValue("pass")
-	if strings.TrimSpace(pass) == "" {
-		return nil, fmt.Errorf("cannot register an empty password")
+	email := r.FormValue("email")
+	if strings.TrimSpace(email) == "" {
+		return nil, fmt.Errorf("cannot register an empty email")
 	}
 
-	return loginRegisterRequest{
-		User: user,
-		Pass: pass,
+	return endpoint.RegisterRequest{
+		User:       user,
+		Pass:       pass,
+		Email:      email,
+		InviteCode: strings.TrimSpace(r.FormValue("invite_code")),
 	}, nil
 }
 
@@ -127,70 +127,143 @@ func EncodeResponseJSON(_ context.Context, w http.ResponseWriter, response inter
 	return json.NewEncoder(w).Encode(response)
 }
 
-func EncodeResponseString(_ context.Context, w http.ResponseWriter, _ interface{}) error {
-	r, err := http.NewRequest(http.MethodGet, "/", nil)
-	if err != nil {
-		return fmt.Errorf("error while creating request: %w", err)
+func EncodeResponseString(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		return fmt.Errorf("error while registering email: %w", f.Failed())
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	setFlash(w, service.MessageRegistered)
 
-	return nil
+	return redirectHome(w)
 }
 
-func SetMainResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
-	w.Header().Set("content-type", "text/html")
+// TokenCookieConfig describes the cookies the form handlers keep tokens in.
+// They are always HttpOnly and SameSite=Strict.
+type TokenCookieConfig struct {
+	Secure bool
+}
+
+// DefaultTokenCookieConfig returns Secure cookies. Browsers drop Secure
+// cookies over plain HTTP other than on localhost, so turn Secure off only
+// when serving without TLS.
+func DefaultTokenCookieConfig() TokenCookieConfig {
+	return TokenCookieConfig{Secure: true}
+}
 
-	tr, ok := response.(service.TemplateRender)
+func (c TokenCookieConfig) SetLoginResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.LoginResponse)
 	if !ok {
-		return fmt.Errorf("error while casting template response: %T", response)
+		return fmt.Errorf("error while casting login response: %T", response)
 	}
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		log.Fatal(fmt.Errorf("error while geting cwd: %w", err))
+	// A failed login leaves the cookies of any current session alone.
+	if resp.Err != nil {
+		return redirectHome(w)
 	}
 
-	parsedTemplate, err := template.ParseFiles(filepath.Join(cwd, "templates", tr.Metadata.Name))
-	if err != nil {
-		log.Fatal(fmt.Errorf("error while parsing template: %w", err))
+	// Without remember me both cookies end with the browser session.
+	var expires time.Time
+	if resp.RememberMe {
+		expires = resp.ExpiresAt
 	}
 
-	if err := parsedTemplate.Execute(w, tr.Variables); err != nil {
-		return fmt.Errorf("error while executing template: %w", err)
+	http.SetCookie(w, c.tokenCookie(sessionCookieName, resp.AccessToken, expires))
+
+	if resp.RefreshToken != "" {
+		http.SetCookie(w, c.tokenCookie(refreshCookieName, resp.RefreshToken, expires))
 	}
 
-	return nil
+	setFlash(w, service.MessageLoggedIn)
+
+	return redirectHome(w)
 }
 
-func SetLoginResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
-	token, ok := response.(string)
+func (c TokenCookieConfig) SetRefreshResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp, ok := response.(endpoint.RefreshResponse)
 	if !ok {
-		return fmt.Errorf("error while casting login response: %T", response)
+		return fmt.Errorf("error while casting refresh response: %T", response)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "session",
-		Value: token,
-	})
+	if resp.Err == nil {
+		http.SetCookie(w, c.tokenCookie(sessionCookieName, resp.AccessToken, time.Time{}))
+	}
 
-	r, err := http.NewRequest(http.MethodGet, "/", nil)
-	if err != nil {
-		return fmt.Errorf("error while creating request: %w", err)
+	return redirectHome(w)
+}
+
+func (c TokenCookieConfig) SetLogoutResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+	for _, name := range []string{sessionCookieName, refreshCookieName} {
+		cookie := c.tokenCookie(name, "", time.Unix(0, 0))
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	setFlash(w, service.MessageLoggedOut)
 
-	return nil
+	return redirectHome(w)
 }
 
-func SetLogoutResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+// tokenCookie holds a token of the form handlers. Scripts cannot read it and
+// it never travels on requests started by other sites. A zero expires ends it
+// with the browser session.
+func (c TokenCookieConfig) tokenCookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// setFlash leaves the message key for the next page render. The cookie is
+// short-lived in case the redirect is never followed.
+func setFlash(w http.ResponseWriter, key string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:    "session",
-		Value:   "",
-		Expires: time.Unix(0, 0),
+		Name:     flashCookieName,
+		Value:    key,
+		Path:     "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
+}
+
+// clearFlash drops the flash cookie once its message has been shown.
+func clearFlash(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   flashCookieName,
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+func cookieValue(r *http.Request, name string) string {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	return c.Value
+}
+
+func decodeCredentials(r *http.Request) (string, string, error) {
+	user := r.FormValue("user")
+	if strings.TrimSpace(user) == "" {
+		return "", "", fmt.Errorf("cannot register an empty user")
+	}
+
+	pass := r.FormValue("pass")
+	if strings.TrimSpace(pass) == "" {
+		return "", "", fmt.Errorf("cannot register an empty password")
+	}
+
+	return user, pass, nil
+}
 
+func redirectHome(w http.ResponseWriter) error {
 	r, err := http.NewRequest(http.MethodGet, "/", nil)
 	if err != nil {
 		return fmt.Errorf("error while creating request: %w", err)
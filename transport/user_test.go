@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/francisco-serrano/gokit-auth/endpoint"
+	"github.com/francisco-serrano/gokit-auth/service"
+)
+
+// responseCookies returns the cookies set on rec by name.
+func responseCookies(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+
+	return cookies
+}
+
+func TestSetLoginResponseSetsHardenedCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	resp := endpoint.LoginResponse{AccessToken: "access", RefreshToken: "refresh", ExpiresAt: expires, RememberMe: true}
+	if err := DefaultTokenCookieConfig().SetLoginResponse(context.Background(), rec, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := responseCookies(rec)
+	for name, value := range map[string]string{sessionCookieName: "access", refreshCookieName: "refresh"} {
+		c, ok := cookies[name]
+		if !ok {
+			t.Fatalf("cookie %q not set", name)
+		}
+
+		if c.Value != value || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode || c.Path != "/" {
+			t.Errorf("cookie %q = %+v, want value %q, HttpOnly, Secure, SameSite=Strict and Path=/", name, c, value)
+		}
+
+		if !c.Expires.Equal(expires) {
+			t.Errorf("cookie %q expires %s, want %s", name, c.Expires, expires)
+		}
+	}
+}
+
+func TestSetLoginResponseInsecureCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	resp := endpoint.LoginResponse{AccessToken: "access", RefreshToken: "refresh"}
+	if err := (TokenCookieConfig{}).SetLoginResponse(context.Background(), rec, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range responseCookies(rec) {
+		if name != flashCookieName && (c.Secure || !c.HttpOnly) {
+			t.Errorf("cookie %q = %+v, want HttpOnly without Secure", name, c)
+		}
+	}
+}
+
+func TestSetLoginResponseFailureKeepsCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	if err := DefaultTokenCookieConfig().SetLoginResponse(context.Background(), rec, endpoint.LoginResponse{Err: errors.New("invalid credentials")}); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := responseCookies(rec)
+	for _, name := range []string{sessionCookieName, refreshCookieName} {
+		if c, ok := cookies[name]; ok {
+			t.Errorf("failed login set cookie %q = %+v", name, c)
+		}
+	}
+
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want a redirect", rec.Code)
+	}
+}
+
+func TestSetRefreshResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := DefaultTokenCookieConfig().SetRefreshResponse(context.Background(), rec, endpoint.RefreshResponse{Err: errors.New("revoked")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, ok := responseCookies(rec)[sessionCookieName]; ok {
+		t.Fatalf("failed refresh set cookie %+v", c)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := DefaultTokenCookieConfig().SetRefreshResponse(context.Background(), rec, endpoint.RefreshResponse{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := responseCookies(rec)[sessionCookieName]
+	if !ok || c.Value != "access" || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("session cookie = %+v, want a hardened cookie holding the new token", c)
+	}
+}
+
+func TestSetLogoutResponseExpiresCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := DefaultTokenCookieConfig().SetLogoutResponse(context.Background(), rec, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := responseCookies(rec)
+	for _, name := range []string{sessionCookieName, refreshCookieName} {
+		c, ok := cookies[name]
+		if !ok || c.Value != "" || c.MaxAge >= 0 || c.Path != "/" {
+			t.Errorf("cookie %q = %+v, want it expired on path /", name, c)
+		}
+	}
+}
+
+func TestPopulateLanguage(t *testing.T) {
+	localizer := service.DefaultLocalizer()
+
+	for header, want := range map[string]string{
+		"":                        "Welcome back",
+		"es-ES,es;q=0.9,en;q=0.8": "Bienvenido de nuevo",
+		"fr-FR, en;q=0.5":         "Welcome back",
+		"not a language header;;": "Welcome back",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", header)
+
+		if got := localizer.Localize(PopulateLanguage(context.Background(), req), service.MessageLoggedIn); got != want {
+			t.Errorf("Accept-Language %q: message = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDecodeLoginRequestCarriesDevice(t *testing.T) {
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("user=alice&pass=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.RemoteAddr = "192.0.2.7:51234"
+
+	decoded, err := DecodeLoginRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := decoded.(endpoint.LoginRequest)
+	if login.UserAgent != "Mozilla/5.0" || login.IP != "192.0.2.7:51234" {
+		t.Fatalf("DecodeLoginRequest() = %+v, want the request's user agent and address", login)
+	}
+}
+
+func TestRequestIDHooks(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		adopt  bool
+	}{
+		{header: "req-1", adopt: true},
+		{header: "", adopt: false},
+		{header: "forged\nlevel=error", adopt: false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			r.Header.Set("X-Request-ID", tc.header)
+		}
+
+		ctx := PopulateRequestID(context.Background(), r)
+
+		id := service.RequestIDFromContext(ctx)
+		if id == "" || (id == tc.header) != tc.adopt {
+			t.Errorf("X-Request-ID %q gave request ID %q, want adopted %t", tc.header, id, tc.adopt)
+		}
+
+		rec := httptest.NewRecorder()
+		SetRequestIDHeader(ctx, rec)
+
+		if got := rec.Header().Get("X-Request-ID"); got != id {
+			t.Errorf("X-Request-ID response header = %q, want %q", got, id)
+		}
+	}
+}